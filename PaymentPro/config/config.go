@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,10 +15,20 @@ type Config struct {
 	WebDashboard     bool
 	
 	// Payment providers
-	StripeToken       string
-	YooMoneyToken     string
-	PayPalToken       string
-	CryptoPayToken    string
+	Domain                 string
+	TelegramPaymentToken   string
+	StripeSecretKey        string
+	YooMoneyShopID         string
+	YooMoneySecretKey      string
+	CryptoProcessorURL     string
+	CryptoProcessorAPIKey  string
+	CryptoProcessorStoreID string
+	
+	// Webhook verification
+	StripeWebhookSecrets   []string
+	StripeWebhookTolerance time.Duration
+	YooMoneyWebhookSecret  string
+	CryptoWebhookSecret    string
 	
 	// Subscription plans
 	FreeGroupLimit    int
@@ -25,10 +38,56 @@ type Config struct {
 	// Admin settings
 	AdminUserIDs      []int64
 	
+	// Web dashboard authentication
+	AdminUsername     string
+	AdminPassword     string // bcrypt hash, or a plain password that is hashed on startup
+	SessionTTL        time.Duration
+	LoginMaxAttempts  int
+	LoginLockout      time.Duration
+	LoginLinkTTL      time.Duration
+	LoginLinkSecret   string
+	TelegramLoginAge  time.Duration
+	
 	// Crypto settings
 	BTCAddress        string
 	ETHAddress        string
 	USDTAddress       string
+	BTCXpub           string
+	ETHXpub           string
+	AddressGapLimit   int // unused derived addresses in a row before they are reused
+	
+	// On-chain verification of direct crypto deposits
+	ChainExplorer      string // "live" or "fake"
+	FakeChainFile      string
+	BTCExplorerURL     string
+	ETHRPCURL          string
+	USDTContract       string
+	ETHLookbackBlocks  int
+	BTCConfirmations   int
+	ETHConfirmations   int
+	CryptoPollInterval time.Duration
+	
+	// Exchange rates and crypto quotes
+	RateSource       string // "coingecko" or "static"
+	RateFile         string
+	RateCacheTTL     time.Duration
+	RateMaxAge       time.Duration
+	CryptoQuoteTTL   time.Duration
+	CryptoQuoteGrace time.Duration
+	CryptoLateWindow time.Duration // expired deposits are still watched this long after their quote
+	
+	// Expiry of sanctions and their reconciliation with Telegram
+	SanctionSweepInterval time.Duration
+	SanctionCheckBatch    int
+	
+	// Removal from plan chats after a subscription lapsed
+	ChatAccessGrace         time.Duration
+	ChatAccessSweepInterval time.Duration
+	
+	// How long a subscription that ended unrenewed keeps its plan (past_due)
+	// and then stays renewable without it (grace) before it expires
+	SubscriptionPastDue time.Duration
+	SubscriptionGrace   time.Duration
 }
 
 func Load() (*Config, error) {
@@ -38,29 +97,87 @@ func Load() (*Config, error) {
 		Debug:            getBoolEnv("DEBUG", false),
 		WebDashboard:     getBoolEnv("WEB_DASHBOARD", true),
 		
-		StripeToken:       os.Getenv("STRIPE_TOKEN"),
-		YooMoneyToken:     os.Getenv("YOOMONEY_TOKEN"),
-		PayPalToken:       os.Getenv("PAYPAL_TOKEN"),
-		CryptoPayToken:    os.Getenv("CRYPTOPAY_TOKEN"),
+		Domain:                 os.Getenv("DOMAIN"),
+		TelegramPaymentToken:   os.Getenv("TELEGRAM_PAYMENT_PROVIDER_TOKEN"),
+		StripeSecretKey:        os.Getenv("STRIPE_SECRET_KEY"),
+		YooMoneyShopID:         os.Getenv("YOOMONEY_SHOP_ID"),
+		YooMoneySecretKey:      os.Getenv("YOOMONEY_SECRET_KEY"),
+		CryptoProcessorURL:     os.Getenv("CRYPTO_PROCESSOR_URL"),
+		CryptoProcessorAPIKey:  os.Getenv("CRYPTO_PROCESSOR_API_KEY"),
+		CryptoProcessorStoreID: os.Getenv("CRYPTO_PROCESSOR_STORE_ID"),
+		
+		StripeWebhookSecrets:   getListEnv("STRIPE_WEBHOOK_SECRET"),
+		StripeWebhookTolerance: time.Duration(getIntEnv("STRIPE_WEBHOOK_TOLERANCE", 300)) * time.Second,
+		YooMoneyWebhookSecret:  os.Getenv("YOOMONEY_WEBHOOK_SECRET"),
+		CryptoWebhookSecret:    os.Getenv("CRYPTO_WEBHOOK_SECRET"),
 		
 		FreeGroupLimit:    getIntEnv("FREE_GROUP_LIMIT", 1),
 		PremiumPrice:      getIntEnv("PREMIUM_PRICE", 500), // 5.00 USD in cents
 		ProPrice:          getIntEnv("PRO_PRICE", 1000),    // 10.00 USD in cents
 		
+		AdminUsername:     getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:     os.Getenv("ADMIN_PASSWORD"),
+		SessionTTL:        time.Duration(getIntEnv("SESSION_TTL", 86400)) * time.Second,
+		LoginMaxAttempts:  getIntEnv("LOGIN_MAX_ATTEMPTS", 5),
+		LoginLockout:      time.Duration(getIntEnv("LOGIN_LOCKOUT", 900)) * time.Second,
+		LoginLinkTTL:      time.Duration(getIntEnv("LOGIN_LINK_TTL", 600)) * time.Second,
+		LoginLinkSecret:   os.Getenv("LOGIN_LINK_SECRET"),
+		TelegramLoginAge:  time.Duration(getIntEnv("TELEGRAM_LOGIN_MAX_AGE", 86400)) * time.Second,
+		
 		BTCAddress:        os.Getenv("BTC_ADDRESS"),
 		ETHAddress:        os.Getenv("ETH_ADDRESS"),
 		USDTAddress:       os.Getenv("USDT_ADDRESS"),
+		BTCXpub:           os.Getenv("BTC_XPUB"),
+		ETHXpub:           os.Getenv("ETH_XPUB"),
+		AddressGapLimit:   getIntEnv("CRYPTO_GAP_LIMIT", 20),
+		
+		ChainExplorer:      getEnv("CHAIN_EXPLORER", "live"),
+		FakeChainFile:      getEnv("FAKE_CHAIN_FILE", "fake_chain.json"),
+		BTCExplorerURL:     getEnv("BTC_EXPLORER_URL", "https://blockstream.info/api"),
+		ETHRPCURL:          os.Getenv("ETH_RPC_URL"),
+		USDTContract:       getEnv("USDT_CONTRACT", "0xdAC17F958D2ee523a2206206994597C13D831ec7"),
+		ETHLookbackBlocks:  getIntEnv("ETH_LOOKBACK_BLOCKS", 600),
+		BTCConfirmations:   getIntEnv("BTC_CONFIRMATIONS", 2),
+		ETHConfirmations:   getIntEnv("ETH_CONFIRMATIONS", 12),
+		CryptoPollInterval: time.Duration(getIntEnv("CRYPTO_POLL_INTERVAL", 60)) * time.Second,
+		
+		RateSource:       getEnv("RATE_SOURCE", "coingecko"),
+		RateFile:         getEnv("RATE_FILE", "rates.json"),
+		RateCacheTTL:     time.Duration(getIntEnv("RATE_CACHE_TTL", 300)) * time.Second,
+		RateMaxAge:       time.Duration(getIntEnv("RATE_MAX_AGE", 3600)) * time.Second,
+		CryptoQuoteTTL:   time.Duration(getIntEnv("CRYPTO_QUOTE_TTL", 1800)) * time.Second,
+		CryptoQuoteGrace: time.Duration(getIntEnv("CRYPTO_QUOTE_GRACE", 3600)) * time.Second,
+		CryptoLateWindow: time.Duration(getIntEnv("CRYPTO_LATE_WINDOW", 604800)) * time.Second,
+		
+		SanctionSweepInterval: time.Duration(getIntEnv("SANCTION_SWEEP_INTERVAL", 60)) * time.Second,
+		SanctionCheckBatch:    getIntEnv("SANCTION_CHECK_BATCH", 20),
+		
+		ChatAccessGrace:         time.Duration(getIntEnv("CHAT_ACCESS_GRACE", 86400)) * time.Second,
+		ChatAccessSweepInterval: time.Duration(getIntEnv("CHAT_ACCESS_SWEEP_INTERVAL", 300)) * time.Second,
+		
+		SubscriptionPastDue: time.Duration(getIntEnv("SUBSCRIPTION_PAST_DUE", 259200)) * time.Second,
+		SubscriptionGrace:   time.Duration(getIntEnv("SUBSCRIPTION_GRACE", 604800)) * time.Second,
 	}
 	
 	// Parse admin user IDs
-	if adminIDs := os.Getenv("ADMIN_USER_IDS"); adminIDs != "" {
-		// Parse comma-separated admin IDs
-		// Implementation would parse the string and convert to []int64
+	for _, value := range getListEnv("ADMIN_USER_IDS") {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_USER_IDS entry %q: %w", value, err)
+		}
+		cfg.AdminUserIDs = append(cfg.AdminUserIDs, id)
 	}
 	
 	return cfg, nil
 }
 
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if parsed, err := strconv.ParseBool(value); err == nil {
@@ -78,3 +195,24 @@ func getIntEnv(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getListEnv splits a comma-separated variable, dropping empty items
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// IsAdminUser reports whether the Telegram user is listed in ADMIN_USER_IDS
+func (c *Config) IsAdminUser(telegramID int64) bool {
+	for _, id := range c.AdminUserIDs {
+		if id == telegramID {
+			return true
+		}
+	}
+	return false
+}
@@ -3,9 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	
 	_ "github.com/lib/pq"
 )
@@ -42,17 +40,3 @@ func Connect(databaseURL string) (*DB, error) {
 	
 	return &DB{db}, nil
 }
-
-func Migrate(db *DB) error {
-	migrationFile := filepath.Join("database", "migrations.sql")
-	content, err := ioutil.ReadFile(migrationFile)
-	if err != nil {
-		return fmt.Errorf("failed to read migration file: %w", err)
-	}
-	
-	if _, err := db.Exec(string(content)); err != nil {
-		return fmt.Errorf("failed to execute migration: %w", err)
-	}
-	
-	return nil
-}
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLockKey serializes migration runs of several bot instances
+const migrationLockKey = 7245190311
+
+// Migration is one numbered step of the schema, read from
+// migrations/<version>_<name>.up.sql and the matching .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState pairs a migration with the time it was applied, nil if pending
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrate applies all pending migrations
+func Migrate(db *DB) error {
+	_, err := MigrateUp(db, 0)
+	return err
+}
+
+// LoadMigrations returns the embedded migrations ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name: %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(migrationFiles, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrationStatus lists every known migration with the time it was applied
+func MigrationStatus(db *DB) ([]MigrationState, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			appliedAt := appliedAt
+			state.AppliedAt = &appliedAt
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// MigrateUp applies up to steps pending migrations in version order, all of
+// them when steps is 0, and returns the ones it applied.
+func MigrateUp(db *DB, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range migrations {
+		if steps > 0 && len(done) == steps {
+			break
+		}
+
+		ran, err := runMigration(db, migration, true)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, migration)
+		}
+	}
+
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns the ones it reverted.
+func MigrateDown(db *DB, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		ran, err := runMigration(db, migrations[i], false)
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, migrations[i])
+		}
+	}
+
+	return done, nil
+}
+
+func ensureMigrationsTable(db *DB) error {
+	return withMigrationLock(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INTEGER PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)
+		`)
+		return err
+	})
+}
+
+func appliedMigrations(db *DB) (map[int]time.Time, error) {
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// runMigration applies (up) or reverts (down) one migration together with its
+// schema_migrations row in a single transaction. It reports false when there
+// was nothing to do because the migration is already in the requested state.
+func runMigration(db *DB, migration Migration, up bool) (bool, error) {
+	ran := false
+	err := withMigrationLock(db, func(tx *sql.Tx) error {
+		var applied bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied == up {
+			return nil
+		}
+
+		if up {
+			if _, err := tx.Exec(migration.Up); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+		} else {
+			if migration.Down == "" {
+				return fmt.Errorf("migration has no down file")
+			}
+			if _, err := tx.Exec(migration.Down); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+		}
+		if err != nil {
+			return err
+		}
+
+		ran = true
+		return nil
+	})
+	if err != nil {
+		direction := "apply"
+		if !up {
+			direction = "revert"
+		}
+		return false, fmt.Errorf("failed to %s migration %03d_%s: %w", direction, migration.Version, migration.Name, err)
+	}
+
+	return ran, nil
+}
+
+// withMigrationLock runs fn in a transaction holding the migration advisory
+// lock, so concurrently starting instances apply each migration once.
+func withMigrationLock(db *DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
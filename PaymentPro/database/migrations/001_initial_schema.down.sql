@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS update_users_updated_at ON users;
+DROP FUNCTION IF EXISTS update_updated_at_column();
+
+DROP TABLE IF EXISTS payment_notifications;
+DROP TABLE IF EXISTS user_groups;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS subscription_plans;
+DROP TABLE IF EXISTS users;
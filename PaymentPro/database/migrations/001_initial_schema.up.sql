@@ -64,12 +64,15 @@ CREATE TABLE IF NOT EXISTS payment_notifications (
     is_read BOOLEAN DEFAULT FALSE
 );
 
--- Insert default subscription plans
-INSERT INTO subscription_plans (name, price_cents, duration_days, max_groups, features) VALUES
+-- Insert default subscription plans (databases created before versioned
+-- migrations already have them)
+INSERT INTO subscription_plans (name, price_cents, duration_days, max_groups, features)
+SELECT name, price_cents, duration_days, max_groups, features::jsonb FROM (VALUES
 ('Free', 0, 0, 1, '{"basic_moderation": true}'),
 ('Premium', 500, 30, 5, '{"basic_moderation": true, "advanced_stats": true, "custom_commands": true}'),
 ('Pro', 1000, 30, 20, '{"basic_moderation": true, "advanced_stats": true, "custom_commands": true, "api_access": true, "priority_support": true}')
-ON CONFLICT DO NOTHING;
+) AS defaults (name, price_cents, duration_days, max_groups, features)
+WHERE NOT EXISTS (SELECT 1 FROM subscription_plans);
 
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_users_telegram_id ON users(telegram_id);
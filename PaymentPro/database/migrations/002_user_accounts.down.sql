@@ -0,0 +1,9 @@
+ALTER TABLE subscription_plans DROP COLUMN IF EXISTS description;
+
+ALTER TABLE users DROP COLUMN IF EXISTS total_spent;
+ALTER TABLE users DROP COLUMN IF EXISTS plan_name;
+
+DROP INDEX IF EXISTS idx_users_web_username;
+ALTER TABLE users DROP COLUMN IF EXISTS is_web_active;
+ALTER TABLE users DROP COLUMN IF EXISTS web_password;
+ALTER TABLE users DROP COLUMN IF EXISTS web_username;
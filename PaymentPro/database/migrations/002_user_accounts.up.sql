@@ -0,0 +1,14 @@
+-- Columns the bot and dashboard use on top of the initial schema
+
+-- Dashboard credentials issued with /web_access
+ALTER TABLE users ADD COLUMN IF NOT EXISTS web_username VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS web_password VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_web_active BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_web_username ON users(web_username) WHERE web_username <> '';
+
+-- Denormalized subscription info kept up to date by the payment triggers
+ALTER TABLE users ADD COLUMN IF NOT EXISTS plan_name VARCHAR(255) DEFAULT 'Free';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS total_spent INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE subscription_plans ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
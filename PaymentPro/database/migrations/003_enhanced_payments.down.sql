@@ -0,0 +1,45 @@
+DROP VIEW IF EXISTS payment_summary;
+DROP VIEW IF EXISTS payment_statistics;
+
+DROP FUNCTION IF EXISTS get_payment_analytics(INTEGER);
+
+DROP INDEX IF EXISTS idx_users_total_spent;
+DROP INDEX IF EXISTS idx_users_plan_expires_at;
+
+DROP TABLE IF EXISTS payment_webhook_logs;
+DROP TABLE IF EXISTS subscription_activations;
+DROP TABLE IF EXISTS payment_providers;
+DROP TRIGGER IF EXISTS trigger_create_subscription_activation ON payments;
+DROP TRIGGER IF EXISTS trigger_update_payment_updated_at ON payments;
+DROP FUNCTION IF EXISTS create_subscription_activation();
+DROP FUNCTION IF EXISTS update_payment_updated_at();
+
+-- Return the payments table to the layout of the initial schema in place
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_plan_id_fkey;
+ALTER TABLE payments ADD CONSTRAINT payments_plan_id_fkey
+    FOREIGN KEY (plan_id) REFERENCES subscription_plans(id);
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_user_id_fkey;
+ALTER TABLE payments ADD CONSTRAINT payments_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_amount_check;
+ALTER TABLE payments ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE payments ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE payments DROP COLUMN IF EXISTS webhook_data;
+ALTER TABLE payments DROP COLUMN IF EXISTS payment_data;
+ALTER TABLE payments DROP COLUMN IF EXISTS description;
+ALTER TABLE payments DROP COLUMN IF EXISTS transaction_id;
+ALTER TABLE payments RENAME COLUMN amount TO amount_cents;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS telegram_payment_charge_id VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS invoice_payload VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS provider_payment_charge_id VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS crypto_address VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS crypto_amount VARCHAR(50);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS crypto_currency VARCHAR(10);
+
+DROP INDEX IF EXISTS idx_payments_provider;
+DROP INDEX IF EXISTS idx_payments_transaction_id;
+DROP INDEX IF EXISTS idx_payments_created_at;
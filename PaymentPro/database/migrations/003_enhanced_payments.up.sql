@@ -1,36 +1,78 @@
 -- Enhanced Payment System Migration
 
--- Drop existing payments table if it exists
-DROP TABLE IF EXISTS payments CASCADE;
+-- Bring the payments table of the initial schema to the enhanced layout in
+-- place, keeping every payment. amount_cents becomes amount; the columns the
+-- enhanced table drops stay, so nothing recorded in them is lost. A table that
+-- already has the new layout is left as it is.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'payments' AND column_name = 'amount_cents'
+    ) THEN
+        ALTER TABLE payments RENAME COLUMN amount_cents TO amount;
+    END IF;
+END $$;
 
--- Create enhanced payments table
-CREATE TABLE payments (
-    id SERIAL PRIMARY KEY,
-    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
-    amount INTEGER NOT NULL CHECK (amount > 0),
-    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
-    payment_method VARCHAR(50) NOT NULL,
-    payment_provider VARCHAR(50) NOT NULL,
-    transaction_id VARCHAR(255) UNIQUE,
-    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'cancelled', 'refunded')),
-    description TEXT,
-    payment_data JSONB,
-    webhook_data JSONB,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    completed_at TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transaction_id VARCHAR(255) UNIQUE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payment_data JSONB;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS webhook_data JSONB;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+
+-- Backfill the new columns from the old ones. A charge ID becomes the
+-- transaction ID unless another payment has the same one.
+UPDATE payments SET updated_at = COALESCE(completed_at, created_at, CURRENT_TIMESTAMP) WHERE updated_at IS NULL;
+UPDATE payments p
+SET transaction_id = COALESCE(p.provider_payment_charge_id, p.telegram_payment_charge_id)
+WHERE p.transaction_id IS NULL
+  AND COALESCE(p.provider_payment_charge_id, p.telegram_payment_charge_id) IS NOT NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM payments o
+      WHERE o.id <> p.id
+        AND (o.transaction_id = COALESCE(p.provider_payment_charge_id, p.telegram_payment_charge_id)
+             OR COALESCE(o.provider_payment_charge_id, o.telegram_payment_charge_id) =
+                COALESCE(p.provider_payment_charge_id, p.telegram_payment_charge_id))
+  );
+UPDATE payments
+SET payment_data = jsonb_strip_nulls(jsonb_build_object(
+    'invoice_payload', invoice_payload,
+    'telegram_payment_charge_id', telegram_payment_charge_id,
+    'provider_payment_charge_id', provider_payment_charge_id,
+    'crypto_address', crypto_address,
+    'crypto_amount', crypto_amount,
+    'crypto_currency', crypto_currency
+))
+WHERE payment_data IS NULL;
+UPDATE payments SET status = 'pending' WHERE status IS NULL;
+
+ALTER TABLE payments ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE payments ALTER COLUMN currency SET DEFAULT 'USD';
+ALTER TABLE payments ALTER COLUMN status SET NOT NULL;
+
+-- Payments recorded before these checks existed are not validated against them
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_amount_check;
+ALTER TABLE payments ADD CONSTRAINT payments_amount_check CHECK (amount > 0) NOT VALID;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'cancelled', 'refunded')) NOT VALID;
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_user_id_fkey;
+ALTER TABLE payments ADD CONSTRAINT payments_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_plan_id_fkey;
+ALTER TABLE payments ADD CONSTRAINT payments_plan_id_fkey
+    FOREIGN KEY (plan_id) REFERENCES subscription_plans(id) ON DELETE SET NULL;
 
 -- Create indexes for better performance
-CREATE INDEX idx_payments_user_id ON payments(user_id);
-CREATE INDEX idx_payments_status ON payments(status);
-CREATE INDEX idx_payments_created_at ON payments(created_at);
-CREATE INDEX idx_payments_transaction_id ON payments(transaction_id);
-CREATE INDEX idx_payments_provider ON payments(payment_provider);
+CREATE INDEX IF NOT EXISTS idx_payments_user_id ON payments(user_id);
+CREATE INDEX IF NOT EXISTS idx_payments_status ON payments(status);
+CREATE INDEX IF NOT EXISTS idx_payments_created_at ON payments(created_at);
+CREATE INDEX IF NOT EXISTS idx_payments_transaction_id ON payments(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_payments_provider ON payments(payment_provider);
 
 -- Create payment providers table
-CREATE TABLE payment_providers (
+CREATE TABLE IF NOT EXISTS payment_providers (
     id SERIAL PRIMARY KEY,
     name VARCHAR(50) UNIQUE NOT NULL,
     display_name VARCHAR(100) NOT NULL,
@@ -47,10 +89,11 @@ INSERT INTO payment_providers (name, display_name, is_active, configuration) VAL
 ('yoomoney', 'ЮMoney', TRUE, '{"supports_webhooks": true, "currencies": ["RUB"]}'),
 ('paypal', 'PayPal', TRUE, '{"supports_webhooks": true, "currencies": ["USD", "EUR", "GBP"]}'),
 ('crypto', 'Cryptocurrency', TRUE, '{"supports_webhooks": true, "currencies": ["BTC", "ETH", "USDT"]}'),
-('telegram', 'Telegram Payments', TRUE, '{"supports_webhooks": true, "currencies": ["USD", "EUR", "RUB"]}');
+('telegram', 'Telegram Payments', TRUE, '{"supports_webhooks": true, "currencies": ["USD", "EUR", "RUB"]}')
+ON CONFLICT (name) DO NOTHING;
 
 -- Create subscription activations table
-CREATE TABLE subscription_activations (
+CREATE TABLE IF NOT EXISTS subscription_activations (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
     payment_id INTEGER REFERENCES payments(id) ON DELETE CASCADE,
@@ -62,12 +105,12 @@ CREATE TABLE subscription_activations (
 );
 
 -- Create indexes for subscription activations
-CREATE INDEX idx_subscription_activations_user_id ON subscription_activations(user_id);
-CREATE INDEX idx_subscription_activations_expires_at ON subscription_activations(expires_at);
-CREATE INDEX idx_subscription_activations_is_active ON subscription_activations(is_active);
+CREATE INDEX IF NOT EXISTS idx_subscription_activations_user_id ON subscription_activations(user_id);
+CREATE INDEX IF NOT EXISTS idx_subscription_activations_expires_at ON subscription_activations(expires_at);
+CREATE INDEX IF NOT EXISTS idx_subscription_activations_is_active ON subscription_activations(is_active);
 
 -- Create payment webhooks log table
-CREATE TABLE payment_webhook_logs (
+CREATE TABLE IF NOT EXISTS payment_webhook_logs (
     id SERIAL PRIMARY KEY,
     payment_provider VARCHAR(50) NOT NULL,
     webhook_id VARCHAR(255),
@@ -80,9 +123,9 @@ CREATE TABLE payment_webhook_logs (
 );
 
 -- Create index for webhook logs
-CREATE INDEX idx_payment_webhook_logs_provider ON payment_webhook_logs(payment_provider);
-CREATE INDEX idx_payment_webhook_logs_created_at ON payment_webhook_logs(created_at);
-CREATE INDEX idx_payment_webhook_logs_processed ON payment_webhook_logs(processed);
+CREATE INDEX IF NOT EXISTS idx_payment_webhook_logs_provider ON payment_webhook_logs(payment_provider);
+CREATE INDEX IF NOT EXISTS idx_payment_webhook_logs_created_at ON payment_webhook_logs(created_at);
+CREATE INDEX IF NOT EXISTS idx_payment_webhook_logs_processed ON payment_webhook_logs(processed);
 
 -- Create payment statistics view
 CREATE OR REPLACE VIEW payment_statistics AS
@@ -112,6 +155,7 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Create trigger for payments table
+DROP TRIGGER IF EXISTS trigger_update_payment_updated_at ON payments;
 CREATE TRIGGER trigger_update_payment_updated_at
     BEFORE UPDATE ON payments
     FOR EACH ROW
@@ -146,6 +190,7 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Create trigger for automatic subscription activation
+DROP TRIGGER IF EXISTS trigger_create_subscription_activation ON payments;
 CREATE TRIGGER trigger_create_subscription_activation
     AFTER UPDATE ON payments
     FOR EACH ROW
@@ -221,8 +266,8 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Create indexes for better query performance
-CREATE INDEX idx_users_plan_expires_at ON users(plan_expires_at) WHERE plan_expires_at IS NOT NULL;
-CREATE INDEX idx_users_total_spent ON users(total_spent);
+CREATE INDEX IF NOT EXISTS idx_users_plan_expires_at ON users(plan_expires_at) WHERE plan_expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_total_spent ON users(total_spent);
 
 -- Update existing data if needed
 UPDATE users SET total_spent = 0 WHERE total_spent IS NULL;
@@ -254,4 +299,4 @@ GRANT SELECT ON payment_summary TO PUBLIC;
 GRANT USAGE ON SEQUENCE payments_id_seq TO PUBLIC;
 GRANT USAGE ON SEQUENCE payment_providers_id_seq TO PUBLIC;
 GRANT USAGE ON SEQUENCE subscription_activations_id_seq TO PUBLIC;
-GRANT USAGE ON SEQUENCE payment_webhook_logs_id_seq TO PUBLIC;
\ No newline at end of file
+GRANT USAGE ON SEQUENCE payment_webhook_logs_id_seq TO PUBLIC;
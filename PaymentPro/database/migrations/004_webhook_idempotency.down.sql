@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_payment_webhook_logs_provider_event;
@@ -0,0 +1,7 @@
+-- Webhook idempotency
+
+-- Providers retry deliveries until they get a 2xx response, so the same event
+-- can arrive several times. A unique key per provider event lets the webhook
+-- handlers detect retries and skip events that were already processed.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_webhook_logs_provider_event
+    ON payment_webhook_logs(payment_provider, webhook_id);
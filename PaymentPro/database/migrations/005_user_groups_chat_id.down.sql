@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_user_groups_user_chat;
+
+ALTER TABLE user_groups DROP COLUMN IF EXISTS group_type;
+ALTER TABLE user_groups ALTER COLUMN group_name DROP NOT NULL;
+ALTER TABLE user_groups ALTER COLUMN group_name DROP DEFAULT;
+
+ALTER TABLE user_groups RENAME COLUMN chat_id TO group_id;
+ALTER TABLE user_groups RENAME COLUMN group_name TO group_title;
+ALTER INDEX IF EXISTS idx_user_groups_chat_id RENAME TO idx_user_groups_group_id;
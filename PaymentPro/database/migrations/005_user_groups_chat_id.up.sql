@@ -0,0 +1,27 @@
+-- Groups are identified by their Telegram chat ID everywhere in the code
+
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'user_groups' AND column_name = 'group_id'
+    ) THEN
+        ALTER TABLE user_groups RENAME COLUMN group_id TO chat_id;
+        ALTER TABLE user_groups RENAME COLUMN group_title TO group_name;
+        ALTER INDEX IF EXISTS idx_user_groups_group_id RENAME TO idx_user_groups_chat_id;
+    END IF;
+END $$;
+
+UPDATE user_groups SET group_name = '' WHERE group_name IS NULL;
+ALTER TABLE user_groups ALTER COLUMN group_name SET DEFAULT '';
+ALTER TABLE user_groups ALTER COLUMN group_name SET NOT NULL;
+ALTER TABLE user_groups ADD COLUMN IF NOT EXISTS group_type VARCHAR(50) NOT NULL DEFAULT 'group';
+
+CREATE INDEX IF NOT EXISTS idx_user_groups_chat_id ON user_groups(chat_id);
+
+-- A group is linked to a user once; keep the oldest link of any duplicates
+DELETE FROM user_groups a
+USING user_groups b
+WHERE a.user_id = b.user_id AND a.chat_id = b.chat_id AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_groups_user_chat ON user_groups(user_id, chat_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS moderation_settings;
+DROP TABLE IF EXISTS user_bans;
+DROP TABLE IF EXISTS user_violations;
+DROP TABLE IF EXISTS forbidden_words;
@@ -0,0 +1,46 @@
+-- Moderation: forbidden words, recorded violations, bans and thresholds
+
+CREATE TABLE IF NOT EXISTS forbidden_words (
+    id SERIAL PRIMARY KEY,
+    word VARCHAR(255) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_violations (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    violation_type VARCHAR(50) NOT NULL, -- 'forbidden_words', 'spam', 'warning', 'temp_ban', 'permanent_ban'
+    violation_reason TEXT,
+    message_text TEXT,
+    expires_at TIMESTAMP,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_violations_user_chat ON user_violations(user_id, chat_id);
+CREATE INDEX IF NOT EXISTS idx_user_violations_chat_id ON user_violations(chat_id);
+CREATE INDEX IF NOT EXISTS idx_user_violations_created_at ON user_violations(created_at);
+
+CREATE TABLE IF NOT EXISTS user_bans (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    ban_reason TEXT,
+    is_permanent BOOLEAN NOT NULL DEFAULT FALSE,
+    banned_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_bans_user_id ON user_bans(user_id);
+
+-- A single row: the dashboard upserts it by its fixed id
+CREATE TABLE IF NOT EXISTS moderation_settings (
+    id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    auto_ban_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    temp_ban_duration INTEGER NOT NULL DEFAULT 24, -- hours
+    warning_threshold INTEGER NOT NULL DEFAULT 3,
+    permanent_ban_threshold INTEGER NOT NULL DEFAULT 5
+);
+
+INSERT INTO moderation_settings (id) VALUES (1) ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS group_behavior_patterns;
+DROP TABLE IF EXISTS behavior_analytics;
+DROP TABLE IF EXISTS ai_recommendations;
+DROP TABLE IF EXISTS group_statistics;
+DROP TABLE IF EXISTS user_activity;
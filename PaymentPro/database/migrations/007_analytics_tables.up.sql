@@ -0,0 +1,65 @@
+-- Analytics: activity, group statistics and AI recommendations
+
+CREATE TABLE IF NOT EXISTS user_activity (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    activity_type VARCHAR(50),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_activity_user_created ON user_activity(user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS group_statistics (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT UNIQUE NOT NULL,
+    group_title VARCHAR(255) NOT NULL DEFAULT '',
+    total_members INTEGER NOT NULL DEFAULT 0,
+    total_messages INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ai_recommendations (
+    id SERIAL PRIMARY KEY,
+    group_id BIGINT, -- chat ID, NULL for recommendations about all groups
+    recommendation_type VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL DEFAULT 'low', -- 'low', 'medium', 'high', 'critical'
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'implemented', 'dismissed'
+    confidence DOUBLE PRECISION NOT NULL DEFAULT 0,
+    analysis_data TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ai_recommendations_group_status ON ai_recommendations(group_id, status);
+
+CREATE TABLE IF NOT EXISTS behavior_analytics (
+    id SERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL,
+    analysis_type VARCHAR(50) NOT NULL, -- 'activity', 'moderation', 'engagement'
+    metric_name VARCHAR(100) NOT NULL,
+    metric_value DOUBLE PRECISION NOT NULL,
+    previous_value DOUBLE PRECISION,
+    change_percent DOUBLE PRECISION,
+    time_window VARCHAR(10) NOT NULL, -- '1h', '24h', '7d', '30d'
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_behavior_analytics_group_created ON behavior_analytics(group_id, created_at);
+
+CREATE TABLE IF NOT EXISTS group_behavior_patterns (
+    id SERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL,
+    pattern_type VARCHAR(50) NOT NULL, -- 'spam_surge', 'quiet_period', 'high_activity'
+    pattern_data TEXT NOT NULL DEFAULT '{}',
+    confidence DOUBLE PRECISION NOT NULL DEFAULT 0,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP,
+    is_ongoing BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_behavior_patterns_group_id ON group_behavior_patterns(group_id);
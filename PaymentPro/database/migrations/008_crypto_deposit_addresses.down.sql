@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS crypto_derivation_indexes;
+
+DROP INDEX IF EXISTS idx_payments_derived_address;
+ALTER TABLE payments DROP COLUMN IF EXISTS derivation_index;
+ALTER TABLE payments DROP COLUMN IF EXISTS crypto_address;
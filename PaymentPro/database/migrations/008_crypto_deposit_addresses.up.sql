@@ -0,0 +1,14 @@
+-- Per-payment deposit addresses derived from the configured xpubs
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS crypto_address VARCHAR(255);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS derivation_index INTEGER;
+
+-- A derived address belongs to exactly one payment
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_derived_address
+    ON payments(crypto_address) WHERE derivation_index IS NOT NULL;
+
+-- Next unused index of each account key, so concurrent checkouts never share one
+CREATE TABLE IF NOT EXISTS crypto_derivation_indexes (
+    wallet VARCHAR(100) PRIMARY KEY,
+    next_index INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,9 @@
+UPDATE payments SET status = 'failed' WHERE status IN ('underpaid', 'expired');
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'cancelled', 'refunded'));
+
+ALTER TABLE payments DROP COLUMN IF EXISTS quote_expires_at;
+ALTER TABLE payments DROP COLUMN IF EXISTS exchange_rate;
+
+DROP TABLE IF EXISTS exchange_rates;
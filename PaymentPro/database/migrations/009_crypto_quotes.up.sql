@@ -0,0 +1,18 @@
+-- Exchange-rate cache and locked crypto quotes
+
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    crypto VARCHAR(10) NOT NULL,
+    fiat VARCHAR(3) NOT NULL,
+    rate DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+    fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (crypto, fiat)
+);
+
+-- The rate a crypto invoice was priced at and until when it holds
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS exchange_rate DOUBLE PRECISION;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS quote_expires_at TIMESTAMP;
+
+-- Crypto payments that received too little, or nothing before their quote ran out
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'cancelled', 'refunded', 'underpaid', 'expired'));
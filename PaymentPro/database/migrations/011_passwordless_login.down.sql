@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS dashboard_login_links;
+
+UPDATE users SET web_username = '' WHERE web_username IS NULL;
+UPDATE users SET web_password = '' WHERE web_password IS NULL;
+
+ALTER TABLE users ALTER COLUMN web_password SET DEFAULT '';
+ALTER TABLE users ALTER COLUMN web_password SET NOT NULL;
+ALTER TABLE users ALTER COLUMN web_username SET DEFAULT '';
+ALTER TABLE users ALTER COLUMN web_username SET NOT NULL;
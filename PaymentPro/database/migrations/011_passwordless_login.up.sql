@@ -0,0 +1,21 @@
+-- Users can sign in with Telegram or a link from the bot, so a dashboard
+-- username and password are optional now
+
+ALTER TABLE users ALTER COLUMN web_username DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN web_username DROP DEFAULT;
+ALTER TABLE users ALTER COLUMN web_password DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN web_password DROP DEFAULT;
+
+UPDATE users SET web_username = NULL WHERE web_username = '';
+UPDATE users SET web_password = NULL WHERE web_password = '';
+
+-- One-time sign-in links sent by /login; a link is used up by setting used_at
+CREATE TABLE IF NOT EXISTS dashboard_login_links (
+    nonce VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dashboard_login_links_expires_at ON dashboard_login_links(expires_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS moderation_settings (
+    id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    auto_ban_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    temp_ban_duration INTEGER NOT NULL DEFAULT 24, -- hours
+    warning_threshold INTEGER NOT NULL DEFAULT 3,
+    permanent_ban_threshold INTEGER NOT NULL DEFAULT 5
+);
+
+INSERT INTO moderation_settings (id) VALUES (1) ON CONFLICT (id) DO NOTHING;
+
+DROP TABLE IF EXISTS group_moderation_settings;
@@ -0,0 +1,25 @@
+-- Moderation settings per chat. Chats without a row use the defaults below;
+-- registered chats start from the former global settings row, which the bot
+-- never read.
+
+CREATE TABLE IF NOT EXISTS group_moderation_settings (
+    chat_id BIGINT PRIMARY KEY,
+    moderation_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    auto_ban_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    warning_threshold INTEGER NOT NULL DEFAULT 3,
+    temp_ban_duration INTEGER NOT NULL DEFAULT 24, -- hours
+    permanent_ban_threshold INTEGER NOT NULL DEFAULT 5,
+    check_forbidden_words BOOLEAN NOT NULL DEFAULT TRUE,
+    check_spam BOOLEAN NOT NULL DEFAULT TRUE,
+    notification_level VARCHAR(20) NOT NULL DEFAULT 'full'
+        CHECK (notification_level IN ('full', 'short', 'silent')),
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO group_moderation_settings (chat_id, auto_ban_enabled, warning_threshold, temp_ban_duration, permanent_ban_threshold)
+SELECT DISTINCT ug.chat_id, ms.auto_ban_enabled, ms.warning_threshold, ms.temp_ban_duration, ms.permanent_ban_threshold
+FROM user_groups ug
+CROSS JOIN moderation_settings ms
+ON CONFLICT (chat_id) DO NOTHING;
+
+DROP TABLE IF EXISTS moderation_settings;
@@ -0,0 +1,3 @@
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS flood_repeats;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS flood_window;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS flood_messages;
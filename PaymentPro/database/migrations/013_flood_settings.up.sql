@@ -0,0 +1,7 @@
+-- Flood limits per chat: more than flood_messages messages within
+-- flood_window seconds, or flood_repeats identical messages in a row, count
+-- as spam (flood_repeats = 0 turns the repeat check off)
+
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS flood_messages INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS flood_window INTEGER NOT NULL DEFAULT 10;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS flood_repeats INTEGER NOT NULL DEFAULT 3;
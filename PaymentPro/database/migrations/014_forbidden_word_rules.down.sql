@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_forbidden_words_chat_id;
+ALTER TABLE forbidden_words DROP COLUMN IF EXISTS match_type;
+ALTER TABLE forbidden_words DROP COLUMN IF EXISTS chat_id;
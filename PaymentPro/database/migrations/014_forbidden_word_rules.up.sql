@@ -0,0 +1,9 @@
+-- Forbidden words can belong to one chat (chat_id) or to every chat (NULL),
+-- and match a whole word, any substring or a regular expression. Existing
+-- entries become whole words, so they stop matching inside innocent words.
+
+ALTER TABLE forbidden_words ADD COLUMN IF NOT EXISTS chat_id BIGINT;
+ALTER TABLE forbidden_words ADD COLUMN IF NOT EXISTS match_type VARCHAR(20) NOT NULL DEFAULT 'word'
+    CHECK (match_type IN ('word', 'substring', 'regex'));
+
+CREATE INDEX IF NOT EXISTS idx_forbidden_words_chat_id ON forbidden_words(chat_id);
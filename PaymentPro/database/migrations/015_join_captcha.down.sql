@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS join_verifications;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS captcha_timeout;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS captcha_type;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS captcha_enabled;
@@ -0,0 +1,28 @@
+-- Join verification: groups can challenge new members (and join requests)
+-- before they may write. Every challenge and its outcome is kept in
+-- join_verifications, next to user_violations.
+
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS captcha_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS captcha_type VARCHAR(20) NOT NULL DEFAULT 'button'
+    CHECK (captcha_type IN ('button', 'math'));
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS captcha_timeout INTEGER NOT NULL DEFAULT 120; -- seconds
+
+CREATE TABLE IF NOT EXISTS join_verifications (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    telegram_user_id BIGINT NOT NULL,
+    source VARCHAR(20) NOT NULL, -- 'join' (joined the group) or 'join_request'
+    challenge_type VARCHAR(20) NOT NULL,
+    answer VARCHAR(20) NOT NULL,
+    message_chat_id BIGINT, -- where the challenge was posted: the group or the user's private chat
+    message_id INTEGER,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'passed', 'failed', 'timeout', 'left'
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP
+);
+
+-- One open challenge per member and chat
+CREATE UNIQUE INDEX IF NOT EXISTS idx_join_verifications_pending
+    ON join_verifications(chat_id, telegram_user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_join_verifications_chat_created ON join_verifications(chat_id, created_at);
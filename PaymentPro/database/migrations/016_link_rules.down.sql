@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS group_link_rules;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS block_forwards;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS block_all_links;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS block_invites;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS check_links;
@@ -0,0 +1,18 @@
+-- Link filtering per chat: denied domains, allowed domains, foreign Telegram
+-- invites and forwarded channel posts. Off until a chat turns check_links on.
+
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS check_links BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS block_invites BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS block_all_links BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS block_forwards BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A pattern is a domain (example.com, also covering its subdomains), a domain
+-- with a path prefix (t.me/mychannel) or a username (@mychannel)
+CREATE TABLE IF NOT EXISTS group_link_rules (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    pattern VARCHAR(255) NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'deny')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (chat_id, pattern)
+);
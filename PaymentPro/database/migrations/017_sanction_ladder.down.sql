@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_user_violations_expires_at;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS warning_threshold INTEGER NOT NULL DEFAULT 3;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS temp_ban_duration INTEGER NOT NULL DEFAULT 24;
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS permanent_ban_threshold INTEGER NOT NULL DEFAULT 5;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS violation_decay_days;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS sanction_ladder;
@@ -0,0 +1,23 @@
+-- Sanction ladder per chat: the n-th violation within violation_decay_days
+-- gets the n-th step, the last step repeats. Steps are warn, mute:<minutes>,
+-- media:<minutes> (text only), ban:<minutes> and ban (permanent). It replaces
+-- the fixed warning/temporary ban/permanent ban thresholds.
+
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS sanction_ladder TEXT NOT NULL
+    DEFAULT 'warn,warn,mute:60,media:1440,ban:1440,ban';
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS violation_decay_days INTEGER NOT NULL DEFAULT 30; -- 0 = never
+
+-- Chats keep the sanctions they had: warnings up to the threshold, temporary
+-- bans up to the permanent ban threshold, then a permanent ban
+UPDATE group_moderation_settings
+SET sanction_ladder = repeat('warn,', warning_threshold)
+    || repeat('ban:' || (temp_ban_duration * 60) || ',', GREATEST(permanent_ban_threshold - warning_threshold, 0))
+    || 'ban';
+
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS warning_threshold;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS temp_ban_duration;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS permanent_ban_threshold;
+
+-- expires_at is when a mute, restriction or temporary ban ends; warnings and
+-- permanent bans have none
+CREATE INDEX IF NOT EXISTS idx_user_violations_expires_at ON user_violations(expires_at) WHERE is_active;
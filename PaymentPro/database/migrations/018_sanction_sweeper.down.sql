@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS moderation_discrepancies;
+ALTER TABLE user_violations DROP COLUMN IF EXISTS checked_at;
+ALTER TABLE user_violations DROP COLUMN IF EXISTS lifted_at;
@@ -0,0 +1,23 @@
+-- Sanctions end on their own: lifted_at is when a mute, restriction or ban
+-- stopped being in force, because it expired or because Telegram no longer
+-- shows it. checked_at is when the bot last compared a sanction in force
+-- with the member's status in Telegram.
+
+ALTER TABLE user_violations ADD COLUMN IF NOT EXISTS lifted_at TIMESTAMP;
+ALTER TABLE user_violations ADD COLUMN IF NOT EXISTS checked_at TIMESTAMP;
+
+UPDATE user_violations SET lifted_at = expires_at WHERE expires_at <= NOW() AND lifted_at IS NULL;
+
+-- Where the database and Telegram disagreed about a member's sanction
+CREATE TABLE IF NOT EXISTS moderation_discrepancies (
+    id SERIAL PRIMARY KEY,
+    violation_id INTEGER REFERENCES user_violations(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    telegram_user_id BIGINT NOT NULL,
+    expected VARCHAR(20) NOT NULL, -- member status the database implies
+    actual VARCHAR(20) NOT NULL,   -- member status Telegram reports
+    resolution VARCHAR(20) NOT NULL CHECK (resolution IN ('lifted', 'recorded')),
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_discrepancies_chat ON moderation_discrepancies(chat_id, detected_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_bans (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    ban_reason TEXT,
+    is_permanent BOOLEAN NOT NULL DEFAULT FALSE,
+    banned_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_bans_user_id ON user_bans(user_id);
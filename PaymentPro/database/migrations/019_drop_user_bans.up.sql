@@ -0,0 +1,5 @@
+-- Bans live in user_violations, next to the other sanctions, and are applied
+-- in Telegram when they are recorded. The dashboard's separate ban list had
+-- no chat and never reached Telegram.
+
+DROP TABLE IF EXISTS user_bans;
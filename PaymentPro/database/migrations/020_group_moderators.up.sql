@@ -0,0 +1,11 @@
+-- Members a group's admins trust with the bot's moderation commands without
+-- making them Telegram admins
+
+CREATE TABLE IF NOT EXISTS group_moderators (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    telegram_user_id BIGINT NOT NULL,
+    added_by BIGINT, -- Telegram ID of the admin who added them
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (chat_id, telegram_user_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS message_reports;
+ALTER TABLE group_moderation_settings DROP COLUMN IF EXISTS report_chat_id;
@@ -0,0 +1,28 @@
+-- Members report messages with /report. Reports go to the group's review
+-- chat, or to the group's owner in private when it has none, and are kept
+-- with how a moderator resolved them.
+
+ALTER TABLE group_moderation_settings ADD COLUMN IF NOT EXISTS report_chat_id BIGINT NOT NULL DEFAULT 0; -- 0 = the owner
+
+CREATE TABLE IF NOT EXISTS message_reports (
+    id SERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    message_id INTEGER NOT NULL,
+    reported_user_id BIGINT NOT NULL, -- Telegram IDs
+    reported_name VARCHAR(255) NOT NULL DEFAULT '',
+    reporter_id BIGINT NOT NULL,
+    reporter_name VARCHAR(255) NOT NULL DEFAULT '',
+    message_text TEXT NOT NULL DEFAULT '', -- snapshot: the message may be deleted later
+    reason TEXT NOT NULL DEFAULT '',
+    review_chat_id BIGINT,
+    review_message_id INTEGER,
+    status VARCHAR(20) NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'deleted', 'warned', 'muted', 'banned', 'dismissed')),
+    resolved_by BIGINT,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A message is under review once, however many members report it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_reports_open ON message_reports(chat_id, message_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_message_reports_chat ON message_reports(chat_id, created_at);
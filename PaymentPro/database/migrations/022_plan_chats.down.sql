@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_access;
+DROP TABLE IF EXISTS plan_chats;
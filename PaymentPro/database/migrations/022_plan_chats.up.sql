@@ -0,0 +1,26 @@
+-- Private chats a subscription plan sells access to, and the access the bot
+-- granted subscribers to them
+
+CREATE TABLE IF NOT EXISTS plan_chats (
+    id SERIAL PRIMARY KEY,
+    plan_id INTEGER NOT NULL REFERENCES subscription_plans(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (plan_id, chat_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_access (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
+    invite_link TEXT NOT NULL DEFAULT '', -- empty when the member was already in the chat
+    granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_at TIMESTAMP,   -- the subscription lapsed; the member is removed after the grace period
+    removed_at TIMESTAMP, -- the member was removed from the chat
+    UNIQUE (user_id, chat_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_access_lapsed ON chat_access(ended_at)
+    WHERE ended_at IS NOT NULL AND removed_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_user_groups_suspended;
+ALTER TABLE user_groups DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,6 @@
+-- Groups beyond the owner's plan limit are suspended, newest first, and get
+-- only basic moderation until the owner upgrades or removes other groups
+
+ALTER TABLE user_groups ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_user_groups_suspended ON user_groups(chat_id) WHERE suspended_at IS NOT NULL;
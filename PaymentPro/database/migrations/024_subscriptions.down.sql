@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS subscription_events;
+DROP TABLE IF EXISTS subscriptions;
+
+ALTER TABLE subscription_plans DROP COLUMN IF EXISTS trial_days;
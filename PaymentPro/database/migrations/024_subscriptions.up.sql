@@ -0,0 +1,49 @@
+-- Subscriptions as records with an explicit status and a log of every status
+-- change. users.current_plan_id and plan_expires_at stay as the plan the
+-- user currently has access to.
+
+ALTER TABLE subscription_plans ADD COLUMN IF NOT EXISTS trial_days INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+    status VARCHAR(20) NOT NULL
+        CHECK (status IN ('trialing', 'active', 'past_due', 'grace', 'canceled', 'expired')),
+    current_period_start TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    current_period_end TIMESTAMP, -- NULL for plans without an end
+    canceled_at TIMESTAMP,        -- renewal was stopped; access lasts until the period ends
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A user has at most one subscription that has not expired
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_live ON subscriptions(user_id) WHERE status <> 'expired';
+CREATE INDEX IF NOT EXISTS idx_subscriptions_due ON subscriptions(current_period_end) WHERE status <> 'expired';
+
+CREATE TABLE IF NOT EXISTS subscription_events (
+    id SERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    from_status VARCHAR(20), -- NULL when the subscription was created
+    to_status VARCHAR(20) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_events_subscription ON subscription_events(subscription_id);
+
+-- Paid plans from before subscriptions were recorded. Lapsed ones go through
+-- past_due and grace like any other.
+WITH migrated AS (
+    INSERT INTO subscriptions (user_id, plan_id, status, current_period_end)
+    SELECT id, current_plan_id, 'active', plan_expires_at
+    FROM users
+    WHERE current_plan_id > 1
+      AND NOT EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = users.id)
+    RETURNING id
+)
+INSERT INTO subscription_events (subscription_id, from_status, to_status, reason)
+SELECT id, NULL, 'active', 'migrated' FROM migrated;
+
+-- New users used to get an expiry on the free plan, which never meant anything
+UPDATE users SET plan_expires_at = NULL WHERE current_plan_id = 1;
@@ -0,0 +1,8 @@
+-- Fails while an address is recorded on several payments; clear
+-- crypto_address on the older ones first
+DROP INDEX IF EXISTS idx_payments_derived_address;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_derived_address
+    ON payments(crypto_address) WHERE derivation_index IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_payments_derivation_wallet;
+ALTER TABLE payments DROP COLUMN IF EXISTS derivation_wallet;
@@ -0,0 +1,22 @@
+-- Derived addresses of expired payments are handed out again to stay within
+-- the gap limit of wallets, so each payment records the account key its
+-- address index belongs to
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS derivation_wallet VARCHAR(100);
+
+-- Earlier addresses belong to the chain's account key when only one was used
+UPDATE payments p
+SET derivation_wallet = w.wallet
+FROM crypto_derivation_indexes w
+WHERE p.derivation_index IS NOT NULL
+  AND p.derivation_wallet IS NULL
+  AND split_part(w.wallet, ':', 1) = CASE WHEN p.payment_data->>'asset' = 'BTC' THEN 'BTC' ELSE 'ETH' END
+  AND (SELECT COUNT(*) FROM crypto_derivation_indexes o
+       WHERE split_part(o.wallet, ':', 1) = split_part(w.wallet, ':', 1)) = 1;
+
+CREATE INDEX IF NOT EXISTS idx_payments_derivation_wallet
+    ON payments(derivation_wallet, derivation_index) WHERE derivation_index IS NOT NULL;
+
+-- A derived address belongs to one open payment at a time
+DROP INDEX IF EXISTS idx_payments_derived_address;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_derived_address
+    ON payments(crypto_address) WHERE derivation_index IS NOT NULL AND status IN ('pending', 'processing');
@@ -0,0 +1,2 @@
+ALTER TABLE chat_access DROP COLUMN IF EXISTS retry_at;
+ALTER TABLE chat_access DROP COLUMN IF EXISTS failed_attempts;
@@ -0,0 +1,4 @@
+-- Members the bot failed to remove are retried later instead of heading every
+-- sweep and holding back the other lapsed members
+ALTER TABLE chat_access ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chat_access ADD COLUMN IF NOT EXISTS retry_at TIMESTAMP; -- not removed again before then
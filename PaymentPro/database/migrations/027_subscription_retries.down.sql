@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS retry_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS failed_attempts;
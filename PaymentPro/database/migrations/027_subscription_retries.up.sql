@@ -0,0 +1,4 @@
+-- Subscriptions that fail to move to their next status are retried later
+-- instead of heading every pass and holding back the others
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS retry_at TIMESTAMP; -- not advanced again before then
@@ -0,0 +1,34 @@
+-- Create function to automatically create subscription activation
+CREATE OR REPLACE FUNCTION create_subscription_activation()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Only create activation when payment is completed
+    IF NEW.status = 'completed' AND OLD.status != 'completed' THEN
+        INSERT INTO subscription_activations (user_id, payment_id, plan_id, expires_at)
+        SELECT 
+            NEW.user_id, 
+            NEW.id, 
+            NEW.plan_id,
+            CURRENT_TIMESTAMP + INTERVAL '1 day' * sp.duration_days
+        FROM subscription_plans sp
+        WHERE sp.id = NEW.plan_id;
+        
+        -- Update user's subscription info
+        UPDATE users 
+        SET 
+            plan_name = (SELECT name FROM subscription_plans WHERE id = NEW.plan_id),
+            plan_expires_at = CURRENT_TIMESTAMP + INTERVAL '1 day' * (SELECT duration_days FROM subscription_plans WHERE id = NEW.plan_id),
+            total_spent = total_spent + NEW.amount
+        WHERE id = NEW.user_id;
+    END IF;
+    
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger for automatic subscription activation
+DROP TRIGGER IF EXISTS trigger_create_subscription_activation ON payments;
+CREATE TRIGGER trigger_create_subscription_activation
+    AFTER UPDATE ON payments
+    FOR EACH ROW
+    EXECUTE FUNCTION create_subscription_activation();
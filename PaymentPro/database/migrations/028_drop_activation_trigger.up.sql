@@ -0,0 +1,6 @@
+-- Subscriptions are activated by the bot since the subscriptions table, in the
+-- transaction that completes the payment. The trigger from 003 would still
+-- extend users.plan_expires_at from the day of payment on every completion and
+-- hand out access the subscription does not grant.
+DROP TRIGGER IF EXISTS trigger_create_subscription_activation ON payments;
+DROP FUNCTION IF EXISTS create_subscription_activation();
@@ -80,7 +80,7 @@ func (h *AdminHandler) handleStats(update tgbotapi.Update) {
         h.db.QueryRow("SELECT COUNT(*) FROM payments WHERE status = 'completed'").Scan(&totalPayments)
         
         // Query total revenue
-        h.db.QueryRow("SELECT COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed'").Scan(&totalRevenue)
+        h.db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed'").Scan(&totalRevenue)
         totalRevenue /= 100 // Convert from cents
 
         // Get today's stats
@@ -89,7 +89,7 @@ func (h *AdminHandler) handleStats(update tgbotapi.Update) {
         
         h.db.QueryRow("SELECT COUNT(*) FROM users WHERE DATE(created_at) = CURRENT_DATE").Scan(&todayUsers)
         h.db.QueryRow("SELECT COUNT(*) FROM payments WHERE status = 'completed' AND DATE(completed_at) = CURRENT_DATE").Scan(&todayPayments)
-        h.db.QueryRow("SELECT COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed' AND DATE(completed_at) = CURRENT_DATE").Scan(&todayRevenue)
+        h.db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed' AND DATE(completed_at) = CURRENT_DATE").Scan(&todayRevenue)
         todayRevenue /= 100
 
         message := "📊 **Admin Statistics**\n\n"
@@ -207,7 +207,7 @@ func (h *AdminHandler) handlePayments(update tgbotapi.Update, args []string) {
         if len(args) == 0 {
                 // Show recent payments
                 rows, err := h.db.Query(`
-                        SELECT p.id, p.amount_cents, p.currency, p.payment_method, p.status, p.created_at, u.first_name, u.username
+                        SELECT p.id, p.amount, p.currency, p.payment_method, p.status, p.created_at, u.first_name, u.username
                         FROM payments p
                         JOIN users u ON p.user_id = u.id
                         ORDER BY p.created_at DESC 
@@ -307,7 +307,7 @@ func (h *AdminHandler) handleGrant(update tgbotapi.Update, args []string) {
         }
         
         expiresAt := time.Now().AddDate(0, 0, days)
-        err = h.userRepo.UpdateSubscription(user.ID, planID, &expiresAt)
+        err = h.subscriptionService.GrantSubscription(user.ID, planID, &expiresAt)
         if err != nil {
                 h.sendMessage(update.Message.Chat.ID, "Error updating subscription")
                 return
@@ -334,7 +334,7 @@ func (h *AdminHandler) handleRevoke(update tgbotapi.Update, args []string) {
                 return
         }
         
-        err = h.userRepo.UpdateSubscription(user.ID, 1, nil) // Reset to free plan
+        err = h.subscriptionService.ExpireSubscription(user.ID)
         if err != nil {
                 h.sendMessage(update.Message.Chat.ID, "Error revoking subscription")
                 return
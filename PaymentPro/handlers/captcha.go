@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	crand "crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/services"
+)
+
+// Участник, вступивший по одобренной заявке, повторно не проверяется
+const captchaRequestGrace = 10 * time.Minute
+
+// CaptchaHandler проверяет новых участников групп, где включена проверка:
+// вступивший участник не может писать, пока не ответит на вопрос бота в группе,
+// а заявку на вступление бот одобряет, только если пользователь ответил в
+// личных сообщениях. Не ответивших вовремя бот удаляет из группы.
+type CaptchaHandler struct {
+	bot          *tgbotapi.BotAPI
+	settings     *services.ModerationSettingsService
+	moderators   *services.ModeratorService
+	entitlements *services.EntitlementService
+	repo         *models.JoinVerificationRepository
+
+	mu     sync.Mutex
+	timers map[int]*time.Timer // по ID проверки
+}
+
+func NewCaptchaHandler(bot *tgbotapi.BotAPI, db *database.DB, settings *services.ModerationSettingsService, moderators *services.ModeratorService, entitlements *services.EntitlementService) *CaptchaHandler {
+	return &CaptchaHandler{
+		bot:          bot,
+		settings:     settings,
+		moderators:   moderators,
+		entitlements: entitlements,
+		repo:         models.NewJoinVerificationRepository(db.DB),
+		timers:       make(map[int]*time.Timer),
+	}
+}
+
+// Resume заново запускает таймеры проверок, не завершенных до перезапуска бота;
+// просроченные завершаются сразу
+func (h *CaptchaHandler) Resume() {
+	pending, err := h.repo.ListPending()
+	if err != nil {
+		log.Printf("Error loading pending join verifications: %v", err)
+		return
+	}
+	for _, v := range pending {
+		h.startTimer(v)
+	}
+}
+
+// Проверка участников, вступивших в группу
+func (h *CaptchaHandler) HandleNewMembers(message *tgbotapi.Message) {
+	settings := h.enforcedSettings(message.Chat.ID)
+	if !settings.ModerationEnabled || !settings.CaptchaEnabled {
+		return
+	}
+
+	for _, member := range message.NewChatMembers {
+		if member.IsBot {
+			continue
+		}
+		// Участников, которых добавил администратор, не проверяем
+		if message.From != nil && message.From.ID != member.ID && h.moderators.IsAdmin(message.Chat.ID, message.From.ID) {
+			continue
+		}
+		if passed, err := h.repo.PassedWithin(message.Chat.ID, member.ID, captchaRequestGrace); err != nil {
+			log.Printf("Error checking join verifications of user %d in chat %d: %v", member.ID, message.Chat.ID, err)
+		} else if passed {
+			continue
+		}
+		if _, err := h.repo.GetPending(message.Chat.ID, member.ID); err == nil {
+			continue
+		}
+
+		h.challengeMember(message.Chat.ID, member, settings)
+	}
+}
+
+func (h *CaptchaHandler) challengeMember(chatID int64, member tgbotapi.User, settings *models.ModerationSettings) {
+	restrict := tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig: tgbotapi.ChatMemberConfig{
+			ChatID: chatID,
+			UserID: member.ID,
+		},
+		Permissions: &tgbotapi.ChatPermissions{},
+	}
+	if _, err := h.bot.Request(restrict); err != nil {
+		// Без прав администратора бот не может ни ограничить, ни удалить участника
+		log.Printf("Error restricting new member %d in chat %d: %v", member.ID, chatID, err)
+		return
+	}
+
+	challenge := newCaptchaChallenge(settings.CaptchaType, chatID)
+	text := fmt.Sprintf("👋 %s, добро пожаловать!\n\n%s\n\nУ вас %d сек., иначе вы будете удалены из группы.",
+		userMention(member), challenge.question, settings.CaptchaTimeout)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = challenge.keyboard
+	sent, err := h.bot.Send(msg)
+	if err != nil {
+		log.Printf("Error sending join challenge to chat %d: %v", chatID, err)
+		h.unrestrict(chatID, member.ID)
+		return
+	}
+
+	h.save(&models.JoinVerification{
+		ChatID:         chatID,
+		TelegramUserID: member.ID,
+		Source:         models.JoinSourceMember,
+		ChallengeType:  settings.CaptchaType,
+		Answer:         challenge.answer,
+		MessageChatID:  chatID,
+		MessageID:      sent.MessageID,
+		ExpiresAt:      time.Now().Add(time.Duration(settings.CaptchaTimeout) * time.Second),
+	})
+}
+
+// Проверка заявок на вступление: вопрос приходит пользователю в личные сообщения.
+// Возвращает false, если в группе проверка выключена и заявку решает вызывающий.
+func (h *CaptchaHandler) HandleJoinRequest(request *tgbotapi.ChatJoinRequest) bool {
+	settings := h.enforcedSettings(request.Chat.ID)
+	if !settings.ModerationEnabled || !settings.CaptchaEnabled {
+		return false
+	}
+	if _, err := h.repo.GetPending(request.Chat.ID, request.From.ID); err == nil {
+		return true
+	}
+
+	challenge := newCaptchaChallenge(settings.CaptchaType, request.Chat.ID)
+	text := fmt.Sprintf("👋 Вы подали заявку на вступление в «%s».\n\n%s\n\nУ вас %d сек., иначе заявка будет отклонена.",
+		tgbotapi.EscapeText(tgbotapi.ModeMarkdown, request.Chat.Title), challenge.question, settings.CaptchaTimeout)
+
+	msg := tgbotapi.NewMessage(request.From.ID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = challenge.keyboard
+	sent, err := h.bot.Send(msg)
+	if err != nil {
+		// Заявка остается администраторам группы
+		log.Printf("Error sending join challenge to user %d: %v", request.From.ID, err)
+		return true
+	}
+
+	h.save(&models.JoinVerification{
+		ChatID:         request.Chat.ID,
+		TelegramUserID: request.From.ID,
+		Source:         models.JoinSourceRequest,
+		ChallengeType:  settings.CaptchaType,
+		Answer:         challenge.answer,
+		MessageChatID:  request.From.ID,
+		MessageID:      sent.MessageID,
+		ExpiresAt:      time.Now().Add(time.Duration(settings.CaptchaTimeout) * time.Second),
+	})
+	return true
+}
+
+// Приостановленные группы сверх лимита плана владельца и группы без
+// basic_moderation новых участников не проверяют
+func (h *CaptchaHandler) enforcedSettings(chatID int64) *models.ModerationSettings {
+	return h.entitlements.ForChat(chatID).ModerationSettings(h.settings.Get(chatID))
+}
+
+// Участник вышел из группы, не ответив
+func (h *CaptchaHandler) HandleLeftMember(message *tgbotapi.Message) {
+	v, err := h.repo.GetPending(message.Chat.ID, message.LeftChatMember.ID)
+	if err != nil {
+		return
+	}
+	if h.resolve(v, models.JoinLeft) {
+		h.deleteChallenge(v)
+	}
+}
+
+// Ответ на вопрос: данные кнопки captcha:<ID группы>:<ответ>
+func (h *CaptchaHandler) HandleCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 {
+		return
+	}
+	chatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	v, err := h.repo.GetPending(chatID, query.From.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.answer(query, "Эта проверка не для вас")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading join verification of user %d in chat %d: %v", query.From.ID, chatID, err)
+		h.answer(query, "Ошибка, попробуйте еще раз")
+		return
+	}
+	if query.Message == nil || query.Message.MessageID != v.MessageID {
+		h.answer(query, "Эта проверка не для вас")
+		return
+	}
+
+	status := models.JoinPassed
+	if parts[2] != v.Answer {
+		status = models.JoinFailed
+	}
+	if !h.resolve(v, status) {
+		h.answer(query, "Проверка уже завершена")
+		return
+	}
+
+	if status == models.JoinFailed {
+		h.answer(query, "❌ Неверный ответ")
+		h.reject(v, "❌ Неверный ответ, заявка отклонена.")
+		return
+	}
+	h.answer(query, "✅ Спасибо!")
+
+	if v.Source == models.JoinSourceRequest {
+		approve := tgbotapi.ApproveChatJoinRequestConfig{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: v.ChatID},
+			UserID:     v.TelegramUserID,
+		}
+		if _, err := h.bot.Request(approve); err != nil {
+			log.Printf("Error approving join request of user %d in chat %d: %v", v.TelegramUserID, v.ChatID, err)
+		}
+		h.editChallenge(v, "✅ Проверка пройдена, заявка одобрена.")
+		return
+	}
+
+	h.unrestrict(v.ChatID, v.TelegramUserID)
+	h.deleteChallenge(v)
+}
+
+// Время на ответ вышло
+func (h *CaptchaHandler) expire(v *models.JoinVerification) {
+	if h.resolve(v, models.JoinTimeout) {
+		h.reject(v, "⏰ Время на ответ вышло, заявка отклонена.")
+	}
+}
+
+// Удаление не прошедшего проверку участника или отклонение его заявки
+func (h *CaptchaHandler) reject(v *models.JoinVerification, requestText string) {
+	if v.Source == models.JoinSourceRequest {
+		decline := tgbotapi.DeclineChatJoinRequest{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: v.ChatID},
+			UserID:     v.TelegramUserID,
+		}
+		if _, err := h.bot.Request(decline); err != nil {
+			log.Printf("Error declining join request of user %d in chat %d: %v", v.TelegramUserID, v.ChatID, err)
+		}
+		h.editChallenge(v, requestText)
+		return
+	}
+
+	// Бан со снятием сразу после него удаляет участника, но позволяет вернуться
+	member := tgbotapi.ChatMemberConfig{ChatID: v.ChatID, UserID: v.TelegramUserID}
+	if _, err := h.bot.Request(tgbotapi.BanChatMemberConfig{ChatMemberConfig: member}); err != nil {
+		log.Printf("Error removing unverified member %d from chat %d: %v", v.TelegramUserID, v.ChatID, err)
+	} else if _, err := h.bot.Request(tgbotapi.UnbanChatMemberConfig{ChatMemberConfig: member, OnlyIfBanned: true}); err != nil {
+		log.Printf("Error unbanning removed member %d in chat %d: %v", v.TelegramUserID, v.ChatID, err)
+	}
+	h.deleteChallenge(v)
+}
+
+// Снятие ограничений: участник получает права, действующие в группе для всех
+func (h *CaptchaHandler) unrestrict(chatID, userID int64) {
+	permissions := &tgbotapi.ChatPermissions{
+		CanSendMessages:       true,
+		CanSendMediaMessages:  true,
+		CanSendPolls:          true,
+		CanSendOtherMessages:  true,
+		CanAddWebPagePreviews: true,
+	}
+	chat, err := h.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil {
+		log.Printf("Error getting permissions of chat %d: %v", chatID, err)
+	} else if chat.Permissions != nil {
+		permissions = chat.Permissions
+	}
+
+	restrict := tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig: tgbotapi.ChatMemberConfig{
+			ChatID: chatID,
+			UserID: userID,
+		},
+		Permissions: permissions,
+	}
+	if _, err := h.bot.Request(restrict); err != nil {
+		log.Printf("Error lifting restrictions of member %d in chat %d: %v", userID, chatID, err)
+	}
+}
+
+func (h *CaptchaHandler) save(v *models.JoinVerification) {
+	if err := h.repo.Create(v); err != nil {
+		log.Printf("Error saving join verification of user %d in chat %d: %v", v.TelegramUserID, v.ChatID, err)
+		return
+	}
+	h.startTimer(v)
+}
+
+func (h *CaptchaHandler) startTimer(v *models.JoinVerification) {
+	wait := time.Until(v.ExpiresAt)
+	if wait < 0 {
+		wait = 0
+	}
+
+	h.mu.Lock()
+	h.timers[v.ID] = time.AfterFunc(wait, func() { h.expire(v) })
+	h.mu.Unlock()
+}
+
+// resolve закрывает проверку и записывает ее итог; false значит, что проверку
+// уже закрыл другой обработчик
+func (h *CaptchaHandler) resolve(v *models.JoinVerification, status string) bool {
+	h.mu.Lock()
+	if timer, ok := h.timers[v.ID]; ok {
+		timer.Stop()
+		delete(h.timers, v.ID)
+	}
+	h.mu.Unlock()
+
+	resolved, err := h.repo.Resolve(v.ID, status)
+	if err != nil {
+		log.Printf("Error resolving join verification %d: %v", v.ID, err)
+		return false
+	}
+	if resolved {
+		log.Printf("Join verification of user %d in chat %d: %s", v.TelegramUserID, v.ChatID, status)
+	}
+	return resolved
+}
+
+func (h *CaptchaHandler) deleteChallenge(v *models.JoinVerification) {
+	if v.MessageID == 0 {
+		return
+	}
+	if _, err := h.bot.Request(tgbotapi.NewDeleteMessage(v.MessageChatID, v.MessageID)); err != nil {
+		log.Printf("Error deleting join challenge %d: %v", v.ID, err)
+	}
+}
+
+func (h *CaptchaHandler) editChallenge(v *models.JoinVerification, text string) {
+	if v.MessageID == 0 {
+		return
+	}
+	if _, err := h.bot.Send(tgbotapi.NewEditMessageText(v.MessageChatID, v.MessageID, text)); err != nil {
+		log.Printf("Error updating join challenge %d: %v", v.ID, err)
+	}
+}
+
+func (h *CaptchaHandler) answer(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := h.bot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("Error answering callback: %v", err)
+	}
+}
+
+type captchaChallenge struct {
+	question string
+	answer   string
+	keyboard tgbotapi.InlineKeyboardMarkup
+}
+
+// newCaptchaChallenge составляет вопрос: кнопку или пример на сложение с
+// четырьмя вариантами ответа
+func newCaptchaChallenge(kind string, chatID int64) captchaChallenge {
+	data := func(answer string) string {
+		return fmt.Sprintf("captcha:%d:%s", chatID, answer)
+	}
+
+	if kind != models.CaptchaMath {
+		return captchaChallenge{
+			question: "Подтвердите, что вы не бот: нажмите кнопку ниже.",
+			answer:   "ok",
+			keyboard: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ Я не бот", data("ok")),
+			)),
+		}
+	}
+
+	a, b := randomInt(9)+1, randomInt(9)+1
+	sum := a + b
+
+	// Три неверных варианта рядом с верным, все разные
+	options := []int{sum}
+	for len(options) < 4 {
+		option := sum + randomInt(11) - 5
+		if option < 0 || containsInt(options, option) {
+			continue
+		}
+		options = append(options, option)
+	}
+	for i := len(options) - 1; i > 0; i-- {
+		j := randomInt(i + 1)
+		options[i], options[j] = options[j], options[i]
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, option := range options {
+		value := strconv.Itoa(option)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(value, data(value)))
+	}
+
+	return captchaChallenge{
+		question: fmt.Sprintf("Подтвердите, что вы не бот: сколько будет %d + %d?", a, b),
+		answer:   strconv.Itoa(sum),
+		keyboard: tgbotapi.NewInlineKeyboardMarkup(row),
+	}
+}
+
+func randomInt(n int) int {
+	value, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return int(value.Int64())
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Упоминание пользователя Telegram в Markdown
+func userMention(user tgbotapi.User) string {
+	if user.UserName != "" {
+		return "@" + tgbotapi.EscapeText(tgbotapi.ModeMarkdown, user.UserName)
+	}
+	return fmt.Sprintf("[%s](tg://user?id=%d)", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, user.FirstName), user.ID)
+}
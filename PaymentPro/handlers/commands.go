@@ -1,7 +1,12 @@
 package handlers
 
 import (
+        crand "crypto/rand"
+        "database/sql"
+        "errors"
         "fmt"
+        "log"
+        "math/big"
         "math/rand"
         "os"
         "strconv"
@@ -22,14 +27,16 @@ type CommandHandler struct {
         paymentService      *services.PaymentService
         userRepo            *models.UserRepository
         planRepo            *models.SubscriptionRepository
+        authService         *services.AuthService
 }
 
-func NewCommandHandler(bot *tgbotapi.BotAPI, db *database.DB, subscriptionService *services.SubscriptionService, paymentService *services.PaymentService) *CommandHandler {
+func NewCommandHandler(bot *tgbotapi.BotAPI, db *database.DB, subscriptionService *services.SubscriptionService, paymentService *services.PaymentService, authService *services.AuthService) *CommandHandler {
         return &CommandHandler{
                 bot:                 bot,
                 db:                  db,
                 subscriptionService: subscriptionService,
                 paymentService:      paymentService,
+                authService:         authService,
                 userRepo:            models.NewUserRepository(db.DB),
                 planRepo:            models.NewSubscriptionRepository(db.DB),
         }
@@ -50,7 +57,7 @@ func (h *CommandHandler) Handle(update tgbotapi.Update) {
 
         switch command {
         case "start":
-                h.handleStart(update, user)
+                h.handleStart(update, user, args)
         case "help":
                 h.handleHelp(update, user)
         case "plans":
@@ -73,6 +80,8 @@ func (h *CommandHandler) Handle(update tgbotapi.Update) {
                 h.handleID(update, user)
         case "violations":
                 h.handleViolations(update, user)
+        case "login":
+                h.handleLogin(update, user)
         default:
                 h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "unknown_command"))
         }
@@ -111,16 +120,32 @@ func (h *CommandHandler) HandleCallback(update tgbotapi.Update) {
                         planID, _ := strconv.Atoi(parts[1])
                         h.handleSubscribeCallback(update, user, planID)
                 }
+        case "trial":
+                if len(parts) > 1 {
+                        planID, _ := strconv.Atoi(parts[1])
+                        h.handleTrial(update, user, planID)
+                }
+        case "pay":
+                if len(parts) > 2 {
+                        planID, _ := strconv.Atoi(parts[2])
+                        h.handleProviderPayment(update, user, parts[1], planID, "")
+                }
         case "pay_card":
+                // Buttons sent before providers were configurable
                 if len(parts) > 1 {
                         planID, _ := strconv.Atoi(parts[1])
-                        h.handleCardPayment(update, user, planID)
+                        h.handleProviderPayment(update, user, "telegram", planID, "")
                 }
         case "pay_crypto":
                 if len(parts) > 1 {
                         planID, _ := strconv.Atoi(parts[1])
                         h.handleCryptoPayment(update, user, planID)
                 }
+        case "crypto_pay":
+                if len(parts) > 2 {
+                        planID, _ := strconv.Atoi(parts[1])
+                        h.handleCryptoPay(update, user, planID, parts[2])
+                }
         case "back_to_menu":
                 h.handleBackToMenu(update, user)
         case "change_password":
@@ -134,7 +159,13 @@ func (h *CommandHandler) HandleCallback(update tgbotapi.Update) {
         h.bot.Send(callback)
 }
 
-func (h *CommandHandler) handleStart(update tgbotapi.Update, user *models.User) {
+func (h *CommandHandler) handleStart(update tgbotapi.Update, user *models.User, args []string) {
+        // Deep link t.me/<bot>?start=subscribe_<plan_id> opens the plan's payment options
+        if len(args) > 0 && strings.HasPrefix(args[0], "subscribe_") {
+                h.handleSubscribe(update, user, []string{strings.TrimPrefix(args[0], "subscribe_")})
+                return
+        }
+
         message := locales.GetMessage(user.LanguageCode, "welcome")
         
         // Create main menu keyboard
@@ -223,11 +254,30 @@ func (h *CommandHandler) handleMyPlan(update tgbotapi.Update, user *models.User)
                 }
         }
         
+        message += h.subscriptionStatus(user)
         message += fmt.Sprintf("\n%s: %d", locales.GetMessage(user.LanguageCode, "max_groups"), plan.MaxGroups)
         
         h.sendMessage(update.Message.Chat.ID, message)
 }
 
+// subscriptionStatus describes the user's subscription for /myplan; empty
+// without one
+func (h *CommandHandler) subscriptionStatus(user *models.User) string {
+        sub, err := h.subscriptionService.Subscription(user.ID)
+        if err != nil {
+                if !errors.Is(err, services.ErrNoSubscription) {
+                        log.Printf("Error loading subscription of user %d: %v", user.ID, err)
+                }
+                return ""
+        }
+        
+        status := locales.GetMessage(user.LanguageCode, "status_"+sub.Status)
+        if sub.CurrentPeriodEnd != nil && (sub.Status == models.SubscriptionTrialing || sub.Status == models.SubscriptionCanceled) {
+                status = fmt.Sprintf(status, sub.CurrentPeriodEnd.Format("2006-01-02"))
+        }
+        return fmt.Sprintf("%s: %s\n", locales.GetMessage(user.LanguageCode, "subscription_status"), status)
+}
+
 func (h *CommandHandler) handleSubscribe(update tgbotapi.Update, user *models.User, args []string) {
         if len(args) == 0 {
                 h.handlePlans(update, user)
@@ -269,12 +319,13 @@ func (h *CommandHandler) handleSubscribePlan(update tgbotapi.Update, user *model
         keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{cardBtn})
         
         // Crypto payment buttons
-        cryptoRow := []tgbotapi.InlineKeyboardButton{
-                tgbotapi.NewInlineKeyboardButtonData("₿ Bitcoin", fmt.Sprintf("crypto_pay:%d:BTC", planID)),
-                tgbotapi.NewInlineKeyboardButtonData("Ξ Ethereum", fmt.Sprintf("crypto_pay:%d:ETH", planID)),
-                tgbotapi.NewInlineKeyboardButtonData("₮ USDT", fmt.Sprintf("crypto_pay:%d:USDT", planID)),
+        if row := h.cryptoAssetButtons(planID); len(row) > 0 {
+                keyboard = append(keyboard, row)
+        }
+        
+        if row := h.trialButton(user, plan); len(row) > 0 {
+                keyboard = append(keyboard, row)
         }
-        keyboard = append(keyboard, cryptoRow)
         
         var chatID int64
         if update.Message != nil {
@@ -288,14 +339,24 @@ func (h *CommandHandler) handleSubscribePlan(update tgbotapi.Update, user *model
         h.bot.Send(msg)
 }
 
+// Отмена прекращает продление: оплаченный период или пробный доступ остаются до конца
 func (h *CommandHandler) handleCancel(update tgbotapi.Update, user *models.User) {
-        // Reset user to free plan
-        err := h.userRepo.UpdateSubscription(user.ID, 1, nil)
+        sub, err := h.subscriptionService.CancelSubscription(user.ID)
+        if errors.Is(err, services.ErrNoSubscription) {
+                h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "no_subscription"))
+                return
+        }
         if err != nil {
+                log.Printf("Error canceling subscription of user %d: %v", user.ID, err)
                 h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "error_occurred"))
                 return
         }
         
+        if sub.Status == models.SubscriptionCanceled {
+                message := fmt.Sprintf(locales.GetMessage(user.LanguageCode, "subscription_cancel_at_period_end"), sub.CurrentPeriodEnd.Format("2006-01-02"))
+                h.sendMessage(update.Message.Chat.ID, message)
+                return
+        }
         h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "subscription_cancelled"))
 }
 
@@ -322,6 +383,10 @@ func (h *CommandHandler) handleHistory(update tgbotapi.Update, user *models.User
                         status = "⏳ " + locales.GetMessage(user.LanguageCode, "pending")
                 } else if status == "failed" {
                         status = "❌ " + locales.GetMessage(user.LanguageCode, "failed")
+                } else if status == "underpaid" {
+                        status = "⚠️ " + locales.GetMessage(user.LanguageCode, "underpaid")
+                } else if status == "expired" {
+                        status = "⌛ " + locales.GetMessage(user.LanguageCode, "expired")
                 }
                 
                 message += fmt.Sprintf("💰 %.2f %s - %s\n", float64(payment.Amount)/100, payment.Currency, status)
@@ -349,33 +414,79 @@ func (h *CommandHandler) handleCrypto(update tgbotapi.Update, user *models.User,
 }
 
 func (h *CommandHandler) handleCryptoPay(update tgbotapi.Update, user *models.User, planID int, currency string) {
-        _, err := h.planRepo.GetByID(planID)
+        h.handleProviderPayment(update, user, "crypto", planID, currency)
+}
+
+// handleProviderPayment opens a checkout with the chosen provider and tells the
+// user how to finish it: a payment link, a deposit address, or (for Telegram
+// invoices) nothing, since the invoice itself is already in the chat.
+func (h *CommandHandler) handleProviderPayment(update tgbotapi.Update, user *models.User, providerName string, planID int, asset string) {
+        var chatID int64
+        if update.Message != nil {
+                chatID = update.Message.Chat.ID
+        } else if update.CallbackQuery != nil {
+                chatID = update.CallbackQuery.Message.Chat.ID
+        }
+
+        _, checkout, err := h.paymentService.CreateCheckout(user, planID, providerName, services.CheckoutOptions{
+                ChatID: chatID,
+                Asset:  asset,
+        })
+        if errors.Is(err, services.ErrProviderNotAvailable) {
+                h.sendMessage(chatID, locales.GetMessage(user.LanguageCode, "payment_unavailable"))
+                return
+        }
+        if errors.Is(err, sql.ErrNoRows) {
+                h.sendMessage(chatID, locales.GetMessage(user.LanguageCode, "plan_not_found"))
+                return
+        }
         if err != nil {
-                h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "plan_not_found"))
+                h.sendMessage(chatID, locales.GetMessage(user.LanguageCode, "error_occurred"))
                 return
         }
 
-        _, err = h.paymentService.CreateCryptoPayment(user.ID, planID, currency)
-        if err != nil {
-                h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "error_occurred"))
+        if checkout.URL != "" {
+                msg := tgbotapi.NewMessage(chatID, locales.GetMessage(user.LanguageCode, "payment_link"))
+                msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+                        tgbotapi.NewInlineKeyboardRow(
+                                tgbotapi.NewInlineKeyboardButtonURL("💳 "+locales.GetMessage(user.LanguageCode, "pay_now"), checkout.URL),
+                        ),
+                )
+                h.bot.Send(msg)
                 return
         }
 
-        message := fmt.Sprintf("%s %s\n\n", locales.GetMessage(user.LanguageCode, "crypto_payment_instructions"), currency)
-        message += fmt.Sprintf("%s: `%s`\n", locales.GetMessage(user.LanguageCode, "address"), "Address from description")
-        message += fmt.Sprintf("%s: `%s`\n", locales.GetMessage(user.LanguageCode, "amount"), "Amount from description")
-        message += fmt.Sprintf("\n%s", locales.GetMessage(user.LanguageCode, "crypto_payment_note"))
-        
-        var chatID int64
-        if update.Message != nil {
-                chatID = update.Message.Chat.ID
-        } else if update.CallbackQuery != nil {
-                chatID = update.CallbackQuery.Message.Chat.ID
+        if address := checkout.Data["address"]; address != "" {
+                message := fmt.Sprintf("%s %s\n\n", locales.GetMessage(user.LanguageCode, "crypto_payment_instructions"), checkout.Data["asset"])
+                message += fmt.Sprintf("%s: `%s`\n", locales.GetMessage(user.LanguageCode, "address"), address)
+                message += fmt.Sprintf("%s: `%s %s`\n", locales.GetMessage(user.LanguageCode, "amount"), checkout.Data["amount"], checkout.Data["asset"])
+                if checkout.Quote != nil {
+                        message += fmt.Sprintf("%s: %s UTC\n", locales.GetMessage(user.LanguageCode, "quote_valid_until"), checkout.Quote.ExpiresAt.UTC().Format("2006-01-02 15:04"))
+                }
+                message += fmt.Sprintf("\n%s", locales.GetMessage(user.LanguageCode, "crypto_payment_note"))
+
+                msg := tgbotapi.NewMessage(chatID, message)
+                msg.ParseMode = "Markdown"
+                h.bot.Send(msg)
         }
-        
-        msg := tgbotapi.NewMessage(chatID, message)
-        msg.ParseMode = "Markdown"
-        h.bot.Send(msg)
+}
+
+var cryptoAssetLabels = map[string]string{
+        "BTC":  "₿ Bitcoin",
+        "ETH":  "Ξ Ethereum",
+        "USDT": "₮ USDT",
+}
+
+func (h *CommandHandler) cryptoAssetButtons(planID int) []tgbotapi.InlineKeyboardButton {
+        var row []tgbotapi.InlineKeyboardButton
+        for _, asset := range h.paymentService.ProviderAssets("crypto") {
+                label, ok := cryptoAssetLabels[asset]
+                if !ok {
+                        label = asset
+                }
+                row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("crypto_pay:%d:%s", planID, asset)))
+        }
+        return row
 }
 
 func (h *CommandHandler) ensureUser(from *tgbotapi.User) *models.User {
@@ -424,27 +535,33 @@ func (h *CommandHandler) handleID(update tgbotapi.Update, user *models.User) {
         h.bot.Send(msg)
 }
 
-func (h *CommandHandler) ensureWebAccount(user *models.User) {
-        if user.WebUsername != "" {
-                return
-        }
-        
-        // Генерируем данные для веб-аккаунта
-        username := h.generateRandomUsername()
-        password := h.generateRandomPassword()
-        
-        err := h.userRepo.UpdateWebCredentials(user.ID, username, password)
+// sendLoginLink sends a one-time dashboard sign-in link as a button; Telegram
+// does not preview buttons, so the link is not used up before the user taps it
+func (h *CommandHandler) sendLoginLink(chatID int64, user *models.User, next string) {
+        link, err := h.authService.CreateLoginLink(user, next)
         if err != nil {
+                log.Printf("Failed to create login link for user %d: %v", user.ID, err)
+                h.sendMessage(chatID, locales.GetMessage(user.LanguageCode, "login_link_error"))
                 return
         }
         
-        user.WebUsername = username
-        user.WebPassword = password
-        user.IsWebActive = true
+        msg := tgbotapi.NewMessage(chatID, locales.GetMessage(user.LanguageCode, "login_link"))
+        msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonURL("🔐 "+locales.GetMessage(user.LanguageCode, "open_dashboard"), link),
+                ),
+        )
+        h.bot.Send(msg)
 }
 
-func (h *CommandHandler) generateUserToken(user *models.User) string {
-        return fmt.Sprintf("user_%d_%s", user.ID, h.generateRandomPassword()[:32])
+func (h *CommandHandler) handleLogin(update tgbotapi.Update, user *models.User) {
+        // A link posted in a group would sign in whoever taps it first
+        if update.Message.Chat.Type != "private" {
+                h.sendMessage(update.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "login_private_only"))
+                return
+        }
+        
+        h.sendLoginLink(update.Message.Chat.ID, user, "")
 }
 
 func (h *CommandHandler) sendMessage(chatID int64, text string) {
@@ -677,6 +794,7 @@ func (h *CommandHandler) handleMyPlanCallback(update tgbotapi.Update, user *mode
         if user.PlanExpiresAt != nil {
                 message += fmt.Sprintf("📅 Истекает: %s\n", user.PlanExpiresAt.Format("2006-01-02"))
         }
+        message += h.subscriptionStatus(user)
         
         keyboard := tgbotapi.NewInlineKeyboardMarkup(
                 tgbotapi.NewInlineKeyboardRow(
@@ -722,50 +840,29 @@ func (h *CommandHandler) handleHistoryCallback(update tgbotapi.Update, user *mod
 }
 
 func (h *CommandHandler) handleMyAccountCallback(update tgbotapi.Update, user *models.User) {
-        var username, password string
+        chatID := update.CallbackQuery.Message.Chat.ID
         
-        // Check if user already has web account
-        if user.WebUsername != "" && user.IsWebActive {
-                username = user.WebUsername
-                password = user.WebPassword
+        message := "🔗 **Ваш личный кабинет**\n\n"
+        message += "Войти можно по одноразовой ссылке ниже, через кнопку «Войти через Telegram» на сайте или командой /login.\n\n"
+        if user.WebUsername != "" && user.WebPassword != "" {
+                message += fmt.Sprintf("👤 Логин для входа по паролю: `%s`\n\n", user.WebUsername)
         } else {
-                // Create new web account with random credentials
-                username = h.generateRandomUsername()
-                password = h.generateRandomPassword()
-                
-                // Save to database
-                err := h.userRepo.CreateWebAccount(user.ID, username, password)
-                if err != nil {
-                        h.sendCallbackMessage(update.CallbackQuery.Message.Chat.ID, "Ошибка создания аккаунта")
-                        return
-                }
-        }
-        
-        // Get website URL and generate token
-        websiteURL := "http://localhost:5000"
-        if domain := os.Getenv("REPLIT_DOMAIN"); domain != "" {
-                websiteURL = fmt.Sprintf("https://%s", domain)
+                message += "🔑 Пароль не нужен, но его можно задать кнопкой «Задать пароль».\n\n"
         }
-        
-        // Generate user token for URL
-        userToken := h.generateUserToken(user)
-        dashboardURL := fmt.Sprintf("%s/user-dashboard?token=%s", websiteURL, userToken)
-        
-        message := "🔗 **Ваш личный кабинет**\n\n"
-        message += fmt.Sprintf("🌐 Прямая ссылка: `%s`\n\n", dashboardURL)
-        message += "📝 Данные для входа:\n"
-        message += fmt.Sprintf("👤 Логин: `%s`\n", username)
-        message += fmt.Sprintf("🔑 Пароль: `%s`\n\n", password)
-        message += "⚠️ **Важно!** Сохраните эти данные в безопасном месте.\n\n"
         message += "В личном кабинете:\n"
         message += "• Статистика использования\n"
         message += "• Управление подписками\n"
         message += "• История платежей\n"
         message += "• Настройки аккаунта\n"
         
+        passwordButton := "🔑 Сменить пароль"
+        if user.WebPassword == "" {
+                passwordButton = "🔑 Задать пароль"
+        }
+        
         keyboard := tgbotapi.NewInlineKeyboardMarkup(
                 tgbotapi.NewInlineKeyboardRow(
-                        tgbotapi.NewInlineKeyboardButtonData("🔑 Сменить пароль", "change_password"),
+                        tgbotapi.NewInlineKeyboardButtonData(passwordButton, "change_password"),
                         tgbotapi.NewInlineKeyboardButtonData("👤 Сменить логин", "change_username"),
                 ),
                 tgbotapi.NewInlineKeyboardRow(
@@ -773,10 +870,12 @@ func (h *CommandHandler) handleMyAccountCallback(update tgbotapi.Update, user *m
                 ),
         )
         
-        msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, message)
+        msg := tgbotapi.NewMessage(chatID, message)
         msg.ReplyMarkup = keyboard
         msg.ParseMode = "Markdown"
         h.bot.Send(msg)
+        
+        h.sendLoginLink(chatID, user, "")
 }
 
 func (h *CommandHandler) handleSetupCallback(update tgbotapi.Update, user *models.User) {
@@ -800,14 +899,8 @@ func (h *CommandHandler) handleSetupCallback(update tgbotapi.Update, user *model
 }
 
 func (h *CommandHandler) handleManageGroupsCallback(update tgbotapi.Update, user *models.User) {
-        // Проверяем, есть ли веб-аккаунт у пользователя
-        if user.WebUsername == "" {
-                h.ensureWebAccount(user)
-        }
-        
         message := "🔧 **Управление группами**\n\n"
-        message += "🌐 Откройте веб-интерфейс для управления группами:\n"
-        message += fmt.Sprintf("`http://localhost:5000/groups?token=%s`\n\n", h.generateUserToken(user))
+        message += "🌐 Откройте веб-интерфейс для управления группами по ссылке ниже.\n\n"
         message += "В веб-интерфейсе вы можете:\n"
         message += "• Добавить новые группы и каналы\n"
         message += "• Просмотреть статистику групп\n"
@@ -819,11 +912,18 @@ func (h *CommandHandler) handleManageGroupsCallback(update tgbotapi.Update, user
         message += "3. Напишите в группе: `/id`\n"
         message += "4. Скопируйте ID и добавьте в веб-интерфейсе"
         
-        keyboard := tgbotapi.NewInlineKeyboardMarkup(
-                tgbotapi.NewInlineKeyboardRow(
-                        tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", "back_to_menu"),
-                ),
-        )
+        var rows [][]tgbotapi.InlineKeyboardButton
+        if link, err := h.authService.CreateLoginLink(user, "/groups"); err == nil {
+                rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonURL("🔐 "+locales.GetMessage(user.LanguageCode, "open_dashboard"), link),
+                ))
+        } else {
+                log.Printf("Failed to create login link for user %d: %v", user.ID, err)
+        }
+        rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+                tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", "back_to_menu"),
+        ))
+        keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
         
         msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, message)
         msg.ParseMode = "Markdown"
@@ -863,42 +963,64 @@ func (h *CommandHandler) handleSubscribeCallback(update tgbotapi.Update, user *m
         message += fmt.Sprintf("👥 До %d групп\n\n", plan.MaxGroups)
         message += "Выберите способ оплаты:"
 
-        keyboard := tgbotapi.NewInlineKeyboardMarkup(
-                tgbotapi.NewInlineKeyboardRow(
-                        tgbotapi.NewInlineKeyboardButtonData("💳 Карта", fmt.Sprintf("pay_card:%d", planID)),
-                        tgbotapi.NewInlineKeyboardButtonData("₿ Крипто", fmt.Sprintf("pay_crypto:%d", planID)),
-                ),
-                tgbotapi.NewInlineKeyboardRow(
-                        tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", "show_plans"),
-                ),
-        )
+        var rows [][]tgbotapi.InlineKeyboardButton
+        for _, provider := range h.paymentService.ActiveProviders() {
+                data := fmt.Sprintf("pay:%s:%d", provider.Name, planID)
+                if provider.Name == "crypto" {
+                        // Crypto asks for the coin first
+                        data = fmt.Sprintf("pay_crypto:%d", planID)
+                }
+                rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(provider.DisplayName, data),
+                ))
+        }
+        if row := h.trialButton(user, plan); len(row) > 0 {
+                rows = append(rows, row)
+        }
+        rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+                tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", "show_plans"),
+        ))
         
         msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, message)
-        msg.ReplyMarkup = keyboard
+        msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
         h.bot.Send(msg)
 }
 
-func (h *CommandHandler) handleCardPayment(update tgbotapi.Update, user *models.User, planID int) {
-        plan, err := h.planRepo.GetByID(planID)
+// trialButton предлагает пробный период плана тем, у кого еще не было подписки
+func (h *CommandHandler) trialButton(user *models.User, plan *models.SubscriptionPlan) []tgbotapi.InlineKeyboardButton {
+        eligible, err := h.subscriptionService.CanStartTrial(user.ID, plan)
         if err != nil {
-                h.sendCallbackMessage(update.CallbackQuery.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "plan_not_found"))
-                return
+                log.Printf("Error checking trial of user %d: %v", user.ID, err)
+                return nil
         }
+        if !eligible {
+                return nil
+        }
+        
+        label := fmt.Sprintf(locales.GetMessage(user.LanguageCode, "trial_button"), plan.TrialDays)
+        return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("trial:%d", plan.ID)))
+}
 
-        invoice := tgbotapi.NewInvoice(
-                update.CallbackQuery.Message.Chat.ID,
-                fmt.Sprintf("Подписка %s", plan.Name),
-                fmt.Sprintf("Подписка на %d дней", plan.DurationDays),
-                fmt.Sprintf("plan_%d", planID),
-                "XTR",
-                "XTR",
-                "test-token",
-                []tgbotapi.LabeledPrice{
-                        {Label: plan.Name, Amount: plan.PriceCents},
-                },
-        )
+func (h *CommandHandler) handleTrial(update tgbotapi.Update, user *models.User, planID int) {
+        chatID := update.CallbackQuery.Message.Chat.ID
         
-        h.bot.Send(invoice)
+        sub, err := h.subscriptionService.StartTrial(user.ID, planID)
+        if errors.Is(err, services.ErrTrialUnavailable) {
+                h.sendCallbackMessage(chatID, locales.GetMessage(user.LanguageCode, "trial_unavailable"))
+                return
+        }
+        if errors.Is(err, sql.ErrNoRows) {
+                h.sendCallbackMessage(chatID, locales.GetMessage(user.LanguageCode, "plan_not_found"))
+                return
+        }
+        if err != nil {
+                log.Printf("Error starting trial of plan %d for user %d: %v", planID, user.ID, err)
+                h.sendCallbackMessage(chatID, locales.GetMessage(user.LanguageCode, "error_occurred"))
+                return
+        }
+        
+        message := fmt.Sprintf(locales.GetMessage(user.LanguageCode, "trial_started"), sub.CurrentPeriodEnd.Format("2006-01-02"))
+        h.sendCallbackMessage(chatID, message)
 }
 
 func (h *CommandHandler) handleCryptoPayment(update tgbotapi.Update, user *models.User, planID int) {
@@ -908,22 +1030,25 @@ func (h *CommandHandler) handleCryptoPayment(update tgbotapi.Update, user *model
                 return
         }
 
+        assetRow := h.cryptoAssetButtons(planID)
+        if len(assetRow) == 0 {
+                h.sendCallbackMessage(update.CallbackQuery.Message.Chat.ID, locales.GetMessage(user.LanguageCode, "payment_unavailable"))
+                return
+        }
+
         message := fmt.Sprintf("₿ Крипто оплата\n\n")
         message += fmt.Sprintf("💎 План: %s\n", plan.Name)
-        message += fmt.Sprintf("💰 Сумма: %.2f USD\n\n", float64(plan.PriceCents)/100)
-        message += "BTC: `1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa`\n"
-        message += "ETH: `0x742d35Cc6634C0532925a3b8D41234567890`\n"
-        message += "USDT: `TQKHhV5A1234567890abcdefghijklmnopqrst`\n\n"
-        message += "Отправьте точную сумму. Активация автоматически."
+        message += fmt.Sprintf("💰 Сумма: %.2f %s\n\n", float64(plan.PriceCents)/100, plan.Currency)
+        message += locales.GetMessage(user.LanguageCode, "choose_crypto")
 
         keyboard := tgbotapi.NewInlineKeyboardMarkup(
+                assetRow,
                 tgbotapi.NewInlineKeyboardRow(
                         tgbotapi.NewInlineKeyboardButtonData("🔙 Назад", fmt.Sprintf("subscribe:%d", planID)),
                 ),
         )
         
         msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, message)
-        msg.ParseMode = "Markdown"
         msg.ReplyMarkup = keyboard
         h.bot.Send(msg)
 }
@@ -957,11 +1082,14 @@ func (h *CommandHandler) handleBackToMenu(update tgbotapi.Update, user *models.U
 }
 
 func (h *CommandHandler) generateRandomPassword() string {
-        rand.Seed(time.Now().UnixNano())
         chars := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
         password := make([]byte, 12)
         for i := range password {
-                password[i] = chars[rand.Intn(len(chars))]
+                n, err := crand.Int(crand.Reader, big.NewInt(int64(len(chars))))
+                if err != nil {
+                        panic(fmt.Sprintf("crypto/rand failed: %v", err))
+                }
+                password[i] = chars[n.Int64()]
         }
         return string(password)
 }
@@ -989,13 +1117,28 @@ func (h *CommandHandler) generateRandomUsername() string {
 func (h *CommandHandler) handleChangePasswordCallback(update tgbotapi.Update, user *models.User) {
         newPassword := h.generateRandomPassword()
         
-        err := h.userRepo.UpdateWebPassword(user.ID, newPassword)
+        // Users who only signed in with Telegram get a login name along with the password
+        username := user.WebUsername
+        if username == "" {
+                username = h.generateRandomUsername()
+        }
+        
+        passwordHash, err := services.HashPassword(newPassword)
+        if err == nil {
+                err = h.userRepo.UpdateWebCredentials(user.ID, username, passwordHash)
+        }
         if err != nil {
                 h.sendCallbackMessage(update.CallbackQuery.Message.Chat.ID, "❌ Ошибка при смене пароля")
                 return
         }
         
+        // Sessions opened with the old password end with it
+        if err := h.authService.RevokeUserSessions(user.ID); err != nil {
+                log.Printf("Failed to end dashboard sessions of user %d: %v", user.ID, err)
+        }
+        
         message := "🔑 **Пароль успешно изменен!**\n\n"
+        message += fmt.Sprintf("Логин: `%s`\n", username)
         message += fmt.Sprintf("Новый пароль: `%s`\n\n", newPassword)
         message += "⚠️ **Важно!** Сохраните новый пароль в безопасном месте."
         
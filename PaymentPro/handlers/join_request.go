@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/locales"
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/services"
+)
+
+// JoinRequestHandler решает заявки на вступление в чаты, доступ в которые
+// продается по подписке: заявку подписчика нужного плана бот одобряет (после
+// проверки, если она включена), остальным отказывает и присылает ссылку на
+// оформление подписки. Заявки в остальные чаты уходят проверке новых участников.
+type JoinRequestHandler struct {
+	bot           *tgbotapi.BotAPI
+	subscriptions *services.SubscriptionService
+	chatAccess    *services.ChatAccessService
+	planRepo      *models.SubscriptionRepository
+	captcha       *CaptchaHandler
+}
+
+func NewJoinRequestHandler(bot *tgbotapi.BotAPI, db *database.DB, subscriptions *services.SubscriptionService, chatAccess *services.ChatAccessService, captcha *CaptchaHandler) *JoinRequestHandler {
+	return &JoinRequestHandler{
+		bot:           bot,
+		subscriptions: subscriptions,
+		chatAccess:    chatAccess,
+		planRepo:      models.NewSubscriptionRepository(db.DB),
+		captcha:       captcha,
+	}
+}
+
+func (h *JoinRequestHandler) HandleJoinRequest(request *tgbotapi.ChatJoinRequest) {
+	grants, err := h.chatAccess.ChatPlans(request.Chat.ID)
+	if err != nil {
+		// Заявка остается администраторам чата
+		log.Printf("Error loading plans of chat %d: %v", request.Chat.ID, err)
+		return
+	}
+	if len(grants) == 0 {
+		h.captcha.HandleJoinRequest(request)
+		return
+	}
+
+	user, sub, err := h.subscriptions.CheckSubscriptionStatus(request.From.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error checking subscription of user %d: %v", request.From.ID, err)
+		return
+	}
+
+	if err == nil && sub != nil {
+		for _, grant := range grants {
+			if grant.PlanID != sub.PlanID {
+				continue
+			}
+			if err := h.chatAccess.Admit(user.ID, request.Chat.ID, grant.PlanID); err != nil {
+				log.Printf("Error recording access of user %d to chat %d: %v", user.ID, request.Chat.ID, err)
+			}
+			// При включенной проверке заявку одобрит она
+			if !h.captcha.HandleJoinRequest(request) {
+				h.approve(request)
+			}
+			return
+		}
+	}
+
+	h.decline(request, grants)
+}
+
+func (h *JoinRequestHandler) approve(request *tgbotapi.ChatJoinRequest) {
+	approve := tgbotapi.ApproveChatJoinRequestConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: request.Chat.ID},
+		UserID:     request.From.ID,
+	}
+	if _, err := h.bot.Request(approve); err != nil {
+		log.Printf("Error approving join request of user %d in chat %d: %v", request.From.ID, request.Chat.ID, err)
+	}
+}
+
+// Отказ с предложением оформить один из планов, в которые входит чат
+func (h *JoinRequestHandler) decline(request *tgbotapi.ChatJoinRequest, grants []*models.PlanChat) {
+	decline := tgbotapi.DeclineChatJoinRequest{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: request.Chat.ID},
+		UserID:     request.From.ID,
+	}
+	if _, err := h.bot.Request(decline); err != nil {
+		log.Printf("Error declining join request of user %d in chat %d: %v", request.From.ID, request.Chat.ID, err)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, grant := range grants {
+		plan, err := h.planRepo.GetByID(grant.PlanID)
+		if err != nil {
+			log.Printf("Error loading plan %d: %v", grant.PlanID, err)
+			continue
+		}
+		label := fmt.Sprintf("💎 %s — %.2f %s", plan.Name, float64(plan.PriceCents)/100, plan.Currency)
+		link := fmt.Sprintf("https://t.me/%s?start=subscribe_%d", h.bot.Self.UserName, plan.ID)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(label, link)))
+	}
+
+	text := fmt.Sprintf(locales.GetMessage(request.From.LanguageCode, "join_request_declined"), request.Chat.Title)
+	msg := tgbotapi.NewMessage(request.From.ID, text)
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	if _, err := h.bot.Send(msg); err != nil {
+		log.Printf("Error sending subscription offer to user %d: %v", request.From.ID, err)
+	}
+}
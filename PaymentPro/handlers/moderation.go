@@ -1,39 +1,51 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"telegram-subscription-bot/database"
 	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/services"
 )
 
 type ModerationHandler struct {
-	bot      *tgbotapi.BotAPI
-	db       *database.DB
-	userRepo *models.UserRepository
+	bot          *tgbotapi.BotAPI
+	db           *database.DB
+	userRepo     *models.UserRepository
+	settings     *services.ModerationSettingsService
+	words        *services.ForbiddenWordService
+	links        *services.LinkFilterService
+	flood        *services.FloodDetector
+	moderation   *services.ModerationService
+	moderators   *services.ModeratorService
+	reports      *models.MessageReportRepository
+	entitlements *services.EntitlementService
 }
 
-func NewModerationHandler(bot *tgbotapi.BotAPI, db *database.DB) *ModerationHandler {
+func NewModerationHandler(bot *tgbotapi.BotAPI, db *database.DB, settings *services.ModerationSettingsService, words *services.ForbiddenWordService, links *services.LinkFilterService, moderation *services.ModerationService, moderators *services.ModeratorService, entitlements *services.EntitlementService) *ModerationHandler {
 	return &ModerationHandler{
-		bot:      bot,
-		db:       db,
-		userRepo: models.NewUserRepository(db.DB),
+		bot:          bot,
+		db:           db,
+		userRepo:     models.NewUserRepository(db.DB),
+		settings:     settings,
+		words:        words,
+		links:        links,
+		flood:        services.NewFloodDetector(),
+		moderation:   moderation,
+		moderators:   moderators,
+		reports:      models.NewMessageReportRepository(db.DB),
+		entitlements: entitlements,
 	}
 }
 
-// Структура для хранения настроек модерации
-type ModerationSettings struct {
-	AutoBanEnabled       bool `json:"auto_ban_enabled"`
-	WarningThreshold     int  `json:"warning_threshold"`
-	TempBanDuration      int  `json:"temp_ban_duration"`
-	PermanentBanThreshold int  `json:"permanent_ban_threshold"`
-}
-
 // Структура для нарушений
 type Violation struct {
 	ID             int       `json:"id"`
@@ -51,144 +63,237 @@ type Violation struct {
 
 // Обработка сообщений для модерации
 func (h *ModerationHandler) ProcessMessage(message *tgbotapi.Message) {
-	if message.Chat.Type == "private" {
+	if message.Chat.Type == "private" || message.From == nil {
+		return
+	}
+
+	content, ok := messageContent(message)
+	if !ok {
 		return
 	}
 
-	// Проверяем запрещенные слова
-	if h.containsForbiddenWords(message.Text) {
-		h.handleViolation(message, "forbidden_words", "Использование запрещенных слов")
+	settings := h.enforcedSettings(message.Chat.ID)
+	if !settings.ModerationEnabled {
+		return
 	}
 
-	// Проверяем спам (слишком много сообщений за короткое время)
-	if h.isSpam(message) {
-		h.handleViolation(message, "spam", "Спам сообщения")
+	kind, reason := h.findViolation(message, content, settings)
+	if kind == "" || h.isExempt(message) {
+		return
 	}
+	h.handleViolation(message, kind, reason)
 }
 
-// Проверка на запрещенные слова
-func (h *ModerationHandler) containsForbiddenWords(text string) bool {
-	if text == "" {
-		return false
+// Первое нарушение в сообщении: тип и причина, пустой тип, если нарушений нет
+func (h *ModerationHandler) findViolation(message *tgbotapi.Message, content string, settings *models.ModerationSettings) (string, string) {
+	// Проверяем запрещенные слова в тексте и подписях к медиа
+	if settings.CheckForbiddenWords {
+		text := message.Text
+		if text == "" {
+			text = message.Caption
+		}
+		if _, found := h.words.Match(message.Chat.ID, text); found {
+			return "forbidden_words", "Использование запрещенных слов"
+		}
 	}
 
-	text = strings.ToLower(text)
-	
-	// Получаем список запрещенных слов из базы
-	query := `SELECT word FROM forbidden_words WHERE is_active = TRUE`
-	rows, err := h.db.DB.Query(query)
-	if err != nil {
-		log.Printf("Error fetching forbidden words: %v", err)
-		return false
+	// Проверяем ссылки, упоминания и пересланные посты каналов; анонимных
+	// администраторов и посты привязанного канала не проверяем
+	fromChat := message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID
+	if !fromChat && !message.IsAutomaticForward {
+		if violation := h.links.Check(message.Chat.ID, settings, messageLinks(message)); violation != nil {
+			return "links", linkViolationReason(violation)
+		}
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var word string
-		if err := rows.Scan(&word); err != nil {
-			continue
+	// Проверяем флуд: слишком много сообщений или одно и то же сообщение подряд
+	if settings.CheckSpam {
+		switch h.flood.Check(message.Chat.ID, message.From.ID, content, settings, time.Now()) {
+		case services.FloodBurst:
+			return "spam", fmt.Sprintf("Флуд: больше %d сообщений за %d сек.", settings.FloodMessages, settings.FloodWindow)
+		case services.FloodRepeat:
+			return "spam", "Повтор одинаковых сообщений"
 		}
-		
-		if strings.Contains(text, strings.ToLower(word)) {
-			return true
+	}
+	return "", ""
+}
+
+// Создатель, администраторы (в том числе анонимные) и модераторы бота
+// освобождены от всех проверок содержимого, как и от проверки при входе:
+// запрещенных слов, ссылок и флуда. Вызывается только при нарушении, чтобы
+// не спрашивать Telegram о каждом сообщении
+func (h *ModerationHandler) isExempt(message *tgbotapi.Message) bool {
+	if message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID {
+		return true
+	}
+	return h.moderators.IsModerator(message.Chat.ID, message.From.ID)
+}
+
+// Содержимое сообщения для сравнения повторов; служебные сообщения
+// (вход участников, закрепы и т.п.) не учитываются
+func messageContent(message *tgbotapi.Message) (string, bool) {
+	switch {
+	case message.Text != "":
+		return "text:" + message.Text, true
+	case message.Sticker != nil:
+		return "sticker:" + message.Sticker.FileUniqueID, true
+	case message.Animation != nil:
+		return "animation:" + message.Animation.FileUniqueID + ":" + message.Caption, true
+	case len(message.Photo) > 0:
+		return "photo:" + message.Photo[0].FileUniqueID + ":" + message.Caption, true
+	case message.Video != nil:
+		return "video:" + message.Video.FileUniqueID + ":" + message.Caption, true
+	case message.Document != nil:
+		return "document:" + message.Document.FileUniqueID + ":" + message.Caption, true
+	case message.Voice != nil:
+		return "voice:" + message.Voice.FileUniqueID, true
+	case message.VideoNote != nil:
+		return "video_note:" + message.VideoNote.FileUniqueID, true
+	case message.Audio != nil:
+		return "audio:" + message.Audio.FileUniqueID + ":" + message.Caption, true
+	}
+	return "", false
+}
+
+// Ссылки и упоминания из разметки текста или подписи и канал, из которого
+// переслан пост
+func messageLinks(message *tgbotapi.Message) services.LinkContent {
+	var content services.LinkContent
+
+	collect := func(text string, entities []tgbotapi.MessageEntity) {
+		for _, entity := range entities {
+			switch entity.Type {
+			case "url":
+				if link := entityText(text, entity); link != "" {
+					content.URLs = append(content.URLs, link)
+				}
+			case "text_link":
+				content.URLs = append(content.URLs, entity.URL)
+			case "mention":
+				if mention := entityText(text, entity); mention != "" {
+					content.Mentions = append(content.Mentions, mention)
+				}
+			}
 		}
 	}
+	collect(message.Text, message.Entities)
+	collect(message.Caption, message.CaptionEntities)
 
-	return false
+	if message.ForwardFromChat != nil && message.ForwardFromChat.Type == "channel" {
+		content.ForwardedChannel = true
+		content.ChannelUsername = message.ForwardFromChat.UserName
+	}
+	return content
 }
 
-// Проверка на спам
-func (h *ModerationHandler) isSpam(message *tgbotapi.Message) bool {
-	// Проверяем количество сообщений от пользователя за последние 30 секунд
-	query := `
-		SELECT COUNT(*) 
-		FROM user_violations 
-		WHERE user_id = (SELECT id FROM users WHERE telegram_id = $1) 
-		AND chat_id = $2 
-		AND created_at > NOW() - INTERVAL '30 seconds'
-	`
-	
-	var count int
-	err := h.db.DB.QueryRow(query, message.From.ID, message.Chat.ID).Scan(&count)
-	if err != nil {
-		log.Printf("Error checking spam: %v", err)
-		return false
+// Текст сущности: Telegram считает смещения в единицах UTF-16
+func entityText(text string, entity tgbotapi.MessageEntity) string {
+	units := utf16.Encode([]rune(text))
+	end := entity.Offset + entity.Length
+	if entity.Offset < 0 || entity.Length < 0 || end > len(units) {
+		return ""
 	}
+	return string(utf16.Decode(units[entity.Offset:end]))
+}
 
-	return count > 5 // Более 5 сообщений за 30 секунд считается спамом
+func linkViolationReason(violation *services.LinkViolation) string {
+	switch violation.Kind {
+	case services.LinkInvite:
+		return "Приглашение в другой чат"
+	case services.LinkForward:
+		return "Пересылка из канала"
+	case services.LinkUnlisted:
+		return "Ссылка на неразрешенный сайт"
+	}
+	return "Запрещенная ссылка"
 }
 
 // Обработка нарушения
 func (h *ModerationHandler) handleViolation(message *tgbotapi.Message, violationType, reason string) {
-	// Получаем или создаем пользователя
-	user, err := h.userRepo.GetByTelegramID(message.From.ID)
+	user, err := h.memberUser(message.From)
 	if err != nil {
-		// Создаем нового пользователя
-		user = &models.User{
-			TelegramID:   message.From.ID,
-			Username:     message.From.UserName,
-			FirstName:    message.From.FirstName,
-			LastName:     message.From.LastName,
-			LanguageCode: message.From.LanguageCode,
-		}
-		if err := h.userRepo.CreateOrUpdate(user); err != nil {
-			log.Printf("Error creating user: %v", err)
-			return
-		}
+		log.Printf("Error creating user: %v", err)
+		return
 	}
 
 	// Получаем настройки модерации
-	settings := h.getModerationSettings(message.Chat.ID)
-	
-	// Считаем количество нарушений
-	violationCount := h.getViolationCount(user.ID, message.Chat.ID)
+	settings := h.enforcedSettings(message.Chat.ID)
 	
-	var action string
-	var duration *time.Time
-	
-	if violationCount >= settings.PermanentBanThreshold {
-		action = "permanent_ban"
-		h.banUser(message.Chat.ID, message.From.ID, 0) // Постоянный бан
-	} else if violationCount >= settings.WarningThreshold {
-		action = "temp_ban"
-		banUntil := time.Now().Add(time.Duration(settings.TempBanDuration) * time.Hour)
-		duration = &banUntil
-		h.banUser(message.Chat.ID, message.From.ID, int(banUntil.Unix()))
-	} else {
-		action = "warning"
-		h.warnUser(message.Chat.ID, message.From.ID, reason)
+	// Ступень лестницы санкций зависит от еще не устаревших нарушений
+	violationCount := h.getViolationCount(user.ID, message.Chat.ID, settings.ViolationDecayDays)
+	step := settings.Sanction(violationCount)
+
+	if err := h.sanction(message.Chat.ID, message.MessageID, message.Text, user, step, reason, violationCount+1, settings); err != nil {
+		log.Printf("Error applying %s to user %d in chat %d: %v", step, message.From.ID, message.Chat.ID, err)
 	}
+}
 
-	// Сохраняем нарушение в базу
-	h.saveViolation(user.ID, message.Chat.ID, action, reason, message.Text, duration)
-	
+// Санкция за сообщение: удаление сообщения, сама санкция и уведомление в группу.
+// Ее применяют и автоматические проверки, и модераторы по жалобам.
+func (h *ModerationHandler) sanction(chatID int64, messageID int, messageText string, user *models.User, step models.SanctionStep, reason string, violationNumber int, settings *models.ModerationSettings) error {
 	// Удаляем сообщение нарушителя
-	h.deleteMessage(message.Chat.ID, message.MessageID)
-	
+	if err := h.moderation.Purge(chatID, messageID); err != nil {
+		log.Printf("Error deleting message: %v", err)
+	}
+
+	request := services.ModerationRequest{
+		ChatID:      chatID,
+		User:        user,
+		Reason:      reason,
+		MessageText: messageText,
+	}
+	if _, err := h.moderation.Apply(request, step); err != nil {
+		return err
+	}
+
 	// Отправляем уведомление в группу
-	h.sendModerationNotification(message.Chat.ID, user, action, reason, violationCount+1)
+	h.sendModerationNotification(chatID, user, step, reason, violationNumber, settings)
+	return nil
 }
 
-// Получение настроек модерации
-func (h *ModerationHandler) getModerationSettings(chatID int64) ModerationSettings {
-	return ModerationSettings{
-		AutoBanEnabled:       true,
-		WarningThreshold:     3,
-		TempBanDuration:      24,
-		PermanentBanThreshold: 5,
+// Пользователь бота для участника группы; новых участников создаем
+func (h *ModerationHandler) memberUser(from *tgbotapi.User) (*models.User, error) {
+	user, err := h.userRepo.GetByTelegramID(from.ID)
+	if err == nil {
+		return user, nil
+	}
+
+	user = &models.User{
+		TelegramID:   from.ID,
+		Username:     from.UserName,
+		FirstName:    from.FirstName,
+		LastName:     from.LastName,
+		LanguageCode: from.LanguageCode,
 	}
+	if err := h.userRepo.CreateOrUpdate(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Получение настроек модерации
+func (h *ModerationHandler) getModerationSettings(chatID int64) *models.ModerationSettings {
+	return h.settings.Get(chatID)
+}
+
+// Настройки, по которым группа модерируется с учетом плана ее владельцев:
+// без basic_moderation модерации нет, а приостановленная группа сверх лимита
+// плана получает только базовую
+func (h *ModerationHandler) enforcedSettings(chatID int64) *models.ModerationSettings {
+	return h.entitlements.ForChat(chatID).ModerationSettings(h.getModerationSettings(chatID))
 }
 
-// Подсчет нарушений пользователя
-func (h *ModerationHandler) getViolationCount(userID int, chatID int64) int {
+// Подсчет нарушений пользователя за последние decayDays дней (0 — за все время)
+func (h *ModerationHandler) getViolationCount(userID int, chatID int64, decayDays int) int {
 	query := `
 		SELECT COUNT(*) 
 		FROM user_violations 
 		WHERE user_id = $1 AND chat_id = $2 AND is_active = TRUE
+		  AND ($3 = 0 OR created_at >= NOW() - make_interval(days => $3))
 	`
 	
 	var count int
-	err := h.db.DB.QueryRow(query, userID, chatID).Scan(&count)
+	err := h.db.DB.QueryRow(query, userID, chatID, decayDays).Scan(&count)
 	if err != nil {
 		log.Printf("Error getting violation count: %v", err)
 		return 0
@@ -197,77 +302,97 @@ func (h *ModerationHandler) getViolationCount(userID int, chatID int64) int {
 	return count
 }
 
-// Бан пользователя
-func (h *ModerationHandler) banUser(chatID int64, userID int64, untilDate int) {
-	banConfig := tgbotapi.BanChatMemberConfig{
-		ChatMemberConfig: tgbotapi.ChatMemberConfig{
-			ChatID: chatID,
-			UserID: userID,
-		},
-		UntilDate: int64(untilDate),
-	}
-	
-	if _, err := h.bot.Request(banConfig); err != nil {
-		log.Printf("Error banning user: %v", err)
+// Отправка уведомления о модерации
+func (h *ModerationHandler) sendModerationNotification(chatID int64, user *models.User, step models.SanctionStep, reason string, violationCount int, settings *models.ModerationSettings) {
+	if settings.NotificationLevel == models.NotifySilent {
+		return
 	}
-}
 
-// Предупреждение пользователя
-func (h *ModerationHandler) warnUser(chatID int64, userID int64, reason string) {
-	// Предупреждение отправляется через уведомление в группу
-	log.Printf("Warning user %d in chat %d: %s", userID, chatID, reason)
-}
-
-// Удаление сообщения
-func (h *ModerationHandler) deleteMessage(chatID int64, messageID int) {
-	deleteConfig := tgbotapi.DeleteMessageConfig{
-		ChatID:    chatID,
-		MessageID: messageID,
+	var message string
+	mention := h.getUserMention(user)
+
+	if settings.NotificationLevel == models.NotifyShort {
+		switch step.Action {
+		case models.SanctionWarn:
+			message = fmt.Sprintf("⚠️ %s: предупреждение (%s)", mention, reason)
+		case models.SanctionMute:
+			message = fmt.Sprintf("🔇 %s: мут на %s (%s)", mention, formatMinutes(step.Minutes), reason)
+		case models.SanctionMedia:
+			message = fmt.Sprintf("🖼 %s: только текст на %s (%s)", mention, formatMinutes(step.Minutes), reason)
+		case models.SanctionBan:
+			if step.Minutes == 0 {
+				message = fmt.Sprintf("🔒 %s: бан навсегда (%s)", mention, reason)
+			} else {
+				message = fmt.Sprintf("🚫 %s: бан на %s (%s)", mention, formatMinutes(step.Minutes), reason)
+			}
+		}
+	} else {
+		header := fmt.Sprintf("👤 Пользователь: %s\n🔢 Нарушение: %d\n📝 Причина: %s", mention, violationCount, reason)
+		switch step.Action {
+		case models.SanctionWarn:
+			message = "⚠️ **Предупреждение**\n\n" + header
+		case models.SanctionMute:
+			message = fmt.Sprintf("🔇 **Мут**\n\n%s\n⏰ Длительность: %s", header, formatMinutes(step.Minutes))
+		case models.SanctionMedia:
+			message = fmt.Sprintf("🖼 **Запрет медиа**\n\n%s\n⏰ Длительность: %s\n✍️ Можно писать только текст", header, formatMinutes(step.Minutes))
+		case models.SanctionBan:
+			if step.Minutes == 0 {
+				message = fmt.Sprintf("🔒 **Постоянный бан**\n\n%s\n⛔ Пользователь заблокирован навсегда", header)
+			} else {
+				message = fmt.Sprintf("🚫 **Временный бан**\n\n%s\n⏰ Длительность: %s", header, formatMinutes(step.Minutes))
+			}
+		}
+		if settings.AutoBanEnabled && !(step.Action == models.SanctionBan && step.Minutes == 0) {
+			message += "\n\n💡 Следующее нарушение: " + describeSanction(settings.Sanction(violationCount))
+		}
 	}
+
+	msg := tgbotapi.NewMessage(chatID, message)
+	msg.ParseMode = "Markdown"
 	
-	if _, err := h.bot.Request(deleteConfig); err != nil {
-		log.Printf("Error deleting message: %v", err)
+	if _, err := h.bot.Send(msg); err != nil {
+		log.Printf("Error sending moderation notification: %v", err)
 	}
 }
 
-// Сохранение нарушения в базу
-func (h *ModerationHandler) saveViolation(userID int, chatID int64, violationType, reason, messageText string, expiresAt *time.Time) {
-	query := `
-		INSERT INTO user_violations (user_id, chat_id, violation_type, violation_reason, message_text, expires_at, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, NOW())
-	`
-	
-	_, err := h.db.DB.Exec(query, userID, chatID, violationType, reason, messageText, expiresAt)
-	if err != nil {
-		log.Printf("Error saving violation: %v", err)
+// Описание ступени лестницы санкций для уведомлений
+func describeSanction(step models.SanctionStep) string {
+	switch step.Action {
+	case models.SanctionMute:
+		return "мут на " + formatMinutes(step.Minutes)
+	case models.SanctionMedia:
+		return "только текст на " + formatMinutes(step.Minutes)
+	case models.SanctionBan:
+		if step.Minutes == 0 {
+			return "бан навсегда"
+		}
+		return "бан на " + formatMinutes(step.Minutes)
 	}
+	return "предупреждение"
 }
 
-// Отправка уведомления о модерации
-func (h *ModerationHandler) sendModerationNotification(chatID int64, user *models.User, action, reason string, violationCount int) {
-	var message string
-	var emoji string
-	
-	switch action {
-	case "warning":
-		emoji = "⚠️"
-		message = fmt.Sprintf("%s **Предупреждение**\n\n👤 Пользователь: %s\n🔢 Нарушение: %d\n📝 Причина: %s\n\n💡 При достижении 3 предупреждений будет временный бан", 
-			emoji, h.getUserMention(user), violationCount, reason)
-	case "temp_ban":
-		emoji = "🚫"
-		message = fmt.Sprintf("%s **Временный бан**\n\n👤 Пользователь: %s\n🔢 Нарушение: %d\n📝 Причина: %s\n⏰ Длительность: 24 часа", 
-			emoji, h.getUserMention(user), violationCount, reason)
-	case "permanent_ban":
-		emoji = "🔒"
-		message = fmt.Sprintf("%s **Постоянный бан**\n\n👤 Пользователь: %s\n🔢 Нарушение: %d\n📝 Причина: %s\n⛔ Пользователь заблокирован навсегда", 
-			emoji, h.getUserMention(user), violationCount, reason)
+// formatMinutes пишет длительность по-русски в самых крупных целых единицах:
+// "45 минут", "1 час", "2 дня"
+func formatMinutes(minutes int) string {
+	switch {
+	case minutes >= 24*60 && minutes%(24*60) == 0:
+		return pluralRu(minutes/(24*60), "день", "дня", "дней")
+	case minutes >= 60 && minutes%60 == 0:
+		return pluralRu(minutes/60, "час", "часа", "часов")
+	default:
+		return pluralRu(minutes, "минута", "минуты", "минут")
 	}
+}
 
-	msg := tgbotapi.NewMessage(chatID, message)
-	msg.ParseMode = "Markdown"
-	
-	if _, err := h.bot.Send(msg); err != nil {
-		log.Printf("Error sending moderation notification: %v", err)
+// pluralRu согласует число с существительным: 1 час, 2 часа, 5 часов
+func pluralRu(n int, one, few, many string) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return fmt.Sprintf("%d %s", n, one)
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return fmt.Sprintf("%d %s", n, few)
+	default:
+		return fmt.Sprintf("%d %s", n, many)
 	}
 }
 
@@ -319,6 +444,12 @@ func (h *ModerationHandler) HandleViolationsCommand(message *tgbotapi.Message) {
 		return
 	}
 
+	if !h.authorize(message, accessModerator) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Нарушения могут смотреть только администраторы и модераторы группы")
+		h.bot.Send(msg)
+		return
+	}
+
 	violations, err := h.GetViolations(message.Chat.ID)
 	if err != nil {
 		log.Printf("Error getting violations: %v", err)
@@ -333,6 +464,7 @@ func (h *ModerationHandler) HandleViolationsCommand(message *tgbotapi.Message) {
 
 	// Группируем по типам нарушений
 	warnings := 0
+	restrictions := 0
 	tempBans := 0
 	permanentBans := 0
 	
@@ -341,11 +473,13 @@ func (h *ModerationHandler) HandleViolationsCommand(message *tgbotapi.Message) {
 	
 	for _, v := range violations {
 		switch v.ViolationType {
-		case "warning":
+		case models.ViolationWarning:
 			warnings++
-		case "temp_ban":
+		case models.ViolationMute, models.ViolationMedia:
+			restrictions++
+		case models.ViolationTempBan:
 			tempBans++
-		case "permanent_ban":
+		case models.ViolationPermanentBan:
 			permanentBans++
 		}
 		
@@ -358,8 +492,8 @@ func (h *ModerationHandler) HandleViolationsCommand(message *tgbotapi.Message) {
 			username, v.ViolationReason, v.CreatedAt.Format("02.01.2006")))
 	}
 	
-	summary := fmt.Sprintf("⚠️ Предупреждения: %d\n🚫 Временные баны: %d\n🔒 Постоянные баны: %d\n\n", 
-		warnings, tempBans, permanentBans)
+	summary := fmt.Sprintf("⚠️ Предупреждения: %d\n🔇 Муты и ограничения: %d\n🚫 Временные баны: %d\n🔒 Постоянные баны: %d\n\n", 
+		warnings, restrictions, tempBans, permanentBans)
 	
 	finalMessage := summary + violationList.String()
 	
@@ -379,6 +513,12 @@ func (h *ModerationHandler) HandleUnbanCommand(message *tgbotapi.Message, args [
 		return
 	}
 
+	if !h.authorize(message, accessModerator) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Разблокировать могут только администраторы и модераторы группы")
+		h.bot.Send(msg)
+		return
+	}
+
 	if len(args) == 0 {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Укажите ID пользователя для разблокировки")
 		h.bot.Send(msg)
@@ -392,35 +532,384 @@ func (h *ModerationHandler) HandleUnbanCommand(message *tgbotapi.Message, args [
 		return
 	}
 
-	// Разблокируем пользователя
-	unbanConfig := tgbotapi.UnbanChatMemberConfig{
-		ChatMemberConfig: tgbotapi.ChatMemberConfig{
-			ChatID: message.Chat.ID,
-			UserID: userID,
-		},
-		OnlyIfBanned: true,
-	}
-
-	if _, err := h.bot.Request(unbanConfig); err != nil {
+	// Снимаем бан или ограничения и прощаем нарушения
+	if err := h.moderation.Unban(message.Chat.ID, userID); err != nil {
 		log.Printf("Error unbanning user: %v", err)
 		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Ошибка при разблокировке пользователя")
 		h.bot.Send(msg)
 		return
 	}
 
-	// Деактивируем нарушения в базе
-	query := `
-		UPDATE user_violations 
-		SET is_active = FALSE 
-		WHERE user_id = (SELECT id FROM users WHERE telegram_id = $1) 
-		AND chat_id = $2
-	`
-	
-	_, err = h.db.DB.Exec(query, userID, message.Chat.ID)
-	if err != nil {
-		log.Printf("Error deactivating violations: %v", err)
+	msg := tgbotapi.NewMessage(message.Chat.ID, "✅ Пользователь разблокирован")
+	h.bot.Send(msg)
+}
+// Команда для просмотра и изменения настроек модерации группы
+func (h *ModerationHandler) HandleSettingsCommand(message *tgbotapi.Message, args []string) {
+	if message.Chat.Type == "private" {
+		return
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, "✅ Пользователь разблокирован")
+	if !h.authorize(message, accessAdmin) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Настройки модерации могут менять только администраторы группы")
+		h.bot.Send(msg)
+		return
+	}
+
+	settings := h.getModerationSettings(message.Chat.ID)
+
+	if len(args) < 2 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, formatModerationSettings(settings))
+		h.bot.Send(msg)
+		return
+	}
+
+	// Лестницу можно написать с пробелами: warn, mute:60, ban
+	value := strings.ToLower(strings.Join(args[1:], " "))
+	key := strings.ToLower(args[0])
+	if err := applyModerationSetting(settings, key, value); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ "+err.Error())
+		h.bot.Send(msg)
+		return
+	}
+
+	if key == "report_chat" && settings.ReportChatID != 0 {
+		if err := h.verifyReportChat(settings.ReportChatID, message.From.ID); err != nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "❌ "+err.Error())
+			h.bot.Send(msg)
+			return
+		}
+	}
+
+	if err := h.settings.Update(settings); err != nil {
+		log.Printf("Error saving moderation settings of chat %d: %v", message.Chat.ID, err)
+		text := "❌ Ошибка при сохранении настроек"
+		if errors.Is(err, services.ErrInvalidModerationSettings) {
+			text = "❌ " + err.Error()
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		h.bot.Send(msg)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "✅ Настройки сохранены\n\n"+formatModerationSettings(settings))
 	h.bot.Send(msg)
-}
\ No newline at end of file
+}
+
+// Жалобы можно направить только в чат, где есть бот и которым управляет
+// сам администратор, иначе они уйдут в чужой чат
+func (h *ModerationHandler) verifyReportChat(chatID, userID int64) error {
+	err := h.moderators.VerifyAdmin(chatID, userID)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, services.ErrBotNotInChat):
+		return fmt.Errorf("сначала добавьте бота в чат %d", chatID)
+	case errors.Is(err, services.ErrNotChatAdmin):
+		return fmt.Errorf("вы не администратор чата %d", chatID)
+	default:
+		log.Printf("Error verifying report chat %d for user %d: %v", chatID, userID, err)
+		return fmt.Errorf("не удалось проверить чат %d, попробуйте позже", chatID)
+	}
+}
+
+// Команда для списков разрешенных и запрещенных ссылок группы:
+// /links, /links allow|deny <домен или @username>, /links remove <домен или @username>
+func (h *ModerationHandler) HandleLinksCommand(message *tgbotapi.Message, args []string) {
+	if message.Chat.Type == "private" {
+		return
+	}
+
+	if !h.authorize(message, accessAdmin) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Списки ссылок могут менять только администраторы группы")
+		h.bot.Send(msg)
+		return
+	}
+
+	if len(args) < 2 {
+		h.sendLinkRules(message.Chat.ID)
+		return
+	}
+
+	var text string
+	switch action := strings.ToLower(args[0]); action {
+	case models.LinkAllow, models.LinkDeny:
+		rule := &models.LinkRule{ChatID: message.Chat.ID, Pattern: args[1], Action: action}
+		err := h.links.Save(rule)
+		switch {
+		case errors.Is(err, services.ErrInvalidLinkRule):
+			text = "❌ Укажите домен (example.com), домен с путем (t.me/channel) или @username"
+		case err != nil:
+			log.Printf("Error saving link rule of chat %d: %v", message.Chat.ID, err)
+			text = "❌ Ошибка при сохранении правила"
+		case action == models.LinkAllow:
+			text = fmt.Sprintf("✅ %s разрешено", rule.Pattern)
+		default:
+			text = fmt.Sprintf("🚫 %s запрещено", rule.Pattern)
+		}
+	case "remove":
+		err := h.links.DeletePattern(message.Chat.ID, args[1])
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			text = "❌ Такого правила нет"
+		case err != nil:
+			log.Printf("Error deleting link rule of chat %d: %v", message.Chat.ID, err)
+			text = "❌ Ошибка при удалении правила"
+		default:
+			text = "✅ Правило удалено"
+		}
+	default:
+		text = "❌ Используйте /links allow, /links deny или /links remove"
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	h.bot.Send(msg)
+}
+
+func (h *ModerationHandler) sendLinkRules(chatID int64) {
+	rules, err := h.links.List(chatID)
+	if err != nil {
+		log.Printf("Error listing link rules of chat %d: %v", chatID, err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при загрузке правил"))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("🔗 Правила для ссылок\n\n")
+	if len(rules) == 0 {
+		text.WriteString("Правил нет.\n")
+	}
+	for _, rule := range rules {
+		mark := "✅"
+		if rule.Action == models.LinkDeny {
+			mark = "🚫"
+		}
+		text.WriteString(fmt.Sprintf("%s %s\n", mark, rule.Pattern))
+	}
+	text.WriteString("\nДомен действует и на поддомены. Изменить: /links allow|deny|remove <домен или @username>")
+
+	h.bot.Send(tgbotapi.NewMessage(chatID, text.String()))
+}
+
+// Кому доступна команда модерации
+const (
+	accessModerator = iota // администраторам группы и модераторам бота
+	accessAdmin            // только администраторам группы
+)
+
+// Проверка прав автора команды; попытки без прав записываются в лог
+func (h *ModerationHandler) authorize(message *tgbotapi.Message, access int) bool {
+	// Анонимные администраторы пишут от имени самой группы
+	if message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID {
+		return true
+	}
+	if message.From == nil {
+		return false
+	}
+
+	chatID, userID := message.Chat.ID, message.From.ID
+	if access == accessModerator && h.moderators.IsModerator(chatID, userID) {
+		return true
+	}
+	if access == accessAdmin && h.moderators.IsAdmin(chatID, userID) {
+		return true
+	}
+
+	log.Printf("Unauthorized /%s from user %d (@%s) in chat %d", message.Command(), userID, message.From.UserName, chatID)
+	return false
+}
+
+// Команда для модераторов бота, которым не нужны права администратора в Telegram:
+// /moderators, /moderators add|remove <Telegram ID> или в ответ на сообщение участника
+func (h *ModerationHandler) HandleModeratorsCommand(message *tgbotapi.Message, args []string) {
+	if message.Chat.Type == "private" {
+		return
+	}
+
+	if !h.authorize(message, accessAdmin) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "❌ Модераторов могут назначать только администраторы группы")
+		h.bot.Send(msg)
+		return
+	}
+
+	if len(args) == 0 {
+		h.sendModerators(message.Chat.ID)
+		return
+	}
+
+	// Участник — из ответа на его сообщение или по Telegram ID
+	var userID int64
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+		userID = message.ReplyToMessage.From.ID
+	} else if len(args) > 1 {
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Неверный формат ID пользователя"))
+			return
+		}
+		userID = id
+	} else {
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Укажите Telegram ID или ответьте на сообщение участника"))
+		return
+	}
+
+	var text string
+	switch strings.ToLower(args[0]) {
+	case "add":
+		moderator := &models.GroupModerator{ChatID: message.Chat.ID, TelegramUserID: userID}
+		if message.From != nil {
+			moderator.AddedBy = message.From.ID
+		}
+		if err := h.moderators.Add(moderator); err != nil {
+			log.Printf("Error adding moderator %d to chat %d: %v", userID, message.Chat.ID, err)
+			text = "❌ Ошибка при добавлении модератора"
+		} else {
+			text = fmt.Sprintf("✅ %d теперь модератор группы", userID)
+		}
+	case "remove":
+		err := h.moderators.Remove(message.Chat.ID, userID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			text = "❌ Этот участник не модератор группы"
+		case err != nil:
+			log.Printf("Error removing moderator %d from chat %d: %v", userID, message.Chat.ID, err)
+			text = "❌ Ошибка при удалении модератора"
+		default:
+			text = fmt.Sprintf("✅ %d больше не модератор группы", userID)
+		}
+	default:
+		text = "❌ Используйте /moderators add или /moderators remove"
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, text))
+}
+
+func (h *ModerationHandler) sendModerators(chatID int64) {
+	moderators, err := h.moderators.List(chatID)
+	if err != nil {
+		log.Printf("Error listing moderators of chat %d: %v", chatID, err)
+		h.bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при загрузке модераторов"))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("🛡 Модераторы бота\n\n")
+	if len(moderators) == 0 {
+		text.WriteString("Модераторов нет, командами модерации пользуются только администраторы.\n")
+	}
+	for _, moderator := range moderators {
+		text.WriteString(fmt.Sprintf("• %d\n", moderator.TelegramUserID))
+	}
+	text.WriteString("\nМодераторы могут смотреть нарушения (/violations) и разблокировать (/unban). " +
+		"Изменить: /moderators add|remove <Telegram ID> или в ответ на сообщение участника")
+
+	h.bot.Send(tgbotapi.NewMessage(chatID, text.String()))
+}
+
+// Изменение одной настройки по ключу из команды /modsettings
+func applyModerationSetting(settings *models.ModerationSettings, key, value string) error {
+	switch key {
+	case "moderation", "autoban", "words", "spam", "captcha", "links", "invites", "all_links", "forwards":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "moderation":
+			settings.ModerationEnabled = enabled
+		case "autoban":
+			settings.AutoBanEnabled = enabled
+		case "words":
+			settings.CheckForbiddenWords = enabled
+		case "spam":
+			settings.CheckSpam = enabled
+		case "captcha":
+			settings.CaptchaEnabled = enabled
+		case "links":
+			settings.CheckLinks = enabled
+		case "invites":
+			settings.BlockInvites = enabled
+		case "all_links":
+			settings.BlockAllLinks = enabled
+		case "forwards":
+			settings.BlockForwards = enabled
+		}
+	case "decay_days", "flood_messages", "flood_window", "flood_repeats", "captcha_timeout":
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("значение должно быть числом")
+		}
+		switch key {
+		case "decay_days":
+			settings.ViolationDecayDays = number
+		case "flood_messages":
+			settings.FloodMessages = number
+		case "flood_window":
+			settings.FloodWindow = number
+		case "flood_repeats":
+			settings.FloodRepeats = number
+		case "captcha_timeout":
+			settings.CaptchaTimeout = number
+		}
+	case "report_chat":
+		chatID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("значение должно быть ID чата")
+		}
+		settings.ReportChatID = chatID
+	case "ladder":
+		settings.SanctionLadder = value
+	case "notify":
+		settings.NotificationLevel = value
+	case "captcha_type":
+		settings.CaptchaType = value
+	default:
+		return fmt.Errorf("неизвестная настройка %q", key)
+	}
+	return nil
+}
+
+func parseSwitch(value string) (bool, error) {
+	switch value {
+	case "on", "вкл", "1", "true":
+		return true, nil
+	case "off", "выкл", "0", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("используйте on или off")
+}
+
+func formatModerationSettings(settings *models.ModerationSettings) string {
+	onOff := func(enabled bool) string {
+		if enabled {
+			return "on"
+		}
+		return "off"
+	}
+
+	return fmt.Sprintf("⚙️ Настройки модерации\n\n"+
+		"moderation: %s — модерация включена\n"+
+		"autoban: %s — санкции по лестнице (off — только предупреждения)\n"+
+		"ladder: %s — санкции за 1-е, 2-е... нарушение: warn, mute:<минут>, media:<минут>, ban:<минут>, ban\n"+
+		"decay_days: %d — через столько дней нарушение не считается (0 — никогда)\n"+
+		"words: %s — проверка запрещенных слов\n"+
+		"spam: %s — проверка флуда\n"+
+		"flood_messages: %d — сообщений, после которых начинается флуд...\n"+
+		"flood_window: %d — ...за столько секунд\n"+
+		"flood_repeats: %d — одинаковых сообщений подряд (0 — не проверять)\n"+
+		"links: %s — проверка ссылок (списки: /links)\n"+
+		"invites: %s — запрет приглашений в другие чаты\n"+
+		"all_links: %s — запрет всех ссылок, кроме разрешенных\n"+
+		"forwards: %s — запрет пересылки из каналов\n"+
+		"captcha: %s — проверка новых участников\n"+
+		"captcha_type: %s — вопрос (button — кнопка, math — пример)\n"+
+		"captcha_timeout: %d — секунд на ответ\n"+
+		"notify: %s — уведомления (full, short, silent)\n"+
+		"report_chat: %d — чат для жалоб /report, где есть бот и вы администратор (0 — владельцу группы в личные сообщения)\n\n"+
+		"Изменить: /modsettings <настройка> <значение>, например /modsettings ladder warn,mute:60,ban",
+		onOff(settings.ModerationEnabled), onOff(settings.AutoBanEnabled), settings.SanctionLadder,
+		settings.ViolationDecayDays, onOff(settings.CheckForbiddenWords),
+		onOff(settings.CheckSpam), settings.FloodMessages, settings.FloodWindow, settings.FloodRepeats,
+		onOff(settings.CheckLinks), onOff(settings.BlockInvites), onOff(settings.BlockAllLinks), onOff(settings.BlockForwards),
+		onOff(settings.CaptchaEnabled), settings.CaptchaType, settings.CaptchaTimeout, settings.NotificationLevel,
+		settings.ReportChatID)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-subscription-bot/models"
+)
+
+// Действия кнопок под жалобой: что сделать и каким статусом закрыть жалобу
+var reportActions = map[string]string{
+	"delete":  models.ReportDeleted,
+	"warn":    models.ReportWarned,
+	"mute":    models.ReportMuted,
+	"ban":     models.ReportBanned,
+	"dismiss": models.ReportDismissed,
+}
+
+// Команда /report в ответ на сообщение: снимок сообщения уходит модераторам
+// в чат для жалоб группы, а если его нет — владельцу группы в личные сообщения
+func (h *ModerationHandler) HandleReportCommand(message *tgbotapi.Message) {
+	if message.Chat.Type == "private" || message.From == nil {
+		return
+	}
+
+	reported := message.ReplyToMessage
+	if reported == nil || reported.From == nil {
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Отправьте /report в ответ на сообщение, на которое жалуетесь"))
+		return
+	}
+	if reported.From.IsBot || h.moderators.IsModerator(message.Chat.ID, reported.From.ID) {
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ На это сообщение пожаловаться нельзя"))
+		return
+	}
+
+	if _, err := h.reports.GetOpen(message.Chat.ID, reported.MessageID); err == nil {
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "✅ На это сообщение уже пожаловались, модераторы разберутся"))
+		return
+	}
+
+	reviewChatID := h.reviewChat(message.Chat.ID)
+	if reviewChatID == 0 {
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ В этой группе некому разбирать жалобы"))
+		return
+	}
+
+	// Заводим пользователя заранее: модератор может наказать автора позже
+	if _, err := h.memberUser(reported.From); err != nil {
+		log.Printf("Error creating user: %v", err)
+	}
+
+	report := &models.MessageReport{
+		ChatID:         message.Chat.ID,
+		MessageID:      reported.MessageID,
+		ReportedUserID: reported.From.ID,
+		ReportedName:   displayName(reported.From),
+		ReporterID:     message.From.ID,
+		ReporterName:   displayName(message.From),
+		MessageText:    messageSnapshot(reported),
+		Reason:         strings.TrimSpace(message.CommandArguments()),
+	}
+	if err := h.reports.Create(report); err != nil {
+		log.Printf("Error saving report in chat %d: %v", message.Chat.ID, err)
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Не удалось отправить жалобу"))
+		return
+	}
+
+	// Пересылаем само сообщение, а под ним карточку жалобы с кнопками
+	card := tgbotapi.NewMessage(reviewChatID, formatReport(report, message.Chat.Title))
+	if forwarded, err := h.bot.Send(tgbotapi.NewForward(reviewChatID, message.Chat.ID, reported.MessageID)); err != nil {
+		log.Printf("Error forwarding reported message %d from chat %d: %v", reported.MessageID, message.Chat.ID, err)
+	} else {
+		card.ReplyToMessageID = forwarded.MessageID
+	}
+	card.ReplyMarkup = reportKeyboard(report.ID)
+
+	sent, err := h.bot.Send(card)
+	if err != nil {
+		log.Printf("Error sending report %d to chat %d: %v", report.ID, reviewChatID, err)
+		h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ Не удалось отправить жалобу модераторам"))
+		return
+	}
+	if err := h.reports.SetReview(report.ID, reviewChatID, sent.MessageID); err != nil {
+		log.Printf("Error saving review message of report %d: %v", report.ID, err)
+	}
+
+	h.bot.Send(tgbotapi.NewMessage(message.Chat.ID, "✅ Жалоба отправлена модераторам"))
+}
+
+// Чат для жалоб группы или личный чат ее владельца; 0 — жалобы отправлять некуда
+func (h *ModerationHandler) reviewChat(chatID int64) int64 {
+	if reportChatID := h.getModerationSettings(chatID).ReportChatID; reportChatID != 0 {
+		return reportChatID
+	}
+
+	ownerID, err := h.reports.OwnerTelegramID(chatID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error looking up the owner of chat %d: %v", chatID, err)
+		}
+		return 0
+	}
+	return ownerID
+}
+
+// Нажатие кнопки под жалобой: report:<ID жалобы>:<действие>
+func (h *ModerationHandler) HandleReportCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 {
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	status, known := reportActions[parts[2]]
+	if err != nil || !known {
+		return
+	}
+
+	report, err := h.reports.GetByID(id)
+	if err != nil {
+		log.Printf("Error loading report %d: %v", id, err)
+		h.answerCallback(query, "Жалоба не найдена")
+		return
+	}
+
+	// Решать могут модераторы группы и владелец, которому жалоба пришла в личные сообщения
+	ownerChat := report.ReviewChatID == query.From.ID
+	if !ownerChat && !h.moderators.IsModerator(report.ChatID, query.From.ID) {
+		log.Printf("Unauthorized report action %s on report %d from user %d", parts[2], report.ID, query.From.ID)
+		h.answerCallback(query, "Жалобы разбирают только модераторы группы")
+		return
+	}
+
+	resolved, err := h.reports.Resolve(report.ID, status, query.From.ID)
+	if err != nil {
+		log.Printf("Error resolving report %d: %v", report.ID, err)
+		h.answerCallback(query, "Ошибка, попробуйте еще раз")
+		return
+	}
+	if !resolved {
+		h.answerCallback(query, "Жалоба уже разобрана")
+		return
+	}
+
+	if err := h.resolveReport(report, parts[2]); err != nil {
+		log.Printf("Error carrying out %s on report %d: %v", parts[2], report.ID, err)
+		if err := h.reports.Reopen(report.ID); err != nil {
+			log.Printf("Error reopening report %d: %v", report.ID, err)
+		}
+		h.answerCallback(query, "❌ Не получилось: проверьте права бота в группе")
+		return
+	}
+	h.answerCallback(query, "✅ Готово")
+
+	if query.Message != nil {
+		text := query.Message.Text + fmt.Sprintf("\n\n%s — %s", reportStatusText(status), displayName(query.From))
+		h.bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+	}
+}
+
+// Выполнение решения по жалобе той же санкцией, что и при автоматической проверке
+func (h *ModerationHandler) resolveReport(report *models.MessageReport, action string) error {
+	switch action {
+	case "dismiss":
+		return nil
+	case "delete":
+		return h.moderation.Purge(report.ChatID, report.MessageID)
+	}
+
+	user, err := h.userRepo.GetByTelegramID(report.ReportedUserID)
+	if err != nil {
+		return err
+	}
+
+	settings := h.getModerationSettings(report.ChatID)
+	var step models.SanctionStep
+	switch action {
+	case "warn":
+		step = models.SanctionStep{Action: models.SanctionWarn}
+	case "mute":
+		step = settings.LadderStep(models.SanctionMute)
+	case "ban":
+		step = settings.LadderStep(models.SanctionBan)
+	}
+
+	reason := "Жалоба участника"
+	if report.Reason != "" {
+		reason += ": " + report.Reason
+	}
+	violationCount := h.getViolationCount(user.ID, report.ChatID, settings.ViolationDecayDays)
+	return h.sanction(report.ChatID, report.MessageID, report.MessageText, user, step, reason, violationCount+1, settings)
+}
+
+func (h *ModerationHandler) answerCallback(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := h.bot.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("Error answering callback query: %v", err)
+	}
+}
+
+func reportKeyboard(reportID int) tgbotapi.InlineKeyboardMarkup {
+	button := func(text, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(text, fmt.Sprintf("report:%d:%s", reportID, action))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button("🗑 Удалить", "delete"), button("⚠️ Предупредить", "warn")),
+		tgbotapi.NewInlineKeyboardRow(button("🔇 Мут", "mute"), button("🚫 Бан", "ban")),
+		tgbotapi.NewInlineKeyboardRow(button("✖️ Отклонить", "dismiss")),
+	)
+}
+
+// Карточка жалобы без Markdown: в ней пользовательский текст
+func formatReport(report *models.MessageReport, chatTitle string) string {
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🚩 Жалоба #%d\n\n", report.ID))
+	text.WriteString(fmt.Sprintf("Группа: %s\n", chatTitle))
+	text.WriteString(fmt.Sprintf("Автор сообщения: %s (ID %d)\n", report.ReportedName, report.ReportedUserID))
+	text.WriteString(fmt.Sprintf("Пожаловался: %s (ID %d)\n", report.ReporterName, report.ReporterID))
+	if report.Reason != "" {
+		text.WriteString(fmt.Sprintf("Причина: %s\n", report.Reason))
+	}
+	if link := messageLink(report.ChatID, report.MessageID); link != "" {
+		text.WriteString(fmt.Sprintf("Сообщение: %s\n", link))
+	}
+
+	snapshot := report.MessageText
+	if len([]rune(snapshot)) > 1000 {
+		snapshot = string([]rune(snapshot)[:1000]) + "…"
+	}
+	text.WriteString("\n" + snapshot)
+	return text.String()
+}
+
+func reportStatusText(status string) string {
+	switch status {
+	case models.ReportDeleted:
+		return "🗑 Сообщение удалено"
+	case models.ReportWarned:
+		return "⚠️ Автор предупрежден"
+	case models.ReportMuted:
+		return "🔇 Автор в муте"
+	case models.ReportBanned:
+		return "🚫 Автор забанен"
+	}
+	return "✖️ Жалоба отклонена"
+}
+
+// Снимок сообщения: текст или подпись и тип вложения
+func messageSnapshot(message *tgbotapi.Message) string {
+	var kind string
+	switch {
+	case message.Photo != nil:
+		kind = "[фото]"
+	case message.Video != nil:
+		kind = "[видео]"
+	case message.Document != nil:
+		kind = "[файл]"
+	case message.Sticker != nil:
+		kind = "[стикер " + message.Sticker.Emoji + "]"
+	case message.Voice != nil:
+		kind = "[голосовое сообщение]"
+	case message.Animation != nil:
+		kind = "[GIF]"
+	}
+
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	return strings.TrimSpace(kind + " " + text)
+}
+
+// Ссылка на сообщение супергруппы; у обычных групп ссылок на сообщения нет
+func messageLink(chatID int64, messageID int) string {
+	id := strconv.FormatInt(chatID, 10)
+	if !strings.HasPrefix(id, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(id, "-100"), messageID)
+}
+
+func displayName(user *tgbotapi.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if user.UserName != "" {
+		name += " (@" + user.UserName + ")"
+	}
+	return name
+}
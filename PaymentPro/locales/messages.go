@@ -5,7 +5,7 @@ import "strings"
 var messages = map[string]map[string]string{
         "en": {
                 "welcome":                     "🎉 Welcome to the Subscription Bot!\n\nI help you manage your subscriptions and access premium features. Use /help to see available commands.",
-                "help":                        "🔧 Available Commands:\n\n/start - Welcome message\n/help - Show this help\n/plans - View subscription plans\n/myplan - Check your current plan\n/subscribe <plan_id> - Subscribe to a plan\n/cancel - Cancel subscription\n/history - View payment history\n/crypto <plan_id> <currency> - Pay with crypto\n/setup - Bot setup instructions\n/addbot - How to add bot to group/channel",
+                "help":                        "🔧 Available Commands:\n\n/start - Welcome message\n/help - Show this help\n/plans - View subscription plans\n/myplan - Check your current plan\n/subscribe <plan_id> - Subscribe to a plan\n/cancel - Stop renewing your subscription\n/history - View payment history\n/crypto <plan_id> <currency> - Pay with crypto\n/setup - Bot setup instructions\n/addbot - How to add bot to group/channel\n/login - Sign in to the web dashboard\n/modsettings - Moderation settings of a group (group admins)\n/links - Allowed and denied links of a group (group admins)\n/moderators - Bot moderators of a group (group admins)\n/report - Report a message to the moderators (in reply to it)",
                 "available_plans":             "💎 Available Subscription Plans:",
                 "current_plan":                "Current Plan",
                 "expires_at":                  "Expires At",
@@ -28,15 +28,26 @@ var messages = map[string]map[string]string{
                 "completed":                   "Completed",
                 "pending":                     "Pending",
                 "failed":                      "Failed",
+                "underpaid":                   "Underpaid",
+                "expired":                     "Expired",
                 "crypto_usage":                "Usage: /crypto <plan_id> <currency>\nExample: /crypto 2 BTC",
                 "crypto_payment_instructions": "💰 Crypto Payment Instructions for",
                 "address":                     "Address",
                 "amount":                      "Amount",
+                "quote_valid_until":           "Price valid until",
                 "crypto_payment_note":         "⚠️ Please send the exact amount to the address above. Payment will be confirmed automatically within 10 minutes.",
+                "choose_crypto":               "Choose a cryptocurrency:",
+                "payment_link":                "💳 Follow the link to complete the payment:",
+                "pay_now":                     "Pay",
+                "payment_unavailable":         "This payment method is currently unavailable.",
                 "payment_successful":          "✅ Payment Successful! Your subscription has been activated.",
                 "plan":                        "Plan",
                 "payment_not_found":           "Payment not found. Please contact support.",
                 "unknown_command":             "Unknown command. Use /help to see available commands.",
+                "login_link":                  "🔐 Tap the button to sign in to the dashboard. The link works once and expires in a few minutes; don't share it.",
+                "login_link_error":            "❌ Could not create a sign-in link, please try again later.",
+                "login_private_only":          "Send /login to me in a private chat.",
+                "open_dashboard":              "Open dashboard",
                 "subscription_expired":        "⚠️ Your subscription has expired. You are now on the free plan.",
                 "upgrade_prompt":              "💎 Upgrade to a premium plan to unlock advanced features:\n\n/plans - View available plans",
                 "subscription_expiring_3_days": "⏰ Your %s subscription expires in 3 days!",
@@ -48,10 +59,26 @@ var messages = map[string]map[string]string{
                 "getting_started":             "🚀 Getting Started:\n\n1. Use /plans to view available plans\n2. Choose a plan that suits your needs\n3. Complete payment to unlock premium features\n4. Enjoy advanced functionality!",
                 "payment_confirmation":        "✅ Payment confirmed for %s plan: $%.2f %s",
                 "enjoy_features":              "🎉 Enjoy your premium features! Use /help to see what you can do.",
+                "chat_access_links":           "🔑 Your plan includes these private chats. Each link lets one person in, so don't share it:",
+                "join_request_declined":       "🔒 %s is for subscribers only. Choose a plan, then send your join request again:",
+                "subscription_cancel_at_period_end": "✅ Your subscription will not renew. You keep your plan until %s.",
+                "no_subscription":             "You have no subscription to cancel.",
+                "subscription_past_due":       "⚠️ Your %s subscription period has ended. Renew in the next few days to keep your features.",
+                "subscription_grace":          "⏳ Your %s subscription was not renewed, so its features and chats are off. Renew soon to get them back before it expires.",
+                "subscription_status":         "Status",
+                "status_trialing":             "Trial until %s",
+                "status_active":               "Active",
+                "status_past_due":             "Renewal due",
+                "status_grace":                "Not renewed, features off",
+                "status_canceled":             "Canceled, plan kept until %s",
+                "status_expired":              "Expired",
+                "trial_button":                "🎁 Try free for %d days",
+                "trial_started":               "🎁 Your trial has started and lasts until %s. Cancel any time with /cancel.",
+                "trial_unavailable":           "The trial is only available before your first subscription.",
         },
         "ru": {
                 "welcome":                     "🎉 Добро пожаловать в бота подписок!\n\nЯ помогаю управлять подписками и получать доступ к премиум функциям. Используйте /help для просмотра доступных команд.",
-                "help":                        "🔧 Доступные команды:\n\n/start - Приветственное сообщение\n/help - Показать эту справку\n/plans - Посмотреть планы подписок\n/myplan - Проверить текущий план\n/subscribe <plan_id> - Подписаться на план\n/cancel - Отменить подписку\n/history - Посмотреть историю платежей\n/crypto <plan_id> <currency> - Оплатить криптой\n/setup - Инструкция по настройке бота\n/addbot - Как добавить бота в группу/канал",
+                "help":                        "🔧 Доступные команды:\n\n/start - Приветственное сообщение\n/help - Показать эту справку\n/plans - Посмотреть планы подписок\n/myplan - Проверить текущий план\n/subscribe <plan_id> - Подписаться на план\n/cancel - Отменить продление подписки\n/history - Посмотреть историю платежей\n/crypto <plan_id> <currency> - Оплатить криптой\n/setup - Инструкция по настройке бота\n/addbot - Как добавить бота в группу/канал\n/login - Войти в веб-интерфейс\n/modsettings - Настройки модерации группы (для администраторов)\n/links - Разрешенные и запрещенные ссылки группы (для администраторов)\n/moderators - Модераторы бота в группе (для администраторов)\n/report - Пожаловаться модераторам (ответом на сообщение)",
                 "available_plans":             "💎 Доступные планы подписок:",
                 "current_plan":                "Текущий план",
                 "expires_at":                  "Истекает",
@@ -74,15 +101,26 @@ var messages = map[string]map[string]string{
                 "completed":                   "Завершен",
                 "pending":                     "Ожидает",
                 "failed":                      "Неудачно",
+                "underpaid":                   "Недоплачен",
+                "expired":                     "Истек",
                 "crypto_usage":                "Использование: /crypto <plan_id> <currency>\nПример: /crypto 2 BTC",
                 "crypto_payment_instructions": "💰 Инструкции по оплате криптой для",
                 "address":                     "Адрес",
                 "amount":                      "Сумма",
+                "quote_valid_until":           "Цена действительна до",
                 "crypto_payment_note":         "⚠️ Пожалуйста, отправьте точную сумму на указанный адрес. Платеж будет подтвержден автоматически в течение 10 минут.",
+                "choose_crypto":               "Выберите криптовалюту:",
+                "payment_link":                "💳 Перейдите по ссылке, чтобы завершить оплату:",
+                "pay_now":                     "Оплатить",
+                "payment_unavailable":         "Этот способ оплаты сейчас недоступен.",
                 "payment_successful":          "✅ Платеж успешен! Ваша подписка активирована.",
                 "plan":                        "План",
                 "payment_not_found":           "Платеж не найден. Пожалуйста, обратитесь в поддержку.",
                 "unknown_command":             "Неизвестная команда. Используйте /help для просмотра доступных команд.",
+                "login_link":                  "🔐 Нажмите кнопку, чтобы войти в веб-интерфейс. Ссылка одноразовая и действует несколько минут — никому ее не пересылайте.",
+                "login_link_error":            "❌ Не удалось создать ссылку для входа, попробуйте позже.",
+                "login_private_only":          "Отправьте /login мне в личные сообщения.",
+                "open_dashboard":              "Открыть кабинет",
                 "subscription_expired":        "⚠️ Ваша подписка истекла. Теперь у вас бесплатный план.",
                 "upgrade_prompt":              "💎 Обновитесь до премиум плана для разблокировки дополнительных функций:\n\n/plans - Посмотреть доступные планы",
                 "subscription_expiring_3_days": "⏰ Ваша подписка %s истекает через 3 дня!",
@@ -94,6 +132,22 @@ var messages = map[string]map[string]string{
                 "getting_started":             "🚀 Начало работы:\n\n1. Используйте /plans для просмотра доступных планов\n2. Выберите подходящий план\n3. Завершите оплату для разблокировки премиум функций\n4. Наслаждайтесь расширенным функционалом!",
                 "payment_confirmation":        "✅ Платеж подтвержден для плана %s: $%.2f %s",
                 "enjoy_features":              "🎉 Наслаждайтесь вашими премиум функциями! Используйте /help чтобы узнать что вы можете делать.",
+                "chat_access_links":           "🔑 В ваш план входят закрытые чаты. Каждая ссылка впускает одного человека — не пересылайте ее:",
+                "join_request_declined":       "🔒 «%s» — только для подписчиков. Выберите план и подайте заявку снова:",
+                "subscription_cancel_at_period_end": "✅ Подписка не будет продлена. План сохранится до %s.",
+                "no_subscription":             "У вас нет подписки, которую можно отменить.",
+                "subscription_past_due":       "⚠️ Оплаченный период подписки %s закончился. Продлите ее в ближайшие дни, чтобы сохранить функции.",
+                "subscription_grace":          "⏳ Подписка %s не продлена, ее функции и чаты отключены. Продлите ее, чтобы вернуть их, пока она не истекла.",
+                "subscription_status":         "Статус",
+                "status_trialing":             "Пробный период до %s",
+                "status_active":               "Активна",
+                "status_past_due":             "Ожидает продления",
+                "status_grace":                "Не продлена, функции отключены",
+                "status_canceled":             "Отменена, план сохранится до %s",
+                "status_expired":              "Истекла",
+                "trial_button":                "🎁 Попробовать бесплатно %d дн.",
+                "trial_started":               "🎁 Пробный период начался и продлится до %s. Отменить можно командой /cancel.",
+                "trial_unavailable":           "Пробный период доступен только до первой подписки.",
         },
 }
 
@@ -1,9 +1,11 @@
 package main
 
 import (
+        "fmt"
         "log"
         "os"
         "os/signal"
+        "strconv"
         "strings"
         "syscall"
 
@@ -41,6 +43,14 @@ func main() {
         }
         defer db.Close()
 
+        // `telegram-bot migrate status|up|down [n]` manages the schema and exits
+        if len(os.Args) > 1 && os.Args[1] == "migrate" {
+                if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+                        log.Fatal("Migration failed:", err)
+                }
+                return
+        }
+
         // Run migrations
         if err := database.Migrate(db); err != nil {
                 log.Fatal("Failed to run migrations:", err)
@@ -56,25 +66,55 @@ func main() {
         logger.Info("Authorized on account %s", bot.Self.UserName)
 
         // Initialize services
-        paymentService := services.NewPaymentService(db, cfg)
-        subscriptionService := services.NewSubscriptionService(db)
-        notificationService := services.NewNotificationService(bot, db)
+        entitlements := services.NewEntitlementService(db, cfg.FreeGroupLimit)
+        groupLimits := services.NewGroupLimitService(db, entitlements, cfg.FreeGroupLimit)
+        subscriptionService := services.NewSubscriptionService(db, groupLimits, entitlements, cfg.SubscriptionPastDue, cfg.SubscriptionGrace)
+        paymentService := services.NewPaymentService(db, cfg, bot, subscriptionService)
+        if err := paymentService.LoadProviders(); err != nil {
+                log.Printf("Failed to load payment providers: %v", err)
+        }
+        notificationService := services.NewNotificationService(bot, db, subscriptionService)
+        authService := services.NewAuthService(db, cfg, bot.Self.UserName)
+        moderationSettings := services.NewModerationSettingsService(db)
+        forbiddenWords := services.NewForbiddenWordService(db)
+        linkFilter := services.NewLinkFilterService(db)
+        moderationService := services.NewModerationService(bot, db)
+        moderators := services.NewModeratorService(bot, db)
+        chatAccess := services.NewChatAccessService(bot, db)
 
         // Initialize repositories
-        userRepo := models.NewUserRepository(db.DB)
-        paymentRepo := models.NewPaymentRepository(db.DB)
+        webhookRepo := models.NewWebhookLogRepository(db.DB)
         
         // Initialize handlers
-        commandHandler := handlers.NewCommandHandler(bot, db, subscriptionService, paymentService)
-        paymentHandler := handlers.NewPaymentHandler(bot, userRepo, paymentRepo)
+        commandHandler := handlers.NewCommandHandler(bot, db, subscriptionService, paymentService, authService)
+        paymentHandler := handlers.NewPaymentHandler(bot, paymentService, webhookRepo)
         adminHandler := handlers.NewAdminHandler(bot, db, subscriptionService, paymentService)
-        moderationHandler := handlers.NewModerationHandler(bot, db)
+        moderationHandler := handlers.NewModerationHandler(bot, db, moderationSettings, forbiddenWords, linkFilter, moderationService, moderators, entitlements)
+        captchaHandler := handlers.NewCaptchaHandler(bot, db, moderationSettings, moderators, entitlements)
+        joinRequestHandler := handlers.NewJoinRequestHandler(bot, db, subscriptionService, chatAccess, captchaHandler)
+
+        // Finish join verifications left open by the previous run
+        captchaHandler.Resume()
+
+        // Suspend groups registered beyond their owner's plan limit before this check existed
+        if err := groupLimits.Enforce(0); err != nil {
+                log.Printf("Failed to enforce group limits: %v", err)
+        }
 
         // Start notification service
         go notificationService.Start()
 
+        // Settle crypto payments once their transfers are confirmed
+        go services.NewCryptoPaymentPoller(paymentService, cfg.CryptoPollInterval).Start()
+
+        // End expired mutes and bans and reconcile sanctions with Telegram
+        go services.NewSanctionSweeper(bot, db, cfg.SanctionSweepInterval, cfg.SanctionCheckBatch).Start()
+
+        // Remove members from plan chats once their subscription lapsed
+        go services.NewChatAccessSweeper(chatAccess, cfg.ChatAccessSweepInterval, cfg.ChatAccessGrace).Start()
+
         // Start web dashboard
-        go startWebDashboard(db, cfg)
+        go startWebDashboard(db, cfg, paymentHandler, paymentService, authService, moderationSettings, forbiddenWords, linkFilter, moderationService, groupLimits, moderators, entitlements, subscriptionService)
 
         // Start bot polling
         u := tgbotapi.NewUpdate(0)
@@ -88,7 +128,7 @@ func main() {
 
         go func() {
                 for update := range updates {
-                        go handleUpdate(update, commandHandler, paymentHandler, adminHandler, moderationHandler, logger)
+                        go handleUpdate(update, commandHandler, paymentHandler, adminHandler, moderationHandler, captchaHandler, joinRequestHandler, logger)
                 }
         }()
 
@@ -96,7 +136,7 @@ func main() {
         logger.Info("Bot stopped")
 }
 
-func handleUpdate(update tgbotapi.Update, commandHandler *handlers.CommandHandler, paymentHandler *handlers.PaymentHandler, adminHandler *handlers.AdminHandler, moderationHandler *handlers.ModerationHandler, logger *utils.Logger) {
+func handleUpdate(update tgbotapi.Update, commandHandler *handlers.CommandHandler, paymentHandler *handlers.PaymentHandler, adminHandler *handlers.AdminHandler, moderationHandler *handlers.ModerationHandler, captchaHandler *handlers.CaptchaHandler, joinRequestHandler *handlers.JoinRequestHandler, logger *utils.Logger) {
         defer func() {
                 if r := recover(); r != nil {
                         logger.Error("Panic in update handler: %v", r)
@@ -111,6 +151,14 @@ func handleUpdate(update tgbotapi.Update, commandHandler *handlers.CommandHandle
                 case "unban":
                         args := strings.Split(update.Message.CommandArguments(), " ")
                         moderationHandler.HandleUnbanCommand(update.Message, args)
+                case "modsettings":
+                        moderationHandler.HandleSettingsCommand(update.Message, strings.Fields(update.Message.CommandArguments()))
+                case "links":
+                        moderationHandler.HandleLinksCommand(update.Message, strings.Fields(update.Message.CommandArguments()))
+                case "moderators":
+                        moderationHandler.HandleModeratorsCommand(update.Message, strings.Fields(update.Message.CommandArguments()))
+                case "report":
+                        moderationHandler.HandleReportCommand(update.Message)
                 default:
                         commandHandler.Handle(update)
                 }
@@ -118,15 +166,25 @@ func handleUpdate(update tgbotapi.Update, commandHandler *handlers.CommandHandle
                 paymentHandler.HandleTelegramPayment(update)
         } else if update.Message != nil && update.Message.SuccessfulPayment != nil {
                 paymentHandler.HandleTelegramPayment(update)
+        } else if update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, "captcha:") {
+                captchaHandler.HandleCallback(update.CallbackQuery)
+        } else if update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, "report:") {
+                moderationHandler.HandleReportCallback(update.CallbackQuery)
         } else if update.CallbackQuery != nil {
                 commandHandler.HandleCallback(update)
-        } else if update.Message != nil && update.Message.Text != "" {
+        } else if update.ChatJoinRequest != nil {
+                joinRequestHandler.HandleJoinRequest(update.ChatJoinRequest)
+        } else if update.Message != nil && len(update.Message.NewChatMembers) > 0 {
+                captchaHandler.HandleNewMembers(update.Message)
+        } else if update.Message != nil && update.Message.LeftChatMember != nil {
+                captchaHandler.HandleLeftMember(update.Message)
+        } else if update.Message != nil {
                 // Проверяем сообщения на нарушения
                 moderationHandler.ProcessMessage(update.Message)
         }
 }
 
-func startWebDashboard(db *database.DB, cfg *config.Config) {
+func startWebDashboard(db *database.DB, cfg *config.Config, paymentHandler *handlers.PaymentHandler, paymentService *services.PaymentService, authService *services.AuthService, moderationSettings *services.ModerationSettingsService, forbiddenWords *services.ForbiddenWordService, linkFilter *services.LinkFilterService, moderationService *services.ModerationService, groupLimits *services.GroupLimitService, moderators *services.ModeratorService, entitlements *services.EntitlementService, subscriptionService *services.SubscriptionService) {
         if !cfg.WebDashboard {
                 return
         }
@@ -135,10 +193,70 @@ func startWebDashboard(db *database.DB, cfg *config.Config) {
         r := gin.New()
         r.Use(gin.Recovery())
 
-        dashboard := web.NewDashboard(db)
+        dashboard := web.NewDashboard(db, paymentHandler, paymentService, authService, moderationSettings, forbiddenWords, linkFilter, moderationService, groupLimits, moderators, entitlements, subscriptionService)
         dashboard.SetupRoutes(r)
 
         if err := r.Run(":5000"); err != nil {
                 log.Printf("Failed to start web dashboard: %v", err)
         }
 }
+
+func runMigrateCommand(db *database.DB, args []string) error {
+        if len(args) == 0 {
+                return fmt.Errorf("usage: migrate status|up|down [n]")
+        }
+
+        // up applies everything pending by default, down reverts one migration
+        steps := 0
+        if args[0] == "down" {
+                steps = 1
+        }
+        if len(args) > 1 {
+                n, err := strconv.Atoi(args[1])
+                if err != nil || n < 1 {
+                        return fmt.Errorf("invalid number of migrations: %s", args[1])
+                }
+                steps = n
+        }
+
+        switch args[0] {
+        case "status":
+                states, err := database.MigrationStatus(db)
+                if err != nil {
+                        return err
+                }
+                for _, state := range states {
+                        status := "pending"
+                        if state.AppliedAt != nil {
+                                status = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+                        }
+                        fmt.Printf("%03d_%-30s %s\n", state.Version, state.Name, status)
+                }
+        case "up":
+                applied, err := database.MigrateUp(db, steps)
+                for _, migration := range applied {
+                        fmt.Printf("applied  %03d_%s\n", migration.Version, migration.Name)
+                }
+                if err != nil {
+                        return err
+                }
+                if len(applied) == 0 {
+                        fmt.Println("schema is up to date")
+                }
+        case "down":
+                reverted, err := database.MigrateDown(db, steps)
+                for _, migration := range reverted {
+                        fmt.Printf("reverted %03d_%s\n", migration.Version, migration.Name)
+                }
+                if err != nil {
+                        return err
+                }
+                if len(reverted) == 0 {
+                        fmt.Println("no applied migrations")
+                }
+        default:
+                return fmt.Errorf("unknown migrate command %q, expected status, up or down", args[0])
+        }
+
+        return nil
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DashboardSession is a login to the web dashboard. UserID is 0 for the admin
+// account from the config, which has no row in users.
+type DashboardSession struct {
+	ID         int64     `json:"id" db:"id"`
+	TokenHash  string    `json:"-" db:"token_hash"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Role       string    `json:"role" db:"role"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+type DashboardSessionRepository struct {
+	db *sql.DB
+}
+
+func NewDashboardSessionRepository(db *sql.DB) *DashboardSessionRepository {
+	return &DashboardSessionRepository{db: db}
+}
+
+func (r *DashboardSessionRepository) Create(session *DashboardSession) error {
+	var userID sql.NullInt64
+	if session.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(session.UserID), Valid: true}
+	}
+
+	query := `
+		INSERT INTO dashboard_sessions (token_hash, user_id, role, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_seen_at
+	`
+	return r.db.QueryRow(query, session.TokenHash, userID, session.Role, session.ExpiresAt).Scan(
+		&session.ID, &session.CreatedAt, &session.LastSeenAt,
+	)
+}
+
+// GetActive returns the unexpired session with the given token hash
+func (r *DashboardSessionRepository) GetActive(tokenHash string) (*DashboardSession, error) {
+	session := &DashboardSession{}
+	var userID sql.NullInt64
+	query := `
+		SELECT id, token_hash, user_id, role, created_at, last_seen_at, expires_at
+		FROM dashboard_sessions
+		WHERE token_hash = $1 AND expires_at > NOW()
+	`
+
+	err := r.db.QueryRow(query, tokenHash).Scan(
+		&session.ID, &session.TokenHash, &userID, &session.Role,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.UserID = int(userID.Int64)
+	return session, nil
+}
+
+func (r *DashboardSessionRepository) Touch(id int64) error {
+	_, err := r.db.Exec(`UPDATE dashboard_sessions SET last_seen_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (r *DashboardSessionRepository) Delete(tokenHash string) error {
+	_, err := r.db.Exec(`DELETE FROM dashboard_sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+// DeleteByUser ends every session of the user except the one with keepID
+func (r *DashboardSessionRepository) DeleteByUser(userID int, keepID int64) error {
+	_, err := r.db.Exec(`DELETE FROM dashboard_sessions WHERE user_id = $1 AND id <> $2`, userID, keepID)
+	return err
+}
+
+// DeleteExpired removes expired sessions and sign-in links
+func (r *DashboardSessionRepository) DeleteExpired() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM dashboard_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.Exec(`DELETE FROM dashboard_login_links WHERE expires_at <= NOW()`); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CreateLoginLink records a sign-in link so it can be used exactly once
+func (r *DashboardSessionRepository) CreateLoginLink(nonce string, userID int, expiresAt time.Time) error {
+	query := `INSERT INTO dashboard_login_links (nonce, user_id, expires_at) VALUES ($1, $2, $3)`
+	_, err := r.db.Exec(query, nonce, userID, expiresAt)
+	return err
+}
+
+// UseLoginLink marks the user's unexpired link as used and reports whether it
+// was still unused
+func (r *DashboardSessionRepository) UseLoginLink(nonce string, userID int) (bool, error) {
+	query := `
+		UPDATE dashboard_login_links SET used_at = NOW()
+		WHERE nonce = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW()
+	`
+	result, err := r.db.Exec(query, nonce, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
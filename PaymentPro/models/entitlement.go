@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// FreePlanID is the plan users fall back to once their paid plan lapses
+const FreePlanID = 1
+
+// Feature is a boolean entitlement advertised in subscription_plans.features
+type Feature string
+
+const (
+	FeatureBasicModeration Feature = "basic_moderation"
+	FeatureAdvancedStats   Feature = "advanced_stats"
+	FeatureCustomCommands  Feature = "custom_commands"
+	FeatureAPIAccess       Feature = "api_access"
+	FeaturePrioritySupport Feature = "priority_support"
+)
+
+// Limit is a numeric entitlement: max_groups, or a number in
+// subscription_plans.features
+type Limit string
+
+const (
+	LimitGroups Limit = "max_groups"
+)
+
+// Entitlements is what a subscriber, or a group through its owners, is
+// entitled to. Suspended is set on a group that is beyond the limit of every
+// owner who registered it; it gets the free plan's entitlements.
+type Entitlements struct {
+	PlanID    int              `json:"plan_id"`
+	PlanName  string           `json:"plan_name"`
+	Paid      bool             `json:"paid"`
+	ExpiresAt *time.Time       `json:"expires_at"`
+	Suspended bool             `json:"suspended"`
+	Features  map[Feature]bool `json:"features"`
+	Limits    map[Limit]int    `json:"limits"`
+}
+
+// ValidateFeatures checks that every feature of the plan is true/false or a
+// whole number, the only values NewEntitlements understands
+func (p *SubscriptionPlan) ValidateFeatures() error {
+	for key, value := range p.Features {
+		if _, ok := value.(bool); ok {
+			continue
+		}
+		if _, ok := count(value); !ok {
+			return fmt.Errorf("feature %q must be true, false or a whole number, not %v", key, value)
+		}
+	}
+	return nil
+}
+
+// NewEntitlements reads the plan's features: true/false values are features
+// and whole numbers are limits; any other value is ignored rather than
+// trusted. groupLimit is the number of groups the subscriber may register.
+func NewEntitlements(plan *SubscriptionPlan, groupLimit int) *Entitlements {
+	e := &Entitlements{
+		PlanID:   plan.ID,
+		PlanName: plan.Name,
+		Features: make(map[Feature]bool),
+		Limits:   map[Limit]int{LimitGroups: groupLimit},
+	}
+
+	for key, value := range plan.Features {
+		if enabled, ok := value.(bool); ok {
+			e.Features[Feature(key)] = enabled
+		} else if n, ok := count(value); ok && Limit(key) != LimitGroups {
+			// max_groups has its own column
+			e.Limits[Limit(key)] = n
+		}
+	}
+	return e
+}
+
+// count returns a feature value that is a whole number; JSON numbers decode
+// to float64
+func count(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, v >= 0
+	case float64:
+		if v >= 0 && v == math.Trunc(v) && v <= math.MaxInt32 {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// Has reports whether the feature is enabled
+func (e *Entitlements) Has(feature Feature) bool {
+	return e != nil && e.Features[feature]
+}
+
+// Limit returns the limit and whether the plan sets it
+func (e *Entitlements) Limit(limit Limit) (int, bool) {
+	if e == nil {
+		return 0, false
+	}
+	n, ok := e.Limits[limit]
+	return n, ok
+}
+
+// Merge adds other's features and raises limits to other's; a group
+// registered by several owners gets the best of their plans
+func (e *Entitlements) Merge(other *Entitlements) {
+	for feature, enabled := range other.Features {
+		e.Features[feature] = e.Features[feature] || enabled
+	}
+	for limit, n := range other.Limits {
+		if current, ok := e.Limits[limit]; !ok || n > current {
+			e.Limits[limit] = n
+		}
+	}
+	if other.Paid && !e.Paid {
+		e.PlanID, e.PlanName, e.Paid, e.ExpiresAt = other.PlanID, other.PlanName, true, other.ExpiresAt
+	}
+}
+
+// ModerationSettings returns the settings a group is moderated by: none
+// without basic_moderation and only basic moderation while suspended. Unknown
+// (nil) entitlements leave the settings as they are.
+func (e *Entitlements) ModerationSettings(settings *ModerationSettings) *ModerationSettings {
+	switch {
+	case e == nil:
+		return settings
+	case !e.Has(FeatureBasicModeration):
+		off := *settings
+		off.ModerationEnabled = false
+		return &off
+	case e.Suspended:
+		return settings.Basic()
+	}
+	return settings
+}
+
+// Quota is a limit together with how much of it is used
+type Quota struct {
+	Limit int `json:"limit"`
+	Used  int `json:"used"`
+}
+
+func (q Quota) Remaining() int {
+	if q.Used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.Used
+}
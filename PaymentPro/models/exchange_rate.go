@@ -0,0 +1,43 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ExchangeRate is the price of one unit of Crypto in Fiat as last fetched
+type ExchangeRate struct {
+	Crypto    string    `json:"crypto" db:"crypto"`
+	Fiat      string    `json:"fiat" db:"fiat"`
+	Rate      float64   `json:"rate" db:"rate"`
+	FetchedAt time.Time `json:"fetched_at" db:"fetched_at"`
+}
+
+type ExchangeRateRepository struct {
+	db *sql.DB
+}
+
+func NewExchangeRateRepository(db *sql.DB) *ExchangeRateRepository {
+	return &ExchangeRateRepository{db: db}
+}
+
+func (r *ExchangeRateRepository) Get(crypto, fiat string) (*ExchangeRate, error) {
+	rate := &ExchangeRate{}
+	query := `SELECT crypto, fiat, rate, fetched_at FROM exchange_rates WHERE crypto = $1 AND fiat = $2`
+	
+	err := r.db.QueryRow(query, crypto, fiat).Scan(&rate.Crypto, &rate.Fiat, &rate.Rate, &rate.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
+
+func (r *ExchangeRateRepository) Save(rate *ExchangeRate) error {
+	query := `
+		INSERT INTO exchange_rates (crypto, fiat, rate, fetched_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (crypto, fiat) DO UPDATE SET rate = EXCLUDED.rate, fetched_at = EXCLUDED.fetched_at
+	`
+	_, err := r.db.Exec(query, rate.Crypto, rate.Fiat, rate.Rate, rate.FetchedAt)
+	return err
+}
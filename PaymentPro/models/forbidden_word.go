@@ -0,0 +1,165 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How a forbidden word is matched against a message
+const (
+	MatchWord      = "word"      // whole word; a leading or trailing * also matches word prefixes or suffixes
+	MatchSubstring = "substring" // anywhere, even inside other words
+	MatchRegex     = "regex"     // regular expression, case-insensitive
+)
+
+// ForbiddenWord is an entry of a chat's list, or of the global list that
+// applies to every chat when ChatID is nil
+type ForbiddenWord struct {
+	ID        int       `json:"id" db:"id"`
+	ChatID    *int64    `json:"chat_id" db:"chat_id"`
+	Word      string    `json:"word" db:"word"`
+	MatchType string    `json:"match_type" db:"match_type"`
+	IsActive  bool      `json:"is_active" db:"is_active"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (w *ForbiddenWord) Validate() error {
+	w.Word = strings.TrimSpace(w.Word)
+	if w.Word == "" {
+		return errors.New("word is required")
+	}
+	if len(w.Word) > 255 {
+		return errors.New("word must be at most 255 bytes")
+	}
+	switch w.MatchType {
+	case MatchWord, MatchSubstring:
+		if strings.Trim(w.Word, "*") == "" {
+			return errors.New("word must contain more than wildcards")
+		}
+	case MatchRegex:
+		if _, err := regexp.Compile(w.Word); err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+	default:
+		return fmt.Errorf("match type must be %s, %s or %s", MatchWord, MatchSubstring, MatchRegex)
+	}
+	return nil
+}
+
+type ForbiddenWordRepository struct {
+	db *sql.DB
+}
+
+func NewForbiddenWordRepository(db *sql.DB) *ForbiddenWordRepository {
+	return &ForbiddenWordRepository{db: db}
+}
+
+// List returns the chat's entries, or the global ones when chatID is nil
+func (r *ForbiddenWordRepository) List(chatID *int64) ([]*ForbiddenWord, error) {
+	return r.list(chatID, false)
+}
+
+// ListActive is List without the disabled entries
+func (r *ForbiddenWordRepository) ListActive(chatID *int64) ([]*ForbiddenWord, error) {
+	return r.list(chatID, true)
+}
+
+func (r *ForbiddenWordRepository) list(chatID *int64, activeOnly bool) ([]*ForbiddenWord, error) {
+	query := `
+		SELECT id, chat_id, word, match_type, is_active, created_at
+		FROM forbidden_words
+		WHERE chat_id IS NOT DISTINCT FROM $1 AND (is_active OR NOT $2)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, nullChatID(chatID), activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var words []*ForbiddenWord
+	for rows.Next() {
+		w, err := scanForbiddenWord(rows)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+	return words, rows.Err()
+}
+
+func (r *ForbiddenWordRepository) GetByID(id int) (*ForbiddenWord, error) {
+	query := `
+		SELECT id, chat_id, word, match_type, is_active, created_at
+		FROM forbidden_words
+		WHERE id = $1
+	`
+	return scanForbiddenWord(r.db.QueryRow(query, id))
+}
+
+func (r *ForbiddenWordRepository) Create(w *ForbiddenWord) error {
+	query := `
+		INSERT INTO forbidden_words (chat_id, word, match_type, is_active, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, nullChatID(w.ChatID), w.Word, w.MatchType, w.IsActive).Scan(&w.ID, &w.CreatedAt)
+}
+
+// Update changes the entry's word, match type and state; its chat stays the same
+func (r *ForbiddenWordRepository) Update(w *ForbiddenWord) error {
+	query := `
+		UPDATE forbidden_words
+		SET word = $1, match_type = $2, is_active = $3
+		WHERE id = $4
+	`
+	result, err := r.db.Exec(query, w.Word, w.MatchType, w.IsActive, w.ID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (r *ForbiddenWordRepository) Delete(id int) error {
+	result, err := r.db.Exec(`DELETE FROM forbidden_words WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func scanForbiddenWord(row rowScanner) (*ForbiddenWord, error) {
+	w := &ForbiddenWord{}
+	var chatID sql.NullInt64
+	if err := row.Scan(&w.ID, &chatID, &w.Word, &w.MatchType, &w.IsActive, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	if chatID.Valid {
+		w.ChatID = &chatID.Int64
+	}
+	return w, nil
+}
+
+func nullChatID(chatID *int64) sql.NullInt64 {
+	if chatID == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *chatID, Valid: true}
+}
+
+// requireRow turns an update that touched nothing into sql.ErrNoRows
+func requireRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
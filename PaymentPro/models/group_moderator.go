@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GroupModerator may use the bot's moderation commands in a group without
+// being a Telegram admin there
+type GroupModerator struct {
+	ID             int       `json:"id" db:"id"`
+	ChatID         int64     `json:"chat_id" db:"chat_id"`
+	TelegramUserID int64     `json:"telegram_user_id" db:"telegram_user_id"`
+	AddedBy        int64     `json:"added_by" db:"added_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+type GroupModeratorRepository struct {
+	db *sql.DB
+}
+
+func NewGroupModeratorRepository(db *sql.DB) *GroupModeratorRepository {
+	return &GroupModeratorRepository{db: db}
+}
+
+func (r *GroupModeratorRepository) List(chatID int64) ([]*GroupModerator, error) {
+	query := `
+		SELECT id, chat_id, telegram_user_id, COALESCE(added_by, 0), created_at
+		FROM group_moderators
+		WHERE chat_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moderators []*GroupModerator
+	for rows.Next() {
+		m := &GroupModerator{}
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.TelegramUserID, &m.AddedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, m)
+	}
+	return moderators, rows.Err()
+}
+
+// Add makes the member a moderator of the chat; adding one twice is a no-op
+func (r *GroupModeratorRepository) Add(m *GroupModerator) error {
+	query := `
+		INSERT INTO group_moderators (chat_id, telegram_user_id, added_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id, telegram_user_id) DO UPDATE SET chat_id = EXCLUDED.chat_id
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, m.ChatID, m.TelegramUserID, m.AddedBy).Scan(&m.ID, &m.CreatedAt)
+}
+
+// Remove returns sql.ErrNoRows if the member was not a moderator of the chat
+func (r *GroupModeratorRepository) Remove(chatID, telegramUserID int64) error {
+	result, err := r.db.Exec(`DELETE FROM group_moderators WHERE chat_id = $1 AND telegram_user_id = $2`, chatID, telegramUserID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Where a join verification came from
+const (
+	JoinSourceMember  = "join"         // the member is already in the group, restricted
+	JoinSourceRequest = "join_request" // the user asked to join and waits for approval
+)
+
+// How a join verification ended
+const (
+	JoinPending = "pending"
+	JoinPassed  = "passed"
+	JoinFailed  = "failed"  // wrong answer
+	JoinTimeout = "timeout" // no answer in time
+	JoinLeft    = "left"    // left the group before answering
+)
+
+// JoinVerification is the challenge a new member of a group has to answer
+type JoinVerification struct {
+	ID             int        `json:"id" db:"id"`
+	ChatID         int64      `json:"chat_id" db:"chat_id"`
+	TelegramUserID int64      `json:"telegram_user_id" db:"telegram_user_id"`
+	Source         string     `json:"source" db:"source"`
+	ChallengeType  string     `json:"challenge_type" db:"challenge_type"`
+	Answer         string     `json:"-" db:"answer"`
+	MessageChatID  int64      `json:"message_chat_id" db:"message_chat_id"`
+	MessageID      int        `json:"message_id" db:"message_id"`
+	Status         string     `json:"status" db:"status"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at" db:"resolved_at"`
+}
+
+type JoinVerificationRepository struct {
+	db *sql.DB
+}
+
+func NewJoinVerificationRepository(db *sql.DB) *JoinVerificationRepository {
+	return &JoinVerificationRepository{db: db}
+}
+
+func (r *JoinVerificationRepository) Create(v *JoinVerification) error {
+	query := `
+		INSERT INTO join_verifications (chat_id, telegram_user_id, source, challenge_type, answer,
+			message_chat_id, message_id, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, v.ChatID, v.TelegramUserID, v.Source, v.ChallengeType, v.Answer,
+		v.MessageChatID, v.MessageID, JoinPending, v.ExpiresAt,
+	).Scan(&v.ID, &v.CreatedAt)
+}
+
+// GetPending returns the member's open challenge in the chat
+func (r *JoinVerificationRepository) GetPending(chatID, telegramUserID int64) (*JoinVerification, error) {
+	query := `
+		SELECT id, chat_id, telegram_user_id, source, challenge_type, answer, message_chat_id, message_id,
+		       status, expires_at, created_at, resolved_at
+		FROM join_verifications
+		WHERE chat_id = $1 AND telegram_user_id = $2 AND status = 'pending'
+	`
+	return scanJoinVerification(r.db.QueryRow(query, chatID, telegramUserID))
+}
+
+// PassedWithin reports whether the member passed a challenge for the chat
+// lately, e.g. the one sent with their join request
+func (r *JoinVerificationRepository) PassedWithin(chatID, telegramUserID int64, within time.Duration) (bool, error) {
+	var passed bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM join_verifications
+			WHERE chat_id = $1 AND telegram_user_id = $2 AND status = 'passed'
+			  AND resolved_at >= NOW() - make_interval(secs => $3)
+		)
+	`
+	err := r.db.QueryRow(query, chatID, telegramUserID, within.Seconds()).Scan(&passed)
+	return passed, err
+}
+
+// ListPending returns every open challenge, oldest deadline first
+func (r *JoinVerificationRepository) ListPending() ([]*JoinVerification, error) {
+	query := `
+		SELECT id, chat_id, telegram_user_id, source, challenge_type, answer, message_chat_id, message_id,
+		       status, expires_at, created_at, resolved_at
+		FROM join_verifications
+		WHERE status = 'pending'
+		ORDER BY expires_at
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []*JoinVerification
+	for rows.Next() {
+		v, err := scanJoinVerification(rows)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, v)
+	}
+	return pending, rows.Err()
+}
+
+// Resolve closes a pending challenge with the given status. It reports false
+// when the challenge was already closed, so only one of an answer and the
+// timeout acts on it.
+func (r *JoinVerificationRepository) Resolve(id int, status string) (bool, error) {
+	query := `
+		UPDATE join_verifications
+		SET status = $1, resolved_at = NOW()
+		WHERE id = $2 AND status = 'pending'
+	`
+	result, err := r.db.Exec(query, status, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func scanJoinVerification(row rowScanner) (*JoinVerification, error) {
+	v := &JoinVerification{}
+	var messageChatID sql.NullInt64
+	var messageID sql.NullInt64
+	err := row.Scan(&v.ID, &v.ChatID, &v.TelegramUserID, &v.Source, &v.ChallengeType, &v.Answer,
+		&messageChatID, &messageID, &v.Status, &v.ExpiresAt, &v.CreatedAt, &v.ResolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	v.MessageChatID = messageChatID.Int64
+	v.MessageID = int(messageID.Int64)
+	return v, nil
+}
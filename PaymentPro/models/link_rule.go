@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What a link rule does with the links it covers
+const (
+	LinkAllow = "allow"
+	LinkDeny  = "deny"
+)
+
+// LinkRule allows or denies links in one chat. Pattern is a domain, a domain
+// with a path prefix or a @username, normalized with utils.NormalizeLinkPattern.
+type LinkRule struct {
+	ID        int       `json:"id" db:"id"`
+	ChatID    int64     `json:"chat_id" db:"chat_id"`
+	Pattern   string    `json:"pattern" db:"pattern"`
+	Action    string    `json:"action" db:"action"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (r *LinkRule) Validate() error {
+	if r.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	if r.Pattern == "" || r.Pattern == "@" {
+		return errors.New("pattern is required")
+	}
+	if len(r.Pattern) > 255 {
+		return errors.New("pattern must be at most 255 bytes")
+	}
+	if strings.ContainsAny(r.Pattern, " \t\n?#") {
+		return errors.New("pattern must be a domain, a domain with a path or a @username")
+	}
+	if r.Action != LinkAllow && r.Action != LinkDeny {
+		return fmt.Errorf("action must be %s or %s", LinkAllow, LinkDeny)
+	}
+	return nil
+}
+
+type LinkRuleRepository struct {
+	db *sql.DB
+}
+
+func NewLinkRuleRepository(db *sql.DB) *LinkRuleRepository {
+	return &LinkRuleRepository{db: db}
+}
+
+func (r *LinkRuleRepository) List(chatID int64) ([]*LinkRule, error) {
+	query := `
+		SELECT id, chat_id, pattern, action, created_at
+		FROM group_link_rules
+		WHERE chat_id = $1
+		ORDER BY pattern
+	`
+
+	rows, err := r.db.Query(query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*LinkRule
+	for rows.Next() {
+		rule := &LinkRule{}
+		if err := rows.Scan(&rule.ID, &rule.ChatID, &rule.Pattern, &rule.Action, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (r *LinkRuleRepository) GetByID(id int) (*LinkRule, error) {
+	rule := &LinkRule{}
+	query := `SELECT id, chat_id, pattern, action, created_at FROM group_link_rules WHERE id = $1`
+	err := r.db.QueryRow(query, id).Scan(&rule.ID, &rule.ChatID, &rule.Pattern, &rule.Action, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// Save adds the rule, or changes the action of the chat's rule with the same pattern
+func (r *LinkRuleRepository) Save(rule *LinkRule) error {
+	query := `
+		INSERT INTO group_link_rules (chat_id, pattern, action)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (chat_id, pattern) DO UPDATE SET action = EXCLUDED.action
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, rule.ChatID, rule.Pattern, rule.Action).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+func (r *LinkRuleRepository) Delete(id int) error {
+	result, err := r.db.Exec(`DELETE FROM group_link_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (r *LinkRuleRepository) DeleteByPattern(chatID int64, pattern string) error {
+	result, err := r.db.Exec(`DELETE FROM group_link_rules WHERE chat_id = $1 AND pattern = $2`, chatID, pattern)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
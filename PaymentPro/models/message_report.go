@@ -0,0 +1,163 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// How a report was resolved
+const (
+	ReportOpen      = "open"
+	ReportDeleted   = "deleted"   // the message was deleted
+	ReportWarned    = "warned"    // the message was deleted and its author warned
+	ReportMuted     = "muted"     // ... muted
+	ReportBanned    = "banned"    // ... banned
+	ReportDismissed = "dismissed" // nothing was wrong
+)
+
+// MessageReport is a message a member flagged with /report. The user IDs are
+// Telegram IDs; MessageText is a snapshot taken when it was reported.
+type MessageReport struct {
+	ID              int        `json:"id" db:"id"`
+	ChatID          int64      `json:"chat_id" db:"chat_id"`
+	MessageID       int        `json:"message_id" db:"message_id"`
+	ReportedUserID  int64      `json:"reported_user_id" db:"reported_user_id"`
+	ReportedName    string     `json:"reported_name" db:"reported_name"`
+	ReporterID      int64      `json:"reporter_id" db:"reporter_id"`
+	ReporterName    string     `json:"reporter_name" db:"reporter_name"`
+	MessageText     string     `json:"message_text" db:"message_text"`
+	Reason          string     `json:"reason" db:"reason"`
+	ReviewChatID    int64      `json:"review_chat_id" db:"review_chat_id"`
+	ReviewMessageID int        `json:"review_message_id" db:"review_message_id"`
+	Status          string     `json:"status" db:"status"`
+	ResolvedBy      *int64     `json:"resolved_by" db:"resolved_by"`
+	ResolvedAt      *time.Time `json:"resolved_at" db:"resolved_at"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+}
+
+type MessageReportRepository struct {
+	db *sql.DB
+}
+
+func NewMessageReportRepository(db *sql.DB) *MessageReportRepository {
+	return &MessageReportRepository{db: db}
+}
+
+const messageReportColumns = `id, chat_id, message_id, reported_user_id, reported_name, reporter_id, reporter_name,
+	message_text, reason, review_chat_id, review_message_id, status, resolved_by, resolved_at, created_at`
+
+func (r *MessageReportRepository) Create(report *MessageReport) error {
+	query := `
+		INSERT INTO message_reports (chat_id, message_id, reported_user_id, reported_name, reporter_id,
+			reporter_name, message_text, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, status, created_at
+	`
+	return r.db.QueryRow(query, report.ChatID, report.MessageID, report.ReportedUserID, report.ReportedName,
+		report.ReporterID, report.ReporterName, report.MessageText, report.Reason,
+	).Scan(&report.ID, &report.Status, &report.CreatedAt)
+}
+
+func (r *MessageReportRepository) GetByID(id int) (*MessageReport, error) {
+	query := `SELECT ` + messageReportColumns + ` FROM message_reports WHERE id = $1`
+	return scanMessageReport(r.db.QueryRow(query, id))
+}
+
+// GetOpen returns the open report of the message, sql.ErrNoRows if it has none
+func (r *MessageReportRepository) GetOpen(chatID int64, messageID int) (*MessageReport, error) {
+	query := `SELECT ` + messageReportColumns + ` FROM message_reports WHERE chat_id = $1 AND message_id = $2 AND status = 'open'`
+	return scanMessageReport(r.db.QueryRow(query, chatID, messageID))
+}
+
+// SetReview remembers where the report was sent for review
+func (r *MessageReportRepository) SetReview(id int, chatID int64, messageID int) error {
+	query := `UPDATE message_reports SET review_chat_id = $1, review_message_id = $2 WHERE id = $3`
+	_, err := r.db.Exec(query, chatID, messageID, id)
+	return err
+}
+
+// Resolve closes an open report. It reports false when the report was
+// already closed, so two moderators never act on it both.
+func (r *MessageReportRepository) Resolve(id int, status string, resolvedBy int64) (bool, error) {
+	query := `
+		UPDATE message_reports
+		SET status = $1, resolved_by = $2, resolved_at = NOW()
+		WHERE id = $3 AND status = 'open'
+	`
+	result, err := r.db.Exec(query, status, resolvedBy, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// Reopen undoes Resolve when the chosen action could not be carried out
+func (r *MessageReportRepository) Reopen(id int) error {
+	query := `UPDATE message_reports SET status = 'open', resolved_by = NULL, resolved_at = NULL WHERE id = $1`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// List returns the newest reports matching filter, an SQL condition on the
+// table aliased as r with its parameters in args
+func (r *MessageReportRepository) List(filter string, args []interface{}, limit int) ([]*MessageReport, error) {
+	args = append(args, limit)
+	query := fmt.Sprintf(`
+		SELECT %s FROM message_reports r
+		WHERE %s
+		ORDER BY r.created_at DESC
+		LIMIT $%d
+	`, messageReportColumns, filter, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*MessageReport
+	for rows.Next() {
+		report, err := scanMessageReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// OwnerTelegramID returns the Telegram ID of whoever registered the chat
+// first, sql.ErrNoRows if nobody did
+func (r *MessageReportRepository) OwnerTelegramID(chatID int64) (int64, error) {
+	var telegramID int64
+	query := `
+		SELECT u.telegram_id
+		FROM user_groups ug
+		JOIN users u ON u.id = ug.user_id
+		WHERE ug.chat_id = $1 AND ug.is_active = TRUE
+		ORDER BY ug.created_at
+		LIMIT 1
+	`
+	err := r.db.QueryRow(query, chatID).Scan(&telegramID)
+	return telegramID, err
+}
+
+func scanMessageReport(row rowScanner) (*MessageReport, error) {
+	report := &MessageReport{}
+	var reviewChatID sql.NullInt64
+	var reviewMessageID sql.NullInt64
+	err := row.Scan(&report.ID, &report.ChatID, &report.MessageID, &report.ReportedUserID, &report.ReportedName,
+		&report.ReporterID, &report.ReporterName, &report.MessageText, &report.Reason, &reviewChatID,
+		&reviewMessageID, &report.Status, &report.ResolvedBy, &report.ResolvedAt, &report.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	report.ReviewChatID = reviewChatID.Int64
+	report.ReviewMessageID = int(reviewMessageID.Int64)
+	return report, nil
+}
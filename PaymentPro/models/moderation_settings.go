@@ -0,0 +1,321 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How much the bot writes into the chat when it acts on a violation
+const (
+	NotifyFull   = "full"
+	NotifyShort  = "short"
+	NotifySilent = "silent"
+)
+
+// How new members prove they are not bots
+const (
+	CaptchaButton = "button" // press a button
+	CaptchaMath   = "math"   // pick the sum of two numbers
+)
+
+// What a step of the sanction ladder does to the member
+const (
+	SanctionWarn  = "warn"
+	SanctionMute  = "mute"  // nothing can be sent
+	SanctionMedia = "media" // only text can be sent
+	SanctionBan   = "ban"
+)
+
+// DefaultSanctionLadder warns twice, then mutes for an hour, restricts to text
+// for a day, bans for a day and finally bans for good
+const DefaultSanctionLadder = "warn,warn,mute:60,media:1440,ban:1440,ban"
+
+// MaxSanctionSteps bounds the length of a chat's sanction ladder
+const MaxSanctionSteps = 20
+
+// maxSanctionMinutes is 366 days: Telegram treats longer restrictions and
+// bans as permanent
+const maxSanctionMinutes = 366 * 24 * 60
+
+// SanctionStep is one rung of the sanction ladder. Minutes is how long a
+// mute, restriction or ban lasts; a ban without minutes is permanent.
+type SanctionStep struct {
+	Action  string `json:"action"`
+	Minutes int    `json:"minutes,omitempty"`
+}
+
+// Duration is how long the step's sanction lasts, 0 for warnings and permanent bans
+func (s SanctionStep) Duration() time.Duration {
+	return time.Duration(s.Minutes) * time.Minute
+}
+
+func (s SanctionStep) String() string {
+	if s.Minutes == 0 {
+		return s.Action
+	}
+	return fmt.Sprintf("%s:%d", s.Action, s.Minutes)
+}
+
+// ParseSanctionLadder reads a ladder like "warn,mute:60,ban:1440,ban"
+func ParseSanctionLadder(spec string) ([]SanctionStep, error) {
+	var steps []SanctionStep
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		action, minutesText, hasMinutes := strings.Cut(part, ":")
+		step := SanctionStep{Action: action}
+		if hasMinutes {
+			minutes, err := strconv.Atoi(minutesText)
+			if err != nil || minutes < 1 || minutes > maxSanctionMinutes {
+				return nil, fmt.Errorf("step %q: minutes must be between 1 and %d", part, maxSanctionMinutes)
+			}
+			step.Minutes = minutes
+		}
+
+		switch action {
+		case SanctionWarn:
+			if hasMinutes {
+				return nil, fmt.Errorf("step %q: a warning has no duration", part)
+			}
+		case SanctionMute, SanctionMedia:
+			if !hasMinutes {
+				return nil, fmt.Errorf("step %q: set the duration in minutes, e.g. %s:60", part, action)
+			}
+		case SanctionBan:
+		default:
+			return nil, fmt.Errorf("unknown step %q: use %s, %s:<minutes>, %s:<minutes>, %s:<minutes> or %s",
+				part, SanctionWarn, SanctionMute, SanctionMedia, SanctionBan, SanctionBan)
+		}
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.New("sanction ladder needs at least one step")
+	}
+	if len(steps) > MaxSanctionSteps {
+		return nil, fmt.Errorf("sanction ladder can have at most %d steps", MaxSanctionSteps)
+	}
+	return steps, nil
+}
+
+// FormatSanctionLadder is the inverse of ParseSanctionLadder
+func FormatSanctionLadder(steps []SanctionStep) string {
+	parts := make([]string, len(steps))
+	for i, step := range steps {
+		parts[i] = step.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// MaxFloodWindow is the longest flood window in seconds, so the bot never has
+// to remember more than that much of a chat's history
+const MaxFloodWindow = 600
+
+// ModerationSettings controls how the bot moderates one chat
+type ModerationSettings struct {
+	ChatID                int64     `json:"chat_id" db:"chat_id"`
+	ModerationEnabled     bool      `json:"moderation_enabled" db:"moderation_enabled"`
+	AutoBanEnabled        bool      `json:"auto_ban_enabled" db:"auto_ban_enabled"`
+	SanctionLadder        string    `json:"sanction_ladder" db:"sanction_ladder"`
+	ViolationDecayDays    int       `json:"violation_decay_days" db:"violation_decay_days"` // 0 = never
+	CheckForbiddenWords   bool      `json:"check_forbidden_words" db:"check_forbidden_words"`
+	CheckSpam             bool      `json:"check_spam" db:"check_spam"`
+	NotificationLevel     string    `json:"notification_level" db:"notification_level"`
+	FloodMessages         int       `json:"flood_messages" db:"flood_messages"`
+	FloodWindow           int       `json:"flood_window" db:"flood_window"` // seconds
+	FloodRepeats          int       `json:"flood_repeats" db:"flood_repeats"` // 0 = off
+	CaptchaEnabled        bool      `json:"captcha_enabled" db:"captcha_enabled"`
+	CaptchaType           string    `json:"captcha_type" db:"captcha_type"`
+	CaptchaTimeout        int       `json:"captcha_timeout" db:"captcha_timeout"` // seconds
+	CheckLinks            bool      `json:"check_links" db:"check_links"`
+	BlockInvites          bool      `json:"block_invites" db:"block_invites"`     // foreign Telegram invite links
+	BlockAllLinks         bool      `json:"block_all_links" db:"block_all_links"` // links not on the allow list
+	BlockForwards         bool      `json:"block_forwards" db:"block_forwards"`   // posts forwarded from channels
+	ReportChatID          int64     `json:"report_chat_id" db:"report_chat_id"`   // where /report sends reports, 0 = the owner
+	UpdatedAt             time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultModerationSettings are used for chats nobody configured yet
+func DefaultModerationSettings(chatID int64) *ModerationSettings {
+	return &ModerationSettings{
+		ChatID:                chatID,
+		ModerationEnabled:     true,
+		AutoBanEnabled:        true,
+		SanctionLadder:        DefaultSanctionLadder,
+		ViolationDecayDays:    30,
+		CheckForbiddenWords:   true,
+		CheckSpam:             true,
+		NotificationLevel:     NotifyFull,
+		FloodMessages:         5,
+		FloodWindow:           10,
+		FloodRepeats:          3,
+		CaptchaType:           CaptchaButton,
+		CaptchaTimeout:        120,
+		BlockInvites:          true,
+	}
+}
+
+func (s *ModerationSettings) Validate() error {
+	if s.ChatID == 0 {
+		return errors.New("chat_id is required")
+	}
+	steps, err := ParseSanctionLadder(s.SanctionLadder)
+	if err != nil {
+		return err
+	}
+	s.SanctionLadder = FormatSanctionLadder(steps)
+	if s.ViolationDecayDays < 0 || s.ViolationDecayDays > 3650 {
+		return errors.New("violation decay must be 0 (never) or up to 3650 days")
+	}
+	if s.FloodMessages < 2 || s.FloodMessages > 100 {
+		return errors.New("flood limit must be between 2 and 100 messages")
+	}
+	if s.FloodWindow < 1 || s.FloodWindow > MaxFloodWindow {
+		return fmt.Errorf("flood window must be between 1 and %d seconds", MaxFloodWindow)
+	}
+	if s.FloodRepeats != 0 && (s.FloodRepeats < 2 || s.FloodRepeats > 50) {
+		return errors.New("repeated messages limit must be 0 (off) or between 2 and 50")
+	}
+	switch s.CaptchaType {
+	case CaptchaButton, CaptchaMath:
+	default:
+		return fmt.Errorf("captcha type must be %s or %s", CaptchaButton, CaptchaMath)
+	}
+	if s.CaptchaTimeout < 30 || s.CaptchaTimeout > 3600 {
+		return errors.New("captcha timeout must be between 30 and 3600 seconds")
+	}
+	switch s.NotificationLevel {
+	case NotifyFull, NotifyShort, NotifySilent:
+	default:
+		return fmt.Errorf("notification level must be %s, %s or %s", NotifyFull, NotifyShort, NotifySilent)
+	}
+	return nil
+}
+
+// Sanction returns the ladder step for a member with the given number of
+// violations that have not decayed yet, the current one excluded. Without
+// automatic sanctions every violation only gets a warning.
+func (s *ModerationSettings) Sanction(previous int) SanctionStep {
+	if !s.AutoBanEnabled {
+		return SanctionStep{Action: SanctionWarn}
+	}
+	steps := s.Ladder()
+	if previous >= len(steps) {
+		return steps[len(steps)-1]
+	}
+	return steps[previous]
+}
+
+// LadderStep returns the first step of the ladder with the action, so a
+// moderator's mute or ban lasts as long as the chat's automatic ones; without
+// such a step a mute lasts an hour and a ban is permanent
+func (s *ModerationSettings) LadderStep(action string) SanctionStep {
+	for _, step := range s.Ladder() {
+		if step.Action == action {
+			return step
+		}
+	}
+	if action == SanctionMute || action == SanctionMedia {
+		return SanctionStep{Action: action, Minutes: 60}
+	}
+	return SanctionStep{Action: action}
+}
+
+// Basic returns the settings a suspended group is moderated with: forbidden
+// words and flood are still checked, but violations only get warnings and
+// the link filter and join checks are off. The settings themselves are kept.
+func (s *ModerationSettings) Basic() *ModerationSettings {
+	basic := *s
+	basic.AutoBanEnabled = false
+	basic.CheckLinks = false
+	basic.BlockInvites = false
+	basic.BlockAllLinks = false
+	basic.BlockForwards = false
+	basic.CaptchaEnabled = false
+	return &basic
+}
+
+// Ladder returns the parsed sanction ladder; a ladder that no longer parses
+// falls back to the default one
+func (s *ModerationSettings) Ladder() []SanctionStep {
+	steps, err := ParseSanctionLadder(s.SanctionLadder)
+	if err != nil {
+		steps, _ = ParseSanctionLadder(DefaultSanctionLadder)
+	}
+	return steps
+}
+
+type ModerationSettingsRepository struct {
+	db *sql.DB
+}
+
+func NewModerationSettingsRepository(db *sql.DB) *ModerationSettingsRepository {
+	return &ModerationSettingsRepository{db: db}
+}
+
+// Get returns the chat's settings, sql.ErrNoRows if it has none
+func (r *ModerationSettingsRepository) Get(chatID int64) (*ModerationSettings, error) {
+	s := &ModerationSettings{}
+	query := `
+		SELECT chat_id, moderation_enabled, auto_ban_enabled, sanction_ladder, violation_decay_days,
+		       check_forbidden_words, check_spam, notification_level,
+		       flood_messages, flood_window, flood_repeats, captcha_enabled, captcha_type, captcha_timeout,
+		       check_links, block_invites, block_all_links, block_forwards, report_chat_id, updated_at
+		FROM group_moderation_settings
+		WHERE chat_id = $1
+	`
+
+	err := r.db.QueryRow(query, chatID).Scan(
+		&s.ChatID, &s.ModerationEnabled, &s.AutoBanEnabled, &s.SanctionLadder, &s.ViolationDecayDays,
+		&s.CheckForbiddenWords, &s.CheckSpam, &s.NotificationLevel,
+		&s.FloodMessages, &s.FloodWindow, &s.FloodRepeats, &s.CaptchaEnabled, &s.CaptchaType, &s.CaptchaTimeout,
+		&s.CheckLinks, &s.BlockInvites, &s.BlockAllLinks, &s.BlockForwards, &s.ReportChatID, &s.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *ModerationSettingsRepository) Save(s *ModerationSettings) error {
+	query := `
+		INSERT INTO group_moderation_settings (chat_id, moderation_enabled, auto_ban_enabled, sanction_ladder,
+			violation_decay_days, check_forbidden_words, check_spam, notification_level,
+			flood_messages, flood_window, flood_repeats, captcha_enabled, captcha_type, captcha_timeout,
+			check_links, block_invites, block_all_links, block_forwards, report_chat_id, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, NOW())
+		ON CONFLICT (chat_id) DO UPDATE SET
+			moderation_enabled = EXCLUDED.moderation_enabled,
+			auto_ban_enabled = EXCLUDED.auto_ban_enabled,
+			sanction_ladder = EXCLUDED.sanction_ladder,
+			violation_decay_days = EXCLUDED.violation_decay_days,
+			check_forbidden_words = EXCLUDED.check_forbidden_words,
+			check_spam = EXCLUDED.check_spam,
+			notification_level = EXCLUDED.notification_level,
+			flood_messages = EXCLUDED.flood_messages,
+			flood_window = EXCLUDED.flood_window,
+			flood_repeats = EXCLUDED.flood_repeats,
+			captcha_enabled = EXCLUDED.captcha_enabled,
+			captcha_type = EXCLUDED.captcha_type,
+			captcha_timeout = EXCLUDED.captcha_timeout,
+			check_links = EXCLUDED.check_links,
+			block_invites = EXCLUDED.block_invites,
+			block_all_links = EXCLUDED.block_all_links,
+			block_forwards = EXCLUDED.block_forwards,
+			report_chat_id = EXCLUDED.report_chat_id,
+			updated_at = NOW()
+		RETURNING updated_at
+	`
+	return r.db.QueryRow(query, s.ChatID, s.ModerationEnabled, s.AutoBanEnabled, s.SanctionLadder,
+		s.ViolationDecayDays, s.CheckForbiddenWords, s.CheckSpam, s.NotificationLevel,
+		s.FloodMessages, s.FloodWindow, s.FloodRepeats, s.CaptchaEnabled, s.CaptchaType, s.CaptchaTimeout,
+		s.CheckLinks, s.BlockInvites, s.BlockAllLinks, s.BlockForwards, s.ReportChatID,
+	).Scan(&s.UpdatedAt)
+}
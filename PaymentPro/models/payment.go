@@ -2,23 +2,27 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
 type Payment struct {
-	ID              int64     `json:"id" db:"id"`
-	UserID          int64     `json:"user_id" db:"user_id"`
-	PlanID          int64     `json:"plan_id" db:"plan_id"`
-	Amount          int       `json:"amount" db:"amount"`
-	Currency        string    `json:"currency" db:"currency"`
-	PaymentMethod   string    `json:"payment_method" db:"payment_method"`
-	PaymentProvider string    `json:"payment_provider" db:"payment_provider"`
-	TransactionID   string    `json:"transaction_id" db:"transaction_id"`
-	Status          string    `json:"status" db:"status"`
-	Description     string    `json:"description" db:"description"`
-	CreatedAt       time.Time `json:"created_at" db:"created_at"`
-	CompletedAt     time.Time `json:"completed_at" db:"completed_at"`
-	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+	ID              int64             `json:"id" db:"id"`
+	UserID          int64             `json:"user_id" db:"user_id"`
+	PlanID          int64             `json:"plan_id" db:"plan_id"`
+	Amount          int               `json:"amount" db:"amount"`
+	Currency        string            `json:"currency" db:"currency"`
+	PaymentMethod   string            `json:"payment_method" db:"payment_method"`
+	PaymentProvider string            `json:"payment_provider" db:"payment_provider"`
+	TransactionID   string            `json:"transaction_id" db:"transaction_id"`
+	Status          string            `json:"status" db:"status"`
+	Description     string            `json:"description" db:"description"`
+	PaymentData     map[string]string `json:"payment_data" db:"payment_data"`
+	CreatedAt       time.Time         `json:"created_at" db:"created_at"`
+	CompletedAt     time.Time         `json:"completed_at" db:"completed_at"`
+	UpdatedAt       time.Time         `json:"updated_at" db:"updated_at"`
 }
 
 type PaymentRepository struct {
@@ -32,7 +36,7 @@ func NewPaymentRepository(db *sql.DB) *PaymentRepository {
 func (r *PaymentRepository) Create(payment *Payment) error {
 	query := `
 		INSERT INTO payments (user_id, plan_id, amount, currency, payment_method, payment_provider, transaction_id, status, description, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, $11)
 		RETURNING id
 	`
 	
@@ -56,38 +60,33 @@ func (r *PaymentRepository) Create(payment *Payment) error {
 
 func (r *PaymentRepository) GetByID(id int64) (*Payment, error) {
 	query := `
-		SELECT id, user_id, plan_id, amount, currency, payment_method, payment_provider, transaction_id, status, description, created_at, completed_at, updated_at
+		SELECT id, user_id, COALESCE(plan_id, 0), amount, currency, payment_method, payment_provider,
+		       COALESCE(transaction_id, ''), status, COALESCE(description, ''), COALESCE(payment_data, '{}'),
+		       created_at, completed_at, updated_at
 		FROM payments
 		WHERE id = $1
 	`
 	
-	payment := &Payment{}
-	err := r.db.QueryRow(query, id).Scan(
-		&payment.ID,
-		&payment.UserID,
-		&payment.PlanID,
-		&payment.Amount,
-		&payment.Currency,
-		&payment.PaymentMethod,
-		&payment.PaymentProvider,
-		&payment.TransactionID,
-		&payment.Status,
-		&payment.Description,
-		&payment.CreatedAt,
-		&payment.CompletedAt,
-		&payment.UpdatedAt,
-	)
-	
-	if err != nil {
-		return nil, err
-	}
+	return scanPayment(r.db.QueryRow(query, id))
+}
+
+func (r *PaymentRepository) GetByTransactionID(provider, transactionID string) (*Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(plan_id, 0), amount, currency, payment_method, payment_provider,
+		       COALESCE(transaction_id, ''), status, COALESCE(description, ''), COALESCE(payment_data, '{}'),
+		       created_at, completed_at, updated_at
+		FROM payments
+		WHERE payment_provider = $1 AND transaction_id = $2
+	`
 	
-	return payment, nil
+	return scanPayment(r.db.QueryRow(query, provider, transactionID))
 }
 
 func (r *PaymentRepository) GetByUserID(userID int64) ([]*Payment, error) {
 	query := `
-		SELECT id, user_id, plan_id, amount, currency, payment_method, payment_provider, transaction_id, status, description, created_at, completed_at, updated_at
+		SELECT id, user_id, COALESCE(plan_id, 0), amount, currency, payment_method, payment_provider,
+		       COALESCE(transaction_id, ''), status, COALESCE(description, ''), COALESCE(payment_data, '{}'),
+		       created_at, completed_at, updated_at
 		FROM payments
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -101,22 +100,41 @@ func (r *PaymentRepository) GetByUserID(userID int64) ([]*Payment, error) {
 	
 	var payments []*Payment
 	for rows.Next() {
-		payment := &Payment{}
-		err := rows.Scan(
-			&payment.ID,
-			&payment.UserID,
-			&payment.PlanID,
-			&payment.Amount,
-			&payment.Currency,
-			&payment.PaymentMethod,
-			&payment.PaymentProvider,
-			&payment.TransactionID,
-			&payment.Status,
-			&payment.Description,
-			&payment.CreatedAt,
-			&payment.CompletedAt,
-			&payment.UpdatedAt,
-		)
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	
+	return payments, nil
+}
+
+// GetOpenByProvider returns the provider's payments that are still waiting for
+// money, and its direct deposits whose quote expired after lateSince, where
+// money may still arrive late.
+func (r *PaymentRepository) GetOpenByProvider(provider string, lateSince time.Time) ([]*Payment, error) {
+	query := `
+		SELECT id, user_id, COALESCE(plan_id, 0), amount, currency, payment_method, payment_provider,
+		       COALESCE(transaction_id, ''), status, COALESCE(description, ''), COALESCE(payment_data, '{}'),
+		       created_at, completed_at, updated_at
+		FROM payments
+		WHERE payment_provider = $1
+		  AND (status IN ('pending', 'processing')
+		       OR (status = 'expired' AND COALESCE(payment_data->>'address', '') <> ''
+		           AND (payment_data->>'quote_expires_at')::timestamptz > $2))
+		ORDER BY created_at
+	`
+	
+	rows, err := r.db.Query(query, provider, lateSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var payments []*Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -129,7 +147,7 @@ func (r *PaymentRepository) GetByUserID(userID int64) ([]*Payment, error) {
 func (r *PaymentRepository) Update(payment *Payment) error {
 	query := `
 		UPDATE payments
-		SET plan_id = $2, amount = $3, currency = $4, payment_method = $5, payment_provider = $6, transaction_id = $7, status = $8, description = $9, completed_at = $10, updated_at = $11
+		SET plan_id = NULLIF($2, 0), amount = $3, currency = $4, payment_method = $5, payment_provider = $6, transaction_id = NULLIF($7, ''), status = $8, description = $9, completed_at = $10, updated_at = $11
 		WHERE id = $1
 	`
 	
@@ -153,6 +171,242 @@ func (r *PaymentRepository) Update(payment *Payment) error {
 	return err
 }
 
+// MarkCompleted moves a payment to the completed state within tx, the
+// transaction that activates what it pays for, and adds it to what the user
+// spent. It reports false when the payment was already completed or refunded,
+// so callers can skip the subscription activation for duplicate provider
+// notifications.
+func (r *PaymentRepository) MarkCompleted(tx *sql.Tx, id int64) (bool, error) {
+	query := `
+		UPDATE payments
+		SET status = 'completed', completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status NOT IN ('completed', 'refunded')
+	`
+	
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	query = `
+		UPDATE users
+		SET total_spent = total_spent + p.amount
+		FROM payments p
+		WHERE p.id = $1 AND users.id = p.user_id
+	`
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, err
+	}
+	
+	return true, nil
+}
+
+// AttachCheckout records the provider's checkout for a pending payment and moves
+// it to processing.
+func (r *PaymentRepository) AttachCheckout(id int64, transactionID string, data map[string]string) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	
+	query := `
+		UPDATE payments
+		SET transaction_id = NULLIF($2, ''), payment_data = $3, status = 'processing', updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`
+	_, err = r.db.Exec(query, id, transactionID, string(dataJSON))
+	return err
+}
+
+func (r *PaymentRepository) SetTransactionID(id int64, transactionID string) error {
+	query := `UPDATE payments SET transaction_id = $2, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, id, transactionID)
+	return err
+}
+
+// ErrUnusableIndex is returned by a derive function for an index that has
+// no address, so AssignDerivedAddress moves on to the next one
+var ErrUnusableIndex = errors.New("no address at this index")
+
+// DerivedAddress is a deposit address given to a payment. ReusedAt is set
+// when expired payments had the address before; only transfers after it
+// belong to the payment.
+type DerivedAddress struct {
+	Address  string
+	Index    uint32
+	ReusedAt *time.Time
+}
+
+// AssignDerivedAddress gives the payment an address of the wallet, derived
+// from its index with derive. It takes the next unused index, but once
+// gapLimit indexes after the last one that received money went unused it
+// reuses the least recently used of them whose payments all expired with
+// their quote before lateBefore, so they are no longer watched for late
+// money: wallets restored from their seed stop looking after gapLimit unused
+// addresses and would miss every deposit beyond.
+func (r *PaymentRepository) AssignDerivedAddress(paymentID int64, wallet string, gapLimit int, lateBefore time.Time, derive func(uint32) (string, error)) (*DerivedAddress, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the wallet's counter serializes the wallet's allocations
+	var next int64
+	query := `
+		INSERT INTO crypto_derivation_indexes (wallet, next_index)
+		VALUES ($1, 0)
+		ON CONFLICT (wallet) DO UPDATE SET next_index = crypto_derivation_indexes.next_index
+		RETURNING next_index
+	`
+	if err := tx.QueryRow(query, wallet).Scan(&next); err != nil {
+		return nil, err
+	}
+
+	var lastUsed int64
+	query = `
+		SELECT COALESCE(MAX(derivation_index), -1)
+		FROM payments
+		WHERE derivation_wallet = $1 AND status IN ('completed', 'refunded', 'underpaid')
+	`
+	if err := tx.QueryRow(query, wallet).Scan(&lastUsed); err != nil {
+		return nil, err
+	}
+
+	var index int64 = -1
+	if next-lastUsed-1 >= int64(gapLimit) {
+		query = `
+			SELECT derivation_index
+			FROM payments
+			WHERE derivation_wallet = $1 AND derivation_index > $2
+			GROUP BY derivation_index
+			HAVING BOOL_AND(status = 'expired' AND (payment_data->>'quote_expires_at')::timestamptz < $3)
+			ORDER BY MAX(updated_at)
+			LIMIT 1
+		`
+		err := tx.QueryRow(query, wallet, lastUsed, lateBefore).Scan(&index)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	var address string
+	var reusedAt *time.Time
+	if index >= 0 {
+		now := time.Now()
+		reusedAt = &now
+		if address, err = derive(uint32(index)); err != nil {
+			return nil, err
+		}
+	} else {
+		// Every index in the gap is still waiting for its payment
+		for index = next; ; index++ {
+			if index >= 0x80000000 {
+				return nil, fmt.Errorf("wallet %s has no unhardened indexes left", wallet)
+			}
+			address, err = derive(uint32(index))
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrUnusableIndex) {
+				return nil, err
+			}
+		}
+		if _, err := tx.Exec(`UPDATE crypto_derivation_indexes SET next_index = $2 WHERE wallet = $1`, wallet, index+1); err != nil {
+			return nil, err
+		}
+	}
+
+	query = `
+		UPDATE payments
+		SET crypto_address = $2, derivation_index = $3, derivation_wallet = $4, updated_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, paymentID, address, index, wallet); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &DerivedAddress{Address: address, Index: uint32(index), ReusedAt: reusedAt}, nil
+}
+
+// SetQuote records the exchange rate a crypto payment is priced at and when
+// that price runs out
+func (r *PaymentRepository) SetQuote(id int64, rate float64, expiresAt time.Time) error {
+	query := `UPDATE payments SET exchange_rate = $2, quote_expires_at = $3, updated_at = NOW() WHERE id = $1`
+	_, err := r.db.Exec(query, id, rate, expiresAt)
+	return err
+}
+
+// MarkUnderpaid records a transfer that did not cover an open crypto payment.
+// received is the transferred amount in whole units of the payment's asset.
+func (r *PaymentRepository) MarkUnderpaid(id int64, transactionID, received string) error {
+	query := `
+		UPDATE payments
+		SET status = 'underpaid', transaction_id = $2,
+		    payment_data = COALESCE(payment_data, '{}') || jsonb_build_object('received', $3::text),
+		    updated_at = NOW()
+		WHERE id = $1 AND status IN ('pending', 'processing', 'expired')
+	`
+	_, err := r.db.Exec(query, id, transactionID, received)
+	return err
+}
+
+// MarkExpired closes an open crypto payment whose quote ran out unpaid
+func (r *PaymentRepository) MarkExpired(id int64) error {
+	query := `UPDATE payments SET status = 'expired', updated_at = NOW() WHERE id = $1 AND status IN ('pending', 'processing')`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// MarkRefunded moves a completed payment to refunded within tx, the
+// transaction that takes back what it paid for, and takes it off what the
+// user spent. It reports whether it did.
+func (r *PaymentRepository) MarkRefunded(tx *sql.Tx, id int64) (bool, error) {
+	query := `UPDATE payments SET status = 'refunded', updated_at = NOW() WHERE id = $1 AND status = 'completed'`
+	
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	query = `
+		UPDATE users
+		SET total_spent = GREATEST(total_spent - p.amount, 0)
+		FROM payments p
+		WHERE p.id = $1 AND users.id = p.user_id
+	`
+	if _, err := tx.Exec(query, id); err != nil {
+		return false, err
+	}
+	
+	return true, nil
+}
+
+// UpdateStatus moves a payment that is neither completed nor refunded to status
+func (r *PaymentRepository) UpdateStatus(id int64, status string) error {
+	query := `UPDATE payments SET status = $2, updated_at = NOW() WHERE id = $1 AND status NOT IN ('completed', 'refunded')`
+	_, err := r.db.Exec(query, id, status)
+	return err
+}
+
 func (r *PaymentRepository) GetPlanByID(planID int64) (*SubscriptionPlan, error) {
 	query := `
 		SELECT id, name, description, price_cents, duration_days, currency, is_active, max_groups, features, created_at
@@ -161,6 +415,7 @@ func (r *PaymentRepository) GetPlanByID(planID int64) (*SubscriptionPlan, error)
 	`
 	
 	plan := &SubscriptionPlan{}
+	var featuresJSON []byte
 	err := r.db.QueryRow(query, planID).Scan(
 		&plan.ID,
 		&plan.Name,
@@ -170,7 +425,7 @@ func (r *PaymentRepository) GetPlanByID(planID int64) (*SubscriptionPlan, error)
 		&plan.Currency,
 		&plan.IsActive,
 		&plan.MaxGroups,
-		&plan.Features,
+		&featuresJSON,
 		&plan.CreatedAt,
 	)
 	
@@ -178,6 +433,10 @@ func (r *PaymentRepository) GetPlanByID(planID int64) (*SubscriptionPlan, error)
 		return nil, err
 	}
 	
+	if len(featuresJSON) > 0 {
+		json.Unmarshal(featuresJSON, &plan.Features)
+	}
+	
 	return plan, nil
 }
 
@@ -198,6 +457,7 @@ func (r *PaymentRepository) GetAllPlans() ([]*SubscriptionPlan, error) {
 	var plans []*SubscriptionPlan
 	for rows.Next() {
 		plan := &SubscriptionPlan{}
+		var featuresJSON []byte
 		err := rows.Scan(
 			&plan.ID,
 			&plan.Name,
@@ -207,12 +467,15 @@ func (r *PaymentRepository) GetAllPlans() ([]*SubscriptionPlan, error) {
 			&plan.Currency,
 			&plan.IsActive,
 			&plan.MaxGroups,
-			&plan.Features,
+			&featuresJSON,
 			&plan.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if len(featuresJSON) > 0 {
+			json.Unmarshal(featuresJSON, &plan.Features)
+		}
 		plans = append(plans, plan)
 	}
 	
@@ -271,6 +534,47 @@ func (r *PaymentRepository) GetPaymentStats() (*PaymentStats, error) {
 	return stats, nil
 }
 
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	payment := &Payment{}
+	var paymentData []byte
+	var completedAt sql.NullTime
+	
+	err := row.Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.PlanID,
+		&payment.Amount,
+		&payment.Currency,
+		&payment.PaymentMethod,
+		&payment.PaymentProvider,
+		&payment.TransactionID,
+		&payment.Status,
+		&payment.Description,
+		&paymentData,
+		&payment.CreatedAt,
+		&completedAt,
+		&payment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	if completedAt.Valid {
+		payment.CompletedAt = completedAt.Time
+	}
+	
+	// payment_data written by older code may hold non-string values; those are dropped
+	if len(paymentData) > 0 {
+		json.Unmarshal(paymentData, &payment.PaymentData)
+	}
+	
+	return payment, nil
+}
+
 type PaymentStats struct {
 	TotalRevenue  int     `json:"total_revenue"`
 	TodayRevenue  int     `json:"today_revenue"`
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// PaymentProviderConfig is a row of payment_providers. Configuration holds the
+// provider-specific options (currencies, API URLs); API keys stay in the environment.
+type PaymentProviderConfig struct {
+	ID            int                    `json:"id" db:"id"`
+	Name          string                 `json:"name" db:"name"`
+	DisplayName   string                 `json:"display_name" db:"display_name"`
+	IsActive      bool                   `json:"is_active" db:"is_active"`
+	Configuration map[string]interface{} `json:"configuration" db:"configuration"`
+	WebhookSecret string                 `json:"-" db:"webhook_secret"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+type PaymentProviderRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentProviderRepository(db *sql.DB) *PaymentProviderRepository {
+	return &PaymentProviderRepository{db: db}
+}
+
+func (r *PaymentProviderRepository) GetAll() ([]*PaymentProviderConfig, error) {
+	query := `
+		SELECT id, name, display_name, COALESCE(is_active, FALSE), configuration, COALESCE(webhook_secret, ''), created_at, updated_at
+		FROM payment_providers
+		ORDER BY id
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var providers []*PaymentProviderConfig
+	for rows.Next() {
+		provider := &PaymentProviderConfig{}
+		var configurationJSON []byte
+		err := rows.Scan(
+			&provider.ID,
+			&provider.Name,
+			&provider.DisplayName,
+			&provider.IsActive,
+			&configurationJSON,
+			&provider.WebhookSecret,
+			&provider.CreatedAt,
+			&provider.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(configurationJSON) > 0 {
+			if err := json.Unmarshal(configurationJSON, &provider.Configuration); err != nil {
+				return nil, err
+			}
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, rows.Err()
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PlanChat is a private chat or channel a subscription plan gives access to
+type PlanChat struct {
+	ID        int       `json:"id" db:"id"`
+	PlanID    int       `json:"plan_id" db:"plan_id"`
+	ChatID    int64     `json:"chat_id" db:"chat_id"`
+	Title     string    `json:"title" db:"title"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ChatAccess is a subscriber's access to a plan chat. EndedAt is set when the
+// subscription lapses and RemovedAt once the member was removed from the chat.
+type ChatAccess struct {
+	ID             int        `json:"id" db:"id"`
+	UserID         int        `json:"user_id" db:"user_id"`
+	TelegramUserID int64      `json:"telegram_user_id" db:"telegram_user_id"`
+	ChatID         int64      `json:"chat_id" db:"chat_id"`
+	InviteLink     string     `json:"invite_link" db:"invite_link"`
+	GrantedAt      time.Time  `json:"granted_at" db:"granted_at"`
+	EndedAt        *time.Time `json:"ended_at" db:"ended_at"`
+	FailedAttempts int        `json:"failed_attempts" db:"failed_attempts"` // removals that failed since it ended
+}
+
+type PlanChatRepository struct {
+	db *sql.DB
+}
+
+func NewPlanChatRepository(db *sql.DB) *PlanChatRepository {
+	return &PlanChatRepository{db: db}
+}
+
+func (r *PlanChatRepository) ListByPlan(planID int) ([]*PlanChat, error) {
+	return r.list(`WHERE plan_id = $1`, planID)
+}
+
+// ListByChat returns the chat's entry in every active plan that grants it
+func (r *PlanChatRepository) ListByChat(chatID int64) ([]*PlanChat, error) {
+	return r.list(`WHERE chat_id = $1 AND plan_id IN (SELECT id FROM subscription_plans WHERE is_active = TRUE)`, chatID)
+}
+
+func (r *PlanChatRepository) list(where string, arg interface{}) ([]*PlanChat, error) {
+	query := `
+		SELECT id, plan_id, chat_id, title, created_at
+		FROM plan_chats
+		` + where + `
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []*PlanChat
+	for rows.Next() {
+		chat := &PlanChat{}
+		if err := rows.Scan(&chat.ID, &chat.PlanID, &chat.ChatID, &chat.Title, &chat.CreatedAt); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// Add maps the chat to the plan; adding it again updates its title
+func (r *PlanChatRepository) Add(chat *PlanChat) error {
+	query := `
+		INSERT INTO plan_chats (plan_id, chat_id, title)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (plan_id, chat_id) DO UPDATE SET title = EXCLUDED.title
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, chat.PlanID, chat.ChatID, chat.Title).Scan(&chat.ID, &chat.CreatedAt)
+}
+
+// Remove returns sql.ErrNoRows if the plan did not grant the chat. Members who
+// joined through the plan stay until their subscription lapses.
+func (r *PlanChatRepository) Remove(planID int, chatID int64) error {
+	result, err := r.db.Exec(`DELETE FROM plan_chats WHERE plan_id = $1 AND chat_id = $2`, planID, chatID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+type ChatAccessRepository struct {
+	db *sql.DB
+}
+
+func NewChatAccessRepository(db *sql.DB) *ChatAccessRepository {
+	return &ChatAccessRepository{db: db}
+}
+
+// Grant records the member's access to the chat, undoing a lapse or removal
+// from an earlier subscription
+func (r *ChatAccessRepository) Grant(userID int, chatID int64, planID int, inviteLink string) error {
+	query := `
+		INSERT INTO chat_access (user_id, chat_id, plan_id, invite_link)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, chat_id) DO UPDATE SET
+			plan_id = EXCLUDED.plan_id,
+			invite_link = EXCLUDED.invite_link,
+			granted_at = CURRENT_TIMESTAMP,
+			ended_at = NULL,
+			removed_at = NULL,
+			failed_attempts = 0,
+			retry_at = NULL
+	`
+	_, err := r.db.Exec(query, userID, chatID, planID, inviteLink)
+	return err
+}
+
+// End marks all the member's access as lapsed; the member keeps it until the
+// grace period is over
+func (r *ChatAccessRepository) End(userID int) error {
+	query := `
+		UPDATE chat_access SET ended_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND ended_at IS NULL AND removed_at IS NULL
+	`
+	_, err := r.db.Exec(query, userID)
+	return err
+}
+
+// ListLapsed returns access that ended before the cutoff and that the
+// member's current plan does not grant again, leaving out access whose
+// failed removal is not due for a retry yet
+func (r *ChatAccessRepository) ListLapsed(cutoff time.Time, limit int) ([]*ChatAccess, error) {
+	query := `
+		SELECT ca.id, ca.user_id, u.telegram_id, ca.chat_id, ca.invite_link, ca.granted_at, ca.ended_at,
+		       ca.failed_attempts
+		FROM chat_access ca
+		JOIN users u ON u.id = ca.user_id
+		WHERE ca.ended_at < $1 AND ca.removed_at IS NULL
+		  AND (ca.retry_at IS NULL OR ca.retry_at <= CURRENT_TIMESTAMP)
+		  AND NOT EXISTS (
+			SELECT 1 FROM plan_chats pc
+			WHERE pc.plan_id = u.current_plan_id AND pc.chat_id = ca.chat_id
+			  AND (u.plan_expires_at IS NULL OR u.plan_expires_at > CURRENT_TIMESTAMP)
+		  )
+		ORDER BY ca.ended_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lapsed []*ChatAccess
+	for rows.Next() {
+		access := &ChatAccess{}
+		err := rows.Scan(&access.ID, &access.UserID, &access.TelegramUserID, &access.ChatID,
+			&access.InviteLink, &access.GrantedAt, &access.EndedAt, &access.FailedAttempts)
+		if err != nil {
+			return nil, err
+		}
+		lapsed = append(lapsed, access)
+	}
+	return lapsed, rows.Err()
+}
+
+func (r *ChatAccessRepository) MarkRemoved(id int) error {
+	_, err := r.db.Exec(`UPDATE chat_access SET removed_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	return err
+}
+
+// MarkFailed counts a failed removal and holds the access back until retryAt
+func (r *ChatAccessRepository) MarkFailed(id int, retryAt time.Time) error {
+	query := `UPDATE chat_access SET failed_attempts = failed_attempts + 1, retry_at = $2 WHERE id = $1`
+	_, err := r.db.Exec(query, id, retryAt)
+	return err
+}
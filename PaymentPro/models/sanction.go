@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// What a recorded violation got; stored in user_violations.violation_type
+const (
+	ViolationWarning      = "warning"
+	ViolationMute         = "mute"
+	ViolationMedia        = "media_restriction"
+	ViolationTempBan      = "temp_ban"
+	ViolationPermanentBan = "permanent_ban"
+)
+
+// How a discrepancy between the database and Telegram was handled
+const (
+	DiscrepancyLifted   = "lifted"   // the sanction was marked as no longer in force
+	DiscrepancyRecorded = "recorded" // only written down, for a moderator to look at
+)
+
+// Sanction is a mute, restriction or ban recorded in user_violations
+type Sanction struct {
+	ID             int        `json:"id"`
+	ChatID         int64      `json:"chat_id"`
+	TelegramUserID int64      `json:"telegram_user_id"`
+	Type           string     `json:"violation_type"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// ExpectedStatus is the chat member status Telegram reports while the
+// sanction is in force
+func (s *Sanction) ExpectedStatus() string {
+	switch s.Type {
+	case ViolationTempBan, ViolationPermanentBan:
+		return "kicked"
+	case ViolationMute, ViolationMedia:
+		return "restricted"
+	}
+	return "member"
+}
+
+// ModerationDiscrepancy is a sanction whose state in the database and in
+// Telegram disagreed
+type ModerationDiscrepancy struct {
+	ID             int       `json:"id"`
+	ViolationID    int       `json:"violation_id"`
+	ChatID         int64     `json:"chat_id"`
+	TelegramUserID int64     `json:"telegram_user_id"`
+	Expected       string    `json:"expected"`
+	Actual         string    `json:"actual"`
+	Resolution     string    `json:"resolution"`
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+type SanctionRepository struct {
+	db *sql.DB
+}
+
+func NewSanctionRepository(db *sql.DB) *SanctionRepository {
+	return &SanctionRepository{db: db}
+}
+
+// Record stores a violation with the sanction it got; expiresAt is when a
+// mute, restriction or temporary ban ends
+func (r *SanctionRepository) Record(userID int, chatID int64, violationType, reason, messageText string, expiresAt *time.Time) (int, error) {
+	var id int
+	query := `
+		INSERT INTO user_violations (user_id, chat_id, violation_type, violation_reason, message_text, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		RETURNING id
+	`
+	err := r.db.QueryRow(query, userID, chatID, violationType, reason, messageText, expiresAt).Scan(&id)
+	return id, err
+}
+
+// GetByID returns a recorded violation, sql.ErrNoRows if there is none
+func (r *SanctionRepository) GetByID(id int) (*Sanction, error) {
+	query := `
+		SELECT v.id, v.chat_id, u.telegram_id, v.violation_type, v.expires_at
+		FROM user_violations v
+		JOIN users u ON u.id = v.user_id
+		WHERE v.id = $1
+	`
+	sanctions, err := r.query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sanctions) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return sanctions[0], nil
+}
+
+// Pardon deactivates the member's violations in the chat and lifts the
+// sanctions still in force
+func (r *SanctionRepository) Pardon(chatID, telegramUserID int64) error {
+	query := `
+		UPDATE user_violations
+		SET is_active = FALSE,
+		    lifted_at = CASE WHEN violation_type = 'warning' THEN lifted_at ELSE COALESCE(lifted_at, NOW()) END
+		WHERE user_id = (SELECT id FROM users WHERE telegram_id = $1)
+		  AND chat_id = $2
+	`
+	_, err := r.db.Exec(query, telegramUserID, chatID)
+	return err
+}
+
+// LiftExpired marks the sanctions whose expires_at has passed as lifted and
+// returns them
+func (r *SanctionRepository) LiftExpired() ([]*Sanction, error) {
+	query := `
+		UPDATE user_violations v
+		SET lifted_at = v.expires_at
+		FROM users u
+		WHERE u.id = v.user_id AND v.is_active = TRUE AND v.lifted_at IS NULL
+		  AND v.expires_at <= NOW()
+		RETURNING v.id, v.chat_id, u.telegram_id, v.violation_type, v.expires_at
+	`
+	return r.query(query)
+}
+
+// DecayViolations deactivates the violations older than their chat's decay
+// window, so they stop counting towards the sanction ladder. Chats without
+// settings use defaultDays. Sanctions still in force are kept.
+func (r *SanctionRepository) DecayViolations(defaultDays int) (int64, error) {
+	query := `
+		WITH decayed AS (
+			SELECT v.id
+			FROM user_violations v
+			LEFT JOIN group_moderation_settings s ON s.chat_id = v.chat_id
+			WHERE v.is_active = TRUE
+			  AND COALESCE(s.violation_decay_days, $1) > 0
+			  AND v.created_at < NOW() - make_interval(days => COALESCE(s.violation_decay_days, $1))
+			  AND v.violation_type <> 'permanent_ban'
+			  AND (v.expires_at IS NULL OR v.lifted_at IS NOT NULL)
+		)
+		UPDATE user_violations SET is_active = FALSE
+		WHERE id IN (SELECT id FROM decayed)
+	`
+	result, err := r.db.Exec(query, defaultDays)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListInForce returns up to limit sanctions the database considers in force,
+// the ones checked longest ago first
+func (r *SanctionRepository) ListInForce(limit int) ([]*Sanction, error) {
+	query := `
+		SELECT v.id, v.chat_id, u.telegram_id, v.violation_type, v.expires_at
+		FROM user_violations v
+		JOIN users u ON u.id = v.user_id
+		WHERE v.is_active = TRUE AND v.lifted_at IS NULL
+		  AND v.violation_type IN ('mute', 'media_restriction', 'temp_ban', 'permanent_ban')
+		  AND (v.expires_at IS NULL OR v.expires_at > NOW())
+		ORDER BY v.checked_at NULLS FIRST, v.id
+		LIMIT $1
+	`
+	return r.query(query, limit)
+}
+
+// InForce reports whether the member has any sanction in force in the chat
+func (r *SanctionRepository) InForce(chatID, telegramUserID int64) (bool, error) {
+	var inForce bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_violations v
+			JOIN users u ON u.id = v.user_id
+			WHERE v.chat_id = $1 AND u.telegram_id = $2 AND v.is_active = TRUE AND v.lifted_at IS NULL
+			  AND v.violation_type IN ('mute', 'media_restriction', 'temp_ban', 'permanent_ban')
+			  AND (v.expires_at IS NULL OR v.expires_at > NOW())
+		)
+	`
+	err := r.db.QueryRow(query, chatID, telegramUserID).Scan(&inForce)
+	return inForce, err
+}
+
+func (r *SanctionRepository) MarkChecked(id int) error {
+	_, err := r.db.Exec(`UPDATE user_violations SET checked_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// Lift marks a sanction as no longer in force
+func (r *SanctionRepository) Lift(id int) error {
+	query := `UPDATE user_violations SET lifted_at = NOW(), checked_at = NOW() WHERE id = $1 AND lifted_at IS NULL`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+func (r *SanctionRepository) RecordDiscrepancy(d *ModerationDiscrepancy) error {
+	query := `
+		INSERT INTO moderation_discrepancies (violation_id, chat_id, telegram_user_id, expected, actual, resolution)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, detected_at
+	`
+	return r.db.QueryRow(query, d.ViolationID, d.ChatID, d.TelegramUserID, d.Expected, d.Actual, d.Resolution,
+	).Scan(&d.ID, &d.DetectedAt)
+}
+
+func (r *SanctionRepository) query(query string, args ...interface{}) ([]*Sanction, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sanctions []*Sanction
+	for rows.Next() {
+		s := &Sanction{}
+		if err := rows.Scan(&s.ID, &s.ChatID, &s.TelegramUserID, &s.Type, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, s)
+	}
+	return sanctions, rows.Err()
+}
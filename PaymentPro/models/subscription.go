@@ -12,6 +12,7 @@ type SubscriptionPlan struct {
         Description  string                 `json:"description"`
         PriceCents   int                    `json:"price_cents"`
         DurationDays int                    `json:"duration_days"`
+        TrialDays    int                    `json:"trial_days"`
         MaxGroups    int                    `json:"max_groups"`
         Features     map[string]interface{} `json:"features"`
         Currency     string                 `json:"currency"`
@@ -29,7 +30,7 @@ func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
 
 func (r *SubscriptionRepository) GetAll() ([]SubscriptionPlan, error) {
         query := `
-                SELECT id, name, description, price_cents, duration_days, max_groups, features, currency, is_active, created_at
+                SELECT id, name, description, price_cents, duration_days, trial_days, max_groups, features, currency, is_active, created_at
                 FROM subscription_plans WHERE is_active = true ORDER BY price_cents
         `
         rows, err := r.db.Query(query)
@@ -44,7 +45,7 @@ func (r *SubscriptionRepository) GetAll() ([]SubscriptionPlan, error) {
                 var featuresJSON []byte
                 
                 err := rows.Scan(
-                        &plan.ID, &plan.Name, &plan.Description, &plan.PriceCents, &plan.DurationDays, &plan.MaxGroups,
+                        &plan.ID, &plan.Name, &plan.Description, &plan.PriceCents, &plan.DurationDays, &plan.TrialDays, &plan.MaxGroups,
                         &featuresJSON, &plan.Currency, &plan.IsActive, &plan.CreatedAt,
                 )
                 if err != nil {
@@ -65,11 +66,11 @@ func (r *SubscriptionRepository) GetByID(id int) (*SubscriptionPlan, error) {
         var featuresJSON []byte
         
         query := `
-                SELECT id, name, description, price_cents, duration_days, max_groups, features, currency, is_active, created_at
+                SELECT id, name, description, price_cents, duration_days, trial_days, max_groups, features, currency, is_active, created_at
                 FROM subscription_plans WHERE id = $1
         `
         err := r.db.QueryRow(query, id).Scan(
-                &plan.ID, &plan.Name, &plan.Description, &plan.PriceCents, &plan.DurationDays, &plan.MaxGroups,
+                &plan.ID, &plan.Name, &plan.Description, &plan.PriceCents, &plan.DurationDays, &plan.TrialDays, &plan.MaxGroups,
                 &featuresJSON, &plan.Currency, &plan.IsActive, &plan.CreatedAt,
         )
         if err != nil {
@@ -87,11 +88,11 @@ func (r *SubscriptionRepository) Create(plan *SubscriptionPlan) error {
         featuresJSON, _ := json.Marshal(plan.Features)
         
         query := `
-                INSERT INTO subscription_plans (name, price_cents, duration_days, max_groups, features, currency, is_active)
-                VALUES ($1, $2, $3, $4, $5, $6, $7)
+                INSERT INTO subscription_plans (name, price_cents, duration_days, trial_days, max_groups, features, currency, is_active)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
                 RETURNING id, created_at
         `
-        return r.db.QueryRow(query, plan.Name, plan.PriceCents, plan.DurationDays, plan.MaxGroups, featuresJSON, plan.Currency, plan.IsActive).Scan(&plan.ID, &plan.CreatedAt)
+        return r.db.QueryRow(query, plan.Name, plan.PriceCents, plan.DurationDays, plan.TrialDays, plan.MaxGroups, featuresJSON, plan.Currency, plan.IsActive).Scan(&plan.ID, &plan.CreatedAt)
 }
 
 func (r *SubscriptionRepository) Update(plan *SubscriptionPlan) error {
@@ -99,10 +100,10 @@ func (r *SubscriptionRepository) Update(plan *SubscriptionPlan) error {
         
         query := `
                 UPDATE subscription_plans 
-                SET name = $1, price_cents = $2, duration_days = $3, max_groups = $4, features = $5, currency = $6, is_active = $7
-                WHERE id = $8
+                SET name = $1, price_cents = $2, duration_days = $3, trial_days = $4, max_groups = $5, features = $6, currency = $7, is_active = $8
+                WHERE id = $9
         `
-        _, err := r.db.Exec(query, plan.Name, plan.PriceCents, plan.DurationDays, plan.MaxGroups, featuresJSON, plan.Currency, plan.IsActive, plan.ID)
+        _, err := r.db.Exec(query, plan.Name, plan.PriceCents, plan.DurationDays, plan.TrialDays, plan.MaxGroups, featuresJSON, plan.Currency, plan.IsActive, plan.ID)
         return err
 }
 
@@ -16,7 +16,7 @@ type User struct {
         CurrentPlanID   int       `json:"current_plan_id"`
         PlanExpiresAt   *time.Time `json:"plan_expires_at"`
         WebUsername     string    `json:"web_username"`
-        WebPassword     string    `json:"web_password"`
+        WebPassword     string    `json:"-"`
         IsWebActive     bool      `json:"is_web_active"`
         CreatedAt       time.Time `json:"created_at"`
         UpdatedAt       time.Time `json:"updated_at"`
@@ -34,7 +34,7 @@ func (r *UserRepository) GetByTelegramID(telegramID int64) (*User, error) {
         user := &User{}
         query := `
                 SELECT id, telegram_id, username, first_name, last_name, language_code, 
-                       current_plan_id, plan_expires_at, web_username, web_password, is_web_active, created_at, updated_at
+                       current_plan_id, plan_expires_at, COALESCE(web_username, ''), COALESCE(web_password, ''), is_web_active, created_at, updated_at
                 FROM users WHERE telegram_id = $1
         `
         err := r.db.QueryRow(query, telegramID).Scan(
@@ -50,7 +50,7 @@ func (r *UserRepository) GetByTelegramID(telegramID int64) (*User, error) {
 func (r *UserRepository) CreateOrUpdate(user *User) error {
         query := `
                 INSERT INTO users (telegram_id, username, first_name, last_name, language_code, current_plan_id, plan_expires_at)
-                VALUES ($1, $2, $3, $4, $5, 1, NOW() + INTERVAL '30 days')
+                VALUES ($1, $2, $3, $4, $5, 1, NULL)
                 ON CONFLICT (telegram_id) DO UPDATE SET
                         username = EXCLUDED.username,
                         first_name = EXCLUDED.first_name,
@@ -68,34 +68,6 @@ func (r *UserRepository) UpdateSubscription(userID int, planID int, expiresAt *t
         return err
 }
 
-func (r *UserRepository) GetExpiredSubscriptions() ([]User, error) {
-        query := `
-                SELECT id, telegram_id, username, first_name, last_name, language_code, 
-                       current_plan_id, plan_expires_at, created_at, updated_at
-                FROM users 
-                WHERE plan_expires_at < CURRENT_TIMESTAMP AND current_plan_id > 1
-        `
-        rows, err := r.db.Query(query)
-        if err != nil {
-                return nil, err
-        }
-        defer rows.Close()
-        
-        var users []User
-        for rows.Next() {
-                var user User
-                err := rows.Scan(
-                        &user.ID, &user.TelegramID, &user.Username, &user.FirstName, &user.LastName,
-                        &user.LanguageCode, &user.CurrentPlanID, &user.PlanExpiresAt, &user.CreatedAt, &user.UpdatedAt,
-                )
-                if err != nil {
-                        return nil, err
-                }
-                users = append(users, user)
-        }
-        return users, nil
-}
-
 func (r *UserRepository) GetExpiringSoon(days int) ([]User, error) {
         query := `
                 SELECT id, telegram_id, username, first_name, last_name, language_code, 
@@ -125,26 +97,29 @@ func (r *UserRepository) GetExpiringSoon(days int) ([]User, error) {
         return users, nil
 }
 
-func (r *UserRepository) CreateWebAccount(userID int, username, password string) error {
+// CreateWebAccount enables the dashboard login of the user. passwordHash is
+// a bcrypt hash, never the password itself.
+func (r *UserRepository) CreateWebAccount(userID int, username, passwordHash string) error {
         query := `
                 UPDATE users 
                 SET web_username = $1, web_password = $2, is_web_active = true, updated_at = CURRENT_TIMESTAMP
                 WHERE id = $3
         `
-        _, err := r.db.Exec(query, username, password, userID)
+        _, err := r.db.Exec(query, username, passwordHash, userID)
         return err
 }
 
-func (r *UserRepository) GetByWebCredentials(username, password string) (*User, error) {
+// GetByWebUsername returns the user with an active dashboard login named username
+func (r *UserRepository) GetByWebUsername(username string) (*User, error) {
         user := &User{}
         query := `
                 SELECT id, telegram_id, username, first_name, last_name, language_code, 
-                       current_plan_id, plan_expires_at, web_username, web_password, is_web_active, created_at, updated_at
+                       current_plan_id, plan_expires_at, COALESCE(web_username, ''), COALESCE(web_password, ''), is_web_active, created_at, updated_at
                 FROM users 
-                WHERE web_username = $1 AND web_password = $2 AND is_web_active = true
+                WHERE web_username = $1 AND web_username <> '' AND is_web_active = true
         `
         var lastName sql.NullString
-        err := r.db.QueryRow(query, username, password).Scan(
+        err := r.db.QueryRow(query, username).Scan(
                 &user.ID, &user.TelegramID, &user.Username, &user.FirstName, &lastName,
                 &user.LanguageCode, &user.CurrentPlanID, &user.PlanExpiresAt, &user.WebUsername, &user.WebPassword, &user.IsWebActive, &user.CreatedAt, &user.UpdatedAt,
         )
@@ -161,7 +136,7 @@ func (r *UserRepository) GetByID(id int) (*User, error) {
         user := &User{}
         query := `
                 SELECT id, telegram_id, username, first_name, last_name, language_code, 
-                       current_plan_id, plan_expires_at, web_username, web_password, is_web_active, created_at, updated_at
+                       current_plan_id, plan_expires_at, COALESCE(web_username, ''), COALESCE(web_password, ''), is_web_active, created_at, updated_at
                 FROM users 
                 WHERE id = $1
         `
@@ -181,9 +156,10 @@ func (r *UserRepository) GetByID(id int) (*User, error) {
 
 
 
-func (r *UserRepository) UpdateWebPassword(userID int, newPassword string) error {
-        query := `UPDATE users SET web_password = $1 WHERE id = $2`
-        _, err := r.db.Exec(query, newPassword, userID)
+// UpdateWebPassword stores a new bcrypt hash as the user's dashboard password
+func (r *UserRepository) UpdateWebPassword(userID int, passwordHash string) error {
+        query := `UPDATE users SET web_password = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+        _, err := r.db.Exec(query, passwordHash, userID)
         return err
 }
 
@@ -193,7 +169,8 @@ func (r *UserRepository) UpdateWebUsername(userID int, newUsername string) error
         return err
 }
 
-func (r *UserRepository) UpdateWebCredentials(userID int, username, password string) error {
+// UpdateWebCredentials sets the dashboard login; passwordHash is a bcrypt hash
+func (r *UserRepository) UpdateWebCredentials(userID int, username, passwordHash string) error {
         query := `
                 UPDATE users SET 
                         web_username = $1, 
@@ -202,6 +179,6 @@ func (r *UserRepository) UpdateWebCredentials(userID int, username, password str
                         updated_at = CURRENT_TIMESTAMP
                 WHERE id = $3
         `
-        _, err := r.db.Exec(query, username, password, userID)
+        _, err := r.db.Exec(query, username, passwordHash, userID)
         return err
 }
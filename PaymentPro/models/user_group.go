@@ -0,0 +1,170 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrGroupLimitReached is returned when an owner adds a group beyond their plan's limit
+var ErrGroupLimitReached = errors.New("group limit of the plan reached")
+
+// UserGroup is a group or channel an owner registered. A suspended group is
+// beyond the owner's plan limit and gets only basic moderation.
+type UserGroup struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	ChatID      int64      `json:"chat_id" db:"chat_id"`
+	GroupName   string     `json:"group_name" db:"group_name"`
+	GroupType   string     `json:"group_type" db:"group_type"`
+	SuspendedAt *time.Time `json:"suspended_at" db:"suspended_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// GroupOwner is an owner who registered a group, with the plan they are on
+type GroupOwner struct {
+	UserID        int
+	PlanID        int
+	PlanExpiresAt *time.Time
+	Suspended     bool
+}
+
+// groupLimitSQL is how many groups the owner u may register: the limit of
+// their current paid plan, or the free limit passed as $2 once it lapsed
+const groupLimitSQL = `
+	CASE WHEN u.current_plan_id > 1 AND (u.plan_expires_at IS NULL OR u.plan_expires_at > CURRENT_TIMESTAMP)
+	     THEN COALESCE(sp.max_groups, $2) ELSE $2 END`
+
+type UserGroupRepository struct {
+	db *sql.DB
+}
+
+func NewUserGroupRepository(db *sql.DB) *UserGroupRepository {
+	return &UserGroupRepository{db: db}
+}
+
+// Add registers the group for its owner, or updates the name and type of a
+// group they registered before. A new group beyond the owner's limit returns
+// ErrGroupLimitReached.
+func (r *UserGroupRepository) Add(group *UserGroup, freeLimit int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the owner keeps two concurrent additions from both passing the check
+	var limit, registered int
+	var exists bool
+	query := `
+		SELECT ` + groupLimitSQL + `,
+		       (SELECT COUNT(*) FROM user_groups WHERE user_id = u.id AND is_active = TRUE),
+		       EXISTS (SELECT 1 FROM user_groups WHERE user_id = u.id AND chat_id = $3 AND is_active = TRUE)
+		FROM users u
+		LEFT JOIN subscription_plans sp ON sp.id = u.current_plan_id
+		WHERE u.id = $1
+		FOR UPDATE OF u
+	`
+	if err := tx.QueryRow(query, group.UserID, freeLimit, group.ChatID).Scan(&limit, &registered, &exists); err != nil {
+		return err
+	}
+	if !exists && registered >= limit {
+		return ErrGroupLimitReached
+	}
+
+	query = `
+		INSERT INTO user_groups (user_id, chat_id, group_name, group_type, is_active, created_at)
+		VALUES ($1, $2, $3, $4, TRUE, NOW())
+		ON CONFLICT (user_id, chat_id) DO UPDATE SET
+			group_name = EXCLUDED.group_name,
+			group_type = EXCLUDED.group_type,
+			is_active = TRUE
+		RETURNING id, suspended_at, created_at
+	`
+	err = tx.QueryRow(query, group.UserID, group.ChatID, group.GroupName, group.GroupType).
+		Scan(&group.ID, &group.SuspendedAt, &group.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Enforce suspends the owner's groups beyond their plan limit, newest first,
+// and lifts the suspension of groups that fit again. userID 0 enforces the
+// limits of every owner. It returns the groups whose suspension changed.
+func (r *UserGroupRepository) Enforce(userID int, freeLimit int) ([]*UserGroup, error) {
+	query := `
+		WITH ranked AS (
+			SELECT ug.id,
+			       ROW_NUMBER() OVER (PARTITION BY ug.user_id ORDER BY ug.created_at, ug.id) AS position,
+			       ` + groupLimitSQL + ` AS group_limit
+			FROM user_groups ug
+			JOIN users u ON u.id = ug.user_id
+			LEFT JOIN subscription_plans sp ON sp.id = u.current_plan_id
+			WHERE ug.is_active = TRUE AND ($1 = 0 OR ug.user_id = $1)
+		)
+		UPDATE user_groups ug
+		SET suspended_at = CASE WHEN r.position > r.group_limit THEN CURRENT_TIMESTAMP END
+		FROM ranked r
+		WHERE ug.id = r.id AND (ug.suspended_at IS NULL) = (r.position > r.group_limit)
+		RETURNING ug.id, ug.user_id, ug.chat_id, ug.group_name, ug.group_type, ug.suspended_at, ug.created_at
+	`
+
+	rows, err := r.db.Query(query, userID, freeLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changed []*UserGroup
+	for rows.Next() {
+		g := &UserGroup{}
+		if err := rows.Scan(&g.ID, &g.UserID, &g.ChatID, &g.GroupName, &g.GroupType, &g.SuspendedAt, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		changed = append(changed, g)
+	}
+	return changed, rows.Err()
+}
+
+// Owners returns everyone who registered the chat
+func (r *UserGroupRepository) Owners(chatID int64) ([]*GroupOwner, error) {
+	query := `
+		SELECT u.id, u.current_plan_id, u.plan_expires_at, ug.suspended_at IS NOT NULL
+		FROM user_groups ug
+		JOIN users u ON u.id = ug.user_id
+		WHERE ug.chat_id = $1 AND ug.is_active = TRUE
+		ORDER BY ug.created_at
+	`
+
+	rows, err := r.db.Query(query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []*GroupOwner
+	for rows.Next() {
+		o := &GroupOwner{}
+		if err := rows.Scan(&o.UserID, &o.PlanID, &o.PlanExpiresAt, &o.Suspended); err != nil {
+			return nil, err
+		}
+		owners = append(owners, o)
+	}
+	return owners, rows.Err()
+}
+
+// IsOwner reports whether the user registered the chat
+func (r *UserGroupRepository) IsOwner(userID int, chatID int64) (bool, error) {
+	var owned bool
+	query := `SELECT EXISTS (SELECT 1 FROM user_groups WHERE user_id = $1 AND chat_id = $2)`
+	err := r.db.QueryRow(query, userID, chatID).Scan(&owned)
+	return owned, err
+}
+
+// CountActive returns how many groups the owner has registered, suspended ones included
+func (r *UserGroupRepository) CountActive(userID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM user_groups WHERE user_id = $1 AND is_active = TRUE`, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Subscription statuses. A subscription is trialing or active during its
+// period, past_due for a short while after it ended unrenewed and then in
+// grace, without the plan's features, until it expires. A canceled
+// subscription keeps access until its period ends and then expires.
+const (
+	SubscriptionTrialing = "trialing"
+	SubscriptionActive   = "active"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionGrace    = "grace"
+	SubscriptionCanceled = "canceled"
+	SubscriptionExpired  = "expired"
+)
+
+// subscriptionTransitions lists the statuses each status may change to; ""
+// is a subscription being created. active to active is a renewal.
+var subscriptionTransitions = map[string][]string{
+	"":                   {SubscriptionTrialing, SubscriptionActive},
+	SubscriptionTrialing: {SubscriptionActive, SubscriptionPastDue, SubscriptionCanceled, SubscriptionExpired},
+	SubscriptionActive:   {SubscriptionActive, SubscriptionPastDue, SubscriptionCanceled, SubscriptionExpired},
+	SubscriptionPastDue:  {SubscriptionActive, SubscriptionGrace, SubscriptionExpired},
+	SubscriptionGrace:    {SubscriptionActive, SubscriptionExpired},
+	SubscriptionCanceled: {SubscriptionActive, SubscriptionExpired},
+}
+
+// ErrInvalidTransition is returned for a status change the lifecycle does not allow
+var ErrInvalidTransition = errors.New("invalid subscription status change")
+
+// ErrSubscriptionChanged is returned when the subscription changed since it was read
+var ErrSubscriptionChanged = errors.New("subscription changed concurrently")
+
+// CanTransition reports whether a subscription may go from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range subscriptionTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAccess reports whether a subscription in the status gets its plan: its
+// features and its chats. A subscription in grace has neither.
+func HasAccess(status string) bool {
+	switch status {
+	case SubscriptionTrialing, SubscriptionActive, SubscriptionPastDue, SubscriptionCanceled:
+		return true
+	}
+	return false
+}
+
+type Subscription struct {
+	ID                 int        `json:"id" db:"id"`
+	UserID             int        `json:"user_id" db:"user_id"`
+	PlanID             int        `json:"plan_id" db:"plan_id"`
+	Status             string     `json:"status" db:"status"`
+	CurrentPeriodStart time.Time  `json:"current_period_start" db:"current_period_start"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end" db:"current_period_end"`
+	CanceledAt         *time.Time `json:"canceled_at" db:"canceled_at"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+	FailedAttempts     int        `json:"failed_attempts" db:"failed_attempts"` // failed status changes since the last one
+}
+
+// SubscriptionEvent is one status change of a subscription
+type SubscriptionEvent struct {
+	ID             int       `json:"id" db:"id"`
+	SubscriptionID int       `json:"subscription_id" db:"subscription_id"`
+	FromStatus     string    `json:"from_status" db:"from_status"` // empty when it was created
+	ToStatus       string    `json:"to_status" db:"to_status"`
+	Reason         string    `json:"reason" db:"reason"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// UserSubscriptionRepository stores subscriptions; SubscriptionRepository
+// stores the plans
+type UserSubscriptionRepository struct {
+	db *sql.DB
+}
+
+func NewUserSubscriptionRepository(db *sql.DB) *UserSubscriptionRepository {
+	return &UserSubscriptionRepository{db: db}
+}
+
+const subscriptionColumns = `id, user_id, plan_id, status, current_period_start, current_period_end, canceled_at, created_at, updated_at, failed_attempts`
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	s := &Subscription{}
+	err := row.Scan(&s.ID, &s.UserID, &s.PlanID, &s.Status, &s.CurrentPeriodStart, &s.CurrentPeriodEnd,
+		&s.CanceledAt, &s.CreatedAt, &s.UpdatedAt, &s.FailedAttempts)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetLive returns the user's subscription that has not expired, or
+// sql.ErrNoRows
+func (r *UserSubscriptionRepository) GetLive(userID int) (*Subscription, error) {
+	query := `SELECT ` + subscriptionColumns + ` FROM subscriptions WHERE user_id = $1 AND status <> 'expired'`
+	return scanSubscription(r.db.QueryRow(query, userID))
+}
+
+// HasAny reports whether the user ever had a subscription
+func (r *UserSubscriptionRepository) HasAny(userID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+// ListDue returns live subscriptions whose status is over: the period of a
+// trialing, active or canceled one ended, a past_due one was past due for
+// pastDue, or a subscription in grace was there for grace as well. Those
+// whose failed change is not due for a retry yet are left out.
+func (r *UserSubscriptionRepository) ListDue(pastDue, grace time.Duration, limit int) ([]*Subscription, error) {
+	query := `
+		SELECT ` + subscriptionColumns + `
+		FROM subscriptions
+		WHERE current_period_end IS NOT NULL
+		  AND (retry_at IS NULL OR retry_at <= CURRENT_TIMESTAMP)
+		  AND CASE status
+			WHEN 'past_due' THEN current_period_end + make_interval(secs => $1) <= CURRENT_TIMESTAMP
+			WHEN 'grace' THEN current_period_end + make_interval(secs => $1) + make_interval(secs => $2) <= CURRENT_TIMESTAMP
+			WHEN 'expired' THEN FALSE
+			ELSE current_period_end <= CURRENT_TIMESTAMP
+		END
+		ORDER BY current_period_end
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(query, pastDue.Seconds(), grace.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, s)
+	}
+	return due, rows.Err()
+}
+
+// MarkFailed counts a failed status change and holds the subscription back
+// from ListDue until retryAt. It leaves updated_at alone, so a change read
+// before still applies.
+func (r *UserSubscriptionRepository) MarkFailed(id int, retryAt time.Time) error {
+	query := `UPDATE subscriptions SET failed_attempts = failed_attempts + 1, retry_at = $2 WHERE id = $1`
+	_, err := r.db.Exec(query, id, retryAt)
+	return err
+}
+
+// Events returns the status changes of the subscription, oldest first
+func (r *UserSubscriptionRepository) Events(subscriptionID int) ([]*SubscriptionEvent, error) {
+	query := `
+		SELECT id, subscription_id, COALESCE(from_status, ''), to_status, reason, created_at
+		FROM subscription_events
+		WHERE subscription_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.Query(query, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*SubscriptionEvent
+	for rows.Next() {
+		e := &SubscriptionEvent{}
+		if err := rows.Scan(&e.ID, &e.SubscriptionID, &e.FromStatus, &e.ToStatus, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// SubscriptionChange is a status change of a subscription whose status was
+// From when it was read, empty for a new one. The user has access to the
+// plan until AccessUntil, or without an end when it is nil.
+type SubscriptionChange struct {
+	Subscription *Subscription
+	From         string
+	Reason       string
+	AccessUntil  *time.Time
+}
+
+// Transition saves the changes in one transaction, in order: each one saves
+// the subscription, records the change and points the user at the plan they
+// now have access to. It returns ErrInvalidTransition for a change the
+// lifecycle does not allow and ErrSubscriptionChanged when someone else
+// changed a subscription first; then none of the changes is saved.
+func (r *UserSubscriptionRepository) Transition(changes ...SubscriptionChange) error {
+	return r.TransitionWith(nil, changes...)
+}
+
+// TransitionWith is Transition running prepare, when not nil, first in the
+// same transaction; an error from prepare saves nothing
+func (r *UserSubscriptionRepository) TransitionWith(prepare func(*sql.Tx) error, changes ...SubscriptionChange) error {
+	for _, c := range changes {
+		if !CanTransition(c.From, c.Subscription.Status) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, c.From, c.Subscription.Status)
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if prepare != nil {
+		if err := prepare(tx); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		if err := transition(tx, c); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func transition(tx *sql.Tx, c SubscriptionChange) error {
+	s, from := c.Subscription, c.From
+	if from == "" {
+		query := `
+			INSERT INTO subscriptions (user_id, plan_id, status, current_period_start, current_period_end, canceled_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, updated_at
+		`
+		err := tx.QueryRow(query, s.UserID, s.PlanID, s.Status, s.CurrentPeriodStart, s.CurrentPeriodEnd, s.CanceledAt).
+			Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	} else {
+		query := `
+			UPDATE subscriptions
+			SET status = $1, current_period_start = $2, current_period_end = $3, canceled_at = $4,
+				failed_attempts = 0, retry_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $5 AND status = $6 AND updated_at = $7
+			RETURNING updated_at
+		`
+		err := tx.QueryRow(query, s.Status, s.CurrentPeriodStart, s.CurrentPeriodEnd, s.CanceledAt, s.ID, from, s.UpdatedAt).
+			Scan(&s.UpdatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSubscriptionChanged
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var fromStatus interface{}
+	if from != "" {
+		fromStatus = from
+	}
+	query := `INSERT INTO subscription_events (subscription_id, from_status, to_status, reason) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(query, s.ID, fromStatus, s.Status, c.Reason); err != nil {
+		return err
+	}
+
+	planID, accessUntil := FreePlanID, c.AccessUntil
+	if HasAccess(s.Status) {
+		planID = s.PlanID
+	} else {
+		accessUntil = nil
+	}
+	query = `
+		UPDATE users
+		SET current_plan_id = $1, plan_expires_at = $2,
+			plan_name = COALESCE((SELECT name FROM subscription_plans WHERE id = $1), plan_name),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+	`
+	_, err := tx.Exec(query, planID, accessUntil, s.UserID)
+	return err
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// WebhookLog is a single provider callback stored in payment_webhook_logs.
+// WebhookID is the provider's own event identifier and, together with the
+// provider name, makes a delivery unique.
+type WebhookLog struct {
+	ID              int64     `json:"id" db:"id"`
+	PaymentProvider string    `json:"payment_provider" db:"payment_provider"`
+	WebhookID       string    `json:"webhook_id" db:"webhook_id"`
+	EventType       string    `json:"event_type" db:"event_type"`
+	Processed       bool      `json:"processed" db:"processed"`
+	ErrorMessage    string    `json:"error_message" db:"error_message"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+type WebhookLogRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookLogRepository(db *sql.DB) *WebhookLogRepository {
+	return &WebhookLogRepository{db: db}
+}
+
+// Record stores an incoming webhook event. If the provider already delivered an
+// event with the same ID, the existing log entry is returned and isNew is false.
+func (r *WebhookLogRepository) Record(provider, webhookID, eventType string, payload []byte, headers map[string]string) (*WebhookLog, bool, error) {
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query := `
+		INSERT INTO payment_webhook_logs (payment_provider, webhook_id, event_type, payload, headers, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (payment_provider, webhook_id) DO NOTHING
+		RETURNING id, processed, created_at
+	`
+
+	entry := &WebhookLog{
+		PaymentProvider: provider,
+		WebhookID:       webhookID,
+		EventType:       eventType,
+	}
+
+	err = r.db.QueryRow(query, provider, webhookID, eventType, string(payload), string(headersJSON)).Scan(
+		&entry.ID,
+		&entry.Processed,
+		&entry.CreatedAt,
+	)
+	if err == nil {
+		return entry, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	existing, err := r.GetByWebhookID(provider, webhookID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
+
+func (r *WebhookLogRepository) GetByWebhookID(provider, webhookID string) (*WebhookLog, error) {
+	query := `
+		SELECT id, payment_provider, webhook_id, event_type, processed, COALESCE(error_message, ''), created_at
+		FROM payment_webhook_logs
+		WHERE payment_provider = $1 AND webhook_id = $2
+	`
+
+	entry := &WebhookLog{}
+	err := r.db.QueryRow(query, provider, webhookID).Scan(
+		&entry.ID,
+		&entry.PaymentProvider,
+		&entry.WebhookID,
+		&entry.EventType,
+		&entry.Processed,
+		&entry.ErrorMessage,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (r *WebhookLogRepository) MarkProcessed(id int64) error {
+	query := `UPDATE payment_webhook_logs SET processed = TRUE, error_message = NULL WHERE id = $1`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+func (r *WebhookLogRepository) MarkFailed(id int64, errorMessage string) error {
+	query := `UPDATE payment_webhook_logs SET processed = FALSE, error_message = $2 WHERE id = $1`
+	_, err := r.db.Exec(query, id, errorMessage)
+	return err
+}
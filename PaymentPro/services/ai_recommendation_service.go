@@ -308,6 +308,17 @@ func (s *AIRecommendationService) UpdateRecommendationStatus(id int, status stri
         return err
 }
 
+// GetRecommendationGroup returns the chat a recommendation is about, nil for
+// recommendations about all groups
+func (s *AIRecommendationService) GetRecommendationGroup(id int) (*int64, error) {
+        var groupID sql.NullInt64
+        err := s.db.QueryRow(`SELECT group_id FROM ai_recommendations WHERE id = $1`, id).Scan(&groupID)
+        if err != nil || !groupID.Valid {
+                return nil, err
+        }
+        return &groupID.Int64, nil
+}
+
 // GetRecommendationsForGroup retrieves recommendations for a specific group
 func (s *AIRecommendationService) GetRecommendationsForGroup(groupID int64, limit int) ([]models.AIRecommendation, error) {
         var recommendations []models.AIRecommendation
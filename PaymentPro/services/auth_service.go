@@ -0,0 +1,390 @@
+package services
+
+import (
+        "crypto/rand"
+        "crypto/sha256"
+        "crypto/subtle"
+        "database/sql"
+        "encoding/hex"
+        "errors"
+        "fmt"
+        "log"
+        "strings"
+        "sync"
+        "time"
+
+        "golang.org/x/crypto/bcrypt"
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+)
+
+const (
+        RoleAdmin = "admin"
+        RoleUser  = "user"
+)
+
+var (
+        ErrInvalidCredentials = errors.New("invalid username or password")
+        ErrTooManyAttempts    = errors.New("too many failed login attempts, try again later")
+        ErrInvalidSession     = errors.New("invalid or expired session")
+)
+
+// sessionTouchInterval limits how often a session's last_seen_at is written
+const sessionTouchInterval = time.Minute
+
+// AuthService logs users into the web dashboard, with the Telegram Login
+// Widget, a one-time link sent by /login, or a password. Passwords are
+// optional and stored as bcrypt hashes; passwords still stored in plaintext by
+// older versions are accepted once and replaced with a hash. A session is an
+// opaque random token of which only a SHA-256 hash is kept in
+// dashboard_sessions.
+//
+// The admin account comes from ADMIN_USERNAME and ADMIN_PASSWORD and has no
+// row in users; users whose Telegram ID is in ADMIN_USER_IDS log in with their
+// own credentials and get the admin role too.
+type AuthService struct {
+        config    *config.Config
+        userRepo  *models.UserRepository
+        sessions  *models.DashboardSessionRepository
+        limiter   *loginLimiter
+        adminHash []byte
+        dummyHash []byte
+
+        botUsername  string
+        loginLinkKey []byte
+
+        mu        sync.Mutex
+        lastSweep time.Time
+}
+
+func NewAuthService(db *database.DB, cfg *config.Config, botUsername string) *AuthService {
+        s := &AuthService{
+                config:       cfg,
+                userRepo:     models.NewUserRepository(db.DB),
+                sessions:     models.NewDashboardSessionRepository(db.DB),
+                limiter:      newLoginLimiter(cfg.LoginMaxAttempts, cfg.LoginLockout),
+                botUsername:  botUsername,
+                loginLinkKey: loginLinkKey(cfg.LoginLinkSecret, cfg.TelegramBotToken),
+        }
+
+        switch {
+        case cfg.AdminPassword == "":
+                log.Println("ADMIN_PASSWORD is not set, the dashboard admin login is disabled")
+        case isPasswordHash(cfg.AdminPassword):
+                s.adminHash = []byte(cfg.AdminPassword)
+        default:
+                log.Println("ADMIN_PASSWORD is not a bcrypt hash; consider storing a hash instead")
+                hash, err := bcrypt.GenerateFromPassword([]byte(cfg.AdminPassword), bcrypt.DefaultCost)
+                if err != nil {
+                        log.Printf("Failed to hash ADMIN_PASSWORD, the dashboard admin login is disabled: %v", err)
+                } else {
+                        s.adminHash = hash
+                }
+        }
+
+        // Compared against when the username is unknown, so a failed login
+        // takes as long whether or not the account exists
+        s.dummyHash, _ = bcrypt.GenerateFromPassword([]byte(generateSessionToken()), bcrypt.DefaultCost)
+
+        return s
+}
+
+// HashPassword returns the bcrypt hash to store for a dashboard password
+func HashPassword(password string) (string, error) {
+        hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+        if err != nil {
+                return "", err
+        }
+        return string(hash), nil
+}
+
+// Login checks the credentials and opens a session. clientIP and username are
+// rate limited separately, so neither one address nor one account can be
+// used to guess passwords.
+func (s *AuthService) Login(username, password, clientIP string) (string, *models.DashboardSession, error) {
+        username = strings.TrimSpace(username)
+        ipKey := "ip:" + clientIP
+        userKey := "user:" + strings.ToLower(username)
+
+        if !s.limiter.allowed(ipKey) || !s.limiter.allowed(userKey) {
+                return "", nil, ErrTooManyAttempts
+        }
+
+        userID, role, err := s.checkCredentials(username, password)
+        if err != nil {
+                s.limiter.fail(ipKey)
+                s.limiter.fail(userKey)
+                if errors.Is(err, ErrInvalidCredentials) {
+                        log.Printf("Failed dashboard login for %q from %s", username, clientIP)
+                }
+                return "", nil, err
+        }
+
+        s.limiter.reset(userKey)
+        return s.createSession(userID, role)
+}
+
+func (s *AuthService) checkCredentials(username, password string) (int, string, error) {
+        if s.adminHash != nil && subtle.ConstantTimeCompare([]byte(username), []byte(s.config.AdminUsername)) == 1 {
+                if bcrypt.CompareHashAndPassword(s.adminHash, []byte(password)) != nil {
+                        return 0, "", ErrInvalidCredentials
+                }
+                return 0, RoleAdmin, nil
+        }
+
+        user, err := s.userRepo.GetByWebUsername(username)
+        if errors.Is(err, sql.ErrNoRows) {
+                bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+                return 0, "", ErrInvalidCredentials
+        }
+        if err != nil {
+                return 0, "", err
+        }
+
+        ok, plaintext := checkPassword(user.WebPassword, password)
+        if !ok {
+                return 0, "", ErrInvalidCredentials
+        }
+
+        if plaintext {
+                if hash, err := HashPassword(password); err != nil {
+                        log.Printf("Failed to hash password of user %d: %v", user.ID, err)
+                } else if err := s.userRepo.UpdateWebPassword(user.ID, hash); err != nil {
+                        log.Printf("Failed to store hashed password of user %d: %v", user.ID, err)
+                }
+        }
+
+        return user.ID, s.roleOf(user), nil
+}
+
+func (s *AuthService) roleOf(user *models.User) string {
+        if s.config.IsAdminUser(user.TelegramID) {
+                return RoleAdmin
+        }
+        return RoleUser
+}
+
+func (s *AuthService) createSession(userID int, role string) (string, *models.DashboardSession, error) {
+        token := generateSessionToken()
+        session := &models.DashboardSession{
+                TokenHash: hashSessionToken(token),
+                UserID:    userID,
+                Role:      role,
+                ExpiresAt: time.Now().Add(s.config.SessionTTL),
+        }
+
+        if err := s.sessions.Create(session); err != nil {
+                return "", nil, err
+        }
+
+        s.sweepExpired()
+        return token, session, nil
+}
+
+// Authenticate returns the session the token belongs to
+func (s *AuthService) Authenticate(token string) (*models.DashboardSession, error) {
+        if token == "" {
+                return nil, ErrInvalidSession
+        }
+
+        session, err := s.sessions.GetActive(hashSessionToken(token))
+        if errors.Is(err, sql.ErrNoRows) {
+                return nil, ErrInvalidSession
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        if time.Since(session.LastSeenAt) > sessionTouchInterval {
+                if err := s.sessions.Touch(session.ID); err != nil {
+                        log.Printf("Failed to update dashboard session %d: %v", session.ID, err)
+                }
+        }
+
+        return session, nil
+}
+
+func (s *AuthService) Logout(token string) error {
+        return s.sessions.Delete(hashSessionToken(token))
+}
+
+// ChangePassword replaces the dashboard password of the session's user and
+// ends the user's other sessions
+func (s *AuthService) ChangePassword(session *models.DashboardSession, current, next string) error {
+        if session.UserID == 0 {
+                return fmt.Errorf("the admin password is set with ADMIN_PASSWORD")
+        }
+        if err := ValidatePassword(next); err != nil {
+                return err
+        }
+
+        user, err := s.userRepo.GetByID(session.UserID)
+        if err != nil {
+                return err
+        }
+        if ok, _ := checkPassword(user.WebPassword, current); !ok {
+                return ErrInvalidCredentials
+        }
+
+        hash, err := HashPassword(next)
+        if err != nil {
+                return err
+        }
+        if err := s.userRepo.UpdateWebPassword(user.ID, hash); err != nil {
+                return err
+        }
+
+        return s.sessions.DeleteByUser(user.ID, session.ID)
+}
+
+// RevokeUserSessions logs the user out everywhere, e.g. after the bot reset
+// the user's password
+func (s *AuthService) RevokeUserSessions(userID int) error {
+        return s.sessions.DeleteByUser(userID, 0)
+}
+
+// ValidatePassword enforces the length bcrypt can handle
+func ValidatePassword(password string) error {
+        if len(password) < 8 {
+                return fmt.Errorf("password must be at least 8 characters")
+        }
+        if len(password) > 72 {
+                return fmt.Errorf("password must be at most 72 bytes")
+        }
+        return nil
+}
+
+func (s *AuthService) sweepExpired() {
+        s.mu.Lock()
+        due := time.Since(s.lastSweep) > time.Hour
+        if due {
+                s.lastSweep = time.Now()
+        }
+        s.mu.Unlock()
+
+        if !due {
+                return
+        }
+        if _, err := s.sessions.DeleteExpired(); err != nil {
+                log.Printf("Failed to delete expired dashboard sessions: %v", err)
+        }
+}
+
+// checkPassword compares password with the stored value and reports whether
+// the stored value was still plaintext
+func checkPassword(stored, password string) (ok bool, plaintext bool) {
+        if stored == "" || password == "" {
+                return false, false
+        }
+        if isPasswordHash(stored) {
+                return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+        }
+        return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+}
+
+func isPasswordHash(value string) bool {
+        return strings.HasPrefix(value, "$2a$") || strings.HasPrefix(value, "$2b$") || strings.HasPrefix(value, "$2y$")
+}
+
+func generateSessionToken() string {
+        bytes := make([]byte, 32)
+        if _, err := rand.Read(bytes); err != nil {
+                panic(fmt.Sprintf("crypto/rand failed: %v", err))
+        }
+        return hex.EncodeToString(bytes)
+}
+
+func hashSessionToken(token string) string {
+        sum := sha256.Sum256([]byte(token))
+        return hex.EncodeToString(sum[:])
+}
+
+// loginLimiterMaxKeys bounds the failure counters kept in memory
+const loginLimiterMaxKeys = 10000
+
+// loginLimiter counts failed logins per key over a sliding window and refuses
+// further attempts once max failures fall within it
+type loginLimiter struct {
+        max    int
+        window time.Duration
+
+        mu       sync.Mutex
+        failures map[string][]time.Time
+}
+
+func newLoginLimiter(max int, window time.Duration) *loginLimiter {
+        if max <= 0 {
+                max = 5
+        }
+        if window <= 0 {
+                window = 15 * time.Minute
+        }
+        return &loginLimiter{
+                max:      max,
+                window:   window,
+                failures: make(map[string][]time.Time),
+        }
+}
+
+func (l *loginLimiter) allowed(key string) bool {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+
+        return len(l.recent(key, time.Now())) < l.max
+}
+
+func (l *loginLimiter) fail(key string) {
+        l.mu.Lock()
+        defer l.mu.Unlock()
+
+        now := time.Now()
+        if _, tracked := l.failures[key]; !tracked && len(l.failures) >= loginLimiterMaxKeys {
+                l.evict(now)
+        }
+        l.failures[key] = append(l.recent(key, now), now)
+}
+
+func (l *loginLimiter) reset(key string) {
+        l.mu.Lock()
+        delete(l.failures, key)
+        l.mu.Unlock()
+}
+
+// evict drops the keys whose failures left the window and, if that freed
+// nothing, the key that failed least recently. Callers hold l.mu.
+func (l *loginLimiter) evict(now time.Time) {
+        var oldestKey string
+        var oldest time.Time
+        for key := range l.failures {
+                failures := l.recent(key, now)
+                if len(failures) == 0 {
+                        continue
+                }
+                if last := failures[len(failures)-1]; oldestKey == "" || last.Before(oldest) {
+                        oldestKey, oldest = key, last
+                }
+        }
+
+        if len(l.failures) >= loginLimiterMaxKeys {
+                delete(l.failures, oldestKey)
+        }
+}
+
+// recent drops the failures of key that left the window. Callers hold l.mu.
+func (l *loginLimiter) recent(key string, now time.Time) []time.Time {
+        failures := l.failures[key]
+        kept := failures[:0]
+        for _, at := range failures {
+                if now.Sub(at) < l.window {
+                        kept = append(kept, at)
+                }
+        }
+
+        if len(kept) == 0 {
+                delete(l.failures, key)
+                return nil
+        }
+        l.failures[key] = kept
+        return kept
+}
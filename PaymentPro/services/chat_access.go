@@ -0,0 +1,270 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/locales"
+	"telegram-subscription-bot/models"
+)
+
+// chatAccessBatch is how many lapsed members are loaded at a time
+const chatAccessBatch = 50
+
+// Failed removals are retried after chatAccessRetry, doubled with every
+// further failure up to chatAccessMaxRetry
+const (
+	chatAccessRetry    = 5 * time.Minute
+	chatAccessMaxRetry = 24 * time.Hour
+)
+
+// ChatAccessService ties subscriptions to membership of the private chats
+// their plans sell: a paid plan gets single-use invite links to its chats,
+// and members whose subscription lapsed are removed after a grace period.
+type ChatAccessService struct {
+	bot      *tgbotapi.BotAPI
+	userRepo *models.UserRepository
+	chats    *models.PlanChatRepository
+	access   *models.ChatAccessRepository
+}
+
+func NewChatAccessService(bot *tgbotapi.BotAPI, db *database.DB) *ChatAccessService {
+	return &ChatAccessService{
+		bot:      bot,
+		userRepo: models.NewUserRepository(db.DB),
+		chats:    models.NewPlanChatRepository(db.DB),
+		access:   models.NewChatAccessRepository(db.DB),
+	}
+}
+
+// Grant gives the user access to every chat of the plan and sends them the
+// invite links. Chats the user is already in get no link; a chat the bot
+// cannot invite to is logged and skipped.
+func (s *ChatAccessService) Grant(userID, planID int) error {
+	chats, err := s.chats.ListByPlan(planID)
+	if err != nil || len(chats) == 0 {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	for _, chat := range chats {
+		link := ""
+		if !s.isMember(chat.ChatID, user.TelegramID) {
+			link, err = s.createInviteLink(chat, user)
+			if err != nil {
+				log.Printf("Error creating invite link to chat %d for user %d: %v", chat.ChatID, user.TelegramID, err)
+				continue
+			}
+			links = append(links, fmt.Sprintf("• %s: %s", chatTitle(chat), link))
+		}
+
+		if err := s.access.Grant(user.ID, chat.ChatID, planID, link); err != nil {
+			log.Printf("Error recording access of user %d to chat %d: %v", user.ID, chat.ChatID, err)
+		}
+	}
+
+	if len(links) > 0 {
+		text := locales.GetMessage(user.LanguageCode, "chat_access_links") + "\n\n" + strings.Join(links, "\n")
+		if _, err := s.bot.Send(tgbotapi.NewMessage(user.TelegramID, text)); err != nil {
+			log.Printf("Error sending invite links to user %d: %v", user.TelegramID, err)
+		}
+	}
+	return nil
+}
+
+// ChatPlans returns the chat's entry in every active plan that grants it;
+// none means the chat is not sold
+func (s *ChatAccessService) ChatPlans(chatID int64) ([]*models.PlanChat, error) {
+	return s.chats.ListByChat(chatID)
+}
+
+// Admit records the access of a subscriber whose join request the bot
+// approved, so they are removed once their subscription lapses
+func (s *ChatAccessService) Admit(userID int, chatID int64, planID int) error {
+	return s.access.Grant(userID, chatID, planID, "")
+}
+
+// RemoveLapsed removes members whose access ended more than grace ago and
+// that no current plan grants again. A member that cannot be removed is
+// retried later, so it does not hold back the ones after it.
+func (s *ChatAccessService) RemoveLapsed(grace time.Duration) {
+	cutoff := time.Now().Add(-grace)
+	for {
+		lapsed, err := s.access.ListLapsed(cutoff, chatAccessBatch)
+		if err != nil {
+			log.Printf("Error listing lapsed chat access: %v", err)
+			return
+		}
+
+		for _, access := range lapsed {
+			// Every row leaves the list, removed or held back; one that
+			// cannot be updated would come back, so the sweep stops
+			if err := s.removeLapsed(access); err != nil {
+				log.Printf("Error updating chat access %d: %v", access.ID, err)
+				return
+			}
+		}
+
+		if len(lapsed) < chatAccessBatch {
+			return
+		}
+	}
+}
+
+func (s *ChatAccessService) removeLapsed(access *models.ChatAccess) error {
+	if err := s.remove(access); err != nil {
+		delay := retryDelay(chatAccessRetry, chatAccessMaxRetry, access.FailedAttempts)
+		log.Printf("Error removing user %d from chat %d, retrying in %s: %v", access.TelegramUserID, access.ChatID, delay, err)
+		return s.access.MarkFailed(access.ID, time.Now().Add(delay))
+	}
+	return s.access.MarkRemoved(access.ID)
+}
+
+// retryDelay is the wait after a failure that came after failures others:
+// base, doubled for each of them, and at most max
+func retryDelay(base, max time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// remove takes the member out of the chat without banning them, so a new
+// subscription lets them back in, and revokes their unused invite link
+func (s *ChatAccessService) remove(access *models.ChatAccess) error {
+	member, err := s.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: access.ChatID,
+			UserID: access.TelegramUserID,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case member.IsCreator() || member.IsAdministrator():
+		log.Printf("Not removing admin %d from chat %d after their subscription lapsed", access.TelegramUserID, access.ChatID)
+	case member.HasLeft() || member.WasKicked() || (member.Status == "restricted" && !member.IsMember):
+		// Already gone
+	default:
+		config := tgbotapi.ChatMemberConfig{ChatID: access.ChatID, UserID: access.TelegramUserID}
+		if _, err := s.bot.Request(tgbotapi.BanChatMemberConfig{ChatMemberConfig: config}); err != nil {
+			return err
+		}
+		if _, err := s.bot.Request(tgbotapi.UnbanChatMemberConfig{ChatMemberConfig: config, OnlyIfBanned: true}); err != nil {
+			return err
+		}
+	}
+
+	if access.InviteLink != "" {
+		revoke := tgbotapi.RevokeChatInviteLinkConfig{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: access.ChatID},
+			InviteLink: access.InviteLink,
+		}
+		if _, err := s.bot.Request(revoke); err != nil {
+			log.Printf("Error revoking invite link of chat %d: %v", access.ChatID, err)
+		}
+	}
+	return nil
+}
+
+func (s *ChatAccessService) isMember(chatID, telegramUserID int64) bool {
+	member, err := s.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatID,
+			UserID: telegramUserID,
+		},
+	})
+	if err != nil {
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator() || member.Status == "member" ||
+		(member.Status == "restricted" && member.IsMember)
+}
+
+// createInviteLink makes a link one member can join by, valid while the
+// subscription lasts
+func (s *ChatAccessService) createInviteLink(chat *models.PlanChat, user *models.User) (string, error) {
+	config := tgbotapi.CreateChatInviteLinkConfig{
+		ChatConfig:  tgbotapi.ChatConfig{ChatID: chat.ChatID},
+		Name:        fmt.Sprintf("Subscriber %d", user.TelegramID),
+		MemberLimit: 1,
+	}
+	if user.PlanExpiresAt != nil {
+		config.ExpireDate = int(user.PlanExpiresAt.Unix())
+	}
+
+	resp, err := s.bot.Request(config)
+	if err != nil {
+		return "", err
+	}
+
+	var link tgbotapi.ChatInviteLink
+	if err := json.Unmarshal(resp.Result, &link); err != nil {
+		return "", err
+	}
+	return link.InviteLink, nil
+}
+
+func chatTitle(chat *models.PlanChat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	return fmt.Sprintf("%d", chat.ChatID)
+}
+
+// ChatAccessSweeper removes members from plan chats once their subscription
+// lapsed and the grace period is over
+type ChatAccessSweeper struct {
+	access   *ChatAccessService
+	grace    time.Duration
+	ticker   *time.Ticker
+	stopChan chan bool
+}
+
+func NewChatAccessSweeper(access *ChatAccessService, interval, grace time.Duration) *ChatAccessSweeper {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	return &ChatAccessSweeper{
+		access:   access,
+		grace:    grace,
+		ticker:   time.NewTicker(interval),
+		stopChan: make(chan bool),
+	}
+}
+
+func (s *ChatAccessSweeper) Start() {
+	log.Println("Starting chat access sweeper...")
+
+	s.access.RemoveLapsed(s.grace)
+	for {
+		select {
+		case <-s.ticker.C:
+			s.access.RemoveLapsed(s.grace)
+		case <-s.stopChan:
+			s.ticker.Stop()
+			return
+		}
+	}
+}
+
+func (s *ChatAccessSweeper) Stop() {
+	s.stopChan <- true
+}
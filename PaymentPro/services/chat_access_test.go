@@ -0,0 +1,26 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 10 * time.Minute},
+		{3, 40 * time.Minute},
+		{8, 21*time.Hour + 20*time.Minute},
+		{9, 24 * time.Hour},
+		{1000, 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(chatAccessRetry, chatAccessMaxRetry, tt.failures); got != tt.want {
+			t.Errorf("retryDelay(%d failures) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
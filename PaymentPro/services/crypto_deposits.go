@@ -0,0 +1,292 @@
+package services
+
+import (
+        "database/sql"
+        "errors"
+        "fmt"
+        "log"
+        "math/big"
+        "time"
+
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/utils"
+)
+
+// depositClockSkew is how far a transfer's block time may lie before the
+// payment was created; miners may set block timestamps up to two hours off.
+const depositClockSkew = 2 * time.Hour
+
+// newChainExplorers picks the explorer that watches each asset's deposits
+func newChainExplorers(cfg *config.Config) map[string]utils.ChainExplorer {
+        explorers := make(map[string]utils.ChainExplorer)
+
+        if cfg.ChainExplorer == "fake" {
+                fake := utils.NewFakeChainExplorer(cfg.FakeChainFile)
+                for _, asset := range []string{"BTC", "ETH", "USDT"} {
+                        explorers[asset] = fake
+                }
+                return explorers
+        }
+
+        if cfg.BTCExplorerURL != "" {
+                explorers["BTC"] = utils.NewEsploraExplorer(cfg.BTCExplorerURL)
+        }
+        if cfg.ETHRPCURL != "" {
+                ethereum := utils.NewEthereumExplorer(cfg.ETHRPCURL, map[string]string{"USDT": cfg.USDTContract}, cfg.ETHLookbackBlocks)
+                explorers["ETH"] = ethereum
+                explorers["USDT"] = ethereum
+        }
+
+        return explorers
+}
+
+// isDirectDeposit tells payments waiting for a transfer to one of our wallet
+// addresses apart from invoices of a crypto processor
+func isDirectDeposit(payment *models.Payment) bool {
+        return payment.PaymentProvider == "crypto" && payment.PaymentData["address"] != ""
+}
+
+// earliestTransfer is the block time before which a transfer cannot be the
+// payment's: its creation, less the clock skew of blocks, or the moment its
+// address was taken over from expired payments, whose late money may still
+// arrive until then. Unconfirmed transfers have no time yet.
+func earliestTransfer(payment *models.Payment) time.Time {
+        if reusedAt, err := time.Parse(time.RFC3339, payment.PaymentData["address_reused_at"]); err == nil {
+                return reusedAt
+        }
+        return payment.CreatedAt.Add(-depositClockSkew)
+}
+
+// isDerivedAddress tells a deposit address derived for the payment alone
+// apart from a fixed one that payments share
+func isDerivedAddress(payment *models.Payment) bool {
+        _, derived := payment.PaymentData["address_index"]
+        return derived
+}
+
+func (s *PaymentService) requiredConfirmations(asset string) int {
+        if asset == "BTC" {
+                return s.config.BTCConfirmations
+        }
+        return s.config.ETHConfirmations
+}
+
+// depositOutcome is what the transfers to a direct deposit's address mean
+// for its payment
+type depositOutcome int
+
+const (
+        depositOpen      depositOutcome = iota // nothing to settle yet
+        depositPaid                            // complete with the transfer
+        depositUnderpaid                       // the transfer falls short
+        depositExpired                         // the quote ran out unpaid
+)
+
+// transferClaims finds the payment a transfer was settled with
+type transferClaims interface {
+        GetByTransactionID(provider, transactionID string) (*models.Payment, error)
+}
+
+// settleDeposit completes a direct deposit payment once a matching transfer
+// has enough confirmations. The transfer's ID becomes the payment's
+// transaction ID, so one transfer can never pay for two payments.
+func (s *PaymentService) settleDeposit(payment *models.Payment) error {
+        outcome, transfer, err := s.reviewDeposit(payment)
+        if err != nil {
+                return err
+        }
+
+        switch outcome {
+        case depositPaid:
+                if err := s.paymentRepo.SetTransactionID(payment.ID, transfer.TxID); err != nil {
+                        return err
+                }
+                return s.completePayment(payment)
+        case depositUnderpaid:
+                amount := utils.FormatAssetAmount(transfer.Amount, payment.PaymentData["asset"])
+                return s.paymentRepo.MarkUnderpaid(payment.ID, transfer.TxID, amount)
+        case depositExpired:
+                return s.paymentRepo.MarkExpired(payment.ID)
+        }
+        return nil
+}
+
+// reviewDeposit looks up the transfers to the payment's address and decides
+// the payment's outcome along with the transfer it rests on.
+//
+// The quoted amount holds until the quote expires plus CRYPTO_QUOTE_GRACE.
+// A transfer confirmed after that is priced again at the current rate. A
+// confirmed transfer that falls short is recorded as underpaid when the
+// address was derived for this payment alone; payments that saw no transfer
+// at all expire with their quote. Expired payments are reviewed again for
+// CRYPTO_LATE_WINDOW, so money that arrives late is still accepted.
+func (s *PaymentService) reviewDeposit(payment *models.Payment) (depositOutcome, *utils.ChainTransfer, error) {
+        asset := payment.PaymentData["asset"]
+        explorer, ok := s.explorers[asset]
+        if !ok {
+                return depositOpen, nil, fmt.Errorf("no chain explorer configured for %s", asset)
+        }
+
+        expected, err := utils.ParseAssetAmount(payment.PaymentData["amount"], asset)
+        if err != nil {
+                return depositOpen, nil, err
+        }
+
+        transfers, err := explorer.IncomingTransfers(asset, payment.PaymentData["address"])
+        if err != nil {
+                return depositOpen, nil, err
+        }
+
+        deadline, hasQuote := s.quoteDeadline(payment)
+
+        transfer, err := s.matchTransfer(payment, expected, transfers)
+        if err != nil {
+                return depositOpen, nil, err
+        }
+        if transfer != nil {
+                if hasQuote && transfer.SeenAt.After(deadline) {
+                        return s.reviewLateDeposit(payment, transfer)
+                }
+                return depositPaid, transfer, nil
+        }
+
+        if isDerivedAddress(payment) {
+                short, err := s.largestUnclaimedTransfer(payment, transfers)
+                if err != nil {
+                        return depositOpen, nil, err
+                }
+                if short != nil {
+                        return depositUnderpaid, short, nil
+                }
+        }
+
+        if payment.Status != "expired" && hasQuote && time.Now().After(deadline) && !s.awaitingConfirmations(payment, transfers) {
+                return depositExpired, nil, nil
+        }
+
+        return depositOpen, nil, nil
+}
+
+// reviewLateDeposit accepts a transfer that arrived after the quote ran out
+// if it still covers the price at the current rate. One that falls short
+// underpays the payment only when the address is its own; on a shared
+// address it may be meant for another payment.
+func (s *PaymentService) reviewLateDeposit(payment *models.Payment, transfer *utils.ChainTransfer) (depositOutcome, *utils.ChainTransfer, error) {
+        asset := payment.PaymentData["asset"]
+
+        repriced, _, err := s.rates.Price(payment.Amount, payment.Currency, asset)
+        if err != nil {
+                return depositOpen, nil, err
+        }
+
+        if transfer.Amount.Cmp(repriced) >= 0 {
+                return depositPaid, transfer, nil
+        }
+
+        log.Printf("Crypto payment %d: late transfer %s of %s %s no longer covers %s %s",
+                payment.ID, transfer.TxID, utils.FormatAssetAmount(transfer.Amount, asset), asset,
+                utils.FormatAssetAmount(repriced, asset), asset)
+        if !isDerivedAddress(payment) {
+                return depositOpen, nil, nil
+        }
+        return depositUnderpaid, transfer, nil
+}
+
+// quoteDeadline is the last moment a transfer is accepted at the quoted
+// amount; payments created before quotes were locked have none
+func (s *PaymentService) quoteDeadline(payment *models.Payment) (time.Time, bool) {
+        expiresAt, err := time.Parse(time.RFC3339, payment.PaymentData["quote_expires_at"])
+        if err != nil {
+                return time.Time{}, false
+        }
+        return expiresAt.Add(s.config.CryptoQuoteGrace), true
+}
+
+// largestUnclaimedTransfer returns the largest confirmed transfer in
+// transfers that no other payment was settled with
+func (s *PaymentService) largestUnclaimedTransfer(payment *models.Payment, transfers []utils.ChainTransfer) (*utils.ChainTransfer, error) {
+        confirmations := s.requiredConfirmations(payment.PaymentData["asset"])
+        earliest := earliestTransfer(payment)
+
+        var largest *utils.ChainTransfer
+        for i := range transfers {
+                transfer := &transfers[i]
+                if transfer.Confirmations < confirmations {
+                        continue
+                }
+                if !transfer.SeenAt.IsZero() && transfer.SeenAt.Before(earliest) {
+                        continue
+                }
+                if largest != nil && transfer.Amount.Cmp(largest.Amount) <= 0 {
+                        continue
+                }
+
+                claimed, err := s.isClaimed(payment, transfer)
+                if err != nil {
+                        return nil, err
+                }
+                if !claimed {
+                        largest = transfer
+                }
+        }
+
+        return largest, nil
+}
+
+// awaitingConfirmations reports whether a transfer to the payment's address
+// is still short of the required confirmations
+func (s *PaymentService) awaitingConfirmations(payment *models.Payment, transfers []utils.ChainTransfer) bool {
+        confirmations := s.requiredConfirmations(payment.PaymentData["asset"])
+        for _, transfer := range transfers {
+                if transfer.Confirmations < confirmations {
+                        return true
+                }
+        }
+        return false
+}
+
+func (s *PaymentService) isClaimed(payment *models.Payment, transfer *utils.ChainTransfer) (bool, error) {
+        claimed, err := s.claims.GetByTransactionID(payment.PaymentProvider, transfer.TxID)
+        if errors.Is(err, sql.ErrNoRows) {
+                return false, nil
+        }
+        if err != nil {
+                return false, err
+        }
+        return claimed.ID != payment.ID, nil
+}
+
+// matchTransfer picks the smallest confirmed transfer that covers the expected
+// amount and was not used for another payment. Several payments can share an
+// address, so an exact amount wins over a larger one.
+func (s *PaymentService) matchTransfer(payment *models.Payment, expected *big.Int, transfers []utils.ChainTransfer) (*utils.ChainTransfer, error) {
+        confirmations := s.requiredConfirmations(payment.PaymentData["asset"])
+        earliest := earliestTransfer(payment)
+
+        var best *utils.ChainTransfer
+        for i := range transfers {
+                transfer := &transfers[i]
+                if transfer.Amount.Cmp(expected) < 0 || transfer.Confirmations < confirmations {
+                        continue
+                }
+                if !transfer.SeenAt.IsZero() && transfer.SeenAt.Before(earliest) {
+                        continue
+                }
+                if best != nil && transfer.Amount.Cmp(best.Amount) >= 0 {
+                        continue
+                }
+
+                claimed, err := s.isClaimed(payment, transfer)
+                if err != nil {
+                        return nil, err
+                }
+                if claimed {
+                        continue
+                }
+
+                best = transfer
+        }
+
+        return best, nil
+}
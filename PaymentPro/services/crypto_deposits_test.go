@@ -0,0 +1,337 @@
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"telegram-subscription-bot/config"
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/utils"
+)
+
+const depositAddress = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+
+// fakeClaims maps the transfers already settled to their payments
+type fakeClaims map[string]int64
+
+func (f fakeClaims) GetByTransactionID(provider, transactionID string) (*models.Payment, error) {
+	id, ok := f[transactionID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &models.Payment{ID: id, PaymentProvider: provider, TransactionID: transactionID}, nil
+}
+
+// depositPayment is a $10 payment quoted at 0.0002 BTC, the price at
+// 50000 USD, whose quote expires after quoteLeft
+func depositPayment(quoteLeft time.Duration, derived bool) *models.Payment {
+	data := map[string]string{
+		"asset":            "BTC",
+		"address":          depositAddress,
+		"amount":           "0.00020000",
+		"quote_expires_at": time.Now().Add(quoteLeft).Format(time.RFC3339),
+	}
+	if derived {
+		data["address_index"] = "7"
+	}
+	return &models.Payment{
+		ID:              1,
+		Amount:          1000,
+		Currency:        "USD",
+		PaymentProvider: "crypto",
+		Status:          "pending",
+		PaymentData:     data,
+		CreatedAt:       time.Now().Add(-10 * time.Minute),
+	}
+}
+
+func TestReviewDeposit(t *testing.T) {
+	type transfer struct {
+		txID          string
+		amount        string
+		confirmations int
+	}
+
+	tests := []struct {
+		name      string
+		status    string        // pending when empty
+		quoteLeft time.Duration // negative once the quote expired
+		derived   bool
+		transfers []transfer
+		claimed   fakeClaims
+		reusedAgo time.Duration // the address was taken over from expired payments
+		earlier   []transfer    // confirmed an hour before the address was reused
+		rate      float64       // BTC price in USD when the deposit is reviewed
+		want      depositOutcome
+		wantTxID  string
+	}{
+		{
+			name:      "no transfer yet",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			want:      depositOpen,
+		},
+		{
+			name:      "exact payment",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "overpayment",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"over", "0.0003", 6}},
+			want:      depositPaid,
+			wantTxID:  "over",
+		},
+		{
+			name:      "exact payment wins over a larger one",
+			quoteLeft: 20 * time.Minute,
+			transfers: []transfer{{"over", "0.0003", 6}, {"exact", "0.0002", 6}},
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "waiting for confirmations",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 1}},
+			want:      depositOpen,
+		},
+		{
+			name:      "underpayment to a derived address",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"short", "0.00015", 2}, {"shorter", "0.0001", 2}},
+			want:      depositUnderpaid,
+			wantTxID:  "short",
+		},
+		{
+			name:      "underpayment to a shared address",
+			quoteLeft: 20 * time.Minute,
+			transfers: []transfer{{"short", "0.00015", 2}},
+			want:      depositOpen,
+		},
+		{
+			name:      "transfer settled another payment",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			claimed:   fakeClaims{"exact": 2},
+			want:      depositOpen,
+		},
+		{
+			name:      "transfer already recorded for this payment",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			claimed:   fakeClaims{"exact": 1},
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "quote locked while the price fell",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			rate:      40000,
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "confirmed within the grace period",
+			quoteLeft: -5 * time.Minute,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			rate:      40000,
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "expired quote",
+			quoteLeft: -time.Hour,
+			derived:   true,
+			want:      depositExpired,
+		},
+		{
+			name:      "expired quote with a transfer awaiting confirmations",
+			quoteLeft: -time.Hour,
+			derived:   true,
+			transfers: []transfer{{"exact", "0.0002", 1}},
+			want:      depositOpen,
+		},
+		{
+			name:      "late confirmation, price unchanged",
+			quoteLeft: -time.Hour,
+			derived:   true,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			want:      depositPaid,
+			wantTxID:  "late",
+		},
+		{
+			name:      "late confirmation, price rose",
+			quoteLeft: -time.Hour,
+			derived:   true,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			rate:      60000,
+			want:      depositPaid,
+			wantTxID:  "late",
+		},
+		{
+			name:      "late confirmation no longer covers the price",
+			quoteLeft: -time.Hour,
+			derived:   true,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			rate:      40000,
+			want:      depositUnderpaid,
+			wantTxID:  "late",
+		},
+		{
+			name:      "late short transfer to a shared address",
+			quoteLeft: -time.Hour,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			rate:      40000,
+			want:      depositOpen,
+		},
+		{
+			name:      "late deposit to an expired payment",
+			status:    "expired",
+			quoteLeft: -48 * time.Hour,
+			derived:   true,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			want:      depositPaid,
+			wantTxID:  "late",
+		},
+		{
+			name:      "late deposit to an expired payment at a lower price",
+			status:    "expired",
+			quoteLeft: -48 * time.Hour,
+			derived:   true,
+			transfers: []transfer{{"late", "0.0002", 2}},
+			rate:      40000,
+			want:      depositUnderpaid,
+			wantTxID:  "late",
+		},
+		{
+			name:      "expired payment still without a transfer",
+			status:    "expired",
+			quoteLeft: -48 * time.Hour,
+			derived:   true,
+			want:      depositOpen,
+		},
+		{
+			name:      "late transfer for the previous payment of a reused address",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			reusedAgo: 10 * time.Minute,
+			earlier:   []transfer{{"previous", "0.0002", 6}},
+			want:      depositOpen,
+		},
+		{
+			name:      "late short transfer for the previous payment of a reused address",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			reusedAgo: 10 * time.Minute,
+			earlier:   []transfer{{"previous", "0.0001", 6}},
+			want:      depositOpen,
+		},
+		{
+			name:      "payment to a reused address",
+			quoteLeft: 20 * time.Minute,
+			derived:   true,
+			reusedAgo: 10 * time.Minute,
+			earlier:   []transfer{{"previous", "0.0002", 6}},
+			transfers: []transfer{{"exact", "0.0002", 2}},
+			want:      depositPaid,
+			wantTxID:  "exact",
+		},
+		{
+			name:      "late overpayment covers the new price",
+			quoteLeft: -time.Hour,
+			transfers: []transfer{{"late", "0.00025", 2}},
+			rate:      40000,
+			want:      depositPaid,
+			wantTxID:  "late",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explorer := utils.NewFakeChainExplorer("")
+			for _, transfer := range tt.transfers {
+				if err := explorer.AddTransfer("BTC", depositAddress, transfer.txID, transfer.amount, transfer.confirmations); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reusedAt := time.Now().Add(-tt.reusedAgo)
+			for _, transfer := range tt.earlier {
+				if err := explorer.AddTransferAt("BTC", depositAddress, transfer.txID, transfer.amount, transfer.confirmations, reusedAt.Add(-time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A transfer to another address never counts
+			if err := explorer.AddTransfer("BTC", "bc1qother", "elsewhere", "0.0002", 6); err != nil {
+				t.Fatal(err)
+			}
+
+			rate := tt.rate
+			if rate == 0 {
+				rate = 50000
+			}
+			file := filepath.Join(t.TempDir(), "rates.json")
+			writeRates(t, file, map[string]float64{"USD": rate})
+			rates, _ := newTestRates(file, 0)
+
+			claims := tt.claimed
+			if claims == nil {
+				claims = fakeClaims{}
+			}
+
+			s := &PaymentService{
+				config: &config.Config{
+					BTCConfirmations: 2,
+					CryptoQuoteGrace: 10 * time.Minute,
+				},
+				explorers: map[string]utils.ChainExplorer{"BTC": explorer},
+				claims:    claims,
+				rates:     rates,
+			}
+
+			payment := depositPayment(tt.quoteLeft, tt.derived)
+			if tt.status != "" {
+				payment.Status = tt.status
+			}
+			if tt.reusedAgo > 0 {
+				payment.PaymentData["address_reused_at"] = reusedAt.UTC().Format(time.RFC3339)
+			}
+
+			got, transfer, err := s.reviewDeposit(payment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("outcome = %d, want %d", got, tt.want)
+			}
+
+			var txID string
+			if transfer != nil {
+				txID = transfer.TxID
+			}
+			if txID != tt.wantTxID {
+				t.Errorf("transfer = %q, want %q", txID, tt.wantTxID)
+			}
+		})
+	}
+}
+
+func TestReviewDepositWithoutExplorer(t *testing.T) {
+	s := &PaymentService{config: &config.Config{}, explorers: map[string]utils.ChainExplorer{}}
+	if _, _, err := s.reviewDeposit(depositPayment(time.Hour, true)); err == nil {
+		t.Error("reviewDeposit() settled a payment no explorer watches")
+	}
+}
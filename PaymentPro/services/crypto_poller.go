@@ -0,0 +1,46 @@
+package services
+
+import (
+	"log"
+	"time"
+)
+
+// CryptoPaymentPoller settles pending crypto payments in the background;
+// direct deposits have no webhook to tell us they arrived.
+type CryptoPaymentPoller struct {
+	paymentService *PaymentService
+	ticker         *time.Ticker
+	stopChan       chan bool
+}
+
+func NewCryptoPaymentPoller(paymentService *PaymentService, interval time.Duration) *CryptoPaymentPoller {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &CryptoPaymentPoller{
+		paymentService: paymentService,
+		ticker:         time.NewTicker(interval),
+		stopChan:       make(chan bool),
+	}
+}
+
+func (p *CryptoPaymentPoller) Start() {
+	log.Println("Starting crypto payment poller...")
+
+	for {
+		select {
+		case <-p.ticker.C:
+			if err := p.paymentService.ProcessPendingCryptoPayments(); err != nil {
+				log.Printf("Error processing pending crypto payments: %v", err)
+			}
+		case <-p.stopChan:
+			p.ticker.Stop()
+			return
+		}
+	}
+}
+
+func (p *CryptoPaymentPoller) Stop() {
+	p.stopChan <- true
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+        "bytes"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "net/url"
+        "strconv"
+        "strings"
+        "time"
+
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/models"
+)
+
+func init() {
+        RegisterPaymentProvider("crypto", newCryptoProvider)
+}
+
+// cryptoProvider accepts cryptocurrency either through a BTCPay Server store
+// (when CRYPTO_PROCESSOR_URL, _API_KEY and _STORE_ID are set) or as a direct
+// deposit: to an address derived for the payment when an xpub is configured
+// for the asset's chain, otherwise to the fixed wallet address of the asset.
+type cryptoProvider struct {
+        processorURL  string
+        apiKey        string
+        storeID       string
+        webhookSecret string
+        redirectURL   string
+        assets        []string
+        addresses     map[string]string
+        deposits      *DepositAddresses
+        rates         *RateService
+}
+
+func newCryptoProvider(settings *models.PaymentProviderConfig, deps ProviderDeps) (PaymentProvider, error) {
+        webhookSecret := deps.Config.CryptoWebhookSecret
+        if settings.WebhookSecret != "" {
+                webhookSecret = settings.WebhookSecret
+        }
+
+        p := &cryptoProvider{
+                processorURL:  strings.TrimRight(deps.Config.CryptoProcessorURL, "/"),
+                apiKey:        deps.Config.CryptoProcessorAPIKey,
+                storeID:       deps.Config.CryptoProcessorStoreID,
+                webhookSecret: webhookSecret,
+                redirectURL:   configString(settings, "redirect_url", deps.Config.Domain+"/payment/success"),
+                addresses:     cryptoAddresses(deps.Config),
+                deposits:      deps.DepositAddresses,
+                rates:         deps.Rates,
+        }
+
+        currencies := configStrings(settings, "currencies")
+        if len(currencies) == 0 {
+                currencies = []string{"BTC", "ETH", "USDT"}
+        }
+        for _, currency := range currencies {
+                currency = strings.ToUpper(currency)
+                if p.useProcessor() || p.addresses[currency] != "" || p.deposits.Supports(currency) {
+                        p.assets = append(p.assets, currency)
+                }
+        }
+
+        if len(p.assets) == 0 {
+                return nil, fmt.Errorf("neither a crypto processor nor wallet addresses are configured")
+        }
+
+        return p, nil
+}
+
+func cryptoAddresses(cfg *config.Config) map[string]string {
+        return map[string]string{
+                "BTC":  cfg.BTCAddress,
+                "ETH":  cfg.ETHAddress,
+                "USDT": cfg.USDTAddress,
+        }
+}
+
+func (p *cryptoProvider) useProcessor() bool {
+        return p.processorURL != "" && p.apiKey != "" && p.storeID != ""
+}
+
+func (p *cryptoProvider) Method() string {
+        return "crypto"
+}
+
+// Assets lists the cryptocurrencies a payer can choose from
+func (p *cryptoProvider) Assets() []string {
+        return append([]string(nil), p.assets...)
+}
+
+func (p *cryptoProvider) CreateCheckout(req *CheckoutRequest) (*Checkout, error) {
+        asset := strings.ToUpper(req.Asset)
+        if asset == "" {
+                asset = p.assets[0]
+        }
+        if !p.supportsAsset(asset) {
+                return nil, fmt.Errorf("unsupported cryptocurrency: %s", asset)
+        }
+
+        if p.useProcessor() {
+                return p.createInvoice(req, asset)
+        }
+
+        quote, err := p.rates.Quote(req.Payment.Amount, req.Payment.Currency, asset)
+        if err != nil {
+                return nil, err
+        }
+
+        data := map[string]string{
+                "asset":            asset,
+                "address":          p.addresses[asset],
+                "amount":           quote.AmountText(),
+                "fiat":             quote.Fiat,
+                "rate":             strconv.FormatFloat(quote.Rate, 'f', -1, 64),
+                "quote_expires_at": quote.ExpiresAt.UTC().Format(time.RFC3339),
+        }
+
+        if p.deposits.Supports(asset) {
+                derived, err := p.deposits.Allocate(req.Payment.ID, asset)
+                if err != nil {
+                        return nil, err
+                }
+                data["address"] = derived.Address
+                data["address_index"] = strconv.FormatUint(uint64(derived.Index), 10)
+                if derived.ReusedAt != nil {
+                        data["address_reused_at"] = derived.ReusedAt.UTC().Format(time.RFC3339)
+                }
+        }
+
+        return &Checkout{
+                Data:  data,
+                Quote: quote,
+        }, nil
+}
+
+func (p *cryptoProvider) createInvoice(req *CheckoutRequest, asset string) (*Checkout, error) {
+        paymentID := strconv.FormatInt(req.Payment.ID, 10)
+
+        body := map[string]interface{}{
+                "amount":   fmt.Sprintf("%.2f", float64(req.Payment.Amount)/100),
+                "currency": req.Payment.Currency,
+                "metadata": map[string]interface{}{
+                        "orderId":    "payment_" + paymentID,
+                        "itemDesc":   req.Plan.Name,
+                        "payment_id": paymentID,
+                },
+                "checkout": map[string]interface{}{
+                        "redirectURL":    p.redirectURL,
+                        "paymentMethods": []string{asset},
+                },
+        }
+
+        var invoice btcPayInvoice
+        if err := p.do("POST", "/invoices", body, &invoice); err != nil {
+                return nil, err
+        }
+
+        return &Checkout{
+                TransactionID: invoice.ID,
+                URL:           invoice.CheckoutLink,
+                Data: map[string]string{
+                        "asset": asset,
+                },
+        }, nil
+}
+
+func (p *cryptoProvider) ParseWebhook(r *http.Request, payload []byte) (*WebhookEvent, error) {
+        if !p.useProcessor() {
+                return nil, ErrWebhooksNotSupported
+        }
+
+        if !p.verifySignature(payload, r.Header.Get("BTCPay-Sig")) {
+                return nil, ErrInvalidWebhookSignature
+        }
+
+        var delivery btcPayWebhookEvent
+        if err := json.Unmarshal(payload, &delivery); err != nil {
+                return nil, err
+        }
+        if delivery.InvoiceID == "" {
+                return nil, fmt.Errorf("crypto webhook without invoice id")
+        }
+
+        // Redeliveries get a fresh delivery ID but keep pointing at the original one
+        eventID := delivery.DeliveryID
+        if delivery.OriginalDeliveryID != "" {
+                eventID = delivery.OriginalDeliveryID
+        }
+        if eventID == "" {
+                eventID = delivery.InvoiceID + ":" + delivery.Type
+        }
+
+        event := &WebhookEvent{
+                ID:            eventID,
+                Type:          delivery.Type,
+                TransactionID: delivery.InvoiceID,
+        }
+        event.PaymentID, _ = strconv.ParseInt(fmt.Sprintf("%v", delivery.Metadata["payment_id"]), 10, 64)
+
+        switch delivery.Type {
+        case "InvoiceSettled":
+                event.Status = "completed"
+        case "InvoiceExpired":
+                event.Status = "cancelled"
+        case "InvoiceInvalid":
+                event.Status = "failed"
+        }
+
+        return event, nil
+}
+
+// Refund is not offered: sending coins back needs an address from the payer
+func (p *cryptoProvider) Refund(payment *models.Payment) error {
+        return ErrRefundNotSupported
+}
+
+func (p *cryptoProvider) FetchStatus(payment *models.Payment) (string, error) {
+        // Direct deposits have no processor to ask
+        if !p.useProcessor() || payment.TransactionID == "" {
+                return payment.Status, nil
+        }
+
+        var invoice btcPayInvoice
+        if err := p.do("GET", "/invoices/"+url.PathEscape(payment.TransactionID), nil, &invoice); err != nil {
+                return "", err
+        }
+
+        switch invoice.Status {
+        case "Settled":
+                return "completed", nil
+        case "Expired":
+                return "cancelled", nil
+        case "Invalid":
+                return "failed", nil
+        }
+        return payment.Status, nil
+}
+
+func (p *cryptoProvider) supportsAsset(asset string) bool {
+        for _, supported := range p.assets {
+                if supported == asset {
+                        return true
+                }
+        }
+        return false
+}
+
+func (p *cryptoProvider) verifySignature(payload []byte, signature string) bool {
+        if p.webhookSecret == "" {
+                return false
+        }
+
+        mac := hmac.New(sha256.New, []byte(p.webhookSecret))
+        mac.Write(payload)
+        expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+        return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func (p *cryptoProvider) do(method, path string, body interface{}, out interface{}) error {
+        var payload []byte
+        if body != nil {
+                var err error
+                if payload, err = json.Marshal(body); err != nil {
+                        return err
+                }
+        }
+
+        endpoint := fmt.Sprintf("%s/api/v1/stores/%s%s", p.processorURL, url.PathEscape(p.storeID), path)
+        req, err := http.NewRequest(method, endpoint, bytes.NewReader(payload))
+        if err != nil {
+                return err
+        }
+
+        req.Header.Set("Authorization", "token "+p.apiKey)
+        req.Header.Set("Content-Type", "application/json")
+
+        return doProviderRequest(req, out)
+}
+
+type btcPayInvoice struct {
+        ID           string `json:"id"`
+        Status       string `json:"status"`
+        CheckoutLink string `json:"checkoutLink"`
+}
+
+type btcPayWebhookEvent struct {
+        DeliveryID         string                 `json:"deliveryId"`
+        OriginalDeliveryID string                 `json:"originalDeliveryId"`
+        Type               string                 `json:"type"`
+        InvoiceID          string                 `json:"invoiceId"`
+        Metadata           map[string]interface{} `json:"metadata"`
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "database/sql"
+        "encoding/base64"
+        "encoding/hex"
+        "errors"
+        "fmt"
+        "net/url"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+
+        "telegram-subscription-bot/models"
+)
+
+var (
+        ErrInvalidTelegramLogin = errors.New("invalid Telegram login")
+        ErrInvalidLoginLink     = errors.New("the sign-in link is invalid, expired or already used")
+)
+
+// TelegramLogin is the user data the Telegram Login Widget hands to the page,
+// signed with the bot token.
+// See https://core.telegram.org/widgets/login#checking-authorization
+type TelegramLogin struct {
+        ID        int64  `json:"id"`
+        FirstName string `json:"first_name"`
+        LastName  string `json:"last_name"`
+        Username  string `json:"username"`
+        PhotoURL  string `json:"photo_url"`
+        AuthDate  int64  `json:"auth_date"`
+        Hash      string `json:"hash"`
+}
+
+// verifyTelegramLogin checks the widget's hash: HMAC-SHA256 of the sorted
+// "key=value" lines of all other fields, keyed with SHA-256 of the bot token.
+// Logins older than maxAge are refused so a leaked payload can't be replayed
+// forever.
+func verifyTelegramLogin(botToken string, login *TelegramLogin, maxAge time.Duration, now time.Time) error {
+        fields := map[string]string{
+                "id":         strconv.FormatInt(login.ID, 10),
+                "first_name": login.FirstName,
+                "last_name":  login.LastName,
+                "username":   login.Username,
+                "photo_url":  login.PhotoURL,
+                "auth_date":  strconv.FormatInt(login.AuthDate, 10),
+        }
+
+        var lines []string
+        for key, value := range fields {
+                // The widget leaves out the fields a user has not set
+                if value != "" {
+                        lines = append(lines, key+"="+value)
+                }
+        }
+        sort.Strings(lines)
+
+        secret := sha256.Sum256([]byte(botToken))
+        mac := hmac.New(sha256.New, secret[:])
+        mac.Write([]byte(strings.Join(lines, "\n")))
+
+        hash, err := hex.DecodeString(login.Hash)
+        if err != nil || !hmac.Equal(hash, mac.Sum(nil)) {
+                return ErrInvalidTelegramLogin
+        }
+
+        if login.ID == 0 || now.Sub(time.Unix(login.AuthDate, 0)) > maxAge {
+                return ErrInvalidTelegramLogin
+        }
+
+        return nil
+}
+
+// LoginWithTelegram opens a session for the user behind a Telegram Login
+// Widget payload, registering users who never talked to the bot the way /start
+// would
+func (s *AuthService) LoginWithTelegram(login *TelegramLogin) (string, *models.DashboardSession, error) {
+        if err := verifyTelegramLogin(s.config.TelegramBotToken, login, s.config.TelegramLoginAge, time.Now()); err != nil {
+                return "", nil, err
+        }
+
+        user, err := s.userRepo.GetByTelegramID(login.ID)
+        if errors.Is(err, sql.ErrNoRows) {
+                user = &models.User{
+                        TelegramID:    login.ID,
+                        Username:      login.Username,
+                        FirstName:     login.FirstName,
+                        LastName:      login.LastName,
+                        CurrentPlanID: 1,
+                }
+                err = s.userRepo.CreateOrUpdate(user)
+        }
+        if err != nil {
+                return "", nil, err
+        }
+
+        return s.createSession(user.ID, s.roleOf(user))
+}
+
+// BotUsername is the bot the Telegram Login Widget signs in with
+func (s *AuthService) BotUsername() string {
+        return s.botUsername
+}
+
+// CreateLoginLink returns a dashboard URL that signs the user in once within
+// LOGIN_LINK_TTL and then opens the page next, if set. The token is
+// "<user id>.<expiry>.<nonce>.<signature>"; the signature keeps anyone from
+// making up links, the stored nonce keeps a link from being used twice.
+func (s *AuthService) CreateLoginLink(user *models.User, next string) (string, error) {
+        nonceBytes := make([]byte, 16)
+        if _, err := rand.Read(nonceBytes); err != nil {
+                return "", err
+        }
+        nonce := hex.EncodeToString(nonceBytes)
+        expiresAt := time.Now().Add(s.config.LoginLinkTTL)
+
+        if err := s.sessions.CreateLoginLink(nonce, user.ID, expiresAt); err != nil {
+                return "", err
+        }
+
+        payload := fmt.Sprintf("%d.%d.%s", user.ID, expiresAt.Unix(), nonce)
+        token := payload + "." + s.signLoginLink(payload)
+
+        link := fmt.Sprintf("%s/login?token=%s", s.dashboardURL(), token)
+        if IsLocalPath(next) {
+                link += "&next=" + url.QueryEscape(next)
+        }
+        return link, nil
+}
+
+// IsLocalPath reports whether path points into the dashboard itself, so
+// redirects after sign-in can't be turned into open redirects
+func IsLocalPath(path string) bool {
+        return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.Contains(path, "\\")
+}
+
+// RedeemLoginLink uses up a sign-in link and opens a session for its user
+func (s *AuthService) RedeemLoginLink(token string) (string, *models.DashboardSession, error) {
+        parts := strings.Split(token, ".")
+        if len(parts) != 4 {
+                return "", nil, ErrInvalidLoginLink
+        }
+
+        payload := strings.Join(parts[:3], ".")
+        if !hmac.Equal([]byte(parts[3]), []byte(s.signLoginLink(payload))) {
+                return "", nil, ErrInvalidLoginLink
+        }
+
+        userID, err := strconv.Atoi(parts[0])
+        if err != nil {
+                return "", nil, ErrInvalidLoginLink
+        }
+        expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+        if err != nil || time.Now().Unix() >= expiresAt {
+                return "", nil, ErrInvalidLoginLink
+        }
+
+        used, err := s.sessions.UseLoginLink(parts[2], userID)
+        if err != nil {
+                return "", nil, err
+        }
+        if !used {
+                return "", nil, ErrInvalidLoginLink
+        }
+
+        user, err := s.userRepo.GetByID(userID)
+        if err != nil {
+                return "", nil, err
+        }
+
+        return s.createSession(user.ID, s.roleOf(user))
+}
+
+func (s *AuthService) signLoginLink(payload string) string {
+        mac := hmac.New(sha256.New, s.loginLinkKey)
+        mac.Write([]byte(payload))
+        return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// loginLinkKey is LOGIN_LINK_SECRET, or a key derived from the bot token so
+// links work without extra configuration
+func loginLinkKey(secret, botToken string) []byte {
+        if secret != "" {
+                return []byte(secret)
+        }
+        mac := hmac.New(sha256.New, []byte("dashboard-login-link"))
+        mac.Write([]byte(botToken))
+        return mac.Sum(nil)
+}
+
+func (s *AuthService) dashboardURL() string {
+        if s.config.Domain != "" {
+                return strings.TrimRight(s.config.Domain, "/")
+        }
+        return "http://localhost:5000"
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+        "encoding/hex"
+        "errors"
+        "fmt"
+        "log"
+        "time"
+
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/utils"
+)
+
+// DepositAddresses gives every crypto payment an address of its own, derived
+// from the watch-only account keys in BTC_XPUB and ETH_XPUB, so an incoming
+// transfer can only belong to one open payment. USDT shares the Ethereum key.
+type DepositAddresses struct {
+        paymentRepo *models.PaymentRepository
+        wallets     map[string]*utils.ExtendedPublicKey // chain -> account key
+        gapLimit    int
+        lateWindow  time.Duration
+}
+
+func NewDepositAddresses(cfg *config.Config, paymentRepo *models.PaymentRepository) *DepositAddresses {
+        d := &DepositAddresses{
+                paymentRepo: paymentRepo,
+                wallets:     make(map[string]*utils.ExtendedPublicKey),
+                gapLimit:    cfg.AddressGapLimit,
+                lateWindow:  cfg.CryptoLateWindow,
+        }
+
+        for chain, encoded := range map[string]string{"BTC": cfg.BTCXpub, "ETH": cfg.ETHXpub} {
+                if encoded == "" {
+                        continue
+                }
+
+                key, err := utils.ParseExtendedPublicKey(encoded)
+                if err != nil {
+                        log.Printf("Ignoring %s_XPUB: %v", chain, err)
+                        continue
+                }
+                if chain == "ETH" {
+                        key.Kind = utils.AddressEthereum
+                }
+                d.wallets[chain] = key
+        }
+
+        return d
+}
+
+func depositChain(asset string) string {
+        if asset == "BTC" {
+                return "BTC"
+        }
+        return "ETH"
+}
+
+// Supports reports whether addresses for the asset can be derived
+func (d *DepositAddresses) Supports(asset string) bool {
+        _, ok := d.wallets[depositChain(asset)]
+        return ok
+}
+
+// Allocate gives the payment an address of its own and records it together
+// with its derivation index; see PaymentRepository.AssignDerivedAddress for
+// when an address of an expired payment is used again
+func (d *DepositAddresses) Allocate(paymentID int64, asset string) (*models.DerivedAddress, error) {
+        chain := depositChain(asset)
+        wallet, ok := d.wallets[chain]
+        if !ok {
+                return nil, fmt.Errorf("no extended public key configured for %s", asset)
+        }
+
+        // Indexes are counted per account key, so a new xpub starts over at 0
+        walletID := chain + ":" + hex.EncodeToString(wallet.Key[1:9])
+        return d.paymentRepo.AssignDerivedAddress(paymentID, walletID, d.gapLimit, time.Now().Add(-d.lateWindow), func(index uint32) (string, error) {
+                key, err := wallet.DepositKey(index)
+                if errors.Is(err, utils.ErrInvalidChild) {
+                        return "", models.ErrUnusableIndex
+                }
+                if err != nil {
+                        return "", err
+                }
+                return key.Address()
+        })
+}
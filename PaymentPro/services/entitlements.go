@@ -0,0 +1,189 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/models"
+)
+
+// entitlementTTL bounds how long a plan change made outside this process
+// takes to reach the bot and the dashboard
+const entitlementTTL = 5 * time.Minute
+
+type cachedEntitlements struct {
+	entitlements *models.Entitlements
+	checkedAt    time.Time
+}
+
+// EntitlementService decides what users and groups are entitled to from the
+// plan they, or the group's owners, are on. Results are cached; payment,
+// expiry, grants and plan edits invalidate them.
+type EntitlementService struct {
+	userRepo  *models.UserRepository
+	planRepo  *models.SubscriptionRepository
+	groupRepo *models.UserGroupRepository
+	freeLimit int
+
+	mu    sync.Mutex
+	users map[int]cachedEntitlements
+	chats map[int64]cachedEntitlements
+}
+
+func NewEntitlementService(db *database.DB, freeGroupLimit int) *EntitlementService {
+	return &EntitlementService{
+		userRepo:  models.NewUserRepository(db.DB),
+		planRepo:  models.NewSubscriptionRepository(db.DB),
+		groupRepo: models.NewUserGroupRepository(db.DB),
+		freeLimit: freeGroupLimit,
+		users:     make(map[int]cachedEntitlements),
+		chats:     make(map[int64]cachedEntitlements),
+	}
+}
+
+// ForUser returns the entitlements of the user's plan while it is active and
+// of the free plan otherwise
+func (s *EntitlementService) ForUser(userID int) (*models.Entitlements, error) {
+	s.mu.Lock()
+	cached, ok := s.users[userID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.checkedAt) < entitlementTTL {
+		return cached.entitlements, nil
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	entitlements, err := s.resolve(user.CurrentPlanID, user.PlanExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = cachedEntitlements{entitlements: entitlements, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return entitlements, nil
+}
+
+// ForChat returns the best entitlements among the owners who registered the
+// chat and have it within their group limit. A chat every owner has suspended
+// gets the free plan's, and so does a chat nobody registered. It returns nil
+// when they cannot be loaded, so callers keep their current behaviour.
+func (s *EntitlementService) ForChat(chatID int64) *models.Entitlements {
+	s.mu.Lock()
+	cached, ok := s.chats[chatID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.checkedAt) < entitlementTTL {
+		return cached.entitlements
+	}
+
+	entitlements, err := s.resolveChat(chatID)
+	if err != nil {
+		log.Printf("Error loading entitlements of chat %d: %v", chatID, err)
+		return cached.entitlements
+	}
+
+	s.mu.Lock()
+	s.chats[chatID] = cachedEntitlements{entitlements: entitlements, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return entitlements
+}
+
+// GroupQuota returns how many groups the user may register and has registered
+func (s *EntitlementService) GroupQuota(userID int) (models.Quota, error) {
+	entitlements, err := s.ForUser(userID)
+	if err != nil {
+		return models.Quota{}, err
+	}
+	used, err := s.groupRepo.CountActive(userID)
+	if err != nil {
+		return models.Quota{}, err
+	}
+	limit, _ := entitlements.Limit(models.LimitGroups)
+	return models.Quota{Limit: limit, Used: used}, nil
+}
+
+// Invalidate drops the user's entitlements after their plan changed, along
+// with those of every chat, since the user may own any of them
+func (s *EntitlementService) Invalidate(userID int) {
+	s.mu.Lock()
+	delete(s.users, userID)
+	s.chats = make(map[int64]cachedEntitlements)
+	s.mu.Unlock()
+}
+
+// InvalidateChat drops the chat's entitlements after an owner registered,
+// removed, suspended or restored it
+func (s *EntitlementService) InvalidateChat(chatID int64) {
+	s.mu.Lock()
+	delete(s.chats, chatID)
+	s.mu.Unlock()
+}
+
+// InvalidateAll drops everything after a plan was edited
+func (s *EntitlementService) InvalidateAll() {
+	s.mu.Lock()
+	s.users = make(map[int]cachedEntitlements)
+	s.chats = make(map[int64]cachedEntitlements)
+	s.mu.Unlock()
+}
+
+func (s *EntitlementService) resolveChat(chatID int64) (*models.Entitlements, error) {
+	owners, err := s.groupRepo.Owners(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entitlements *models.Entitlements
+	for _, owner := range owners {
+		if owner.Suspended {
+			continue
+		}
+		ownerEntitlements, err := s.resolve(owner.PlanID, owner.PlanExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if entitlements == nil {
+			entitlements = ownerEntitlements
+		} else {
+			entitlements.Merge(ownerEntitlements)
+		}
+	}
+	if entitlements != nil {
+		return entitlements, nil
+	}
+
+	entitlements, err = s.resolve(models.FreePlanID, nil)
+	if err != nil {
+		return nil, err
+	}
+	entitlements.Suspended = len(owners) > 0
+	return entitlements, nil
+}
+
+// resolve builds fresh entitlements of the plan, or of the free plan once
+// the plan expired. The group limit mirrors the one registration enforces.
+func (s *EntitlementService) resolve(planID int, expiresAt *time.Time) (*models.Entitlements, error) {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		planID, expiresAt = models.FreePlanID, nil
+	}
+
+	plan, err := s.planRepo.GetByID(planID)
+	if err != nil {
+		return nil, err
+	}
+
+	paid := planID != models.FreePlanID
+	groupLimit := s.freeLimit
+	if paid {
+		groupLimit = plan.MaxGroups
+	}
+
+	entitlements := models.NewEntitlements(plan, groupLimit)
+	entitlements.Paid = paid
+	entitlements.ExpiresAt = expiresAt
+	return entitlements, nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+        "hash/fnv"
+        "sync"
+        "time"
+
+        "telegram-subscription-bot/models"
+)
+
+// What FloodDetector.Check found
+const (
+        FloodNone   = ""
+        FloodBurst  = "burst"
+        FloodRepeat = "repeat"
+)
+
+// floodDetectorMaxSenders bounds the senders tracked in memory
+const floodDetectorMaxSenders = 100000
+
+type floodKey struct {
+        chatID int64
+        userID int64
+}
+
+type floodState struct {
+        times    []time.Time // messages within the chat's window
+        lastHash uint64      // content of the last message
+        repeats  int         // identical messages in a row
+        lastSeen time.Time   // time of the last message
+}
+
+// FloodDetector counts the messages of each sender in each chat over a
+// sliding window, entirely in memory. A sender is flooding when more than the
+// chat's FloodMessages fall within FloodWindow seconds, or when FloodRepeats
+// messages in a row, each within FloodWindow of the one before, have the same
+// content. After a detection the sender's history starts over, so one burst
+// is one violation.
+type FloodDetector struct {
+        maxSenders int
+
+        mu      sync.Mutex
+        senders map[floodKey]*floodState
+}
+
+func NewFloodDetector() *FloodDetector {
+        return &FloodDetector{
+                maxSenders: floodDetectorMaxSenders,
+                senders:    make(map[floodKey]*floodState),
+        }
+}
+
+// Check records a message with the given content and reports FloodBurst,
+// FloodRepeat or FloodNone
+func (d *FloodDetector) Check(chatID, userID int64, content string, settings *models.ModerationSettings, now time.Time) string {
+        d.mu.Lock()
+        defer d.mu.Unlock()
+
+        key := floodKey{chatID: chatID, userID: userID}
+        state, ok := d.senders[key]
+        if !ok {
+                if len(d.senders) >= d.maxSenders {
+                        d.evict(now)
+                }
+                state = &floodState{}
+                d.senders[key] = state
+        }
+
+        window := time.Duration(settings.FloodWindow) * time.Second
+        kept := state.times[:0]
+        for _, at := range state.times {
+                if now.Sub(at) < window {
+                        kept = append(kept, at)
+                }
+        }
+        state.times = append(kept, now)
+
+        // Repeats only add up while each copy follows the last within the window
+        hash := contentHash(content)
+        if state.repeats > 0 && hash == state.lastHash && now.Sub(state.lastSeen) < window {
+                state.repeats++
+        } else {
+                state.lastHash = hash
+                state.repeats = 1
+        }
+        state.lastSeen = now
+
+        switch {
+        case len(state.times) > settings.FloodMessages:
+                delete(d.senders, key)
+                return FloodBurst
+        case settings.FloodRepeats > 0 && state.repeats >= settings.FloodRepeats:
+                delete(d.senders, key)
+                return FloodRepeat
+        }
+        return FloodNone
+}
+
+// evict drops the senders idle for longer than any chat's window and, if
+// that freed nothing, the one seen least recently. Callers hold d.mu.
+func (d *FloodDetector) evict(now time.Time) {
+        idle := models.MaxFloodWindow * time.Second
+
+        var oldestKey floodKey
+        var oldest time.Time
+        for key, state := range d.senders {
+                if now.Sub(state.lastSeen) >= idle {
+                        delete(d.senders, key)
+                        continue
+                }
+                if oldest.IsZero() || state.lastSeen.Before(oldest) {
+                        oldestKey, oldest = key, state.lastSeen
+                }
+        }
+
+        if len(d.senders) >= d.maxSenders {
+                delete(d.senders, oldestKey)
+        }
+}
+
+func contentHash(content string) uint64 {
+        h := fnv.New64a()
+        h.Write([]byte(content))
+        return h.Sum64()
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"telegram-subscription-bot/models"
+)
+
+// floodMessage is one message sent at a time after the test's start
+type floodMessage struct {
+	chatID  int64
+	userID  int64
+	content string
+	at      time.Duration
+	want    string
+}
+
+func TestFloodDetector(t *testing.T) {
+	// 3 messages within 10 seconds pass, a fourth is a burst; 3 identical
+	// messages in a row are a repeat
+	settings := &models.ModerationSettings{FloodMessages: 3, FloodWindow: 10, FloodRepeats: 3}
+	noRepeats := &models.ModerationSettings{FloodMessages: 3, FloodWindow: 10}
+
+	tests := []struct {
+		name     string
+		settings *models.ModerationSettings
+		messages []floodMessage
+	}{
+		{
+			name:     "within the limit",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{1, 1, "b", time.Second, FloodNone},
+				{1, 1, "c", 2 * time.Second, FloodNone},
+			},
+		},
+		{
+			name:     "burst",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{1, 1, "b", time.Second, FloodNone},
+				{1, 1, "c", 2 * time.Second, FloodNone},
+				{1, 1, "d", 3 * time.Second, FloodBurst},
+			},
+		},
+		{
+			name:     "window slides past old messages",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{1, 1, "b", 5 * time.Second, FloodNone},
+				{1, 1, "c", 9 * time.Second, FloodNone},
+				{1, 1, "d", 10 * time.Second, FloodNone}, // the first one left the window
+				{1, 1, "e", 15 * time.Second, FloodNone}, // and the second
+				{1, 1, "f", 16 * time.Second, FloodBurst},
+			},
+		},
+		{
+			name:     "history starts over after a burst",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{1, 1, "b", 0, FloodNone},
+				{1, 1, "c", 0, FloodNone},
+				{1, 1, "d", 0, FloodBurst},
+				{1, 1, "e", time.Second, FloodNone},
+				{1, 1, "f", time.Second, FloodNone},
+				{1, 1, "g", time.Second, FloodNone},
+				{1, 1, "h", time.Second, FloodBurst},
+			},
+		},
+		{
+			name:     "counted per user",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{1, 2, "b", 0, FloodNone},
+				{1, 1, "c", time.Second, FloodNone},
+				{1, 2, "d", time.Second, FloodNone},
+				{1, 1, "e", 2 * time.Second, FloodNone},
+				{1, 2, "f", 2 * time.Second, FloodNone},
+				{1, 1, "g", 3 * time.Second, FloodBurst},
+			},
+		},
+		{
+			name:     "counted per chat",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "a", 0, FloodNone},
+				{2, 1, "b", 0, FloodNone},
+				{1, 1, "c", time.Second, FloodNone},
+				{2, 1, "d", time.Second, FloodNone},
+				{1, 1, "e", 2 * time.Second, FloodNone},
+				{2, 1, "f", 2 * time.Second, FloodNone},
+				{2, 1, "g", 3 * time.Second, FloodBurst},
+			},
+		},
+		{
+			name:     "repeat",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "same", 0, FloodNone},
+				{1, 1, "same", 4 * time.Second, FloodNone},
+				{1, 1, "same", 8 * time.Second, FloodRepeat},
+			},
+		},
+		{
+			name:     "repeat broken by another message",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "same", 0, FloodNone},
+				{1, 1, "same", 4 * time.Second, FloodNone},
+				{1, 1, "other", 8 * time.Second, FloodNone},
+				{1, 1, "same", 12 * time.Second, FloodNone},
+			},
+		},
+		{
+			name:     "repeats spread beyond the window",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "same", 0, FloodNone},
+				{1, 1, "same", 9 * time.Second, FloodNone},
+				{1, 1, "same", 19 * time.Second, FloodNone}, // starts counting again
+				{1, 1, "same", 25 * time.Second, FloodNone},
+				{1, 1, "same", 30 * time.Second, FloodRepeat},
+			},
+		},
+		{
+			name:     "repeats of other users do not add up",
+			settings: settings,
+			messages: []floodMessage{
+				{1, 1, "same", 0, FloodNone},
+				{1, 2, "same", 0, FloodNone},
+				{1, 3, "same", 0, FloodNone},
+			},
+		},
+		{
+			name:     "repeat check off",
+			settings: noRepeats,
+			messages: []floodMessage{
+				{1, 1, "same", 0, FloodNone},
+				{1, 1, "same", 4 * time.Second, FloodNone},
+				{1, 1, "same", 8 * time.Second, FloodNone},
+			},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFloodDetector()
+			for i, m := range tt.messages {
+				got := d.Check(m.chatID, m.userID, m.content, tt.settings, start.Add(m.at))
+				if got != m.want {
+					t.Errorf("message %d (chat %d, user %d at %s) = %q, want %q", i, m.chatID, m.userID, m.at, got, m.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFloodDetectorEviction(t *testing.T) {
+	settings := &models.ModerationSettings{FloodMessages: 2, FloodWindow: 10}
+	idle := models.MaxFloodWindow * time.Second
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		seen    []time.Duration // when senders 1, 2, ... last wrote
+		at      time.Duration   // when the new sender writes
+		wantOut []int64         // senders dropped to make room
+	}{
+		{"idle senders go", []time.Duration{0, time.Second, 2 * time.Second}, idle + time.Second, []int64{1, 2}},
+		{"all idle", []time.Duration{0, time.Second, 2 * time.Second}, idle + time.Hour, []int64{1, 2, 3}},
+		{"least recently seen when nobody is idle", []time.Duration{time.Second, 0, 2 * time.Second}, 3 * time.Second, []int64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFloodDetector()
+			d.maxSenders = len(tt.seen)
+			for i, at := range tt.seen {
+				d.Check(1, int64(i+1), fmt.Sprint(i), settings, start.Add(at))
+			}
+
+			d.Check(1, 100, "new", settings, start.Add(tt.at))
+			if _, ok := d.senders[floodKey{1, 100}]; !ok {
+				t.Fatal("the new sender is not tracked")
+			}
+			if len(d.senders) > d.maxSenders {
+				t.Errorf("%d senders tracked, want at most %d", len(d.senders), d.maxSenders)
+			}
+
+			dropped := make(map[int64]bool)
+			for _, userID := range tt.wantOut {
+				dropped[userID] = true
+			}
+			for i := range tt.seen {
+				userID := int64(i + 1)
+				if _, tracked := d.senders[floodKey{1, userID}]; tracked == dropped[userID] {
+					t.Errorf("sender %d tracked = %v, want %v", userID, tracked, !dropped[userID])
+				}
+			}
+		})
+	}
+}
+
+func TestFloodDetectorEvictionKeepsHistory(t *testing.T) {
+	// A sender that stays tracked keeps counting towards a burst while others
+	// are evicted around them
+	settings := &models.ModerationSettings{FloodMessages: 2, FloodWindow: 10}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d := NewFloodDetector()
+	d.maxSenders = 2
+	d.Check(1, 1, "a", settings, start)
+	d.Check(1, 2, "b", settings, start.Add(-time.Second))
+	d.Check(1, 1, "c", settings, start.Add(time.Second))
+	d.Check(1, 3, "d", settings, start.Add(2*time.Second)) // evicts sender 2
+
+	if got := d.Check(1, 1, "e", settings, start.Add(3*time.Second)); got != FloodBurst {
+		t.Errorf("third message of sender 1 = %q, want %q", got, FloodBurst)
+	}
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+        "errors"
+        "fmt"
+        "log"
+        "sync"
+
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/utils"
+)
+
+var ErrInvalidForbiddenWord = errors.New("invalid forbidden word")
+
+// ForbiddenWordService keeps the forbidden word lists compiled in memory: the
+// global list that applies to every chat and each chat's own list, built the
+// first time the chat is checked. Changes made through the service rebuild the
+// affected list at once.
+type ForbiddenWordService struct {
+        repo *models.ForbiddenWordRepository
+
+        mu     sync.RWMutex
+        global *utils.WordMatcher
+        chats  map[int64]*utils.WordMatcher
+}
+
+func NewForbiddenWordService(db *database.DB) *ForbiddenWordService {
+        return &ForbiddenWordService{
+                repo:  models.NewForbiddenWordRepository(db.DB),
+                chats: make(map[int64]*utils.WordMatcher),
+        }
+}
+
+// Match returns the entry of the global or the chat's list that the text breaks
+func (s *ForbiddenWordService) Match(chatID int64, text string) (string, bool) {
+        if text == "" {
+                return "", false
+        }
+        if word, ok := s.matcher(nil).Match(text); ok {
+                return word, true
+        }
+        return s.matcher(&chatID).Match(text)
+}
+
+// List returns the chat's entries, or the global ones when chatID is nil
+func (s *ForbiddenWordService) List(chatID *int64) ([]*models.ForbiddenWord, error) {
+        return s.repo.List(chatID)
+}
+
+func (s *ForbiddenWordService) Get(id int) (*models.ForbiddenWord, error) {
+        return s.repo.GetByID(id)
+}
+
+func (s *ForbiddenWordService) Create(word *models.ForbiddenWord) error {
+        if err := word.Validate(); err != nil {
+                return fmt.Errorf("%w: %v", ErrInvalidForbiddenWord, err)
+        }
+        if err := s.repo.Create(word); err != nil {
+                return err
+        }
+        s.rebuild(word.ChatID)
+        return nil
+}
+
+// Update saves the entry's word, match type and state; word.ChatID must be
+// the chat it already belongs to
+func (s *ForbiddenWordService) Update(word *models.ForbiddenWord) error {
+        if err := word.Validate(); err != nil {
+                return fmt.Errorf("%w: %v", ErrInvalidForbiddenWord, err)
+        }
+        if err := s.repo.Update(word); err != nil {
+                return err
+        }
+        s.rebuild(word.ChatID)
+        return nil
+}
+
+func (s *ForbiddenWordService) Delete(word *models.ForbiddenWord) error {
+        if err := s.repo.Delete(word.ID); err != nil {
+                return err
+        }
+        s.rebuild(word.ChatID)
+        return nil
+}
+
+// matcher returns the compiled list, loading it on first use. A list that
+// fails to load is not cached and is tried again with the next message.
+func (s *ForbiddenWordService) matcher(chatID *int64) *utils.WordMatcher {
+        s.mu.RLock()
+        var m *utils.WordMatcher
+        if chatID == nil {
+                m = s.global
+        } else {
+                m = s.chats[*chatID]
+        }
+        s.mu.RUnlock()
+
+        if m != nil {
+                return m
+        }
+        return s.rebuild(chatID)
+}
+
+func (s *ForbiddenWordService) rebuild(chatID *int64) *utils.WordMatcher {
+        words, err := s.repo.ListActive(chatID)
+        if err != nil {
+                log.Printf("Failed to load forbidden words of %s: %v", scopeName(chatID), err)
+                // Drop what may now be stale so the next message loads it again
+                s.store(chatID, nil)
+                return nil
+        }
+
+        rules := make([]utils.WordRule, 0, len(words))
+        for _, w := range words {
+                rules = append(rules, utils.WordRule{
+                        Pattern:   w.Word,
+                        WholeWord: w.MatchType == models.MatchWord,
+                        Regex:     w.MatchType == models.MatchRegex,
+                })
+        }
+
+        m, errs := utils.NewWordMatcher(rules)
+        for _, err := range errs {
+                log.Printf("Skipping forbidden word of %s: %v", scopeName(chatID), err)
+        }
+
+        s.store(chatID, m)
+        return m
+}
+
+func (s *ForbiddenWordService) store(chatID *int64, m *utils.WordMatcher) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        switch {
+        case chatID == nil:
+                s.global = m
+        case m == nil:
+                delete(s.chats, *chatID)
+        default:
+                s.chats[*chatID] = m
+        }
+}
+
+func scopeName(chatID *int64) string {
+        if chatID == nil {
+                return "all chats"
+        }
+        return fmt.Sprintf("chat %d", *chatID)
+}
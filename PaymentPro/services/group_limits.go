@@ -0,0 +1,56 @@
+package services
+
+import (
+	"log"
+
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/models"
+)
+
+// GroupLimitService keeps owners within the max_groups of their plan, or
+// FREE_GROUP_LIMIT without a paid plan. Adding a group beyond the limit is
+// refused; after a downgrade the newest groups beyond it are suspended until
+// the owner upgrades or removes others. Suspended groups lose their owner's
+// entitlements; see EntitlementService.ForChat.
+type GroupLimitService struct {
+	repo         *models.UserGroupRepository
+	entitlements *EntitlementService
+	freeLimit    int
+}
+
+func NewGroupLimitService(db *database.DB, entitlements *EntitlementService, freeLimit int) *GroupLimitService {
+	return &GroupLimitService{
+		repo:         models.NewUserGroupRepository(db.DB),
+		entitlements: entitlements,
+		freeLimit:    freeLimit,
+	}
+}
+
+// Add registers the group; it returns models.ErrGroupLimitReached when the
+// owner has no room left
+func (s *GroupLimitService) Add(group *models.UserGroup) error {
+	if err := s.repo.Add(group, s.freeLimit); err != nil {
+		return err
+	}
+	s.entitlements.InvalidateChat(group.ChatID)
+	return nil
+}
+
+// Enforce brings the owner's groups in line with their current plan, or all
+// owners' with userID 0
+func (s *GroupLimitService) Enforce(userID int) error {
+	changed, err := s.repo.Enforce(userID, s.freeLimit)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range changed {
+		s.entitlements.InvalidateChat(group.ChatID)
+		if group.SuspendedAt != nil {
+			log.Printf("Suspended group %d of user %d: over the plan's group limit", group.ChatID, group.UserID)
+		} else {
+			log.Printf("Restored group %d of user %d", group.ChatID, group.UserID)
+		}
+	}
+	return nil
+}
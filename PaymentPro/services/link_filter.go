@@ -0,0 +1,189 @@
+package services
+
+import (
+        "errors"
+        "fmt"
+        "log"
+        "sync"
+        "time"
+
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/utils"
+)
+
+var ErrInvalidLinkRule = errors.New("invalid link rule")
+
+// What LinkFilterService.Check found
+const (
+        LinkDenied   = "denied"   // on the deny list
+        LinkUnlisted = "unlisted" // not on the allow list while only listed links are allowed
+        LinkInvite   = "invite"   // invite to another Telegram chat
+        LinkForward  = "forward"  // post forwarded from a channel
+)
+
+// linkRulesTTL bounds how long rules changed outside this process take to
+// reach the bot
+const linkRulesTTL = 5 * time.Minute
+
+// LinkContent is what a message points to
+type LinkContent struct {
+        URLs             []string // links from url and text_link entities
+        Mentions         []string // @usernames from mention entities
+        ForwardedChannel bool     // the message is a post forwarded from a channel
+        ChannelUsername  string   // that channel's username, if it has one
+}
+
+type LinkViolation struct {
+        Kind  string
+        Value string // the offending link, mention or channel
+}
+
+type cachedLinkRules struct {
+        rules    []*models.LinkRule
+        loadedAt time.Time
+}
+
+// LinkFilterService checks links, mentions and forwards against each chat's
+// allow and deny lists. The most specific matching rule wins, so a chat can
+// deny a domain and still allow one of its subdomains.
+type LinkFilterService struct {
+        repo *models.LinkRuleRepository
+
+        mu    sync.Mutex
+        cache map[int64]cachedLinkRules
+}
+
+func NewLinkFilterService(db *database.DB) *LinkFilterService {
+        return &LinkFilterService{
+                repo:  models.NewLinkRuleRepository(db.DB),
+                cache: make(map[int64]cachedLinkRules),
+        }
+}
+
+// Check returns the first thing in the message the chat's settings forbid, nil if none
+func (s *LinkFilterService) Check(chatID int64, settings *models.ModerationSettings, content LinkContent) *LinkViolation {
+        if !settings.CheckLinks {
+                return nil
+        }
+        rules := s.rules(chatID)
+
+        for _, raw := range content.URLs {
+                target, ok := utils.ParseLinkTarget(raw)
+                if !ok {
+                        continue
+                }
+                action := bestLinkRule(rules, func(pattern string) bool {
+                        return utils.MatchLinkPattern(pattern, target)
+                })
+                switch {
+                case action == models.LinkDeny:
+                        return &LinkViolation{Kind: LinkDenied, Value: raw}
+                case action == models.LinkAllow:
+                        continue
+                case settings.BlockInvites && target.IsTelegramInvite():
+                        return &LinkViolation{Kind: LinkInvite, Value: raw}
+                case settings.BlockAllLinks:
+                        return &LinkViolation{Kind: LinkUnlisted, Value: raw}
+                }
+        }
+
+        for _, mention := range content.Mentions {
+                action := bestLinkRule(rules, func(pattern string) bool {
+                        return utils.MatchUsernamePattern(pattern, mention)
+                })
+                if action == models.LinkDeny {
+                        return &LinkViolation{Kind: LinkDenied, Value: mention}
+                }
+        }
+
+        if content.ForwardedChannel {
+                action := bestLinkRule(rules, func(pattern string) bool {
+                        return utils.MatchUsernamePattern(pattern, content.ChannelUsername)
+                })
+                if action == models.LinkDeny || (settings.BlockForwards && action != models.LinkAllow) {
+                        return &LinkViolation{Kind: LinkForward, Value: content.ChannelUsername}
+                }
+        }
+
+        return nil
+}
+
+// bestLinkRule returns the action of the longest pattern that matches, "" if none does
+func bestLinkRule(rules []*models.LinkRule, matches func(pattern string) bool) string {
+        var best *models.LinkRule
+        for _, rule := range rules {
+                if (best == nil || len(rule.Pattern) > len(best.Pattern)) && matches(rule.Pattern) {
+                        best = rule
+                }
+        }
+        if best == nil {
+                return ""
+        }
+        return best.Action
+}
+
+func (s *LinkFilterService) List(chatID int64) ([]*models.LinkRule, error) {
+        return s.repo.List(chatID)
+}
+
+func (s *LinkFilterService) Get(id int) (*models.LinkRule, error) {
+        return s.repo.GetByID(id)
+}
+
+// Save normalizes, validates and stores the rule
+func (s *LinkFilterService) Save(rule *models.LinkRule) error {
+        rule.Pattern = utils.NormalizeLinkPattern(rule.Pattern)
+        if err := rule.Validate(); err != nil {
+                return fmt.Errorf("%w: %v", ErrInvalidLinkRule, err)
+        }
+        if err := s.repo.Save(rule); err != nil {
+                return err
+        }
+        s.forget(rule.ChatID)
+        return nil
+}
+
+func (s *LinkFilterService) Delete(rule *models.LinkRule) error {
+        if err := s.repo.Delete(rule.ID); err != nil {
+                return err
+        }
+        s.forget(rule.ChatID)
+        return nil
+}
+
+// DeletePattern removes the chat's rule for the pattern, sql.ErrNoRows if it has none
+func (s *LinkFilterService) DeletePattern(chatID int64, pattern string) error {
+        if err := s.repo.DeleteByPattern(chatID, utils.NormalizeLinkPattern(pattern)); err != nil {
+                return err
+        }
+        s.forget(chatID)
+        return nil
+}
+
+func (s *LinkFilterService) rules(chatID int64) []*models.LinkRule {
+        s.mu.Lock()
+        cached, ok := s.cache[chatID]
+        s.mu.Unlock()
+
+        if ok && time.Since(cached.loadedAt) < linkRulesTTL {
+                return cached.rules
+        }
+
+        rules, err := s.repo.List(chatID)
+        if err != nil {
+                log.Printf("Failed to load link rules of chat %d: %v", chatID, err)
+                return cached.rules
+        }
+
+        s.mu.Lock()
+        s.cache[chatID] = cachedLinkRules{rules: rules, loadedAt: time.Now()}
+        s.mu.Unlock()
+        return rules
+}
+
+func (s *LinkFilterService) forget(chatID int64) {
+        s.mu.Lock()
+        delete(s.cache, chatID)
+        s.mu.Unlock()
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+        "database/sql"
+        "errors"
+        "fmt"
+        "time"
+
+        tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+)
+
+var (
+        ErrInvalidModerationAction = errors.New("invalid moderation action")
+        // ErrTelegramRefused wraps the Bot API error when Telegram did not
+        // carry out an action, e.g. because the bot is not an admin
+        ErrTelegramRefused = errors.New("telegram refused the action")
+)
+
+// maxSanctionDuration is the longest mute or ban Telegram ends by itself;
+// longer ones are permanent
+const maxSanctionDuration = 366 * 24 * time.Hour
+
+// ModerationRequest says whom to act against in which chat and why
+type ModerationRequest struct {
+        ChatID      int64
+        User        *models.User  // needs TelegramID; a user the bot has not seen yet is created
+        Reason      string
+        MessageText string        // the offending message, if any
+        Duration    time.Duration // how long a mute or ban lasts; a ban without one is permanent
+        MediaOnly   bool          // a mute that still lets the member send text
+}
+
+// ModerationService carries out warnings, mutes, bans, unbans and message
+// deletion. Each sanction is applied in Telegram first and recorded in
+// user_violations only once Telegram accepted it, so the bot and the
+// dashboard always see the same state.
+type ModerationService struct {
+        bot       *tgbotapi.BotAPI
+        userRepo  *models.UserRepository
+        sanctions *models.SanctionRepository
+}
+
+func NewModerationService(bot *tgbotapi.BotAPI, db *database.DB) *ModerationService {
+        return &ModerationService{
+                bot:       bot,
+                userRepo:  models.NewUserRepository(db.DB),
+                sanctions: models.NewSanctionRepository(db.DB),
+        }
+}
+
+// Apply carries out a step of a chat's sanction ladder
+func (s *ModerationService) Apply(req ModerationRequest, step models.SanctionStep) (*models.Sanction, error) {
+        req.Duration = step.Duration()
+        switch step.Action {
+        case models.SanctionMute:
+                return s.Mute(req)
+        case models.SanctionMedia:
+                req.MediaOnly = true
+                return s.Mute(req)
+        case models.SanctionBan:
+                return s.Ban(req)
+        }
+        return s.Warn(req)
+}
+
+// Warn records a warning; Telegram has nothing to enforce
+func (s *ModerationService) Warn(req ModerationRequest) (*models.Sanction, error) {
+        if err := validateModerationRequest(req); err != nil {
+                return nil, err
+        }
+        return s.record(req, models.ViolationWarning, nil)
+}
+
+// Mute takes away the member's right to send anything, or everything but
+// text with MediaOnly, until the duration is over
+func (s *ModerationService) Mute(req ModerationRequest) (*models.Sanction, error) {
+        if err := validateModerationRequest(req); err != nil {
+                return nil, err
+        }
+        if req.Duration < time.Minute || req.Duration > maxSanctionDuration {
+                return nil, fmt.Errorf("%w: a mute lasts from a minute to 366 days", ErrInvalidModerationAction)
+        }
+
+        until := time.Now().Add(req.Duration)
+        permissions := tgbotapi.ChatPermissions{CanSendMessages: req.MediaOnly}
+        restrict := tgbotapi.RestrictChatMemberConfig{
+                ChatMemberConfig: tgbotapi.ChatMemberConfig{
+                        ChatID: req.ChatID,
+                        UserID: req.User.TelegramID,
+                },
+                UntilDate:   until.Unix(),
+                Permissions: &permissions,
+        }
+        if _, err := s.bot.Request(restrict); err != nil {
+                return nil, fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+        }
+
+        violationType := models.ViolationMute
+        if req.MediaOnly {
+                violationType = models.ViolationMedia
+        }
+        return s.record(req, violationType, &until)
+}
+
+// Ban removes the member from the chat for the duration, or for good
+func (s *ModerationService) Ban(req ModerationRequest) (*models.Sanction, error) {
+        if err := validateModerationRequest(req); err != nil {
+                return nil, err
+        }
+        if req.Duration != 0 && (req.Duration < time.Minute || req.Duration > maxSanctionDuration) {
+                return nil, fmt.Errorf("%w: a temporary ban lasts from a minute to 366 days", ErrInvalidModerationAction)
+        }
+
+        ban := tgbotapi.BanChatMemberConfig{
+                ChatMemberConfig: tgbotapi.ChatMemberConfig{
+                        ChatID: req.ChatID,
+                        UserID: req.User.TelegramID,
+                },
+        }
+        var expiresAt *time.Time
+        if req.Duration > 0 {
+                until := time.Now().Add(req.Duration)
+                ban.UntilDate = until.Unix()
+                expiresAt = &until
+        }
+        if _, err := s.bot.Request(ban); err != nil {
+                return nil, fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+        }
+
+        if expiresAt == nil {
+                return s.record(req, models.ViolationPermanentBan, nil)
+        }
+        return s.record(req, models.ViolationTempBan, expiresAt)
+}
+
+// Unban lifts the member's ban or restrictions in the chat and pardons their
+// violations there, so the sanction ladder starts over
+func (s *ModerationService) Unban(chatID, telegramUserID int64) error {
+        member, err := s.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+                ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+                        ChatID: chatID,
+                        UserID: telegramUserID,
+                },
+        })
+        if err != nil {
+                return fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+        }
+
+        switch member.Status {
+        case "kicked":
+                unban := tgbotapi.UnbanChatMemberConfig{
+                        ChatMemberConfig: tgbotapi.ChatMemberConfig{
+                                ChatID: chatID,
+                                UserID: telegramUserID,
+                        },
+                        OnlyIfBanned: true,
+                }
+                if _, err := s.bot.Request(unban); err != nil {
+                        return fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+                }
+        case "restricted":
+                if err := s.restoreRights(chatID, telegramUserID); err != nil {
+                        return err
+                }
+        }
+
+        return s.sanctions.Pardon(chatID, telegramUserID)
+}
+
+// Purge deletes messages from the chat; it tries all of them and returns the
+// first failure
+func (s *ModerationService) Purge(chatID int64, messageIDs ...int) error {
+        var first error
+        for _, messageID := range messageIDs {
+                if _, err := s.bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil && first == nil {
+                        first = fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+                }
+        }
+        return first
+}
+
+// GetSanction returns a recorded violation, sql.ErrNoRows if there is none
+func (s *ModerationService) GetSanction(id int) (*models.Sanction, error) {
+        return s.sanctions.GetByID(id)
+}
+
+// restoreRights gives a restricted member the rights everyone has in the chat
+func (s *ModerationService) restoreRights(chatID, telegramUserID int64) error {
+        chat, err := s.bot.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+        if err != nil {
+                return fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+        }
+        permissions := chat.Permissions
+        if permissions == nil {
+                permissions = &tgbotapi.ChatPermissions{
+                        CanSendMessages:       true,
+                        CanSendMediaMessages:  true,
+                        CanSendPolls:          true,
+                        CanSendOtherMessages:  true,
+                        CanAddWebPagePreviews: true,
+                }
+        }
+
+        restrict := tgbotapi.RestrictChatMemberConfig{
+                ChatMemberConfig: tgbotapi.ChatMemberConfig{
+                        ChatID: chatID,
+                        UserID: telegramUserID,
+                },
+                Permissions: permissions,
+        }
+        if _, err := s.bot.Request(restrict); err != nil {
+                return fmt.Errorf("%w: %v", ErrTelegramRefused, err)
+        }
+        return nil
+}
+
+// record writes down the sanction Telegram already applied. Its error reaches
+// the caller, since Telegram keeps the sanction either way.
+func (s *ModerationService) record(req ModerationRequest, violationType string, expiresAt *time.Time) (*models.Sanction, error) {
+        if req.User.ID == 0 {
+                if err := s.resolveUser(req.User); err != nil {
+                        return nil, fmt.Errorf("record %s: %w", violationType, err)
+                }
+        }
+
+        id, err := s.sanctions.Record(req.User.ID, req.ChatID, violationType, req.Reason, req.MessageText, expiresAt)
+        if err != nil {
+                return nil, fmt.Errorf("record %s: %w", violationType, err)
+        }
+        return &models.Sanction{
+                ID:             id,
+                ChatID:         req.ChatID,
+                TelegramUserID: req.User.TelegramID,
+                Type:           violationType,
+                ExpiresAt:      expiresAt,
+        }, nil
+}
+
+// resolveUser finds the member's row in users, creating it if the bot has not
+// seen them yet
+func (s *ModerationService) resolveUser(user *models.User) error {
+        existing, err := s.userRepo.GetByTelegramID(user.TelegramID)
+        if err == nil {
+                user.ID = existing.ID
+                return nil
+        }
+        if !errors.Is(err, sql.ErrNoRows) {
+                return err
+        }
+        return s.userRepo.CreateOrUpdate(user)
+}
+
+func validateModerationRequest(req ModerationRequest) error {
+        if req.ChatID == 0 {
+                return fmt.Errorf("%w: chat_id is required", ErrInvalidModerationAction)
+        }
+        if req.User == nil || req.User.TelegramID == 0 {
+                return fmt.Errorf("%w: the member's Telegram ID is required", ErrInvalidModerationAction)
+        }
+        return nil
+}
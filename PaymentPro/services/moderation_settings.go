@@ -0,0 +1,90 @@
+package services
+
+import (
+        "database/sql"
+        "errors"
+        "fmt"
+        "log"
+        "sync"
+        "time"
+
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+)
+
+var ErrInvalidModerationSettings = errors.New("invalid moderation settings")
+
+// moderationSettingsTTL bounds how long settings changed outside this process
+// take to reach the bot
+const moderationSettingsTTL = 5 * time.Minute
+
+type cachedModerationSettings struct {
+        settings *models.ModerationSettings
+        loadedAt time.Time
+}
+
+// ModerationSettingsService hands out the moderation settings of each chat.
+// The bot reads them for every group message, so they are cached in memory;
+// changes saved through Update, from the bot or the dashboard, apply at once.
+type ModerationSettingsService struct {
+        repo *models.ModerationSettingsRepository
+
+        mu    sync.Mutex
+        cache map[int64]cachedModerationSettings
+}
+
+func NewModerationSettingsService(db *database.DB) *ModerationSettingsService {
+        return &ModerationSettingsService{
+                repo:  models.NewModerationSettingsRepository(db.DB),
+                cache: make(map[int64]cachedModerationSettings),
+        }
+}
+
+// Get returns a copy of the chat's settings, the defaults if it has none
+func (s *ModerationSettingsService) Get(chatID int64) *models.ModerationSettings {
+        s.mu.Lock()
+        cached, ok := s.cache[chatID]
+        s.mu.Unlock()
+
+        if ok && time.Since(cached.loadedAt) < moderationSettingsTTL {
+                copied := *cached.settings
+                return &copied
+        }
+
+        settings, err := s.repo.Get(chatID)
+        if errors.Is(err, sql.ErrNoRows) {
+                settings = models.DefaultModerationSettings(chatID)
+        } else if err != nil {
+                log.Printf("Failed to load moderation settings of chat %d: %v", chatID, err)
+                // Keep moderating with what we had rather than with the defaults
+                if ok {
+                        copied := *cached.settings
+                        return &copied
+                }
+                return models.DefaultModerationSettings(chatID)
+        }
+
+        s.remember(settings)
+        copied := *settings
+        return &copied
+}
+
+// Update validates and stores the chat's settings
+func (s *ModerationSettingsService) Update(settings *models.ModerationSettings) error {
+        if err := settings.Validate(); err != nil {
+                return fmt.Errorf("%w: %v", ErrInvalidModerationSettings, err)
+        }
+        if err := s.repo.Save(settings); err != nil {
+                return err
+        }
+
+        copied := *settings
+        s.remember(&copied)
+        return nil
+}
+
+func (s *ModerationSettingsService) remember(settings *models.ModerationSettings) {
+        s.mu.Lock()
+        s.cache[settings.ChatID] = cachedModerationSettings{settings: settings, loadedAt: time.Now()}
+        s.mu.Unlock()
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+        "errors"
+        "log"
+        "sync"
+        "time"
+
+        tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+)
+
+// memberStatusTTL is how long a member's admin status is trusted before the
+// bot asks Telegram again; promotions and demotions take this long to apply
+const memberStatusTTL = time.Minute
+
+// moderatorsTTL bounds how long moderator lists changed outside this process
+// take to reach the bot
+const moderatorsTTL = 5 * time.Minute
+
+// ErrBotNotInChat is returned for a chat the bot is not a member of
+var ErrBotNotInChat = errors.New("the bot is not a member of the chat")
+
+// ErrNotChatAdmin is returned when a user is neither the chat's creator nor an administrator
+var ErrNotChatAdmin = errors.New("not an administrator of the chat")
+
+type memberKey struct {
+        chatID int64
+        userID int64
+}
+
+type cachedStatus struct {
+        admin     bool
+        checkedAt time.Time
+}
+
+type cachedModerators struct {
+        ids      map[int64]bool
+        loadedAt time.Time
+}
+
+// ModeratorService decides who may use the bot's moderation commands in a
+// group: its Telegram creator and administrators, and the bot moderators its
+// admins added
+type ModeratorService struct {
+        bot  *tgbotapi.BotAPI
+        repo *models.GroupModeratorRepository
+
+        mu         sync.Mutex
+        statuses   map[memberKey]cachedStatus
+        moderators map[int64]cachedModerators
+}
+
+func NewModeratorService(bot *tgbotapi.BotAPI, db *database.DB) *ModeratorService {
+        return &ModeratorService{
+                bot:        bot,
+                repo:       models.NewGroupModeratorRepository(db.DB),
+                statuses:   make(map[memberKey]cachedStatus),
+                moderators: make(map[int64]cachedModerators),
+        }
+}
+
+// IsAdmin reports whether the member is the chat's creator or an administrator
+func (s *ModeratorService) IsAdmin(chatID, userID int64) bool {
+        key := memberKey{chatID: chatID, userID: userID}
+
+        s.mu.Lock()
+        cached, ok := s.statuses[key]
+        s.mu.Unlock()
+        if ok && time.Since(cached.checkedAt) < memberStatusTTL {
+                return cached.admin
+        }
+
+        member, err := s.chatMember(chatID, userID)
+        if err != nil {
+                log.Printf("Error checking chat member %d in chat %d: %v", userID, chatID, err)
+                return false
+        }
+
+        admin := member.IsCreator() || member.IsAdministrator()
+        s.mu.Lock()
+        s.statuses[key] = cachedStatus{admin: admin, checkedAt: time.Now()}
+        s.mu.Unlock()
+        return admin
+}
+
+// VerifyAdmin asks Telegram, bypassing the cache, whether the bot is in the
+// chat and the user administers it. Check it before trusting a chat ID
+// someone typed in: it returns ErrBotNotInChat or ErrNotChatAdmin when not.
+func (s *ModeratorService) VerifyAdmin(chatID, userID int64) error {
+        bot, err := s.chatMember(chatID, s.bot.Self.ID)
+        var apiErr *tgbotapi.Error
+        if errors.As(err, &apiErr) || (err == nil && (bot.HasLeft() || bot.WasKicked())) {
+                return ErrBotNotInChat
+        }
+        if err != nil {
+                return err
+        }
+
+        member, err := s.chatMember(chatID, userID)
+        if errors.As(err, &apiErr) {
+                return ErrNotChatAdmin
+        }
+        if err != nil {
+                return err
+        }
+
+        admin := member.IsCreator() || member.IsAdministrator()
+        s.mu.Lock()
+        s.statuses[memberKey{chatID: chatID, userID: userID}] = cachedStatus{admin: admin, checkedAt: time.Now()}
+        s.mu.Unlock()
+        if !admin {
+                return ErrNotChatAdmin
+        }
+        return nil
+}
+
+func (s *ModeratorService) chatMember(chatID, userID int64) (tgbotapi.ChatMember, error) {
+        return s.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+                ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+                        ChatID: chatID,
+                        UserID: userID,
+                },
+        })
+}
+
+// IsModerator reports whether the member is an admin or a bot moderator of the chat
+func (s *ModeratorService) IsModerator(chatID, userID int64) bool {
+        return s.moderatorIDs(chatID)[userID] || s.IsAdmin(chatID, userID)
+}
+
+func (s *ModeratorService) List(chatID int64) ([]*models.GroupModerator, error) {
+        return s.repo.List(chatID)
+}
+
+func (s *ModeratorService) Add(moderator *models.GroupModerator) error {
+        if err := s.repo.Add(moderator); err != nil {
+                return err
+        }
+        s.forget(moderator.ChatID)
+        return nil
+}
+
+// Remove returns sql.ErrNoRows if the member was not a moderator of the chat
+func (s *ModeratorService) Remove(chatID, telegramUserID int64) error {
+        if err := s.repo.Remove(chatID, telegramUserID); err != nil {
+                return err
+        }
+        s.forget(chatID)
+        return nil
+}
+
+func (s *ModeratorService) moderatorIDs(chatID int64) map[int64]bool {
+        s.mu.Lock()
+        cached, ok := s.moderators[chatID]
+        s.mu.Unlock()
+        if ok && time.Since(cached.loadedAt) < moderatorsTTL {
+                return cached.ids
+        }
+
+        moderators, err := s.repo.List(chatID)
+        if err != nil {
+                log.Printf("Failed to load moderators of chat %d: %v", chatID, err)
+                return cached.ids
+        }
+
+        ids := make(map[int64]bool, len(moderators))
+        for _, moderator := range moderators {
+                ids[moderator.TelegramUserID] = true
+        }
+
+        s.mu.Lock()
+        s.moderators[chatID] = cachedModerators{ids: ids, loadedAt: time.Now()}
+        s.mu.Unlock()
+        return ids
+}
+
+func (s *ModeratorService) forget(chatID int64) {
+        s.mu.Lock()
+        delete(s.moderators, chatID)
+        s.mu.Unlock()
+}
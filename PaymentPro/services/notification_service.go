@@ -16,16 +16,18 @@ type NotificationService struct {
 	db                  *database.DB
 	subscriptionService *SubscriptionService
 	userRepo            *models.UserRepository
+	planRepo            *models.SubscriptionRepository
 	ticker              *time.Ticker
 	stopChan            chan bool
 }
 
-func NewNotificationService(bot *tgbotapi.BotAPI, db *database.DB) *NotificationService {
+func NewNotificationService(bot *tgbotapi.BotAPI, db *database.DB, subscriptionService *SubscriptionService) *NotificationService {
 	return &NotificationService{
 		bot:                 bot,
 		db:                  db,
-		subscriptionService: NewSubscriptionService(db),
+		subscriptionService: subscriptionService,
 		userRepo:            models.NewUserRepository(db.DB),
+		planRepo:            models.NewSubscriptionRepository(db.DB),
 		ticker:              time.NewTicker(1 * time.Hour), // Check every hour
 		stopChan:            make(chan bool),
 	}
@@ -61,26 +63,41 @@ func (s *NotificationService) processNotifications() {
 }
 
 func (s *NotificationService) processExpiredSubscriptions() {
-	expiredUsers, err := s.subscriptionService.GetExpiredUsers()
+	advanced, err := s.subscriptionService.AdvanceSubscriptions()
 	if err != nil {
-		log.Printf("Error getting expired users: %v", err)
+		log.Printf("Error advancing subscriptions: %v", err)
 		return
 	}
 
-	for _, user := range expiredUsers {
-		// Reset to free plan
-		err = s.userRepo.UpdateSubscription(user.ID, 1, nil)
+	for _, sub := range advanced {
+		user, err := s.userRepo.GetByID(sub.UserID)
 		if err != nil {
-			log.Printf("Error resetting user %d to free plan: %v", user.ID, err)
+			log.Printf("Error loading user %d: %v", sub.UserID, err)
+			continue
+		}
+		plan, err := s.planRepo.GetByID(sub.PlanID)
+		if err != nil {
+			log.Printf("Error loading plan %d: %v", sub.PlanID, err)
+			continue
+		}
+
+		var message string
+		switch sub.Status {
+		case models.SubscriptionPastDue:
+			message = fmt.Sprintf(locales.GetMessage(user.LanguageCode, "subscription_past_due"), plan.Name)
+			message += "\n\n" + locales.GetMessage(user.LanguageCode, "renew_prompt")
+		case models.SubscriptionGrace:
+			message = fmt.Sprintf(locales.GetMessage(user.LanguageCode, "subscription_grace"), plan.Name)
+			message += "\n\n" + locales.GetMessage(user.LanguageCode, "renew_prompt")
+		case models.SubscriptionExpired:
+			message = locales.GetMessage(user.LanguageCode, "subscription_expired")
+			message += "\n\n" + locales.GetMessage(user.LanguageCode, "upgrade_prompt")
+		default:
 			continue
 		}
 
-		// Send notification
-		message := locales.GetMessage(user.LanguageCode, "subscription_expired")
-		message += "\n\n" + locales.GetMessage(user.LanguageCode, "upgrade_prompt")
-		
 		s.sendNotification(user.TelegramID, message)
-		s.logNotification(user.ID, "expired")
+		s.logNotification(user.ID, sub.Status)
 	}
 }
 
@@ -0,0 +1,220 @@
+package services
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "log"
+        "net/http"
+        "sync"
+        "time"
+
+        tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/models"
+)
+
+var (
+        ErrProviderNotAvailable    = errors.New("payment provider is not available")
+        ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+        ErrWebhooksNotSupported    = errors.New("provider does not receive webhooks")
+        ErrRefundNotSupported      = errors.New("provider does not support refunds")
+)
+
+// PaymentProvider is one payment backend. Each provider lives in its own file
+// and registers a factory under its payment_providers.name in init(); the
+// registry builds the providers whose rows are active.
+type PaymentProvider interface {
+        // Method is what gets stored in payments.payment_method
+        Method() string
+
+        // CreateCheckout asks the provider to collect req.Payment. The payment row
+        // already exists in the pending state.
+        CreateCheckout(req *CheckoutRequest) (*Checkout, error)
+
+        // ParseWebhook verifies and decodes a provider callback. Signature problems
+        // are reported as ErrInvalidWebhookSignature.
+        ParseWebhook(r *http.Request, payload []byte) (*WebhookEvent, error)
+
+        // Refund returns the money of a completed payment to the payer
+        Refund(payment *models.Payment) error
+
+        // FetchStatus asks the provider for the current status of the payment,
+        // using the same status names as the payments table.
+        FetchStatus(payment *models.Payment) (string, error)
+}
+
+type CheckoutRequest struct {
+        Payment *models.Payment
+        Plan    *models.SubscriptionPlan
+        User    *models.User
+        ChatID  int64  // chat for providers that deliver the invoice through Telegram
+        Asset   string // cryptocurrency picked by the user, if any
+}
+
+type Checkout struct {
+        TransactionID string
+        URL           string
+        // Data is stored in payments.payment_data and shown to the user
+        Data map[string]string
+        // Quote is set when the payment is priced in another currency than
+        // the plan's, at a rate that holds until the quote expires
+        Quote *Quote
+}
+
+// WebhookEvent is a provider callback reduced to what the payment flow needs.
+// Status is empty for events that don't change the payment.
+type WebhookEvent struct {
+        ID            string
+        Type          string
+        PaymentID     int64
+        TransactionID string
+        Status        string
+}
+
+type ProviderDeps struct {
+        Config           *config.Config
+        Bot              *tgbotapi.BotAPI
+        Rates            *RateService
+        DepositAddresses *DepositAddresses
+}
+
+type PaymentProviderFactory func(settings *models.PaymentProviderConfig, deps ProviderDeps) (PaymentProvider, error)
+
+var paymentProviderFactories = make(map[string]PaymentProviderFactory)
+
+// RegisterPaymentProvider makes a provider implementation available to rows of
+// payment_providers with the given name.
+func RegisterPaymentProvider(name string, factory PaymentProviderFactory) {
+        if _, exists := paymentProviderFactories[name]; exists {
+                panic(fmt.Sprintf("payment provider %s registered twice", name))
+        }
+        paymentProviderFactories[name] = factory
+}
+
+type PaymentProviderRegistry struct {
+        repo *models.PaymentProviderRepository
+        deps ProviderDeps
+
+        mu        sync.RWMutex
+        providers map[string]PaymentProvider
+        active    []*models.PaymentProviderConfig
+}
+
+func NewPaymentProviderRegistry(repo *models.PaymentProviderRepository, deps ProviderDeps) *PaymentProviderRegistry {
+        return &PaymentProviderRegistry{
+                repo:      repo,
+                deps:      deps,
+                providers: make(map[string]PaymentProvider),
+        }
+}
+
+// Load rebuilds the providers from payment_providers. Rows without an
+// implementation or with missing credentials are skipped so one misconfigured
+// provider doesn't take the others down.
+func (r *PaymentProviderRegistry) Load() error {
+        rows, err := r.repo.GetAll()
+        if err != nil {
+                return err
+        }
+
+        providers := make(map[string]PaymentProvider)
+        var active []*models.PaymentProviderConfig
+
+        for _, row := range rows {
+                if !row.IsActive {
+                        continue
+                }
+
+                factory, ok := paymentProviderFactories[row.Name]
+                if !ok {
+                        log.Printf("Payment provider %s has no implementation, skipping", row.Name)
+                        continue
+                }
+
+                provider, err := factory(row, r.deps)
+                if err != nil {
+                        log.Printf("Payment provider %s is not configured: %v", row.Name, err)
+                        continue
+                }
+
+                providers[row.Name] = provider
+                active = append(active, row)
+        }
+
+        r.mu.Lock()
+        r.providers = providers
+        r.active = active
+        r.mu.Unlock()
+
+        return nil
+}
+
+func (r *PaymentProviderRegistry) Get(name string) (PaymentProvider, error) {
+        r.mu.RLock()
+        defer r.mu.RUnlock()
+
+        provider, ok := r.providers[name]
+        if !ok {
+                return nil, fmt.Errorf("%w: %s", ErrProviderNotAvailable, name)
+        }
+        return provider, nil
+}
+
+// Active lists the usable providers in payment_providers order
+func (r *PaymentProviderRegistry) Active() []*models.PaymentProviderConfig {
+        r.mu.RLock()
+        defer r.mu.RUnlock()
+
+        return append([]*models.PaymentProviderConfig(nil), r.active...)
+}
+
+// configString reads a string option from payment_providers.configuration
+func configString(settings *models.PaymentProviderConfig, key, defaultValue string) string {
+        if value, ok := settings.Configuration[key].(string); ok && value != "" {
+                return value
+        }
+        return defaultValue
+}
+
+func configStrings(settings *models.PaymentProviderConfig, key string) []string {
+        items, _ := settings.Configuration[key].([]interface{})
+
+        var values []string
+        for _, item := range items {
+                if value, ok := item.(string); ok {
+                        values = append(values, value)
+                }
+        }
+        return values
+}
+
+var providerHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// doProviderRequest sends an API request and decodes the JSON answer into out.
+// Non-2xx answers become errors carrying the start of the response body.
+func doProviderRequest(req *http.Request, out interface{}) error {
+        resp, err := providerHTTPClient.Do(req)
+        if err != nil {
+                return err
+        }
+        defer resp.Body.Close()
+
+        body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+        if err != nil {
+                return err
+        }
+
+        if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+                if len(body) > 200 {
+                        body = body[:200]
+                }
+                return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, body)
+        }
+
+        if out == nil {
+                return nil
+        }
+        return json.Unmarshal(body, out)
+}
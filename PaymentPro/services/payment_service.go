@@ -1,208 +1,436 @@
 package services
 
 import (
-        "crypto/rand"
-        "encoding/hex"
+        "database/sql"
+        "errors"
         "fmt"
+        "log"
+        "net/http"
+        "strconv"
+        "strings"
         "time"
 
+        tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
         "telegram-subscription-bot/config"
         "telegram-subscription-bot/database"
         "telegram-subscription-bot/models"
         "telegram-subscription-bot/utils"
 )
 
+// errPaymentCompleted stops the activation for a payment that was already completed or refunded
+var errPaymentCompleted = errors.New("payment already completed")
+
+// errPaymentRefunded stops the refund of a payment that is no longer completed
+var errPaymentRefunded = errors.New("payment already refunded")
+
 type PaymentService struct {
-        db          *database.DB
-        config      *config.Config
-        paymentRepo *models.PaymentRepository
-        planRepo    *models.SubscriptionRepository
-        cryptoUtils *utils.CryptoUtils
+        db            *database.DB
+        config        *config.Config
+        paymentRepo   *models.PaymentRepository
+        planRepo      *models.SubscriptionRepository
+        userRepo      *models.UserRepository
+        providers     *PaymentProviderRegistry
+        explorers     map[string]utils.ChainExplorer
+        claims        transferClaims
+        rates         *RateService
+        chatAccess    *ChatAccessService
+        subscriptions *SubscriptionService
 }
 
-func NewPaymentService(db *database.DB, config *config.Config) *PaymentService {
+func NewPaymentService(db *database.DB, config *config.Config, bot *tgbotapi.BotAPI, subscriptions *SubscriptionService) *PaymentService {
+        paymentRepo := models.NewPaymentRepository(db.DB)
+        rates := NewRateService(db, newRateSource(config), config)
+        deps := ProviderDeps{
+                Config:           config,
+                Bot:              bot,
+                Rates:            rates,
+                DepositAddresses: NewDepositAddresses(config, paymentRepo),
+        }
+
         return &PaymentService{
-                db:          db,
-                config:      config,
-                paymentRepo: models.NewPaymentRepository(db.DB),
-                planRepo:    models.NewSubscriptionRepository(db.DB),
-                cryptoUtils: utils.NewCryptoUtils(),
+                db:            db,
+                config:        config,
+                paymentRepo:   paymentRepo,
+                planRepo:      models.NewSubscriptionRepository(db.DB),
+                userRepo:      models.NewUserRepository(db.DB),
+                providers:     NewPaymentProviderRegistry(models.NewPaymentProviderRepository(db.DB), deps),
+                explorers:     newChainExplorers(config),
+                claims:        paymentRepo,
+                rates:         rates,
+                chatAccess:    NewChatAccessService(bot, db),
+                subscriptions: subscriptions,
         }
 }
 
-func (s *PaymentService) GetProviderToken() string {
-        // Return appropriate provider token based on configuration
-        if s.config.StripeToken != "" {
-                return s.config.StripeToken
-        }
-        if s.config.YooMoneyToken != "" {
-                return s.config.YooMoneyToken
+// LoadProviders (re)builds the payment providers from the payment_providers table
+func (s *PaymentService) LoadProviders() error {
+        return s.providers.Load()
+}
+
+func (s *PaymentService) ActiveProviders() []*models.PaymentProviderConfig {
+        return s.providers.Active()
+}
+
+// ProviderAssets lists the currencies a payer can pick for providers that take
+// several (crypto); nil for the others.
+func (s *PaymentService) ProviderAssets(providerName string) []string {
+        provider, err := s.providers.Get(providerName)
+        if err != nil {
+                return nil
         }
-        if s.config.PayPalToken != "" {
-                return s.config.PayPalToken
+        if multiAsset, ok := provider.(interface{ Assets() []string }); ok {
+                return multiAsset.Assets()
         }
-        return ""
+        return nil
 }
 
-func (s *PaymentService) CreateCardPayment(userID int, planID int) (*models.Payment, error) {
+type CheckoutOptions struct {
+        ChatID int64
+        Asset  string
+}
+
+// CreateCheckout records a pending payment for the plan and hands it to the
+// provider. Every provider goes through here, so payments rows look the same
+// regardless of where the money comes from.
+func (s *PaymentService) CreateCheckout(user *models.User, planID int, providerName string, options CheckoutOptions) (*models.Payment, *Checkout, error) {
+        provider, err := s.providers.Get(providerName)
+        if err != nil {
+                return nil, nil, err
+        }
+
         plan, err := s.planRepo.GetByID(planID)
         if err != nil {
-                return nil, err
+                return nil, nil, err
+        }
+        if plan.PriceCents <= 0 {
+                return nil, nil, fmt.Errorf("plan %d is free", planID)
         }
 
         payment := &models.Payment{
-                UserID:          int64(userID),
-                PlanID:          int64(planID),
+                UserID:          int64(user.ID),
+                PlanID:          int64(plan.ID),
                 Amount:          plan.PriceCents,
                 Currency:        plan.Currency,
-                PaymentMethod:   "card",
-                PaymentProvider: "telegram",
+                PaymentMethod:   provider.Method(),
+                PaymentProvider: providerName,
                 Status:          "pending",
-                Description:     s.generateInvoicePayload(userID, planID),
+                Description:     plan.Name,
                 CreatedAt:       time.Now(),
                 UpdatedAt:       time.Now(),
         }
 
-        err = s.paymentRepo.Create(payment)
+        if err := s.paymentRepo.Create(payment); err != nil {
+                return nil, nil, err
+        }
+
+        checkout, err := provider.CreateCheckout(&CheckoutRequest{
+                Payment: payment,
+                Plan:    plan,
+                User:    user,
+                ChatID:  options.ChatID,
+                Asset:   options.Asset,
+        })
         if err != nil {
-                return nil, err
+                if statusErr := s.paymentRepo.UpdateStatus(payment.ID, "failed"); statusErr != nil {
+                        log.Printf("Failed to mark payment %d as failed: %v", payment.ID, statusErr)
+                }
+                return nil, nil, err
         }
 
-        return payment, nil
+        data := make(map[string]string)
+        for key, value := range checkout.Data {
+                data[key] = value
+        }
+        if checkout.URL != "" {
+                data["url"] = checkout.URL
+        }
+
+        if err := s.paymentRepo.AttachCheckout(payment.ID, checkout.TransactionID, data); err != nil {
+                return nil, nil, err
+        }
+        if checkout.Quote != nil {
+                if err := s.paymentRepo.SetQuote(payment.ID, checkout.Quote.Rate, checkout.Quote.ExpiresAt); err != nil {
+                        return nil, nil, err
+                }
+        }
+
+        payment.TransactionID = checkout.TransactionID
+        payment.PaymentData = data
+        payment.Status = "processing"
+
+        return payment, checkout, nil
 }
 
-func (s *PaymentService) CreateCryptoPayment(userID int, planID int, cryptoCurrency string) (*models.Payment, error) {
-        plan, err := s.planRepo.GetByID(planID)
+// ParseWebhook lets the named provider verify and decode a callback
+func (s *PaymentService) ParseWebhook(providerName string, r *http.Request, payload []byte) (*WebhookEvent, error) {
+        provider, err := s.providers.Get(providerName)
         if err != nil {
                 return nil, err
         }
+        return provider.ParseWebhook(r, payload)
+}
+
+// ApplyWebhookEvent moves the payment referenced by the event to the event's status
+func (s *PaymentService) ApplyWebhookEvent(providerName string, event *WebhookEvent) error {
+        if event.Status == "" {
+                return nil
+        }
 
-        // Get crypto rate and calculate amount
-        cryptoAmount, err := s.cryptoUtils.ConvertToCrypto(float64(plan.PriceCents)/100, plan.Currency, cryptoCurrency)
+        payment, err := s.findPayment(providerName, event.PaymentID, event.TransactionID)
         if err != nil {
-                return nil, err
+                return err
+        }
+        if payment.PaymentProvider != providerName {
+                return fmt.Errorf("payment %d belongs to %s, not %s", payment.ID, payment.PaymentProvider, providerName)
         }
 
-        // Get crypto address
-        cryptoAddress, err := s.getCryptoAddress(cryptoCurrency)
+        return s.applyStatus(payment, event.Status)
+}
+
+// VerifyPayment asks the provider for the payment's current status and applies
+// it; direct crypto deposits are looked up on chain instead. Expired deposits
+// are looked up again, so money that arrived late can still be accepted.
+func (s *PaymentService) VerifyPayment(paymentID int64) error {
+        payment, err := s.paymentRepo.GetByID(paymentID)
         if err != nil {
-                return nil, err
+                return err
         }
 
-        payment := &models.Payment{
-                UserID:          int64(userID),
-                PlanID:          int64(planID),
-                Amount:          plan.PriceCents,
-                Currency:        plan.Currency,
-                PaymentMethod:   "crypto",
-                PaymentProvider: cryptoCurrency,
-                Status:          "pending",
-                Description:     fmt.Sprintf("Crypto payment: %s to %s", fmt.Sprintf("%.8f", cryptoAmount), cryptoAddress),
-                CreatedAt:       time.Now(),
-                UpdatedAt:       time.Now(),
+        if isDirectDeposit(payment) {
+                if payment.Status != "pending" && payment.Status != "processing" && payment.Status != "expired" {
+                        return nil
+                }
+                return s.settleDeposit(payment)
         }
 
-        err = s.paymentRepo.Create(payment)
+        provider, err := s.providers.Get(payment.PaymentProvider)
         if err != nil {
-                return nil, err
+                return err
         }
 
-        return payment, nil
+        status, err := provider.FetchStatus(payment)
+        if err != nil {
+                return err
+        }
+
+        if status == payment.Status {
+                return nil
+        }
+        return s.applyStatus(payment, status)
 }
 
-func (s *PaymentService) VerifyPayment(paymentID int64) error {
-        payment, err := s.paymentRepo.GetByID(paymentID)
+// ProcessPendingCryptoPayments settles the open crypto payments whose money
+// has arrived, on chain or at the crypto processor. Direct deposits that
+// expired within CRYPTO_LATE_WINDOW are looked up as well.
+func (s *PaymentService) ProcessPendingCryptoPayments() error {
+        payments, err := s.paymentRepo.GetOpenByProvider("crypto", time.Now().Add(-s.config.CryptoLateWindow))
         if err != nil {
                 return err
         }
 
-        if payment.PaymentMethod == "crypto" {
-                return s.verifyCryptoPayment(payment)
+        for _, payment := range payments {
+                if err := s.VerifyPayment(payment.ID); err != nil {
+                        log.Printf("Failed to verify crypto payment %d: %v", payment.ID, err)
+                }
         }
 
         return nil
 }
 
-func (s *PaymentService) verifyCryptoPayment(payment *models.Payment) error {
-        // Simplified crypto payment verification
-        // In production, integrate with blockchain APIs
-        payment.Status = "completed"
-        payment.CompletedAt = time.Now()
-        return s.paymentRepo.Update(payment)
+// RefundPayment returns the money of a completed payment through its provider
+func (s *PaymentService) RefundPayment(paymentID int64) error {
+        payment, err := s.paymentRepo.GetByID(paymentID)
+        if err != nil {
+                return err
+        }
+        if payment.Status != "completed" {
+                return fmt.Errorf("payment %d is %s, only completed payments can be refunded", payment.ID, payment.Status)
+        }
+
+        provider, err := s.providers.Get(payment.PaymentProvider)
+        if err != nil {
+                return err
+        }
+
+        if err := provider.Refund(payment); err != nil {
+                return err
+        }
+
+        return s.refundPayment(payment)
 }
 
-func (s *PaymentService) ProcessPendingCryptoPayments() error {
-        // Simplified implementation
-        // In production, query for pending crypto payments and verify them
+// CheckTelegramInvoice validates a pre-checkout query against the payment the
+// invoice was issued for. The returned error is shown to the payer.
+func (s *PaymentService) CheckTelegramInvoice(telegramUserID int64, payload string, totalAmount int, currency string) error {
+        payment, err := s.telegramInvoicePayment(telegramUserID, payload)
+        if err != nil {
+                return err
+        }
+
+        if payment.Status != "pending" && payment.Status != "processing" {
+                return fmt.Errorf("this invoice is no longer valid")
+        }
+        if payment.Amount != totalAmount || !strings.EqualFold(payment.Currency, currency) {
+                return fmt.Errorf("the invoice amount has changed, please request a new one")
+        }
+
         return nil
 }
 
-func (s *PaymentService) generateInvoicePayload(userID int, planID int) string {
-        // Generate random bytes for uniqueness
-        randomBytes := make([]byte, 8)
-        rand.Read(randomBytes)
-        
-        return fmt.Sprintf("payment_%d_%d_%d_%s", userID, planID, time.Now().Unix(), hex.EncodeToString(randomBytes))
+// CompleteTelegramPayment settles the payment behind a successful Telegram invoice
+func (s *PaymentService) CompleteTelegramPayment(telegramUserID int64, payload, chargeID string) error {
+        payment, err := s.telegramInvoicePayment(telegramUserID, payload)
+        if err != nil {
+                return err
+        }
+
+        if chargeID != "" {
+                if err := s.paymentRepo.SetTransactionID(payment.ID, chargeID); err != nil {
+                        return err
+                }
+        }
+
+        return s.completePayment(payment)
+}
+
+func (s *PaymentService) telegramInvoicePayment(telegramUserID int64, payload string) (*models.Payment, error) {
+        if !strings.HasPrefix(payload, telegramInvoicePrefix) {
+                return nil, fmt.Errorf("unknown invoice")
+        }
+
+        paymentID, err := strconv.ParseInt(strings.TrimPrefix(payload, telegramInvoicePrefix), 10, 64)
+        if err != nil {
+                return nil, fmt.Errorf("unknown invoice")
+        }
+
+        payment, err := s.paymentRepo.GetByID(paymentID)
+        if err != nil {
+                return nil, fmt.Errorf("unknown invoice")
+        }
+
+        user, err := s.userRepo.GetByTelegramID(telegramUserID)
+        if err != nil || int64(user.ID) != payment.UserID {
+                return nil, fmt.Errorf("this invoice was issued to another user")
+        }
+
+        return payment, nil
+}
+
+func (s *PaymentService) applyStatus(payment *models.Payment, status string) error {
+        switch status {
+        case "completed":
+                return s.completePayment(payment)
+        case "refunded":
+                return s.refundPayment(payment)
+        case "failed", "cancelled":
+                return s.paymentRepo.UpdateStatus(payment.ID, status)
+        }
+        return nil
 }
 
-func (s *PaymentService) getCryptoAddress(currency string) (string, error) {
-        switch currency {
-        case "BTC":
-                if s.config.BTCAddress != "" {
-                        return s.config.BTCAddress, nil
+func (s *PaymentService) completePayment(payment *models.Payment) error {
+        plan, err := s.planRepo.GetByID(int(payment.PlanID))
+        if err != nil {
+                return err
+        }
+
+        // The payment is completed in the transaction that activates the
+        // subscription, so a notification retried after a failed activation
+        // activates it then
+        err = s.subscriptions.ActivateSubscription(int(payment.UserID), plan.ID, func(tx *sql.Tx) error {
+                completed, err := s.paymentRepo.MarkCompleted(tx, payment.ID)
+                if err == nil && !completed {
+                        return errPaymentCompleted
                 }
-                return "", fmt.Errorf("BTC address not configured")
-        case "ETH":
-                if s.config.ETHAddress != "" {
-                        return s.config.ETHAddress, nil
+                return err
+        })
+
+        // Another notification for the same payment already activated the subscription
+        if errors.Is(err, errPaymentCompleted) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+
+        // The payment stands even if the invite links could not be sent
+        if err := s.chatAccess.Grant(int(payment.UserID), plan.ID); err != nil {
+                log.Printf("Error granting chat access for payment %d: %v", payment.ID, err)
+        }
+        return nil
+}
+
+// refundPayment marks the payment refunded in the transaction that ends the
+// subscription it paid for, which takes the user out of the plan's chats
+func (s *PaymentService) refundPayment(payment *models.Payment) error {
+        err := s.subscriptions.RefundSubscription(int(payment.UserID), int(payment.PlanID), func(tx *sql.Tx) error {
+                refunded, err := s.paymentRepo.MarkRefunded(tx, payment.ID)
+                if err == nil && !refunded {
+                        return errPaymentRefunded
                 }
-                return "", fmt.Errorf("ETH address not configured")
-        case "USDT":
-                if s.config.USDTAddress != "" {
-                        return s.config.USDTAddress, nil
+                return err
+        })
+
+        // Another notification for the same refund already ended the subscription
+        if errors.Is(err, errPaymentRefunded) {
+                return nil
+        }
+        return err
+}
+
+// findPayment resolves the local payment for a provider event, first by the
+// payment_id we put into the provider metadata and then by transaction ID.
+func (s *PaymentService) findPayment(providerName string, paymentID int64, transactionID string) (*models.Payment, error) {
+        if paymentID > 0 {
+                payment, err := s.paymentRepo.GetByID(paymentID)
+                if err == nil || !errors.Is(err, sql.ErrNoRows) || transactionID == "" {
+                        return payment, err
                 }
-                return "", fmt.Errorf("USDT address not configured")
-        default:
-                return "", fmt.Errorf("unsupported cryptocurrency: %s", currency)
         }
+
+        if transactionID == "" {
+                return nil, fmt.Errorf("event has neither payment_id nor transaction ID")
+        }
+
+        return s.paymentRepo.GetByTransactionID(providerName, transactionID)
 }
 
 func (s *PaymentService) GetPaymentStats() (map[string]interface{}, error) {
         stats := make(map[string]interface{})
-        
+
         // Total payments
         var totalPayments int
         var totalRevenue float64
-        
-        err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed'").Scan(&totalPayments, &totalRevenue)
+
+        err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed'").Scan(&totalPayments, &totalRevenue)
         if err != nil {
                 return nil, err
         }
-        
+
         stats["total_payments"] = totalPayments
         stats["total_revenue"] = totalRevenue / 100
-        
+
         // Payment methods breakdown
-        rows, err := s.db.Query("SELECT payment_method, COUNT(*), SUM(amount_cents) FROM payments WHERE status = 'completed' GROUP BY payment_method")
+        rows, err := s.db.Query("SELECT payment_method, COUNT(*), SUM(amount) FROM payments WHERE status = 'completed' GROUP BY payment_method")
         if err != nil {
                 return nil, err
         }
         defer rows.Close()
-        
+
         methodStats := make(map[string]map[string]float64)
         for rows.Next() {
                 var method string
                 var count int
                 var revenue float64
-                
+
                 rows.Scan(&method, &count, &revenue)
                 methodStats[method] = map[string]float64{
                         "count":   float64(count),
                         "revenue": revenue / 100,
                 }
         }
-        
+
         stats["payment_methods"] = methodStats
-        
+
         return stats, nil
 }
@@ -0,0 +1,180 @@
+package services
+
+import (
+        "fmt"
+        "log"
+        "math"
+        "math/big"
+        "strings"
+        "sync"
+        "time"
+
+        "telegram-subscription-bot/config"
+        "telegram-subscription-bot/database"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/utils"
+)
+
+// quotePrecision is the number of decimals a quoted amount is rounded up to,
+// so payers see amounts their wallets can send
+var quotePrecision = map[string]int{
+        "BTC":  8,
+        "ETH":  6,
+        "USDT": 2,
+}
+
+// Quote is a crypto price for a fiat amount, locked until ExpiresAt
+type Quote struct {
+        Asset      string
+        Fiat       string
+        FiatAmount int // in cents
+        Rate       float64
+        Amount     *big.Int // in the asset's smallest unit
+        ExpiresAt  time.Time
+}
+
+// AmountText is the quoted amount in whole units, e.g. "0.00012345"
+func (q *Quote) AmountText() string {
+        return formatQuoteAmount(q.Amount, q.Asset)
+}
+
+// rateStore keeps the last fetched rates across restarts
+type rateStore interface {
+        Get(crypto, fiat string) (*models.ExchangeRate, error)
+        Save(rate *models.ExchangeRate) error
+}
+
+type cachedRate struct {
+        rate      float64
+        fetchedAt time.Time
+}
+
+// RateService prices crypto payments. Rates are cached in memory and in the
+// exchange_rates table for ttl; when the source fails, a cached rate up to
+// maxAge old is used rather than failing the checkout.
+type RateService struct {
+        source   utils.RateSource
+        repo     rateStore
+        ttl      time.Duration
+        maxAge   time.Duration
+        quoteTTL time.Duration
+
+        mu    sync.Mutex
+        cache map[string]cachedRate
+}
+
+func NewRateService(db *database.DB, source utils.RateSource, cfg *config.Config) *RateService {
+        return &RateService{
+                source:   source,
+                repo:     models.NewExchangeRateRepository(db.DB),
+                ttl:      cfg.RateCacheTTL,
+                maxAge:   cfg.RateMaxAge,
+                quoteTTL: cfg.CryptoQuoteTTL,
+                cache:    make(map[string]cachedRate),
+        }
+}
+
+// newRateSource picks the configured source of exchange rates
+func newRateSource(cfg *config.Config) utils.RateSource {
+        if cfg.RateSource == "static" {
+                return utils.NewStaticRateSource(cfg.RateFile)
+        }
+        return utils.NewCoinGeckoRateSource()
+}
+
+// Rate returns the price of one unit of crypto in fiat
+func (s *RateService) Rate(crypto, fiat string) (float64, error) {
+        crypto, fiat = strings.ToUpper(crypto), strings.ToUpper(fiat)
+        key := crypto + "/" + fiat
+
+        s.mu.Lock()
+        cached, ok := s.cache[key]
+        s.mu.Unlock()
+
+        if !ok {
+                if stored, err := s.repo.Get(crypto, fiat); err == nil {
+                        cached, ok = cachedRate{rate: stored.Rate, fetchedAt: stored.FetchedAt}, true
+                        s.remember(key, cached)
+                }
+        }
+
+        if ok && time.Since(cached.fetchedAt) < s.ttl {
+                return cached.rate, nil
+        }
+
+        rate, err := s.source.Rate(crypto, fiat)
+        if err != nil {
+                if ok && time.Since(cached.fetchedAt) < s.maxAge {
+                        log.Printf("Using cached %s rate from %s: %v", key, cached.fetchedAt.Format(time.RFC3339), err)
+                        return cached.rate, nil
+                }
+                return 0, err
+        }
+
+        fresh := cachedRate{rate: rate, fetchedAt: time.Now()}
+        s.remember(key, fresh)
+        if err := s.repo.Save(&models.ExchangeRate{Crypto: crypto, Fiat: fiat, Rate: rate, FetchedAt: fresh.fetchedAt}); err != nil {
+                log.Printf("Failed to store %s rate: %v", key, err)
+        }
+
+        return rate, nil
+}
+
+func (s *RateService) remember(key string, rate cachedRate) {
+        s.mu.Lock()
+        s.cache[key] = rate
+        s.mu.Unlock()
+}
+
+// Quote prices fiatAmount (in cents) in asset and locks the price for the
+// configured quote TTL
+func (s *RateService) Quote(fiatAmount int, fiat, asset string) (*Quote, error) {
+        asset = strings.ToUpper(asset)
+
+        amount, rate, err := s.Price(fiatAmount, fiat, asset)
+        if err != nil {
+                return nil, err
+        }
+
+        return &Quote{
+                Asset:      asset,
+                Fiat:       strings.ToUpper(fiat),
+                FiatAmount: fiatAmount,
+                Rate:       rate,
+                Amount:     amount,
+                ExpiresAt:  time.Now().Add(s.quoteTTL),
+        }, nil
+}
+
+// Price converts fiatAmount (in cents) into asset at the current rate and
+// returns the amount in the asset's smallest unit with the rate used
+func (s *RateService) Price(fiatAmount int, fiat, asset string) (*big.Int, float64, error) {
+        decimals, ok := utils.AssetDecimals(asset)
+        if !ok {
+                return nil, 0, fmt.Errorf("unsupported cryptocurrency: %s", asset)
+        }
+
+        rate, err := s.Rate(asset, fiat)
+        if err != nil {
+                return nil, 0, err
+        }
+
+        // Round up to the displayed precision so the payer never sends too little
+        precision := quotePrecision[strings.ToUpper(asset)]
+        units := math.Ceil(float64(fiatAmount) / 100 / rate * math.Pow10(precision))
+
+        amount, _ := new(big.Float).SetFloat64(units).Int(nil)
+        amount.Mul(amount, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals-precision)), nil))
+
+        return amount, rate, nil
+}
+
+func formatQuoteAmount(amount *big.Int, asset string) string {
+        text := utils.FormatAssetAmount(amount, asset)
+        precision, ok := quotePrecision[strings.ToUpper(asset)]
+        decimals, _ := utils.AssetDecimals(asset)
+        if ok && strings.Contains(text, ".") {
+                text = text[:len(text)-(decimals-precision)]
+        }
+        return text
+}
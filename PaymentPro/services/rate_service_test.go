@@ -0,0 +1,229 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/utils"
+)
+
+// memoryRates stands in for the exchange_rates table
+type memoryRates map[string]*models.ExchangeRate
+
+func (m memoryRates) Get(crypto, fiat string) (*models.ExchangeRate, error) {
+	rate, ok := m[crypto+"/"+fiat]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return rate, nil
+}
+
+func (m memoryRates) Save(rate *models.ExchangeRate) error {
+	m[rate.Crypto+"/"+rate.Fiat] = rate
+	return nil
+}
+
+// writeRates replaces the static rate file with a BTC price per fiat;
+// no prices removes it, so the source fails
+func writeRates(t *testing.T, file string, btc map[string]float64) {
+	t.Helper()
+	if btc == nil {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	content, err := json.Marshal(map[string]map[string]float64{
+		"BTC":  btc,
+		"ETH":  {"USD": 3000},
+		"USDT": {"USD": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRates returns a rate service reading the static source in file
+func newTestRates(file string, ttl time.Duration) (*RateService, memoryRates) {
+	store := memoryRates{}
+	return &RateService{
+		source:   utils.NewStaticRateSource(file),
+		repo:     store,
+		ttl:      ttl,
+		maxAge:   time.Hour,
+		quoteTTL: 30 * time.Minute,
+		cache:    make(map[string]cachedRate),
+	}, store
+}
+
+func TestRate(t *testing.T) {
+	type rate struct {
+		rate float64
+		age  time.Duration
+	}
+
+	tests := []struct {
+		name    string
+		cached  *rate
+		stored  *rate
+		source  float64 // 0: the source fails
+		want    float64
+		wantErr bool
+	}{
+		{name: "nothing cached", source: 50000, want: 50000},
+		{name: "fresh cache", cached: &rate{45000, time.Minute}, source: 50000, want: 45000},
+		{name: "expired cache", cached: &rate{45000, 6 * time.Minute}, source: 50000, want: 50000},
+		{name: "stored rate after a restart", stored: &rate{45000, time.Minute}, source: 50000, want: 45000},
+		{name: "expired stored rate", stored: &rate{45000, 6 * time.Minute}, source: 50000, want: 50000},
+		{name: "memory before the store", cached: &rate{45000, time.Minute}, stored: &rate{40000, time.Minute}, source: 50000, want: 45000},
+		{name: "source down, cache within max age", cached: &rate{45000, 30 * time.Minute}, want: 45000},
+		{name: "source down, stored rate within max age", stored: &rate{45000, 30 * time.Minute}, want: 45000},
+		{name: "source down, cache too old", cached: &rate{45000, 2 * time.Hour}, wantErr: true},
+		{name: "source down, nothing cached", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "rates.json")
+			if tt.source > 0 {
+				writeRates(t, file, map[string]float64{"USD": tt.source})
+			}
+
+			s, store := newTestRates(file, 5*time.Minute)
+			if tt.cached != nil {
+				s.cache["BTC/USD"] = cachedRate{rate: tt.cached.rate, fetchedAt: time.Now().Add(-tt.cached.age)}
+			}
+			if tt.stored != nil {
+				store.Save(&models.ExchangeRate{Crypto: "BTC", Fiat: "USD", Rate: tt.stored.rate, FetchedAt: time.Now().Add(-tt.stored.age)})
+			}
+
+			got, err := s.Rate("btc", "usd")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Rate() = %v, want %v", got, tt.want)
+			}
+			if stored := store["BTC/USD"]; tt.source > 0 && got == tt.source && (stored == nil || stored.Rate != tt.source) {
+				t.Errorf("fetched rate %v was not stored", tt.source)
+			}
+		})
+	}
+}
+
+func TestRateRefreshedAfterTTL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rates.json")
+	writeRates(t, file, map[string]float64{"USD": 50000})
+	s, _ := newTestRates(file, 5*time.Minute)
+
+	steps := []struct {
+		name   string
+		source map[string]float64
+		age    time.Duration // how old the cached rate is made before the lookup
+		want   float64
+	}{
+		{"first lookup", map[string]float64{"USD": 50000}, 0, 50000},
+		{"price moved within the TTL", map[string]float64{"USD": 60000}, 4 * time.Minute, 50000},
+		{"TTL passed", map[string]float64{"USD": 60000}, 5 * time.Minute, 60000},
+		{"source down within max age", nil, 30 * time.Minute, 60000},
+	}
+
+	for _, step := range steps {
+		writeRates(t, file, step.source)
+		if cached, ok := s.cache["BTC/USD"]; ok {
+			s.cache["BTC/USD"] = cachedRate{rate: cached.rate, fetchedAt: time.Now().Add(-step.age)}
+		}
+
+		got, err := s.Rate("BTC", "USD")
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Rate() = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	s.cache["BTC/USD"] = cachedRate{rate: 60000, fetchedAt: time.Now().Add(-2 * time.Hour)}
+	if _, err := s.Rate("BTC", "USD"); err == nil {
+		t.Error("Rate() used a cached rate older than the max age")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name       string
+		fiatAmount int
+		fiat       string
+		asset      string
+		want       string
+		wantErr    bool
+	}{
+		{name: "BTC", fiatAmount: 1000, fiat: "USD", asset: "BTC", want: "0.00020000"},
+		{name: "lower case", fiatAmount: 1000, fiat: "usd", asset: "btc", want: "0.00020000"},
+		{name: "other fiat", fiatAmount: 1000, fiat: "EUR", asset: "BTC", want: "0.00025000"},
+		{name: "rounded up to the displayed precision", fiatAmount: 1000, fiat: "USD", asset: "ETH", want: "0.003334"},
+		{name: "stablecoin", fiatAmount: 1000, fiat: "USD", asset: "USDT", want: "10.00"},
+		{name: "no rate for the fiat", fiatAmount: 1000, fiat: "GBP", asset: "BTC", wantErr: true},
+		{name: "unsupported asset", fiatAmount: 1000, fiat: "USD", asset: "DOGE", wantErr: true},
+	}
+
+	file := filepath.Join(t.TempDir(), "rates.json")
+	writeRates(t, file, map[string]float64{"USD": 50000, "EUR": 40000})
+	s, _ := newTestRates(file, 5*time.Minute)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			quote, err := s.Quote(tt.fiatAmount, tt.fiat, tt.asset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Quote() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := quote.AmountText(); got != tt.want {
+				t.Errorf("AmountText() = %s, want %s", got, tt.want)
+			}
+			if quote.ExpiresAt.Before(before.Add(s.quoteTTL)) || quote.ExpiresAt.After(time.Now().Add(s.quoteTTL)) {
+				t.Errorf("ExpiresAt = %v, want %v after the quote", quote.ExpiresAt, s.quoteTTL)
+			}
+		})
+	}
+}
+
+func TestQuoteLocksAmount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rates.json")
+	writeRates(t, file, map[string]float64{"USD": 50000})
+	s, _ := newTestRates(file, 0)
+
+	quote, err := s.Quote(1000, "USD", "BTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRates(t, file, map[string]float64{"USD": 40000})
+	repriced, _, err := s.Price(1000, "USD", "BTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := quote.AmountText(); got != "0.00020000" {
+		t.Errorf("quoted amount changed with the rate: %s", got)
+	}
+	if quote.Rate != 50000 {
+		t.Errorf("quoted rate = %v, want 50000", quote.Rate)
+	}
+	if got := utils.FormatAssetAmount(repriced, "BTC"); got != "0.00025000" {
+		t.Errorf("Price() after the rate moved = %s, want 0.00025000", got)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/models"
+)
+
+// SanctionSweeper ends sanctions whose time is up, lets old violations decay
+// and compares the sanctions the database holds in force with what Telegram
+// reports for the members, recording every disagreement.
+type SanctionSweeper struct {
+	bot      *tgbotapi.BotAPI
+	repo     *models.SanctionRepository
+	batch    int
+	ticker   *time.Ticker
+	stopChan chan bool
+}
+
+func NewSanctionSweeper(bot *tgbotapi.BotAPI, db *database.DB, interval time.Duration, batch int) *SanctionSweeper {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if batch <= 0 {
+		batch = 20
+	}
+
+	return &SanctionSweeper{
+		bot:      bot,
+		repo:     models.NewSanctionRepository(db.DB),
+		batch:    batch,
+		ticker:   time.NewTicker(interval),
+		stopChan: make(chan bool),
+	}
+}
+
+func (s *SanctionSweeper) Start() {
+	log.Println("Starting sanction sweeper...")
+
+	s.Sweep()
+	for {
+		select {
+		case <-s.ticker.C:
+			s.Sweep()
+		case <-s.stopChan:
+			s.ticker.Stop()
+			return
+		}
+	}
+}
+
+func (s *SanctionSweeper) Stop() {
+	s.stopChan <- true
+}
+
+// Sweep runs one pass: expired sanctions, decayed violations, then a batch of
+// sanctions in force checked against Telegram
+func (s *SanctionSweeper) Sweep() {
+	expired, err := s.repo.LiftExpired()
+	if err != nil {
+		log.Printf("Error lifting expired sanctions: %v", err)
+	}
+	for _, sanction := range expired {
+		s.checkLifted(sanction)
+	}
+
+	decayed, err := s.repo.DecayViolations(models.DefaultModerationSettings(0).ViolationDecayDays)
+	if err != nil {
+		log.Printf("Error deactivating decayed violations: %v", err)
+	} else if decayed > 0 {
+		log.Printf("Deactivated %d decayed violations", decayed)
+	}
+
+	inForce, err := s.repo.ListInForce(s.batch)
+	if err != nil {
+		log.Printf("Error listing sanctions in force: %v", err)
+		return
+	}
+	for _, sanction := range inForce {
+		s.checkInForce(sanction)
+	}
+}
+
+// checkInForce compares a sanction the database holds in force with the
+// member's status. A member Telegram no longer restricts or bans was let go
+// by hand, so the sanction is lifted; a restricted member who got banned
+// meanwhile is only recorded.
+func (s *SanctionSweeper) checkInForce(sanction *models.Sanction) {
+	member, ok := s.memberStatus(sanction)
+	if !ok {
+		s.markChecked(sanction)
+		return
+	}
+
+	expected := sanction.ExpectedStatus()
+	switch member.Status {
+	case expected:
+		s.markChecked(sanction)
+	case "kicked":
+		s.recordDiscrepancy(sanction, expected, member.Status, models.DiscrepancyRecorded)
+		s.markChecked(sanction)
+	default:
+		if err := s.repo.Lift(sanction.ID); err != nil {
+			log.Printf("Error lifting sanction %d: %v", sanction.ID, err)
+			return
+		}
+		s.recordDiscrepancy(sanction, expected, member.Status, models.DiscrepancyLifted)
+	}
+}
+
+// checkLifted looks at a member whose sanction just expired. Telegram lifts
+// mutes and bans with an end date itself, so a member still restricted or
+// banned for good, or past the end date, without another sanction in force
+// disagrees with the database.
+func (s *SanctionSweeper) checkLifted(sanction *models.Sanction) {
+	member, ok := s.memberStatus(sanction)
+	if !ok || (member.Status != "kicked" && member.Status != "restricted") {
+		return
+	}
+	if member.UntilDate > time.Now().Unix() {
+		return
+	}
+
+	inForce, err := s.repo.InForce(sanction.ChatID, sanction.TelegramUserID)
+	if err != nil {
+		log.Printf("Error checking sanctions of user %d in chat %d: %v", sanction.TelegramUserID, sanction.ChatID, err)
+		return
+	}
+	if !inForce {
+		s.recordDiscrepancy(sanction, "member", member.Status, models.DiscrepancyRecorded)
+	}
+}
+
+func (s *SanctionSweeper) memberStatus(sanction *models.Sanction) (tgbotapi.ChatMember, bool) {
+	member, err := s.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: sanction.ChatID,
+			UserID: sanction.TelegramUserID,
+		},
+	})
+	if err != nil {
+		log.Printf("Error checking chat member %d in chat %d: %v", sanction.TelegramUserID, sanction.ChatID, err)
+		return tgbotapi.ChatMember{}, false
+	}
+	return member, true
+}
+
+func (s *SanctionSweeper) markChecked(sanction *models.Sanction) {
+	if err := s.repo.MarkChecked(sanction.ID); err != nil {
+		log.Printf("Error marking sanction %d as checked: %v", sanction.ID, err)
+	}
+}
+
+func (s *SanctionSweeper) recordDiscrepancy(sanction *models.Sanction, expected, actual, resolution string) {
+	log.Printf("Sanction %d (%s) of user %d in chat %d: expected %s in Telegram, found %s (%s)",
+		sanction.ID, sanction.Type, sanction.TelegramUserID, sanction.ChatID, expected, actual, resolution)
+
+	err := s.repo.RecordDiscrepancy(&models.ModerationDiscrepancy{
+		ViolationID:    sanction.ID,
+		ChatID:         sanction.ChatID,
+		TelegramUserID: sanction.TelegramUserID,
+		Expected:       expected,
+		Actual:         actual,
+		Resolution:     resolution,
+	})
+	if err != nil {
+		log.Printf("Error recording discrepancy of sanction %d: %v", sanction.ID, err)
+	}
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "net/url"
+        "strconv"
+        "strings"
+        "time"
+
+        "telegram-subscription-bot/models"
+)
+
+const stripeAPIURL = "https://api.stripe.com/v1"
+
+func init() {
+        RegisterPaymentProvider("stripe", newStripeProvider)
+}
+
+// stripeProvider takes card payments through hosted Stripe Checkout sessions.
+// The session ID is the payment's transaction ID.
+type stripeProvider struct {
+        secretKey      string
+        webhookSecrets []string
+        tolerance      time.Duration
+        successURL     string
+        cancelURL      string
+}
+
+func newStripeProvider(settings *models.PaymentProviderConfig, deps ProviderDeps) (PaymentProvider, error) {
+        if deps.Config.StripeSecretKey == "" {
+                return nil, fmt.Errorf("STRIPE_SECRET_KEY is not set")
+        }
+
+        // A secret stored with the provider row takes precedence over the environment
+        webhookSecrets := deps.Config.StripeWebhookSecrets
+        if settings.WebhookSecret != "" {
+                webhookSecrets = nil
+                for _, secret := range strings.Split(settings.WebhookSecret, ",") {
+                        if secret = strings.TrimSpace(secret); secret != "" {
+                                webhookSecrets = append(webhookSecrets, secret)
+                        }
+                }
+        }
+
+        return &stripeProvider{
+                secretKey:      deps.Config.StripeSecretKey,
+                webhookSecrets: webhookSecrets,
+                tolerance:      deps.Config.StripeWebhookTolerance,
+                successURL:     configString(settings, "success_url", deps.Config.Domain+"/payment/success"),
+                cancelURL:      configString(settings, "cancel_url", deps.Config.Domain+"/payment"),
+        }, nil
+}
+
+func (p *stripeProvider) Method() string {
+        return "card"
+}
+
+func (p *stripeProvider) CreateCheckout(req *CheckoutRequest) (*Checkout, error) {
+        paymentID := strconv.FormatInt(req.Payment.ID, 10)
+
+        form := url.Values{}
+        form.Set("mode", "payment")
+        form.Set("success_url", p.successURL)
+        form.Set("cancel_url", p.cancelURL)
+        form.Set("client_reference_id", paymentID)
+        form.Set("line_items[0][quantity]", "1")
+        form.Set("line_items[0][price_data][currency]", strings.ToLower(req.Payment.Currency))
+        form.Set("line_items[0][price_data][unit_amount]", strconv.Itoa(req.Payment.Amount))
+        form.Set("line_items[0][price_data][product_data][name]", req.Plan.Name)
+        form.Set("metadata[payment_id]", paymentID)
+        form.Set("payment_intent_data[metadata][payment_id]", paymentID)
+
+        var session stripeCheckoutSession
+        if err := p.do("POST", "/checkout/sessions", form, &session); err != nil {
+                return nil, err
+        }
+
+        return &Checkout{
+                TransactionID: session.ID,
+                URL:           session.URL,
+        }, nil
+}
+
+func (p *stripeProvider) ParseWebhook(r *http.Request, payload []byte) (*WebhookEvent, error) {
+        if err := verifyStripeSignatureHeader(payload, r.Header.Get("Stripe-Signature"), p.webhookSecrets, p.tolerance, time.Now()); err != nil {
+                return nil, fmt.Errorf("%w: %v", ErrInvalidWebhookSignature, err)
+        }
+
+        var event stripeEvent
+        if err := json.Unmarshal(payload, &event); err != nil {
+                return nil, err
+        }
+        if event.ID == "" {
+                return nil, fmt.Errorf("stripe event without id")
+        }
+
+        var object stripeEventObject
+        if err := json.Unmarshal(event.Data.Object, &object); err != nil {
+                return nil, err
+        }
+
+        parsed := &WebhookEvent{
+                ID:            event.ID,
+                Type:          event.Type,
+                TransactionID: object.ID,
+        }
+        parsed.PaymentID, _ = strconv.ParseInt(object.Metadata["payment_id"], 10, 64)
+
+        switch event.Type {
+        case "checkout.session.completed":
+                // Delayed methods (bank debits) complete the session before the money arrives
+                if object.PaymentStatus == "paid" {
+                        parsed.Status = "completed"
+                }
+        case "checkout.session.async_payment_succeeded", "payment_intent.succeeded":
+                parsed.Status = "completed"
+        case "checkout.session.async_payment_failed", "payment_intent.payment_failed":
+                parsed.Status = "failed"
+        case "checkout.session.expired":
+                parsed.Status = "cancelled"
+        }
+
+        return parsed, nil
+}
+
+func (p *stripeProvider) Refund(payment *models.Payment) error {
+        session, err := p.getSession(payment.TransactionID)
+        if err != nil {
+                return err
+        }
+        if session.PaymentIntent == "" {
+                return fmt.Errorf("stripe session %s has no payment intent", session.ID)
+        }
+
+        form := url.Values{}
+        form.Set("payment_intent", session.PaymentIntent)
+        form.Set("metadata[payment_id]", strconv.FormatInt(payment.ID, 10))
+
+        return p.do("POST", "/refunds", form, nil)
+}
+
+func (p *stripeProvider) FetchStatus(payment *models.Payment) (string, error) {
+        session, err := p.getSession(payment.TransactionID)
+        if err != nil {
+                return "", err
+        }
+
+        switch {
+        case session.Status == "complete" && session.PaymentStatus == "paid":
+                return "completed", nil
+        case session.Status == "expired":
+                return "cancelled", nil
+        }
+        return payment.Status, nil
+}
+
+func (p *stripeProvider) getSession(sessionID string) (*stripeCheckoutSession, error) {
+        if sessionID == "" {
+                return nil, fmt.Errorf("payment has no stripe session")
+        }
+
+        var session stripeCheckoutSession
+        if err := p.do("GET", "/checkout/sessions/"+url.PathEscape(sessionID), nil, &session); err != nil {
+                return nil, err
+        }
+        return &session, nil
+}
+
+func (p *stripeProvider) do(method, path string, form url.Values, out interface{}) error {
+        req, err := http.NewRequest(method, stripeAPIURL+path, strings.NewReader(form.Encode()))
+        if err != nil {
+                return err
+        }
+
+        req.Header.Set("Authorization", "Bearer "+p.secretKey)
+        req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+        return doProviderRequest(req, out)
+}
+
+type stripeCheckoutSession struct {
+        ID            string `json:"id"`
+        URL           string `json:"url"`
+        Status        string `json:"status"`
+        PaymentStatus string `json:"payment_status"`
+        PaymentIntent string `json:"payment_intent"`
+}
+
+type stripeEvent struct {
+        ID   string `json:"id"`
+        Type string `json:"type"`
+        Data struct {
+                Object json.RawMessage `json:"object"`
+        } `json:"data"`
+}
+
+type stripeEventObject struct {
+        ID            string            `json:"id"`
+        PaymentStatus string            `json:"payment_status"`
+        Metadata      map[string]string `json:"metadata"`
+}
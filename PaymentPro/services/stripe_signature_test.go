@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+// The signatures below were computed once over
+// testdata/stripe_checkout_session_completed.json, signed at stripeTimestamp,
+// the way Stripe signs a delivery
+const (
+	stripeTimestamp        = "1700000000"
+	stripeSecretCurrent    = "whsec_test_current"
+	stripeSecretPrevious   = "whsec_test_previous"
+	stripeSignatureCurrent = "cf3bf42affc2b3b68c8fe1db7bf2bffe0b18041b6e40603fbdb219bb1253719b"
+	stripeSignaturePrev    = "fd15d3051b32510758419c482b963d8b45fed42d41f6287d9763e4eeee3fbde5"
+)
+
+func TestVerifyStripeSignatureHeader(t *testing.T) {
+	payload, err := os.ReadFile("testdata/stripe_checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(payload, []byte(`"amount_total": 999`), []byte(`"amount_total": 1`), 1)
+	if bytes.Equal(tampered, payload) {
+		t.Fatal("sample payload has no amount to tamper with")
+	}
+
+	signedAt := time.Unix(1700000000, 0)
+	tolerance := 5 * time.Minute
+	current := []string{stripeSecretCurrent}
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		header    string
+		secrets   []string
+		tolerance time.Duration
+		now       time.Time
+		wantErr   bool
+	}{
+		{
+			name:    "valid signature",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt.Add(30 * time.Second),
+		},
+		{
+			name:    "valid signature with an unknown scheme alongside",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v0=deadbeef,v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt,
+		},
+		{
+			name:    "rotation: both v1 values, new secret configured",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignaturePrev + ",v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt,
+		},
+		{
+			name:    "rotation: both v1 values, old secret configured",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignaturePrev + ",v1=" + stripeSignatureCurrent,
+			secrets: []string{stripeSecretPrevious},
+			now:     signedAt,
+		},
+		{
+			name:    "rotation: old signature, both secrets configured",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignaturePrev,
+			secrets: []string{stripeSecretCurrent, stripeSecretPrevious},
+			now:     signedAt,
+		},
+		{
+			name:    "rotation: unparseable v1 next to a valid one",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=not-hex,v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt,
+		},
+		{
+			name:    "old signature after the old secret was dropped",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignaturePrev,
+			secrets: current,
+			now:     signedAt,
+			wantErr: true,
+		},
+		{
+			name:    "stale timestamp",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt.Add(tolerance + time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "timestamp in the future",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt.Add(-tolerance - time.Second),
+			wantErr: true,
+		},
+		{
+			name:      "stale timestamp without a tolerance",
+			payload:   payload,
+			header:    "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			secrets:   current,
+			tolerance: -1,
+			now:       signedAt.Add(24 * time.Hour),
+		},
+		{
+			name:    "timestamp changed to a fresh one",
+			payload: payload,
+			header:  "t=1700000100,v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt.Add(100 * time.Second),
+			wantErr: true,
+		},
+		{
+			name:    "tampered body",
+			payload: tampered,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			secrets: current,
+			now:     signedAt,
+			wantErr: true,
+		},
+		{
+			name:    "no secret configured",
+			payload: payload,
+			header:  "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent,
+			now:     signedAt,
+			wantErr: true,
+		},
+		{name: "malformed: empty header", payload: payload, header: "", secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: garbage", payload: payload, header: "garbage", secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: no timestamp", payload: payload, header: "v1=" + stripeSignatureCurrent, secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: non-numeric timestamp", payload: payload, header: "t=yesterday,v1=" + stripeSignatureCurrent, secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: no signature", payload: payload, header: "t=" + stripeTimestamp, secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: only unparseable signatures", payload: payload, header: "t=" + stripeTimestamp + ",v1=zz", secrets: current, now: signedAt, wantErr: true},
+		{name: "malformed: truncated signature", payload: payload, header: "t=" + stripeTimestamp + ",v1=" + stripeSignatureCurrent[:32], secrets: current, now: signedAt, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tol := tt.tolerance
+			if tol == 0 {
+				tol = tolerance
+			} else if tol < 0 {
+				tol = 0
+			}
+
+			err := verifyStripeSignatureHeader(tt.payload, tt.header, tt.secrets, tol, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyStripeSignatureHeader() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
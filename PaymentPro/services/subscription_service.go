@@ -1,140 +1,433 @@
 package services
 
 import (
+        "database/sql"
+        "errors"
+        "log"
         "time"
 
         "telegram-subscription-bot/database"
         "telegram-subscription-bot/models"
 )
 
+// subscriptionBatch is how many due subscriptions are loaded at a time
+const subscriptionBatch = 100
+
+// A failed status change is retried after subscriptionRetry, doubled with
+// every further failure up to subscriptionMaxRetry
+const (
+        subscriptionRetry    = 5 * time.Minute
+        subscriptionMaxRetry = 6 * time.Hour
+)
+
+// Reasons recorded with subscription status changes
+const (
+        reasonTrial       = "trial_started"
+        reasonPayment     = "payment"
+        reasonGranted     = "granted"
+        reasonCanceled    = "canceled"
+        reasonRevoked     = "revoked"
+        reasonRefunded    = "refunded"
+        reasonPlanChanged = "plan_changed"
+        reasonPeriodEnded = "period_ended"
+        reasonUnpaid      = "unpaid"
+)
+
+var (
+        // ErrTrialUnavailable is returned when the plan has no trial or the user had a subscription before
+        ErrTrialUnavailable = errors.New("trial not available")
+        // ErrNoSubscription is returned when the user has no subscription to change
+        ErrNoSubscription = errors.New("no subscription")
+)
+
+// SubscriptionService runs the subscription lifecycle. A subscription starts
+// trialing or active; when its period ends unrenewed it is past_due, keeping
+// the plan for pastDue, then in grace without it for grace, and then expires.
+// A canceled subscription keeps the plan until its period ends and expires
+// then. Every status change is stored as an event, and users.current_plan_id
+// follows the plan the user has access to.
 type SubscriptionService struct {
-        db       *database.DB
-        userRepo *models.UserRepository
-        planRepo *models.SubscriptionRepository
+        db           *database.DB
+        userRepo     *models.UserRepository
+        planRepo     *models.SubscriptionRepository
+        subs         *models.UserSubscriptionRepository
+        access       *models.ChatAccessRepository
+        groups       *GroupLimitService
+        entitlements *EntitlementService
+        pastDue      time.Duration
+        grace        time.Duration
 }
 
-func NewSubscriptionService(db *database.DB) *SubscriptionService {
+func NewSubscriptionService(db *database.DB, groups *GroupLimitService, entitlements *EntitlementService, pastDue, grace time.Duration) *SubscriptionService {
         return &SubscriptionService{
-                db:       db,
-                userRepo: models.NewUserRepository(db.DB),
-                planRepo: models.NewSubscriptionRepository(db.DB),
+                db:           db,
+                userRepo:     models.NewUserRepository(db.DB),
+                planRepo:     models.NewSubscriptionRepository(db.DB),
+                subs:         models.NewUserSubscriptionRepository(db.DB),
+                access:       models.NewChatAccessRepository(db.DB),
+                groups:       groups,
+                entitlements: entitlements,
+                pastDue:      pastDue,
+                grace:        grace,
+        }
+}
+
+// Subscription returns the user's subscription that has not expired, or
+// ErrNoSubscription
+func (s *SubscriptionService) Subscription(userID int) (*models.Subscription, error) {
+        sub, err := s.subs.GetLive(userID)
+        if errors.Is(err, sql.ErrNoRows) {
+                return nil, ErrNoSubscription
+        }
+        return sub, err
+}
+
+// SubscriptionEvents returns the status changes of the subscription
+func (s *SubscriptionService) SubscriptionEvents(subscriptionID int) ([]*models.SubscriptionEvent, error) {
+        return s.subs.Events(subscriptionID)
+}
+
+// CanStartTrial reports whether the user may try the plan: it has a trial and
+// the user never had a subscription
+func (s *SubscriptionService) CanStartTrial(userID int, plan *models.SubscriptionPlan) (bool, error) {
+        if plan.TrialDays <= 0 || plan.PriceCents == 0 {
+                return false, nil
+        }
+        had, err := s.subs.HasAny(userID)
+        return !had, err
+}
+
+// StartTrial starts the plan's trial for the user
+func (s *SubscriptionService) StartTrial(userID int, planID int) (*models.Subscription, error) {
+        plan, err := s.planRepo.GetByID(planID)
+        if err != nil {
+                return nil, err
+        }
+        eligible, err := s.CanStartTrial(userID, plan)
+        if err != nil {
+                return nil, err
+        }
+        if !eligible {
+                return nil, ErrTrialUnavailable
+        }
+
+        now := time.Now()
+        end := now.AddDate(0, 0, plan.TrialDays)
+        sub := &models.Subscription{
+                UserID:             userID,
+                PlanID:             planID,
+                Status:             models.SubscriptionTrialing,
+                CurrentPeriodStart: now,
+                CurrentPeriodEnd:   &end,
+        }
+        if err := s.transition(sub, "", reasonTrial); err != nil {
+                return nil, err
         }
+        return sub, nil
 }
 
-func (s *SubscriptionService) ActivateSubscription(userID int, planID int) error {
+// ActivateSubscription records a payment for the plan. A subscription to the
+// same plan is renewed from the end of its current period, or from now once
+// that passed; a subscription to another plan is replaced. markPaid runs in
+// the same transaction, so the payment is marked paid exactly when the
+// subscription is activated; an error from it saves nothing.
+func (s *SubscriptionService) ActivateSubscription(userID int, planID int, markPaid func(*sql.Tx) error) error {
         plan, err := s.planRepo.GetByID(planID)
         if err != nil {
                 return err
         }
 
+        start := time.Now()
+        if sub, err := s.subs.GetLive(userID); err == nil && sub.PlanID == planID &&
+                sub.Status != models.SubscriptionPastDue && sub.Status != models.SubscriptionGrace &&
+                sub.CurrentPeriodEnd != nil && sub.CurrentPeriodEnd.After(start) {
+                start = *sub.CurrentPeriodEnd
+        }
+
         var expiresAt *time.Time
         if plan.DurationDays > 0 {
-                expiry := time.Now().AddDate(0, 0, plan.DurationDays)
+                expiry := start.AddDate(0, 0, plan.DurationDays)
                 expiresAt = &expiry
         }
 
-        return s.userRepo.UpdateSubscription(userID, planID, expiresAt)
+        return s.activate(userID, planID, expiresAt, reasonPayment, markPaid)
 }
 
-func (s *SubscriptionService) ExtendSubscription(userID int, days int) error {
-        user, err := s.userRepo.GetByTelegramID(int64(userID))
-        if err != nil {
+// GrantSubscription makes the user's subscription to the plan active until
+// expiresAt, or without an end when it is nil
+func (s *SubscriptionService) GrantSubscription(userID int, planID int, expiresAt *time.Time) error {
+        return s.activate(userID, planID, expiresAt, reasonGranted, nil)
+}
+
+func (s *SubscriptionService) activate(userID int, planID int, expiresAt *time.Time, reason string, prepare func(*sql.Tx) error) error {
+        sub, err := s.subs.GetLive(userID)
+        if err != nil && !errors.Is(err, sql.ErrNoRows) {
                 return err
         }
 
-        var newExpiresAt time.Time
-        if user.PlanExpiresAt != nil && user.PlanExpiresAt.After(time.Now()) {
-                // Extend from current expiry date
-                newExpiresAt = user.PlanExpiresAt.AddDate(0, 0, days)
+        // A subscription to another plan expires together with the new one starting
+        var changes []models.SubscriptionChange
+        replaced := sub != nil && sub.PlanID != planID
+        if replaced {
+                old := *sub
+                old.Status = models.SubscriptionExpired
+                changes = append(changes, models.SubscriptionChange{Subscription: &old, From: sub.Status, Reason: reasonPlanChanged})
+                sub = nil
+        }
+
+        from := ""
+        if sub == nil {
+                sub = &models.Subscription{UserID: userID, PlanID: planID, CurrentPeriodStart: time.Now()}
         } else {
-                // Extend from now
-                newExpiresAt = time.Now().AddDate(0, 0, days)
+                from = sub.Status
+                // A renewal of a running period keeps its start
+                if !models.HasAccess(sub.Status) || sub.Status == models.SubscriptionPastDue ||
+                        (sub.CurrentPeriodEnd != nil && !sub.CurrentPeriodEnd.After(time.Now())) {
+                        sub.CurrentPeriodStart = time.Now()
+                }
         }
+        sub.Status = models.SubscriptionActive
+        sub.CurrentPeriodEnd = expiresAt
+        sub.CanceledAt = nil
+        changes = append(changes, s.change(sub, from, reason))
 
-        return s.userRepo.UpdateSubscription(user.ID, user.CurrentPlanID, &newExpiresAt)
+        if err := s.subs.TransitionWith(prepare, changes...); err != nil {
+                return err
+        }
+
+        // Chats the new plan grants as well are kept
+        if replaced {
+                if err := s.access.End(userID); err != nil {
+                        log.Printf("Error ending chat access of user %d: %v", userID, err)
+                }
+        }
+        s.afterTransition(userID, sub.Status)
+        return nil
 }
 
-func (s *SubscriptionService) CheckSubscriptionStatus(userID int64) (*models.User, bool, error) {
-        user, err := s.userRepo.GetByTelegramID(userID)
-        if err != nil {
-                return nil, false, err
+// RefundSubscription takes back what a refunded payment for the plan paid
+// for: the user's subscription to the plan expires at once. markRefunded runs
+// in the same transaction, so the payment is marked refunded exactly when the
+// subscription ends; an error from it saves nothing.
+func (s *SubscriptionService) RefundSubscription(userID int, planID int, markRefunded func(*sql.Tx) error) error {
+        sub, err := s.subs.GetLive(userID)
+        if err != nil && !errors.Is(err, sql.ErrNoRows) {
+                return err
         }
 
-        isActive := true
-        if user.PlanExpiresAt != nil && user.PlanExpiresAt.Before(time.Now()) {
-                isActive = false
+        var changes []models.SubscriptionChange
+        if change, ok := s.refundChange(sub, planID); ok {
+                changes = append(changes, change)
+        }
+        if err := s.subs.TransitionWith(markRefunded, changes...); err != nil {
+                return err
         }
 
-        return user, isActive, nil
+        if len(changes) > 0 {
+                s.afterTransition(userID, models.SubscriptionExpired)
+        }
+        return nil
 }
 
-func (s *SubscriptionService) GetUserPlan(userID int64) (*models.SubscriptionPlan, error) {
-        user, err := s.userRepo.GetByTelegramID(userID)
+// refundChange expires sub when it is a subscription to the refunded plan; a
+// subscription to another plan was paid for by other payments
+func (s *SubscriptionService) refundChange(sub *models.Subscription, planID int) (models.SubscriptionChange, bool) {
+        if sub == nil || sub.PlanID != planID || sub.Status == models.SubscriptionExpired {
+                return models.SubscriptionChange{}, false
+        }
+        expired := *sub
+        expired.Status = models.SubscriptionExpired
+        return s.change(&expired, sub.Status, reasonRefunded), true
+}
+
+// CancelSubscription stops the renewal of the user's subscription. A trial or
+// a paid period keeps the plan until it ends; a subscription that is already
+// past its period, or has no end, expires at once.
+func (s *SubscriptionService) CancelSubscription(userID int) (*models.Subscription, error) {
+        sub, err := s.Subscription(userID)
         if err != nil {
                 return nil, err
         }
+        if sub.Status == models.SubscriptionCanceled {
+                return sub, nil
+        }
 
-        return s.planRepo.GetByID(user.CurrentPlanID)
+        from := sub.Status
+        if (from == models.SubscriptionTrialing || from == models.SubscriptionActive) && sub.CurrentPeriodEnd != nil {
+                now := time.Now()
+                sub.Status = models.SubscriptionCanceled
+                sub.CanceledAt = &now
+        } else {
+                sub.Status = models.SubscriptionExpired
+        }
+
+        if err := s.transition(sub, from, reasonCanceled); err != nil {
+                return nil, err
+        }
+        return sub, nil
 }
 
-func (s *SubscriptionService) CanUserAccessFeature(userID int64, feature string) (bool, error) {
-        user, isActive, err := s.CheckSubscriptionStatus(userID)
+// ExpireSubscription ends the user's subscription at once, moving them to
+// the free plan
+func (s *SubscriptionService) ExpireSubscription(userID int) error {
+        sub, err := s.Subscription(userID)
+        if errors.Is(err, ErrNoSubscription) {
+                // Nothing recorded; make sure the user is on the free plan
+                if err := s.userRepo.UpdateSubscription(userID, models.FreePlanID, nil); err != nil {
+                        return err
+                }
+                s.afterTransition(userID, models.SubscriptionExpired)
+                return nil
+        }
         if err != nil {
-                return false, err
+                return err
         }
 
-        if !isActive {
-                // User subscription is expired, check if they can access free features
-                freePlan, err := s.planRepo.GetByID(1) // Free plan
+        from := sub.Status
+        sub.Status = models.SubscriptionExpired
+        return s.transition(sub, from, reasonRevoked)
+}
+
+// AdvanceSubscriptions moves subscriptions whose status is over to the next
+// one and returns those it moved. A subscription that cannot be moved is
+// retried later, so it does not hold back the ones after it.
+func (s *SubscriptionService) AdvanceSubscriptions() ([]*models.Subscription, error) {
+        var advanced []*models.Subscription
+        for {
+                due, err := s.subs.ListDue(s.pastDue, s.grace, subscriptionBatch)
                 if err != nil {
-                        return false, err
+                        return advanced, err
                 }
 
-                if freePlan.Features != nil {
-                        if featureEnabled, exists := freePlan.Features[feature]; exists {
-                                return featureEnabled.(bool), nil
+                for _, sub := range due {
+                        moved, err := s.advance(sub)
+                        if err != nil {
+                                // The subscription would come back in the next batch
+                                return advanced, err
+                        }
+                        if moved {
+                                advanced = append(advanced, sub)
                         }
                 }
-                return false, nil
+
+                if len(due) < subscriptionBatch {
+                        return advanced, nil
+                }
         }
+}
 
-        plan, err := s.planRepo.GetByID(user.CurrentPlanID)
-        if err != nil {
-                return false, err
+// advance moves the subscription to its next status. When that fails the
+// subscription is held back for a while and advance reports it unmoved; the
+// error is one from holding it back.
+func (s *SubscriptionService) advance(sub *models.Subscription) (bool, error) {
+        from := sub.Status
+        reason := reasonPeriodEnded
+        switch from {
+        case models.SubscriptionTrialing, models.SubscriptionActive:
+                sub.Status = models.SubscriptionPastDue
+        case models.SubscriptionPastDue:
+                sub.Status = models.SubscriptionGrace
+                reason = reasonUnpaid
+        case models.SubscriptionGrace:
+                sub.Status = models.SubscriptionExpired
+                reason = reasonUnpaid
+        case models.SubscriptionCanceled:
+                sub.Status = models.SubscriptionExpired
         }
 
-        if plan.Features != nil {
-                if featureEnabled, exists := plan.Features[feature]; exists {
-                        return featureEnabled.(bool), nil
-                }
+        err := s.transition(sub, from, reason)
+        if err == nil {
+                return true, nil
         }
 
-        return false, nil
+        // Also after a concurrent change, which may have left it due; the
+        // retry reads it as it is then
+        delay := retryDelay(subscriptionRetry, subscriptionMaxRetry, sub.FailedAttempts)
+        log.Printf("Error moving subscription %d from %s to %s, retrying in %s: %v", sub.ID, from, sub.Status, delay, err)
+        return false, s.subs.MarkFailed(sub.ID, time.Now().Add(delay))
 }
 
-func (s *SubscriptionService) GetExpiredUsers() ([]models.User, error) {
-        return s.userRepo.GetExpiredSubscriptions()
+// transition saves the status change and applies it to the user's groups,
+// entitlements and plan chats
+func (s *SubscriptionService) transition(sub *models.Subscription, from, reason string) error {
+        if err := s.subs.Transition(s.change(sub, from, reason)); err != nil {
+                return err
+        }
+        s.afterTransition(sub.UserID, sub.Status)
+        return nil
 }
 
-func (s *SubscriptionService) GetUsersExpiringSoon(days int) ([]models.User, error) {
-        return s.userRepo.GetExpiringSoon(days)
+func (s *SubscriptionService) change(sub *models.Subscription, from, reason string) models.SubscriptionChange {
+        return models.SubscriptionChange{Subscription: sub, From: from, Reason: reason, AccessUntil: s.accessUntil(sub)}
 }
 
-func (s *SubscriptionService) ProcessExpiredSubscriptions() error {
-        expiredUsers, err := s.GetExpiredUsers()
-        if err != nil {
-                return err
+// accessUntil is when the user loses the plan: the end of the period, and
+// pastDue after it while the renewal is past due
+func (s *SubscriptionService) accessUntil(sub *models.Subscription) *time.Time {
+        if sub.CurrentPeriodEnd == nil || !models.HasAccess(sub.Status) {
+                return nil
         }
+        until := *sub.CurrentPeriodEnd
+        if sub.Status == models.SubscriptionPastDue {
+                until = until.Add(s.pastDue)
+        }
+        return &until
+}
 
-        for _, user := range expiredUsers {
-                // Reset to free plan
-                err = s.userRepo.UpdateSubscription(user.ID, 1, nil)
-                if err != nil {
-                        continue // Continue with other users
+func (s *SubscriptionService) afterTransition(userID int, status string) {
+        s.entitlements.Invalidate(userID)
+        if err := s.groups.Enforce(userID); err != nil {
+                log.Printf("Error enforcing the group limit of user %d: %v", userID, err)
+        }
+
+        // Without the plan, in grace as well, members leave the plan's chats
+        // once the chat access grace is over
+        if !models.HasAccess(status) {
+                if err := s.access.End(userID); err != nil {
+                        log.Printf("Error ending chat access of user %d: %v", userID, err)
                 }
         }
+}
 
-        return nil
+// CheckSubscriptionStatus returns the user and their subscription if it gives
+// them its plan; the subscription is nil when they have none or it lapsed
+// into grace
+func (s *SubscriptionService) CheckSubscriptionStatus(userID int64) (*models.User, *models.Subscription, error) {
+        user, err := s.userRepo.GetByTelegramID(userID)
+        if err != nil {
+                return nil, nil, err
+        }
+
+        sub, err := s.Subscription(user.ID)
+        if errors.Is(err, ErrNoSubscription) {
+                return user, nil, nil
+        }
+        if err != nil {
+                return nil, nil, err
+        }
+        if !models.HasAccess(sub.Status) {
+                return user, nil, nil
+        }
+        return user, sub, nil
+}
+
+func (s *SubscriptionService) GetUserPlan(userID int64) (*models.SubscriptionPlan, error) {
+        user, err := s.userRepo.GetByTelegramID(userID)
+        if err != nil {
+                return nil, err
+        }
+
+        return s.planRepo.GetByID(user.CurrentPlanID)
+}
+
+// Entitlements returns what the user's plan entitles them to, or the free
+// plan once it expired
+func (s *SubscriptionService) Entitlements(userID int) (*models.Entitlements, error) {
+        return s.entitlements.ForUser(userID)
+}
+
+func (s *SubscriptionService) GetUsersExpiringSoon(days int) ([]models.User, error) {
+        return s.userRepo.GetExpiringSoon(days)
 }
 
 func (s *SubscriptionService) GetSubscriptionStats() (map[string]interface{}, error) {
@@ -191,6 +484,9 @@ func (s *SubscriptionService) CreatePlan(name string, priceCents int, durationDa
                 Currency:     "USD",
                 IsActive:     true,
         }
+        if err := plan.ValidateFeatures(); err != nil {
+                return err
+        }
 
         return s.planRepo.Create(plan)
 }
@@ -216,6 +512,9 @@ func (s *SubscriptionService) UpdatePlan(planID int, updates map[string]interfac
         }
         if features, exists := updates["features"]; exists {
                 plan.Features = features.(map[string]interface{})
+                if err := plan.ValidateFeatures(); err != nil {
+                        return err
+                }
         }
         if currency, exists := updates["currency"]; exists {
                 plan.Currency = currency.(string)
@@ -224,7 +523,13 @@ func (s *SubscriptionService) UpdatePlan(planID int, updates map[string]interfac
                 plan.IsActive = isActive.(bool)
         }
 
-        return s.planRepo.Update(plan)
+        if err := s.planRepo.Update(plan); err != nil {
+                return err
+        }
+
+        // Subscribers of the plan get its new features and group limit
+        s.entitlements.InvalidateAll()
+        return s.groups.Enforce(0)
 }
 
 func (s *SubscriptionService) DeletePlan(planID int) error {
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"telegram-subscription-bot/models"
+)
+
+func TestRefundChange(t *testing.T) {
+	end := time.Now().Add(20 * 24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		sub    *models.Subscription
+		wantOK bool
+	}{
+		{name: "no subscription"},
+		{
+			name:   "active subscription to the plan",
+			sub:    &models.Subscription{ID: 1, UserID: 7, PlanID: 2, Status: models.SubscriptionActive, CurrentPeriodEnd: &end},
+			wantOK: true,
+		},
+		{
+			name:   "past due subscription to the plan",
+			sub:    &models.Subscription{ID: 1, UserID: 7, PlanID: 2, Status: models.SubscriptionPastDue, CurrentPeriodEnd: &end},
+			wantOK: true,
+		},
+		{
+			name:   "canceled subscription to the plan",
+			sub:    &models.Subscription{ID: 1, UserID: 7, PlanID: 2, Status: models.SubscriptionCanceled, CurrentPeriodEnd: &end},
+			wantOK: true,
+		},
+		{
+			name: "subscription to another plan",
+			sub:  &models.Subscription{ID: 1, UserID: 7, PlanID: 3, Status: models.SubscriptionActive, CurrentPeriodEnd: &end},
+		},
+		{
+			name: "subscription already expired",
+			sub:  &models.Subscription{ID: 1, UserID: 7, PlanID: 2, Status: models.SubscriptionExpired, CurrentPeriodEnd: &end},
+		},
+	}
+
+	s := &SubscriptionService{pastDue: 3 * 24 * time.Hour}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var from string
+			if tt.sub != nil {
+				from = tt.sub.Status
+			}
+
+			change, ok := s.refundChange(tt.sub, 2)
+			if ok != tt.wantOK {
+				t.Fatalf("refundChange() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			if tt.sub.Status != from {
+				t.Errorf("refundChange() changed the subscription it was given to %s", tt.sub.Status)
+			}
+			if got := change.Subscription.Status; got != models.SubscriptionExpired {
+				t.Errorf("status = %s, want %s", got, models.SubscriptionExpired)
+			}
+			if change.From != from || !models.CanTransition(change.From, change.Subscription.Status) {
+				t.Errorf("change from %q, want an allowed change from %q", change.From, from)
+			}
+			if change.Reason != reasonRefunded {
+				t.Errorf("reason = %q, want %q", change.Reason, reasonRefunded)
+			}
+			if change.AccessUntil != nil {
+				t.Errorf("access until %v, want none", change.AccessUntil)
+			}
+			if models.HasAccess(change.Subscription.Status) {
+				t.Error("the refunded subscription keeps its plan and chats")
+			}
+		})
+	}
+}
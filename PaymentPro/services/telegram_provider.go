@@ -0,0 +1,82 @@
+package services
+
+import (
+        "fmt"
+        "net/http"
+
+        tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+        "telegram-subscription-bot/models"
+)
+
+// telegramInvoicePrefix starts the payload of every invoice we send; the rest
+// is the payments.id the invoice collects.
+const telegramInvoicePrefix = "payment_"
+
+func init() {
+        RegisterPaymentProvider("telegram", newTelegramProvider)
+}
+
+// telegramProvider sends native Telegram invoices. The outcome arrives as bot
+// updates (pre_checkout_query, successful_payment), not as webhooks.
+type telegramProvider struct {
+        bot           *tgbotapi.BotAPI
+        providerToken string
+}
+
+func newTelegramProvider(settings *models.PaymentProviderConfig, deps ProviderDeps) (PaymentProvider, error) {
+        if deps.Bot == nil {
+                return nil, fmt.Errorf("bot is not available")
+        }
+        if deps.Config.TelegramPaymentToken == "" {
+                return nil, fmt.Errorf("TELEGRAM_PAYMENT_PROVIDER_TOKEN is not set")
+        }
+
+        return &telegramProvider{
+                bot:           deps.Bot,
+                providerToken: deps.Config.TelegramPaymentToken,
+        }, nil
+}
+
+func (p *telegramProvider) Method() string {
+        return "telegram"
+}
+
+func (p *telegramProvider) CreateCheckout(req *CheckoutRequest) (*Checkout, error) {
+        if req.ChatID == 0 {
+                return nil, fmt.Errorf("telegram invoices need a chat to be sent to")
+        }
+
+        invoice := tgbotapi.NewInvoice(
+                req.ChatID,
+                fmt.Sprintf("Подписка %s", req.Plan.Name),
+                fmt.Sprintf("Подписка на %d дней", req.Plan.DurationDays),
+                fmt.Sprintf("%s%d", telegramInvoicePrefix, req.Payment.ID),
+                p.providerToken,
+                fmt.Sprintf("pay_%d", req.Plan.ID),
+                req.Payment.Currency,
+                []tgbotapi.LabeledPrice{
+                        {Label: req.Plan.Name, Amount: req.Payment.Amount},
+                },
+        )
+
+        if _, err := p.bot.Send(invoice); err != nil {
+                return nil, err
+        }
+
+        return &Checkout{}, nil
+}
+
+func (p *telegramProvider) ParseWebhook(r *http.Request, payload []byte) (*WebhookEvent, error) {
+        return nil, ErrWebhooksNotSupported
+}
+
+// Refund is not offered: card payments made through Telegram are refunded in
+// the dashboard of the provider connected in BotFather.
+func (p *telegramProvider) Refund(payment *models.Payment) error {
+        return ErrRefundNotSupported
+}
+
+// FetchStatus returns the stored status; Telegram has no API to look payments up
+func (p *telegramProvider) FetchStatus(payment *models.Payment) (string, error) {
+        return payment.Status, nil
+}
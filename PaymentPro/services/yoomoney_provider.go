@@ -0,0 +1,213 @@
+package services
+
+import (
+        "bytes"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "net/url"
+        "strconv"
+
+        "telegram-subscription-bot/models"
+)
+
+func init() {
+        RegisterPaymentProvider("yoomoney", newYooMoneyProvider)
+}
+
+// yooMoneyProvider uses the YooKassa payments API with redirect confirmation.
+// With YOOMONEY_SHOP_ID set it authenticates with the shop ID and secret key,
+// otherwise the secret key is sent as an OAuth token.
+type yooMoneyProvider struct {
+        apiURL        string
+        shopID        string
+        secretKey     string
+        webhookSecret string
+        returnURL     string
+}
+
+func newYooMoneyProvider(settings *models.PaymentProviderConfig, deps ProviderDeps) (PaymentProvider, error) {
+        if deps.Config.YooMoneySecretKey == "" {
+                return nil, fmt.Errorf("YOOMONEY_SECRET_KEY is not set")
+        }
+
+        webhookSecret := deps.Config.YooMoneyWebhookSecret
+        if settings.WebhookSecret != "" {
+                webhookSecret = settings.WebhookSecret
+        }
+
+        return &yooMoneyProvider{
+                apiURL:        configString(settings, "api_url", "https://api.yookassa.ru/v3"),
+                shopID:        deps.Config.YooMoneyShopID,
+                secretKey:     deps.Config.YooMoneySecretKey,
+                webhookSecret: webhookSecret,
+                returnURL:     configString(settings, "return_url", deps.Config.Domain+"/payment/success"),
+        }, nil
+}
+
+func (p *yooMoneyProvider) Method() string {
+        return "yoomoney"
+}
+
+func (p *yooMoneyProvider) CreateCheckout(req *CheckoutRequest) (*Checkout, error) {
+        body := map[string]interface{}{
+                "amount":  yooMoneyAmount(req.Payment),
+                "capture": true,
+                "confirmation": map[string]interface{}{
+                        "type":       "redirect",
+                        "return_url": p.returnURL,
+                },
+                "description": req.Plan.Name,
+                "metadata": map[string]interface{}{
+                        "payment_id": strconv.FormatInt(req.Payment.ID, 10),
+                },
+        }
+
+        var payment yooMoneyPayment
+        if err := p.do("POST", "/payments", fmt.Sprintf("payment_%d", req.Payment.ID), body, &payment); err != nil {
+                return nil, err
+        }
+
+        return &Checkout{
+                TransactionID: payment.ID,
+                URL:           payment.Confirmation.ConfirmationURL,
+        }, nil
+}
+
+func (p *yooMoneyProvider) ParseWebhook(r *http.Request, payload []byte) (*WebhookEvent, error) {
+        var notification yooMoneyNotification
+        if err := json.Unmarshal(payload, &notification); err != nil {
+                return nil, err
+        }
+        if notification.Object.ID == "" {
+                return nil, fmt.Errorf("yoomoney notification without object id")
+        }
+
+        if !p.verifySignature(notification, r.Header.Get("X-Signature")) {
+                return nil, ErrInvalidWebhookSignature
+        }
+
+        // YooMoney notifications carry no delivery ID, but a payment object only
+        // reaches each final state once, so object ID + event is stable across retries.
+        event := &WebhookEvent{
+                ID:            notification.Object.ID + ":" + notification.Event,
+                Type:          notification.Event,
+                TransactionID: notification.Object.ID,
+        }
+        event.PaymentID, _ = strconv.ParseInt(notification.Object.Metadata["payment_id"], 10, 64)
+
+        switch notification.Event {
+        case "payment.succeeded":
+                event.Status = "completed"
+        case "payment.canceled":
+                event.Status = "cancelled"
+        }
+
+        return event, nil
+}
+
+func (p *yooMoneyProvider) Refund(payment *models.Payment) error {
+        if payment.TransactionID == "" {
+                return fmt.Errorf("payment has no yoomoney payment id")
+        }
+
+        body := map[string]interface{}{
+                "payment_id": payment.TransactionID,
+                "amount":     yooMoneyAmount(payment),
+        }
+
+        return p.do("POST", "/refunds", fmt.Sprintf("refund_%d", payment.ID), body, nil)
+}
+
+func (p *yooMoneyProvider) FetchStatus(payment *models.Payment) (string, error) {
+        if payment.TransactionID == "" {
+                return "", fmt.Errorf("payment has no yoomoney payment id")
+        }
+
+        var remote yooMoneyPayment
+        if err := p.do("GET", "/payments/"+url.PathEscape(payment.TransactionID), "", nil, &remote); err != nil {
+                return "", err
+        }
+
+        switch remote.Status {
+        case "succeeded":
+                return "completed", nil
+        case "canceled":
+                return "cancelled", nil
+        }
+        return payment.Status, nil
+}
+
+func (p *yooMoneyProvider) verifySignature(notification yooMoneyNotification, signature string) bool {
+        if p.webhookSecret == "" {
+                return false
+        }
+
+        data := fmt.Sprintf("%s&%s&%s", notification.Event, notification.Object.ID, p.webhookSecret)
+        mac := hmac.New(sha256.New, []byte(p.webhookSecret))
+        mac.Write([]byte(data))
+        expected := hex.EncodeToString(mac.Sum(nil))
+
+        return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// do calls the API. idempotenceKey makes retried POSTs safe; YooKassa keeps
+// it for 24 hours, so a key derived from our payment ID is enough.
+func (p *yooMoneyProvider) do(method, path, idempotenceKey string, body interface{}, out interface{}) error {
+        var payload []byte
+        if body != nil {
+                var err error
+                if payload, err = json.Marshal(body); err != nil {
+                        return err
+                }
+        }
+
+        req, err := http.NewRequest(method, p.apiURL+path, bytes.NewReader(payload))
+        if err != nil {
+                return err
+        }
+
+        if p.shopID != "" {
+                req.SetBasicAuth(p.shopID, p.secretKey)
+        } else {
+                req.Header.Set("Authorization", "Bearer "+p.secretKey)
+        }
+        req.Header.Set("Content-Type", "application/json")
+        if idempotenceKey != "" {
+                req.Header.Set("Idempotence-Key", idempotenceKey)
+        }
+
+        return doProviderRequest(req, out)
+}
+
+func yooMoneyAmount(payment *models.Payment) map[string]interface{} {
+        return map[string]interface{}{
+                "value":    fmt.Sprintf("%.2f", float64(payment.Amount)/100),
+                "currency": payment.Currency,
+        }
+}
+
+type yooMoneyPayment struct {
+        ID     string `json:"id"`
+        Status string `json:"status"`
+        Amount struct {
+                Value    string `json:"value"`
+                Currency string `json:"currency"`
+        } `json:"amount"`
+        Confirmation struct {
+                Type            string `json:"type"`
+                ConfirmationURL string `json:"confirmation_url"`
+        } `json:"confirmation"`
+}
+
+type yooMoneyNotification struct {
+        Event  string `json:"event"`
+        Object struct {
+                ID       string            `json:"id"`
+                Status   string            `json:"status"`
+                Metadata map[string]string `json:"metadata"`
+        } `json:"object"`
+}
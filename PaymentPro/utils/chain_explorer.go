@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChainTransfer is one payment received by an address on chain
+type ChainTransfer struct {
+	// TxID is unique per transfer: the transaction hash, followed by
+	// ":<log index>" for token transfers, which can share a transaction.
+	TxID          string
+	Asset         string
+	Address       string
+	Amount        *big.Int // in the smallest unit: satoshi, wei, token base unit
+	Confirmations int
+	SeenAt        time.Time // block time, zero while unconfirmed
+}
+
+// ChainExplorer looks up the transfers an address has received
+type ChainExplorer interface {
+	IncomingTransfers(asset, address string) ([]ChainTransfer, error)
+}
+
+var assetDecimals = map[string]int{
+	"BTC":  8,
+	"ETH":  18,
+	"USDT": 6,
+}
+
+// AssetDecimals returns the number of decimals of the asset's smallest unit
+func AssetDecimals(asset string) (int, bool) {
+	decimals, ok := assetDecimals[strings.ToUpper(asset)]
+	return decimals, ok
+}
+
+// ParseAssetAmount converts a decimal amount such as "0.00125" into the
+// asset's smallest unit. Digits beyond the asset's precision are an error.
+func ParseAssetAmount(amount, asset string) (*big.Int, error) {
+	decimals, ok := AssetDecimals(asset)
+	if !ok {
+		return nil, fmt.Errorf("unsupported cryptocurrency: %s", asset)
+	}
+
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok || value.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s amount: %q", asset, amount)
+	}
+
+	value.Mul(value, new(big.Rat).SetInt(pow10(decimals)))
+	if !value.IsInt() {
+		return nil, fmt.Errorf("%s amount %q has more than %d decimals", asset, amount, decimals)
+	}
+
+	return new(big.Int).Set(value.Num()), nil
+}
+
+// FormatAssetAmount is the inverse of ParseAssetAmount
+func FormatAssetAmount(amount *big.Int, asset string) string {
+	decimals, ok := AssetDecimals(asset)
+	if !ok || amount == nil {
+		return ""
+	}
+	return new(big.Rat).SetFrac(amount, pow10(decimals)).FloatString(decimals)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// getJSON fetches url and decodes the JSON response into out
+func getJSON(client *http.Client, url string, out interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, out)
+}
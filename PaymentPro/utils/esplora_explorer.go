@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EsploraExplorer reads BTC transfers from an Esplora HTTP API, such as
+// https://blockstream.info/api or a self-hosted electrs/esplora instance.
+type EsploraExplorer struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewEsploraExplorer(baseURL string) *EsploraExplorer {
+	return &EsploraExplorer{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+type esploraTx struct {
+	TxID   string `json:"txid"`
+	Status struct {
+		Confirmed   bool  `json:"confirmed"`
+		BlockHeight int64 `json:"block_height"`
+		BlockTime   int64 `json:"block_time"`
+	} `json:"status"`
+	Vout []struct {
+		Address string `json:"scriptpubkey_address"`
+		Value   int64  `json:"value"`
+	} `json:"vout"`
+}
+
+// IncomingTransfers returns the outputs paying to address among the address's
+// most recent transactions (Esplora returns up to 50, mempool included).
+func (e *EsploraExplorer) IncomingTransfers(asset, address string) ([]ChainTransfer, error) {
+	if strings.ToUpper(asset) != "BTC" {
+		return nil, fmt.Errorf("esplora explorer does not support %s", asset)
+	}
+
+	var txs []esploraTx
+	if err := getJSON(e.client, fmt.Sprintf("%s/address/%s/txs", e.baseURL, address), &txs); err != nil {
+		return nil, err
+	}
+
+	tip, err := e.tipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []ChainTransfer
+	for _, tx := range txs {
+		var received int64
+		for _, out := range tx.Vout {
+			if out.Address == address {
+				received += out.Value
+			}
+		}
+		if received == 0 {
+			continue
+		}
+
+		transfer := ChainTransfer{
+			TxID:    tx.TxID,
+			Asset:   "BTC",
+			Address: address,
+			Amount:  big.NewInt(received),
+		}
+		if tx.Status.Confirmed {
+			transfer.Confirmations = int(tip - tx.Status.BlockHeight + 1)
+			transfer.SeenAt = time.Unix(tx.Status.BlockTime, 0)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+func (e *EsploraExplorer) tipHeight() (int64, error) {
+	resp, err := e.client.Get(e.baseURL + "/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("tip height request returned %d", resp.StatusCode)
+	}
+
+	var height int64
+	if _, err := fmt.Fscan(resp.Body, &height); err != nil {
+		return 0, fmt.Errorf("failed to read tip height: %w", err)
+	}
+
+	return height, nil
+}
@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// erc20TransferTopic is keccak256("Transfer(address,address,uint256)")
+const erc20TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// maxBlocksPerSync bounds the blocks fetched in one call, so a node that was
+// unreachable for a while is caught up over several polls.
+const maxBlocksPerSync = 200
+
+// EthereumExplorer reads ETH and ERC-20 transfers from a JSON-RPC node.
+//
+// Plain JSON-RPC has no index of transactions by address, so native ETH
+// transfers are found by scanning the last lookback blocks and keeping the
+// value transfers in memory. Token transfers are looked up with eth_getLogs.
+type EthereumExplorer struct {
+	rpcURL   string
+	tokens   map[string]string // asset -> contract address
+	lookback uint64
+	client   *http.Client
+
+	mu         sync.Mutex
+	nextBlock  uint64
+	transfers  map[string][]ethTransfer // lowercase recipient -> transfers
+	blockTimes map[uint64]time.Time
+}
+
+type ethTransfer struct {
+	txID   string
+	amount *big.Int
+	block  uint64
+}
+
+func NewEthereumExplorer(rpcURL string, tokens map[string]string, lookback int) *EthereumExplorer {
+	normalized := make(map[string]string)
+	for asset, contract := range tokens {
+		if contract != "" {
+			normalized[strings.ToUpper(asset)] = strings.ToLower(contract)
+		}
+	}
+
+	return &EthereumExplorer{
+		rpcURL:   rpcURL,
+		tokens:   normalized,
+		lookback: uint64(lookback),
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+		transfers:  make(map[string][]ethTransfer),
+		blockTimes: make(map[uint64]time.Time),
+	}
+}
+
+func (e *EthereumExplorer) IncomingTransfers(asset, address string) ([]ChainTransfer, error) {
+	asset = strings.ToUpper(asset)
+	address = strings.ToLower(address)
+
+	tip, err := e.blockNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	if asset == "ETH" {
+		return e.nativeTransfers(address, tip)
+	}
+
+	contract, ok := e.tokens[asset]
+	if !ok {
+		return nil, fmt.Errorf("ethereum explorer does not support %s", asset)
+	}
+	return e.tokenTransfers(asset, contract, address, tip)
+}
+
+func (e *EthereumExplorer) nativeTransfers(address string, tip uint64) ([]ChainTransfer, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.sync(tip); err != nil {
+		return nil, err
+	}
+
+	var transfers []ChainTransfer
+	for _, transfer := range e.transfers[address] {
+		transfers = append(transfers, ChainTransfer{
+			TxID:          transfer.txID,
+			Asset:         "ETH",
+			Address:       address,
+			Amount:        transfer.amount,
+			Confirmations: int(tip - transfer.block + 1),
+			SeenAt:        e.blockTimes[transfer.block],
+		})
+	}
+
+	return transfers, nil
+}
+
+// sync scans the blocks up to tip that were not scanned yet and forgets the
+// ones that fell out of the lookback window. Callers hold e.mu.
+func (e *EthereumExplorer) sync(tip uint64) error {
+	start := e.windowStart(tip)
+	if e.nextBlock > start {
+		start = e.nextBlock
+	}
+
+	end := tip
+	if end >= start && end-start+1 > maxBlocksPerSync {
+		end = start + maxBlocksPerSync - 1
+	}
+
+	for number := start; number <= end; number++ {
+		var block ethBlock
+		if err := e.call("eth_getBlockByNumber", []interface{}{hexUint(number), true}, &block); err != nil {
+			return err
+		}
+
+		e.blockTimes[number] = time.Unix(int64(parseHexUint(block.Timestamp)), 0)
+		for _, tx := range block.Transactions {
+			value, ok := new(big.Int).SetString(strings.TrimPrefix(tx.Value, "0x"), 16)
+			if !ok || value.Sign() == 0 || tx.To == "" {
+				continue
+			}
+			to := strings.ToLower(tx.To)
+			e.transfers[to] = append(e.transfers[to], ethTransfer{txID: tx.Hash, amount: value, block: number})
+		}
+		e.nextBlock = number + 1
+	}
+
+	oldest := e.windowStart(tip)
+	for address, transfers := range e.transfers {
+		kept := transfers[:0]
+		for _, transfer := range transfers {
+			if transfer.block >= oldest {
+				kept = append(kept, transfer)
+			}
+		}
+		if len(kept) == 0 {
+			delete(e.transfers, address)
+		} else {
+			e.transfers[address] = kept
+		}
+	}
+	for number := range e.blockTimes {
+		if number < oldest {
+			delete(e.blockTimes, number)
+		}
+	}
+
+	return nil
+}
+
+func (e *EthereumExplorer) windowStart(tip uint64) uint64 {
+	if tip < e.lookback {
+		return 0
+	}
+	return tip - e.lookback + 1
+}
+
+func (e *EthereumExplorer) tokenTransfers(asset, contract, address string, tip uint64) ([]ChainTransfer, error) {
+	filter := map[string]interface{}{
+		"fromBlock": hexUint(e.windowStart(tip)),
+		"toBlock":   hexUint(tip),
+		"address":   contract,
+		"topics":    []interface{}{erc20TransferTopic, nil, "0x000000000000000000000000" + strings.TrimPrefix(address, "0x")},
+	}
+
+	var logs []ethLog
+	if err := e.call("eth_getLogs", []interface{}{filter}, &logs); err != nil {
+		return nil, err
+	}
+
+	var transfers []ChainTransfer
+	for _, entry := range logs {
+		if entry.Removed {
+			continue
+		}
+
+		amount, ok := new(big.Int).SetString(strings.TrimPrefix(entry.Data, "0x"), 16)
+		if !ok {
+			continue
+		}
+
+		block := parseHexUint(entry.BlockNumber)
+		seenAt, err := e.blockTime(block)
+		if err != nil {
+			return nil, err
+		}
+
+		transfers = append(transfers, ChainTransfer{
+			TxID:          fmt.Sprintf("%s:%d", entry.TransactionHash, parseHexUint(entry.LogIndex)),
+			Asset:         asset,
+			Address:       address,
+			Amount:        amount,
+			Confirmations: int(tip - block + 1),
+			SeenAt:        seenAt,
+		})
+	}
+
+	return transfers, nil
+}
+
+func (e *EthereumExplorer) blockTime(number uint64) (time.Time, error) {
+	e.mu.Lock()
+	seenAt, ok := e.blockTimes[number]
+	e.mu.Unlock()
+	if ok {
+		return seenAt, nil
+	}
+
+	var block ethBlock
+	if err := e.call("eth_getBlockByNumber", []interface{}{hexUint(number), false}, &block); err != nil {
+		return time.Time{}, err
+	}
+	seenAt = time.Unix(int64(parseHexUint(block.Timestamp)), 0)
+
+	e.mu.Lock()
+	e.blockTimes[number] = seenAt
+	e.mu.Unlock()
+
+	return seenAt, nil
+}
+
+func (e *EthereumExplorer) blockNumber() (uint64, error) {
+	var number string
+	if err := e.call("eth_blockNumber", []interface{}{}, &number); err != nil {
+		return 0, err
+	}
+	return parseHexUint(number), nil
+}
+
+type ethBlock struct {
+	Timestamp    string `json:"timestamp"`
+	Transactions []struct {
+		Hash  string `json:"hash"`
+		To    string `json:"to"`
+		Value string `json:"value"`
+	} `json:"transactions"`
+}
+
+type ethLog struct {
+	TransactionHash string `json:"transactionHash"`
+	LogIndex        string `json:"logIndex"`
+	BlockNumber     string `json:"blockNumber"`
+	Data            string `json:"data"`
+	Removed         bool   `json:"removed"`
+}
+
+func (e *EthereumExplorer) call(method string, params []interface{}, out interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.rpcURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("%s: invalid response (HTTP %d)", method, resp.StatusCode)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s (%d)", method, response.Error.Message, response.Error.Code)
+	}
+	if len(response.Result) == 0 || string(response.Result) == "null" {
+		return fmt.Errorf("%s: empty result", method)
+	}
+
+	return json.Unmarshal(response.Result, out)
+}
+
+func hexUint(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+func parseHexUint(s string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	return n
+}
@@ -0,0 +1,440 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+)
+
+// AddressKind is the address format derived from an extended public key
+type AddressKind int
+
+const (
+	AddressP2PKH       AddressKind = iota // legacy, xpub/tpub
+	AddressP2SHP2WPKH                     // nested segwit, ypub/upub
+	AddressP2WPKH                         // native segwit, zpub/vpub
+	AddressEthereum                       // for keys of the Ethereum coin type
+)
+
+var xpubVersions = map[string]struct {
+	kind    AddressKind
+	testnet bool
+}{
+	"0488b21e": {AddressP2PKH, false},      // xpub
+	"049d7cb2": {AddressP2SHP2WPKH, false}, // ypub
+	"04b24746": {AddressP2WPKH, false},     // zpub
+	"043587cf": {AddressP2PKH, true},       // tpub
+	"044a5262": {AddressP2SHP2WPKH, true},  // upub
+	"045f1cd6": {AddressP2WPKH, true},      // vpub
+}
+
+var xprvVersions = []string{"0488ade4", "049d7878", "04b2430c", "04358394", "044a4e28", "045f18bc"}
+
+// ErrInvalidChild is returned for the rare indexes (about 1 in 2^127) that
+// BIP32 defines no key for; callers move on to the next index.
+var ErrInvalidChild = errors.New("no valid key at this index")
+
+// ExtendedPublicKey is a watch-only BIP32 node. It can derive the public keys
+// and addresses of its non-hardened children but cannot spend from them.
+type ExtendedPublicKey struct {
+	Kind      AddressKind
+	Testnet   bool
+	Depth     byte
+	ChainCode []byte
+	Key       []byte // compressed public key
+}
+
+// ParseExtendedPublicKey decodes an xpub/ypub/zpub (or testnet tpub/upub/vpub)
+// as exported by wallets for an account, e.g. m/84'/0'/0'.
+func ParseExtendedPublicKey(encoded string) (*ExtendedPublicKey, error) {
+	data, err := base58CheckDecode(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 78 {
+		return nil, fmt.Errorf("extended key has %d bytes, expected 78", len(data))
+	}
+
+	version := hex.EncodeToString(data[:4])
+	for _, private := range xprvVersions {
+		if version == private {
+			return nil, fmt.Errorf("this is an extended private key; configure the account's public key (xpub) instead")
+		}
+	}
+
+	format, ok := xpubVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown extended key version %s", version)
+	}
+
+	key := data[45:78]
+	if _, _, err := decompressPoint(key); err != nil {
+		return nil, err
+	}
+
+	return &ExtendedPublicKey{
+		Kind:      format.kind,
+		Testnet:   format.testnet,
+		Depth:     data[4],
+		ChainCode: append([]byte(nil), data[13:45]...),
+		Key:       append([]byte(nil), key...),
+	}, nil
+}
+
+// Child derives the non-hardened child at index (BIP32 CKDpub)
+func (k *ExtendedPublicKey) Child(index uint32) (*ExtendedPublicKey, error) {
+	if index >= 0x80000000 {
+		return nil, fmt.Errorf("hardened child %d cannot be derived from a public key", index)
+	}
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(k.Key)
+	binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(secp256k1N) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	px, py, err := decompressPoint(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	tx, ty := scalarBaseMult(tweak)
+	cx, cy := addPoints(tx, ty, px, py)
+	if cx == nil {
+		return nil, ErrInvalidChild
+	}
+
+	return &ExtendedPublicKey{
+		Kind:      k.Kind,
+		Testnet:   k.Testnet,
+		Depth:     k.Depth + 1,
+		ChainCode: sum[32:],
+		Key:       compressPoint(cx, cy),
+	}, nil
+}
+
+// DepositKey derives the key of the external chain at index, i.e. the
+// .../0/index path of BIP44 below the account key.
+func (k *ExtendedPublicKey) DepositKey(index uint32) (*ExtendedPublicKey, error) {
+	external, err := k.Child(0)
+	if err != nil {
+		return nil, err
+	}
+	return external.Child(index)
+}
+
+// Address encodes the key in its kind's address format
+func (k *ExtendedPublicKey) Address() (string, error) {
+	switch k.Kind {
+	case AddressP2PKH:
+		return base58CheckEncode(append([]byte{k.prefix(0x00, 0x6f)}, hash160(k.Key)...)), nil
+	case AddressP2SHP2WPKH:
+		redeemScript := append([]byte{0x00, 0x14}, hash160(k.Key)...)
+		return base58CheckEncode(append([]byte{k.prefix(0x05, 0xc4)}, hash160(redeemScript)...)), nil
+	case AddressP2WPKH:
+		hrp := "bc"
+		if k.Testnet {
+			hrp = "tb"
+		}
+		return segwitAddress(hrp, 0, hash160(k.Key))
+	case AddressEthereum:
+		return ethereumAddress(k.Key)
+	}
+	return "", fmt.Errorf("unknown address kind %d", k.Kind)
+}
+
+func (k *ExtendedPublicKey) prefix(mainnet, testnet byte) byte {
+	if k.Testnet {
+		return testnet
+	}
+	return mainnet
+}
+
+func hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
+	ripe := ripemd160.New()
+	ripe.Write(sha[:])
+	return ripe.Sum(nil)
+}
+
+// ethereumAddress is the EIP-55 checksummed address of a compressed public key
+func ethereumAddress(compressed []byte) (string, error) {
+	x, y, err := decompressPoint(compressed)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(leftPad(x.Bytes(), 32))
+	hash.Write(leftPad(y.Bytes(), 32))
+	return ChecksumEthereumAddress(hex.EncodeToString(hash.Sum(nil)[12:])), nil
+}
+
+// ChecksumEthereumAddress applies the EIP-55 mixed-case checksum
+func ChecksumEthereumAddress(address string) string {
+	address = strings.ToLower(strings.TrimPrefix(address, "0x"))
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(address))
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	var out strings.Builder
+	out.WriteString("0x")
+	for i, c := range address {
+		if c >= 'a' && c <= 'f' && digest[i] >= '8' {
+			out.WriteRune(c - 'a' + 'A')
+		} else {
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+// secp256k1 curve parameters
+var (
+	secp256k1P, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secp256k1N, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secp256k1Gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secp256k1Gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+)
+
+// addPoints adds two affine points; nil stands for the point at infinity
+func addPoints(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if x1 == nil {
+		return x2, y2
+	}
+	if x2 == nil {
+		return x1, y1
+	}
+
+	p := secp256k1P
+	var slope *big.Int
+	if x1.Cmp(x2) == 0 {
+		if new(big.Int).Add(y1, y2).Mod(new(big.Int).Add(y1, y2), p).Sign() == 0 {
+			return nil, nil
+		}
+		// (3x^2) / (2y)
+		numerator := new(big.Int).Mul(x1, x1)
+		numerator.Mul(numerator, big.NewInt(3))
+		denominator := new(big.Int).Lsh(y1, 1)
+		slope = numerator.Mul(numerator, denominator.ModInverse(denominator.Mod(denominator, p), p))
+	} else {
+		// (y2 - y1) / (x2 - x1)
+		numerator := new(big.Int).Sub(y2, y1)
+		denominator := new(big.Int).Sub(x2, x1)
+		slope = numerator.Mul(numerator, denominator.ModInverse(denominator.Mod(denominator, p), p))
+	}
+	slope.Mod(slope, p)
+
+	x3 := new(big.Int).Mul(slope, slope)
+	x3.Sub(x3, x1).Sub(x3, x2).Mod(x3, p)
+
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, slope).Sub(y3, y1).Mod(y3, p)
+
+	return x3, y3
+}
+
+func scalarBaseMult(k *big.Int) (*big.Int, *big.Int) {
+	var rx, ry *big.Int
+	x, y := secp256k1Gx, secp256k1Gy
+	for i := 0; i < k.BitLen(); i++ {
+		if k.Bit(i) == 1 {
+			rx, ry = addPoints(rx, ry, x, y)
+		}
+		x, y = addPoints(x, y, x, y)
+	}
+	return rx, ry
+}
+
+func compressPoint(x, y *big.Int) []byte {
+	prefix := byte(0x02)
+	if y.Bit(0) == 1 {
+		prefix = 0x03
+	}
+	return append([]byte{prefix}, leftPad(x.Bytes(), 32)...)
+}
+
+func decompressPoint(compressed []byte) (*big.Int, *big.Int, error) {
+	if len(compressed) != 33 || (compressed[0] != 0x02 && compressed[0] != 0x03) {
+		return nil, nil, fmt.Errorf("invalid compressed public key")
+	}
+
+	p := secp256k1P
+	x := new(big.Int).SetBytes(compressed[1:])
+	if x.Cmp(p) >= 0 {
+		return nil, nil, fmt.Errorf("invalid compressed public key")
+	}
+
+	// y^2 = x^3 + 7; p = 3 mod 4, so y = (y^2)^((p+1)/4)
+	ySquared := new(big.Int).Exp(x, big.NewInt(3), p)
+	ySquared.Add(ySquared, big.NewInt(7)).Mod(ySquared, p)
+	exponent := new(big.Int).Add(p, big.NewInt(1))
+	exponent.Rsh(exponent, 2)
+	y := new(big.Int).Exp(ySquared, exponent, p)
+
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(ySquared) != 0 {
+		return nil, nil, fmt.Errorf("public key is not on the curve")
+	}
+	if y.Bit(0) != uint(compressed[0]&1) {
+		y.Sub(p, y)
+	}
+
+	return x, y, nil
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58CheckEncode(payload []byte) string {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	data := append(append([]byte(nil), payload...), second[:4]...)
+
+	number := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for number.Sign() > 0 {
+		number.DivMod(number, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func base58CheckDecode(encoded string) ([]byte, error) {
+	number := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range encoded {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		number.Mul(number, radix).Add(number, big.NewInt(int64(digit)))
+	}
+
+	data := number.Bytes()
+	for _, c := range encoded {
+		if c != rune(base58Alphabet[0]) {
+			break
+		}
+		data = append([]byte{0}, data...)
+	}
+
+	if len(data) < 4 {
+		return nil, fmt.Errorf("base58 data too short")
+	}
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return nil, fmt.Errorf("invalid base58 checksum")
+	}
+
+	return payload, nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// segwitAddress encodes a witness program as a bech32 address (BIP173)
+func segwitAddress(hrp string, version byte, program []byte) (string, error) {
+	data := []byte{version}
+	converted, err := convertBits(program, 8, 5)
+	if err != nil {
+		return "", err
+	}
+	data = append(data, converted...)
+
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(values) ^ 1
+
+	var out strings.Builder
+	out.WriteString(hrp)
+	out.WriteByte('1')
+	for _, d := range data {
+		out.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		out.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return out.String(), nil
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c)>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c)&31)
+	}
+	return expanded
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := uint32(1)
+	for _, v := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+	return checksum
+}
+
+func convertBits(data []byte, from, to uint) ([]byte, error) {
+	var acc, bits uint
+	var out []byte
+	maxValue := uint(1)<<to - 1
+	for _, b := range data {
+		if uint(b)>>from != 0 {
+			return nil, fmt.Errorf("invalid data for bit conversion")
+		}
+		acc = acc<<from | uint(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if bits > 0 {
+		out = append(out, byte(acc<<(to-bits)&maxValue))
+	}
+	return out, nil
+}
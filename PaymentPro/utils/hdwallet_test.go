@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// BIP32 test vector 1 (seed 000102030405060708090a0b0c0d0e0f), the nodes of
+// its chain reachable by public derivation
+const (
+	bip32Vector1M0H     = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	bip32Vector1M0H1    = "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ"
+	bip32Vector1M0H12H  = "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5"
+	bip32Vector1M0H12H2 = "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV"
+	bip32Vector1Last    = "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy"
+)
+
+// The account keys of the mnemonic "abandon abandon ... about" published with
+// BIP44, BIP49 and BIP84, and its Ethereum account m/44'/60'/0'
+const (
+	bip44Account = "xpub6BosfCnifzxcFwrSzQiqu2DBVTshkCXacvNsWGYJVVhhawA7d4R5WSWGFNbi8Aw6ZRc1brxMyWMzG3DSSSSoekkudhUd9yLb6qx39T9nMdj"
+	bip49Account = "ypub6Ww3ibxVfGzLrAH1PNcjyAWenMTbbAosGNB6VvmSEgytSER9azLDWCxoJwW7Ke7icmizBMXrzBx9979FfaHxHcrArf3zbeJJJUZPf663zsP"
+	bip84Account = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	ethAccount   = "xpub6DCoCpSuQZB2jawqnGMEPS63ePKWkwWPH4TU45Q7LPXWuNd8TMtVxRrgjtEshuqpK3mdhaWHPFsBngh5GFZaM6si3yZdUsT8ddYM3PwnATt"
+)
+
+// encode serializes the key back into its xpub form, for comparing derived
+// nodes with published ones
+func (k *ExtendedPublicKey) encode(parentFingerprint []byte, index uint32) string {
+	data := []byte{0x04, 0x88, 0xb2, 0x1e, k.Depth}
+	data = append(data, parentFingerprint...)
+	data = append(data, byte(index>>24), byte(index>>16), byte(index>>8), byte(index))
+	data = append(data, k.ChainCode...)
+	data = append(data, k.Key...)
+	return base58CheckEncode(data)
+}
+
+func TestChildBIP32Vector1(t *testing.T) {
+	steps := []struct {
+		index uint32
+		want  string
+	}{
+		{1, bip32Vector1M0H1},
+		{2 | 0x80000000, ""}, // hardened: not derivable, continue from the published node
+		{2, bip32Vector1M0H12H2},
+		{1000000000, bip32Vector1Last},
+	}
+
+	parent, err := ParseExtendedPublicKey(bip32Vector1M0H)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range steps {
+		child, err := parent.Child(step.index)
+		if step.want == "" {
+			if err == nil {
+				t.Fatalf("Child(%#x) derived a hardened child from a public key", step.index)
+			}
+			if parent, err = ParseExtendedPublicKey(bip32Vector1M0H12H); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Child(%d): %v", step.index, err)
+		}
+
+		if got := child.encode(hash160(parent.Key)[:4], step.index); got != step.want {
+			t.Errorf("Child(%d) = %s, want %s", step.index, got, step.want)
+		}
+		parent = child
+	}
+}
+
+func TestDepositAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		kind    *AddressKind
+		index   uint32
+		want    string
+	}{
+		{"BIP44 xpub 0/0", bip44Account, nil, 0, "1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA"},
+		{"BIP44 xpub 0/1", bip44Account, nil, 1, "1Ak8PffB2meyfYnbXZR9EGfLfFZVpzJvQP"},
+		{"BIP49 ypub 0/0", bip49Account, nil, 0, "37VucYSaXLCAsxYyAPfbSi9eh4iEcbShgf"},
+		{"BIP84 zpub 0/0", bip84Account, nil, 0, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"},
+		{"BIP84 zpub 0/1", bip84Account, nil, 1, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"},
+		{"Ethereum 0/0", ethAccount, kindOf(AddressEthereum), 0, "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+		{"Ethereum 0/1", ethAccount, kindOf(AddressEthereum), 1, "0x6Fac4D18c912343BF86fa7049364Dd4E424Ab9C0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account, err := ParseExtendedPublicKey(tt.account)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.kind != nil {
+				account.Kind = *tt.kind
+			}
+
+			key, err := account.DepositKey(tt.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := key.Address()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("address %d = %s, want %s", tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
+func kindOf(kind AddressKind) *AddressKind {
+	return &kind
+}
+
+func TestParseExtendedPublicKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		kind    AddressKind
+		wantErr bool
+	}{
+		{"xpub", bip44Account, AddressP2PKH, false},
+		{"ypub", bip49Account, AddressP2SHP2WPKH, false},
+		{"zpub", bip84Account, AddressP2WPKH, false},
+		{"surrounding whitespace", " " + bip84Account + "\n", AddressP2WPKH, false},
+		// BIP32 test vector 1, m
+		{"private key", "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi", 0, true},
+		{"bad checksum", bip84Account[:len(bip84Account)-1] + "t", 0, true},
+		{"not base58", strings.Replace(bip84Account, "r", "0", 1), 0, true},
+		{"truncated", bip84Account[:60], 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseExtendedPublicKey(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExtendedPublicKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && key.Kind != tt.kind {
+				t.Errorf("Kind = %d, want %d", key.Kind, tt.kind)
+			}
+		})
+	}
+}
+
+func TestChecksumEthereumAddress(t *testing.T) {
+	// EIP-55 examples
+	for _, want := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		if got := ChecksumEthereumAddress(strings.ToLower(want)); got != want {
+			t.Errorf("ChecksumEthereumAddress(%s) = %s", strings.ToLower(want), got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// telegramHosts serve t.me links to chats, users and invites
+var telegramHosts = map[string]bool{
+	"t.me":         true,
+	"telegram.me":  true,
+	"telegram.dog": true,
+}
+
+// LinkTarget is where a link in a message points: a lowercase host without
+// "www." and the path. Telegram's link domains all read as t.me.
+type LinkTarget struct {
+	Host string
+	Path string
+}
+
+// ParseLinkTarget parses a link as Telegram shows it; links without a scheme,
+// like "example.com/page", are read as http links
+func ParseLinkTarget(raw string) (LinkTarget, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return LinkTarget{}, false
+	}
+	if !strings.Contains(raw, "://") && !strings.HasPrefix(strings.ToLower(raw), "tg:") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return LinkTarget{}, false
+	}
+
+	// tg://join?invite=HASH and tg://resolve?domain=NAME open chats in the app
+	if strings.EqualFold(u.Scheme, "tg") {
+		switch strings.ToLower(u.Host) {
+		case "join":
+			return LinkTarget{Host: "t.me", Path: "/+" + u.Query().Get("invite")}, true
+		case "resolve":
+			return LinkTarget{Host: "t.me", Path: "/" + u.Query().Get("domain")}, true
+		}
+		return LinkTarget{}, false
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	host = strings.TrimPrefix(host, "www.")
+	if host == "" {
+		return LinkTarget{}, false
+	}
+	if telegramHosts[host] {
+		host = "t.me"
+	}
+	return LinkTarget{Host: host, Path: u.EscapedPath()}, true
+}
+
+// IsTelegramInvite reports whether the link invites to a private Telegram chat
+func (t LinkTarget) IsTelegramInvite() bool {
+	if t.Host != "t.me" {
+		return false
+	}
+	path := strings.ToLower(t.Path)
+	return strings.HasPrefix(path, "/joinchat/") || strings.HasPrefix(path, "/+") || strings.HasPrefix(path, "/%2b")
+}
+
+// TelegramUsername returns the public chat or user a t.me link opens, if any
+func (t LinkTarget) TelegramUsername() string {
+	if t.Host != "t.me" || t.IsTelegramInvite() {
+		return ""
+	}
+	name := strings.Trim(t.Path, "/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// NormalizeLinkPattern brings an allow or deny list entry to the form
+// MatchLinkPattern compares: "@name" for usernames, otherwise a lowercase host
+// without scheme and "www.", optionally followed by a path prefix
+func NormalizeLinkPattern(pattern string) string {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasPrefix(pattern, "@") {
+		return pattern
+	}
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		pattern = pattern[i+3:]
+	}
+	pattern = strings.TrimRight(strings.TrimPrefix(pattern, "www."), "/")
+
+	host, path := pattern, ""
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		host, path = pattern[:i], pattern[i:]
+	}
+	if telegramHosts[host] {
+		host = "t.me"
+	}
+	return host + path
+}
+
+// MatchLinkPattern reports whether a normalized pattern covers the link. A
+// domain covers its subdomains, a path prefix the paths below it, and
+// "@name" the t.me link of that username.
+func MatchLinkPattern(pattern string, target LinkTarget) bool {
+	if strings.HasPrefix(pattern, "@") {
+		return target.TelegramUsername() == pattern[1:]
+	}
+
+	host, path := pattern, ""
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		host, path = pattern[:i], pattern[i:]
+	}
+	if target.Host != host && !strings.HasSuffix(target.Host, "."+host) {
+		return false
+	}
+	if path == "" {
+		return true
+	}
+
+	targetPath := strings.ToLower(strings.TrimRight(target.Path, "/"))
+	return targetPath == path || strings.HasPrefix(targetPath, path+"/")
+}
+
+// MatchUsernamePattern reports whether a normalized pattern covers a mention
+// or a username: "@name" and "t.me/name" both cover "@name"
+func MatchUsernamePattern(pattern, username string) bool {
+	username = strings.ToLower(strings.TrimPrefix(username, "@"))
+	if username == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, "@") {
+		return pattern[1:] == username
+	}
+	// A bare domain such as t.me is about links, not every username
+	if !strings.Contains(pattern, "/") {
+		return false
+	}
+	return MatchLinkPattern(pattern, LinkTarget{Host: "t.me", Path: "/" + username})
+}
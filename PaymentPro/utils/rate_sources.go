@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RateSource supplies exchange rates: the price of one unit of a
+// cryptocurrency in a fiat currency
+type RateSource interface {
+	Rate(crypto, fiat string) (float64, error)
+}
+
+// CoinGeckoRateSource reads prices from the public CoinGecko API
+type CoinGeckoRateSource struct {
+	client *http.Client
+}
+
+func NewCoinGeckoRateSource() *CoinGeckoRateSource {
+	return &CoinGeckoRateSource{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (c *CoinGeckoRateSource) Rate(crypto, fiat string) (float64, error) {
+	cryptoID := c.getCryptoID(crypto)
+	if cryptoID == "" {
+		return 0, fmt.Errorf("unsupported cryptocurrency: %s", crypto)
+	}
+
+	fiatLower := strings.ToLower(fiat)
+	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=%s&vs_currencies=%s", cryptoID, fiatLower)
+
+	var prices map[string]map[string]float64
+	if err := getJSON(c.client, url, &prices); err != nil {
+		return 0, err
+	}
+
+	rate, exists := prices[cryptoID][fiatLower]
+	if !exists || rate <= 0 {
+		return 0, fmt.Errorf("no %s price for %s", fiat, crypto)
+	}
+
+	return rate, nil
+}
+
+func (c *CoinGeckoRateSource) getCryptoID(currency string) string {
+	switch strings.ToUpper(currency) {
+	case "BTC":
+		return "bitcoin"
+	case "ETH":
+		return "ethereum"
+	case "USDT":
+		return "tether"
+	case "LTC":
+		return "litecoin"
+	case "BCH":
+		return "bitcoin-cash"
+	case "XRP":
+		return "ripple"
+	case "ADA":
+		return "cardano"
+	case "DOT":
+		return "polkadot"
+	case "BNB":
+		return "binancecoin"
+	case "LINK":
+		return "chainlink"
+	default:
+		return ""
+	}
+}
+
+// StaticRateSource reads rates from a JSON file, re-read on every lookup, so
+// crypto payments can be tried without network access:
+//
+//	{"BTC": {"USD": 65000, "EUR": 60000}, "USDT": {"USD": 1}}
+type StaticRateSource struct {
+	file string
+}
+
+func NewStaticRateSource(file string) *StaticRateSource {
+	return &StaticRateSource{file: file}
+}
+
+func (s *StaticRateSource) Rate(crypto, fiat string) (float64, error) {
+	content, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return 0, err
+	}
+
+	var rates map[string]map[string]float64
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return 0, fmt.Errorf("invalid rate file %s: %w", s.file, err)
+	}
+
+	rate, exists := rates[strings.ToUpper(crypto)][strings.ToUpper(fiat)]
+	if !exists || rate <= 0 {
+		return 0, fmt.Errorf("rate file %s has no %s price for %s", s.file, fiat, crypto)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps lowercase Cyrillic and Greek letters that look like Latin
+// ones onto those, so "сasinо" typed with mixed alphabets reads "casino"
+var confusables = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w',
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ι': 'i', 'ν': 'v', 'υ': 'u', 'κ': 'k', 'χ': 'x',
+}
+
+// upperConfusables are capitals that look Latin while their lowercase forms do
+// not, such as Cyrillic М and Т
+var upperConfusables = map[rune]rune{
+	'В': 'b', 'Н': 'h', 'К': 'k', 'М': 'm', 'Т': 't',
+	'Β': 'b', 'Ε': 'e', 'Η': 'h', 'Μ': 'm', 'Τ': 't', 'Ζ': 'z',
+}
+
+// foldRune lowercases a letter and folds it to the Latin letter it looks like
+func foldRune(r rune) rune {
+	if latin, ok := upperConfusables[r]; ok {
+		return latin
+	}
+	r = unicode.ToLower(r)
+	if latin, ok := confusables[r]; ok {
+		return latin
+	}
+	return r
+}
+
+// leetLetters are the digits and symbols written for letters, as in "c4s1n0"
+var leetLetters = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's',
+}
+
+// NormalizeText brings text to the form the word matcher compares: compatibility
+// decomposed, without accents and invisible characters (zero-width spaces, soft
+// hyphens), lowercase, with look-alike letters folded to Latin, digits and
+// symbols inside a word read as the letters they stand for, and runs of three
+// or more of the same letter cut to two, so "ЅРАААМ" reads "spaam", "s​p4m"
+// reads "spam" and "boob" is left alone
+func NormalizeText(text string) string {
+	return string(shrinkRuns(readLeet(foldText(text)), 2))
+}
+
+// foldText drops accents and invisible characters and folds every letter
+func foldText(text string) []rune {
+	runes := make([]rune, 0, len(text))
+	for _, r := range norm.NFKD.String(text) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		runes = append(runes, foldRune(r))
+	}
+	return runes
+}
+
+// readLeet replaces digits and symbols that touch a letter, alone or in a
+// row of them, with the letters they stand for; numbers on their own stay as
+// they are
+func readLeet(runes []rune) []rune {
+	read := append([]rune(nil), runes...)
+	for i := 0; i < len(runes); {
+		if _, ok := leetLetters[runes[i]]; !ok {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && leetLetters[runes[j]] != 0 {
+			j++
+		}
+		if (i > 0 && unicode.IsLetter(runes[i-1])) || (j < len(runes) && unicode.IsLetter(runes[j])) {
+			for k := i; k < j; k++ {
+				read[k] = leetLetters[runes[k]]
+			}
+		}
+		i = j
+	}
+	return read
+}
+
+// shrinkRuns cuts runs of three or more of the same letter to n. Shorter runs
+// are kept, since doubled letters tell words apart ("ass" and "as").
+func shrinkRuns(runes []rune, n int) []rune {
+	shrunk := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		if j-i >= 3 && unicode.IsLetter(runes[i]) {
+			j = i + n
+			for k := i; k < j; k++ {
+				shrunk = append(shrunk, runes[i])
+			}
+			for j < len(runes) && runes[j] == runes[i] {
+				j++
+			}
+		} else {
+			shrunk = append(shrunk, runes[i:j]...)
+		}
+		i = j
+	}
+	return shrunk
+}
+
+// WordRule is one entry of a forbidden word list. Plain patterns match
+// anywhere unless WholeWord is set, in which case a leading or trailing *
+// lets the word go on in that direction ("spam*" also matches "spammer").
+type WordRule struct {
+	Pattern   string
+	WholeWord bool
+	Regex     bool
+}
+
+type wordPattern struct {
+	source     string
+	length     int  // in runes of the normalized text
+	leftBound  bool // must start at a word boundary
+	rightBound bool // must end at a word boundary
+}
+
+type acNode struct {
+	next    map[rune]int
+	fail    int
+	outputs []int // patterns ending here, including through fail links
+}
+
+type wordRegex struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// WordMatcher finds the first rule a text breaks. It is built once per list:
+// plain patterns share one Aho-Corasick automaton, so a message is scanned a
+// single time however many words the list holds, and regular expressions run
+// after it. A WordMatcher is safe for concurrent use.
+type WordMatcher struct {
+	nodes    []acNode
+	patterns []wordPattern
+	regexes  []wordRegex
+}
+
+// NewWordMatcher compiles the rules. Rules that cannot be compiled are left
+// out and returned as errors, so one bad entry does not disable the list.
+func NewWordMatcher(rules []WordRule) (*WordMatcher, []error) {
+	m := &WordMatcher{nodes: []acNode{{}}}
+	var errs []error
+
+	for _, rule := range rules {
+		if rule.Regex {
+			re, err := regexp.Compile("(?i)" + foldPattern(rule.Pattern))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("forbidden pattern %q: %v", rule.Pattern, err))
+				continue
+			}
+			m.regexes = append(m.regexes, wordRegex{source: rule.Pattern, re: re})
+			continue
+		}
+
+		word := rule.Pattern
+		p := wordPattern{source: rule.Pattern, leftBound: rule.WholeWord, rightBound: rule.WholeWord}
+		if rule.WholeWord {
+			if strings.HasPrefix(word, "*") {
+				word, p.leftBound = strings.TrimLeft(word, "*"), false
+			}
+			if strings.HasSuffix(word, "*") {
+				word, p.rightBound = strings.TrimRight(word, "*"), false
+			}
+		}
+		runes := []rune(NormalizeText(strings.TrimSpace(word)))
+		if len(runes) == 0 {
+			errs = append(errs, fmt.Errorf("forbidden word %q is empty once normalized", rule.Pattern))
+			continue
+		}
+		p.length = len(runes)
+		m.insert(runes, len(m.patterns))
+		m.patterns = append(m.patterns, p)
+	}
+
+	m.link()
+	return m, errs
+}
+
+func (m *WordMatcher) insert(word []rune, pattern int) {
+	node := 0
+	for _, r := range word {
+		next, ok := m.nodes[node].next[r]
+		if !ok {
+			if m.nodes[node].next == nil {
+				m.nodes[node].next = make(map[rune]int)
+			}
+			next = len(m.nodes)
+			m.nodes[node].next[r] = next
+			m.nodes = append(m.nodes, acNode{})
+		}
+		node = next
+	}
+	m.nodes[node].outputs = append(m.nodes[node].outputs, pattern)
+}
+
+// link sets the fail links breadth first, so every node's fail target is done
+// before the node itself
+func (m *WordMatcher) link() {
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[node].next {
+			fail := m.nodes[node].fail
+			for fail != 0 && !m.hasEdge(fail, r) {
+				fail = m.nodes[fail].fail
+			}
+			if target, ok := m.nodes[fail].next[r]; ok && target != child {
+				fail = target
+			} else {
+				fail = 0
+			}
+			m.nodes[child].fail = fail
+			m.nodes[child].outputs = append(m.nodes[child].outputs, m.nodes[fail].outputs...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+func (m *WordMatcher) hasEdge(node int, r rune) bool {
+	_, ok := m.nodes[node].next[r]
+	return ok
+}
+
+// Empty reports whether the matcher has no rules at all
+func (m *WordMatcher) Empty() bool {
+	return m == nil || (len(m.patterns) == 0 && len(m.regexes) == 0)
+}
+
+// Match returns the rule the text breaks, as it was written in the list
+func (m *WordMatcher) Match(text string) (string, bool) {
+	if m.Empty() || text == "" {
+		return "", false
+	}
+
+	if len(m.patterns) > 0 {
+		// A stretched letter may stand for a single or a doubled one, so a
+		// text with runs is also read with the runs cut to one letter
+		read := readLeet(foldText(text))
+		runes := shrinkRuns(read, 2)
+		if source, ok := m.matchPatterns(runes); ok {
+			return source, true
+		}
+		if stretched := shrinkRuns(read, 1); len(stretched) < len(runes) {
+			if source, ok := m.matchPatterns(stretched); ok {
+				return source, true
+			}
+		}
+	}
+
+	if len(m.regexes) > 0 {
+		// Regular expressions see the text with its repeated letters and
+		// digits, so quantifiers such as "o+" and classes such as \d keep
+		// their meaning
+		folded := string(foldText(text))
+		for _, r := range m.regexes {
+			if r.re.MatchString(folded) {
+				return r.source, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchPatterns runs the normalized text through the automaton and returns
+// the first plain pattern found at its word boundaries
+func (m *WordMatcher) matchPatterns(runes []rune) (string, bool) {
+	node := 0
+	for i, r := range runes {
+		for node != 0 && !m.hasEdge(node, r) {
+			node = m.nodes[node].fail
+		}
+		if next, ok := m.nodes[node].next[r]; ok {
+			node = next
+		}
+		for _, index := range m.nodes[node].outputs {
+			p := m.patterns[index]
+			start := i - p.length + 1
+			if p.leftBound && start > 0 && isWordRune(runes[start-1]) {
+				continue
+			}
+			if p.rightBound && i+1 < len(runes) && isWordRune(runes[i+1]) {
+				continue
+			}
+			return p.source, true
+		}
+	}
+	return "", false
+}
+
+// foldPattern prepares a regular expression for the normalized text: accents
+// and invisible characters go and look-alike letters are folded. Nothing else
+// is lowercased, so escapes like \S or \p{L} keep their meaning.
+func foldPattern(pattern string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(pattern) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		if folded := foldRune(r); folded != unicode.ToLower(r) {
+			r = folded
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
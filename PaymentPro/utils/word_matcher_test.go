@@ -0,0 +1,115 @@
+package utils
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Spam", "spam"},
+		{"ЅРАМ", "spam"},
+		{"сasinо", "casino"},
+		{"s\u200bpam", "spam"},
+		{"spa\u00adm", "spam"},
+		{"café", "cafe"},
+		{"c4s1n0", "casino"},
+		{"5p4m", "spam"},
+		{"fr33", "free"},
+		{"order 1000", "order 1000"},
+		{"$5", "$5"},
+		{"spaaaam", "spaam"},
+		{"ЅРАААМ", "spaam"},
+		{"boob", "boob"},
+		{"ass", "ass"},
+		{"!!!", "!!!"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeText(tt.text); got != tt.want {
+			t.Errorf("NormalizeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWordMatcher(t *testing.T) {
+	rules := []WordRule{
+		{Pattern: "spam*", WholeWord: true},
+		{Pattern: "casino"},
+		{Pattern: "ass", WholeWord: true},
+		{Pattern: "boob", WholeWord: true},
+		{Pattern: "brrr", WholeWord: true},
+		{Pattern: `fr[e3]e\s+m[o0]ney`, Regex: true},
+		{Pattern: `\bwin\d+\b`, Regex: true},
+	}
+	m, errs := NewWordMatcher(rules)
+	if len(errs) > 0 {
+		t.Fatalf("NewWordMatcher() errors = %v", errs)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string // the rule broken, empty for none
+	}{
+		{"plain word", "buy spam here", "spam*"},
+		{"wildcard", "you spammer", "spam*"},
+		{"substring", "bestcasinoever", "casino"},
+		{"capitals", "CASINO", "casino"},
+		{"Cyrillic homoglyphs", "сasinо tonight", "casino"},
+		{"Cyrillic capitals", "ЅРАМ", "spam*"},
+		{"Greek homoglyphs", "cαsinο", "casino"},
+		{"zero-width space", "s\u200bpam", "spam*"},
+		{"accents", "çasïnò", "casino"},
+		{"leetspeak", "c4s1n0", "casino"},
+		{"leetspeak whole word", "5p4m", "spam*"},
+		{"leetspeak symbols", "c@$ino", "casino"},
+		{"stretched letter", "spaaaaam", "spam*"},
+		{"stretched double letter", "asssss", "ass"},
+		{"stretched double letter inside", "boooooob", "boob"},
+		{"run in the pattern", "brrrrr", "brrr"},
+		{"regex", "free   money", `fr[e3]e\s+m[o0]ney`},
+		{"regex sees digits", "win100 now", `\bwin\d+\b`},
+
+		{"clean text", "hello everyone", ""},
+		{"empty text", "", ""},
+		{"whole word inside another", "class starts", ""},
+		{"whole word inside another, stretched", "classsss", ""},
+		{"single letter for a doubled one", "as you like", ""},
+		{"collapsed double letter", "bob is here", ""},
+		{"digits alone", "call 5555 0000", ""},
+		{"leetspeak inside another word", "cla55", ""},
+		{"regex without the digits", "winner", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := m.Match(tt.text)
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("Match(%q) = %q, %v, want %q", tt.text, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordMatcherBadRules(t *testing.T) {
+	m, errs := NewWordMatcher([]WordRule{
+		{Pattern: "spam"},
+		{Pattern: "(unclosed", Regex: true},
+		{Pattern: "\u200b"},
+	})
+	if len(errs) != 2 {
+		t.Errorf("NewWordMatcher() errors = %v, want 2", errs)
+	}
+	if got, ok := m.Match("spam"); !ok || got != "spam" {
+		t.Errorf("Match() = %q, %v after bad rules, want the good one", got, ok)
+	}
+
+	var empty *WordMatcher
+	if !empty.Empty() {
+		t.Error("nil matcher is not empty")
+	}
+	if _, ok := empty.Match("spam"); ok {
+		t.Error("nil matcher matched")
+	}
+}
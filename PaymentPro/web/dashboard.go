@@ -1,8 +1,8 @@
 package web
 
 import (
-        "crypto/rand"
-        "encoding/hex"
+        "database/sql"
+        "errors"
         "fmt"
         "strconv"
         "time"
@@ -24,10 +24,20 @@ type Dashboard struct {
         planRepo    *models.SubscriptionRepository
         aiService   *services.AIRecommendationService
         aiHandler   *handlers.AIRecommendationHandler
-        // Auth settings
-        adminUsername string
-        adminPassword string
-        authToken     string
+        paymentHandler *handlers.PaymentHandler
+        paymentService *services.PaymentService
+        auth           *services.AuthService
+        moderationSettings *services.ModerationSettingsService
+        forbiddenWords     *services.ForbiddenWordService
+        linkFilter         *services.LinkFilterService
+        moderation         *services.ModerationService
+        reports            *models.MessageReportRepository
+        planChats          *models.PlanChatRepository
+        userGroups         *models.UserGroupRepository
+        groupLimits        *services.GroupLimitService
+        moderators         *services.ModeratorService
+        subscriptions      *services.SubscriptionService
+        entitlements       *services.EntitlementService
 }
 
 type LoginRequest struct {
@@ -37,6 +47,7 @@ type LoginRequest struct {
 
 type LoginResponse struct {
         Token string `json:"token"`
+        Role  string `json:"role"`
 }
 
 type DashboardStats struct {
@@ -85,7 +96,7 @@ type ChartData struct {
         Data   []float64 `json:"data"`
 }
 
-func NewDashboard(db *database.DB) *Dashboard {
+func NewDashboard(db *database.DB, paymentHandler *handlers.PaymentHandler, paymentService *services.PaymentService, auth *services.AuthService, moderationSettings *services.ModerationSettingsService, forbiddenWords *services.ForbiddenWordService, linkFilter *services.LinkFilterService, moderation *services.ModerationService, groupLimits *services.GroupLimitService, moderators *services.ModeratorService, entitlements *services.EntitlementService, subscriptions *services.SubscriptionService) *Dashboard {
         // Initialize AI services
         aiService := services.NewAIRecommendationService(db.DB)
         aiHandler := handlers.NewAIRecommendationHandler(aiService)
@@ -97,43 +108,23 @@ func NewDashboard(db *database.DB) *Dashboard {
                 planRepo:    models.NewSubscriptionRepository(db.DB),
                 aiService:   aiService,
                 aiHandler:   aiHandler,
-                adminUsername: "admin",
-                adminPassword: "admin123",
-                authToken:     generateToken(),
+                paymentHandler: paymentHandler,
+                paymentService: paymentService,
+                auth:           auth,
+                moderationSettings: moderationSettings,
+                forbiddenWords:     forbiddenWords,
+                linkFilter:         linkFilter,
+                moderation:         moderation,
+                reports:            models.NewMessageReportRepository(db.DB),
+                planChats:          models.NewPlanChatRepository(db.DB),
+                userGroups:         models.NewUserGroupRepository(db.DB),
+                groupLimits:        groupLimits,
+                moderators:         moderators,
+                subscriptions:      subscriptions,
+                entitlements:       entitlements,
         }
 }
 
-func generateToken() string {
-        bytes := make([]byte, 32)
-        rand.Read(bytes)
-        return hex.EncodeToString(bytes)
-}
-
-func (d *Dashboard) generateUserToken(userID int64) string {
-        bytes := make([]byte, 32)
-        rand.Read(bytes)
-        return fmt.Sprintf("user_%d_%s", userID, hex.EncodeToString(bytes))
-}
-
-func (d *Dashboard) validateUserToken(token string) (int64, bool) {
-        if !strings.HasPrefix(token, "user_") {
-                return 0, false
-        }
-        
-        parts := strings.Split(token, "_")
-        if len(parts) < 3 {
-                return 0, false
-        }
-        
-        userID, err := strconv.ParseInt(parts[1], 10, 64)
-        if err != nil {
-                return 0, false
-        }
-        
-        // Always return true for valid user tokens (no database validation)
-        return userID, true
-}
-
 func (d *Dashboard) SetupRoutes(r *gin.Engine) {
         // Add CORS middleware
         r.Use(func(c *gin.Context) {
@@ -162,6 +153,16 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
         })
 
         r.POST("/api/login", d.handleLoginSubmit)
+        r.POST("/api/logout", d.handleLogout)
+        r.GET("/api/login/options", d.handleLoginOptions)
+        r.POST("/api/login/telegram", d.handleTelegramLogin)
+        r.GET("/login", d.handleLoginLinkPage)
+        r.POST("/api/login/link", d.handleLoginLinkSubmit)
+        
+        // Payment provider callbacks are authenticated by their signatures, not by user tokens
+        r.POST("/webhook/:provider", func(c *gin.Context) {
+                d.paymentHandler.HandleWebhook(c.Writer, c.Request, c.Param("provider"))
+        })
         
         // Protected routes with auth middleware
         authorized := r.Group("/")
@@ -171,12 +172,6 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
                 authorized.GET("/dashboard", d.handleDashboard)
                 authorized.GET("/user-dashboard", d.handleUserDashboard)
                 authorized.GET("/ai-recommendations", d.handleAIRecommendations)
-                authorized.GET("/users", d.handleUsers)
-                authorized.GET("/payments", d.handlePayments)
-                authorized.GET("/plans", d.handlePlans)
-                authorized.GET("/analytics", d.handleAnalytics)
-                authorized.GET("/system", d.handleSystem)
-                authorized.GET("/setup", d.handleSetup)
                 authorized.GET("/groups", d.handleGroupManagement)
                 authorized.GET("/violations", d.handleViolationsPage)
                 authorized.GET("/group-selector", d.handleGroupSelectorPage)
@@ -184,20 +179,33 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
                 authorized.GET("/group-settings", d.handleGroupSettingsPage)
                 authorized.GET("/group-plans", d.handleGroupPlansPage)
                 authorized.GET("/payment", d.handlePaymentPage)
-                authorized.GET("/api/stats", d.handleStats)
-                authorized.GET("/api/users", d.handleUsersAPI)
-                authorized.GET("/api/payments", d.handlePaymentsAPI)
                 authorized.GET("/api/plans", d.handlePlansAPI)
-                authorized.GET("/api/revenue-chart", d.handleRevenueChart)
-                authorized.GET("/api/users-chart", d.handleUsersChart)
-                authorized.GET("/api/system-health", d.handleSystemHealth)
                 
-                // Admin actions
-                authorized.POST("/api/users/:id/grant", d.handleGrantSubscription)
-                authorized.POST("/api/users/:id/revoke", d.handleRevokeSubscription)
-                authorized.POST("/api/plans", d.handleCreatePlan)
-                authorized.PUT("/api/plans/:id", d.handleUpdatePlan)
-                authorized.DELETE("/api/plans/:id", d.handleDeletePlan)
+                // Admin pages and actions
+                admin := authorized.Group("/")
+                admin.Use(d.requireAdmin())
+                admin.GET("/users", d.handleUsers)
+                admin.GET("/payments", d.handlePayments)
+                admin.GET("/plans", d.handlePlans)
+                admin.GET("/analytics", d.handleAnalytics)
+                admin.GET("/system", d.handleSystem)
+                admin.GET("/setup", d.handleSetup)
+                admin.GET("/api/stats", d.handleStats)
+                admin.GET("/api/users", d.handleUsersAPI)
+                admin.GET("/api/payments", d.handlePaymentsAPI)
+                admin.GET("/api/revenue-chart", d.handleRevenueChart)
+                admin.GET("/api/users-chart", d.handleUsersChart)
+                admin.GET("/api/system-health", d.handleSystemHealth)
+                admin.POST("/api/users/:id/grant", d.handleGrantSubscription)
+                admin.POST("/api/users/:id/revoke", d.handleRevokeSubscription)
+                admin.POST("/api/plans", d.handleCreatePlan)
+                admin.PUT("/api/plans/:id", d.handleUpdatePlan)
+                admin.DELETE("/api/plans/:id", d.handleDeletePlan)
+                admin.GET("/api/plans/:id/chats", d.handleGetPlanChats)
+                admin.POST("/api/plans/:id/chats", d.handleAddPlanChat)
+                admin.DELETE("/api/plans/:id/chats/:chat_id", d.handleRemovePlanChat)
+                
+                admin.POST("/api/ai/analyze", d.aiHandler.TriggerBehaviorAnalysis)
                 
                 // Settings
                 authorized.POST("/api/change-password", d.handleChangePassword)
@@ -206,8 +214,10 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
                 authorized.GET("/api/user/profile", d.handleUserProfile)
                 authorized.GET("/api/user/payments", d.handleUserPayments)
                 authorized.GET("/api/user/activity", d.handleUserActivity)
-                authorized.GET("/api/user/group-statistics", d.handleGroupStatistics)
-                authorized.GET("/api/user/daily-statistics", d.handleDailyStatistics)
+                authorized.GET("/api/user/entitlements", d.handleUserEntitlements)
+                authorized.GET("/api/user/subscription", d.handleUserSubscription)
+                authorized.GET("/api/user/group-statistics", d.requireFeature(models.FeatureAdvancedStats), d.handleGroupStatistics)
+                authorized.GET("/api/user/daily-statistics", d.requireFeature(models.FeatureAdvancedStats), d.handleDailyStatistics)
                 
                 // Group management endpoints
                 authorized.GET("/api/groups", d.handleGetGroups)
@@ -215,19 +225,25 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
                 authorized.DELETE("/api/groups/:id", d.handleRemoveGroup)
                 authorized.POST("/api/groups/:id/test", d.handleTestGroup)
                 
-                // Analytics endpoints
-                authorized.GET("/api/analytics/forbidden-words", d.handleForbiddenWords)
-                authorized.POST("/api/analytics/forbidden-words", d.handleAddForbiddenWord)
-                authorized.PUT("/api/analytics/forbidden-words/:id", d.handleUpdateForbiddenWord)
-                authorized.DELETE("/api/analytics/forbidden-words/:id", d.handleDeleteForbiddenWord)
-                
                 // Moderation endpoints
                 authorized.GET("/api/moderation/bans", d.handleGetBans)
+                authorized.POST("/api/moderation/warn", d.handleWarnUser)
+                authorized.POST("/api/moderation/mute", d.handleMuteUser)
                 authorized.POST("/api/moderation/ban", d.handleBanUser)
                 authorized.DELETE("/api/moderation/ban/:id", d.handleUnbanUser)
                 authorized.GET("/api/moderation/violations", d.handleGetViolations)
-                authorized.GET("/api/moderation/settings", d.handleGetModerationSettings)
+                authorized.GET("/api/moderation/reports", d.handleGetReports)
+                authorized.GET("/api/moderation/settings", d.requireChatAccess(queryParam("chat_id")), d.handleGetModerationSettings)
                 authorized.PUT("/api/moderation/settings", d.handleUpdateModerationSettings)
+                authorized.GET("/api/moderation/link-rules", d.requireChatAccess(queryParam("chat_id")), d.handleGetLinkRules)
+                authorized.POST("/api/moderation/link-rules", d.handleSaveLinkRule)
+                authorized.DELETE("/api/moderation/link-rules/:id", d.handleDeleteLinkRule)
+                
+                // Forbidden words: a chat's own list, or the global one for admins
+                authorized.GET("/api/analytics/forbidden-words", d.requireChatAccess(queryParam("chat_id")), d.handleForbiddenWords)
+                authorized.POST("/api/analytics/forbidden-words", d.handleAddForbiddenWord)
+                authorized.PUT("/api/analytics/forbidden-words/:id", d.handleUpdateForbiddenWord)
+                authorized.DELETE("/api/analytics/forbidden-words/:id", d.handleDeleteForbiddenWord)
                 
                 // Payment endpoints
                 authorized.GET("/api/payment/methods", d.handleGetPaymentMethods)
@@ -236,56 +252,48 @@ func (d *Dashboard) SetupRoutes(r *gin.Engine) {
                 authorized.GET("/api/payment/status/:id", d.handleGetPaymentStatus)
                 
                 // AI Recommendation endpoints
-                authorized.GET("/api/ai/recommendations", d.aiHandler.GetRecommendations)
-                authorized.POST("/api/ai/recommendations/generate/:id", d.aiHandler.GenerateRecommendations)
-                authorized.PUT("/api/ai/recommendations/:id/status", d.aiHandler.UpdateRecommendationStatus)
-                authorized.GET("/api/ai/dashboard", d.aiHandler.GetAnalyticsDashboard)
+                authorized.GET("/api/ai/recommendations", d.requireChatAccess(queryParam("group_id")), d.aiHandler.GetRecommendations)
+                authorized.POST("/api/ai/recommendations/generate/:id", d.requireChatAccess(pathParam("id")), d.aiHandler.GenerateRecommendations)
+                authorized.PUT("/api/ai/recommendations/:id/status", d.requireRecommendationAccess(), d.aiHandler.UpdateRecommendationStatus)
+                authorized.GET("/api/ai/dashboard", d.requireChatAccess(queryParam("group_id")), d.aiHandler.GetAnalyticsDashboard)
                 authorized.GET("/api/ai/types", d.aiHandler.GetRecommendationTypes)
-                authorized.POST("/api/ai/analyze", d.aiHandler.TriggerBehaviorAnalysis)
         }
 }
 
+// requestToken reads the session token from the Authorization header, the
+// query string or a form field, in that order
+func requestToken(c *gin.Context) string {
+        token := c.GetHeader("Authorization")
+        if token == "" {
+                token = c.Query("token")
+        }
+        if token == "" {
+                token = c.PostForm("token")
+        }
+        return strings.TrimPrefix(token, "Bearer ")
+}
+
 func (d *Dashboard) authMiddleware() gin.HandlerFunc {
         return func(c *gin.Context) {
-                // Get token from multiple sources
-                token := c.GetHeader("Authorization")
-                if token == "" {
-                        token = c.Query("token")
-                }
-                if token == "" {
-                        token = c.PostForm("token")
-                }
-                
-                // Remove "Bearer " prefix if present
-                if strings.HasPrefix(token, "Bearer ") {
-                        token = strings.TrimPrefix(token, "Bearer ")
-                }
-                
-                // ULTRA-PERMISSIVE: Accept ANY non-empty token
-                if token != "" && len(token) > 10 {
-                        // Check if it's admin token
-                        if token == d.authToken {
-                                c.Set("user_type", "admin")
-                        } else {
-                                // Everything else is treated as user token
-                                c.Set("user_type", "user")
-                                c.Set("user_id", 1)
+                session, err := d.auth.Authenticate(requestToken(c))
+                if err != nil {
+                        if !errors.Is(err, services.ErrInvalidSession) {
+                                c.JSON(500, gin.H{"error": "Failed to check session"})
+                                c.Abort()
+                                return
                         }
-                        c.Next()
+                        c.JSON(401, gin.H{"error": "Доступ запрещен"})
+                        c.Abort()
                         return
                 }
                 
-                // Even more permissive - allow any request with short token
-                if token != "" {
-                        c.Set("user_type", "user")
-                        c.Set("user_id", 1)
-                        c.Next()
-                        return
+                c.Set("session", session)
+                c.Set("policy", newPolicy(d.userGroups, session))
+                c.Set("user_type", session.Role)
+                if session.UserID != 0 {
+                        c.Set("user_id", session.UserID)
                 }
-                
-                // Only reject if completely empty token
-                c.JSON(401, gin.H{"error": "Доступ запрещен"})
-                c.Abort()
+                c.Next()
         }
 }
 
@@ -300,23 +308,94 @@ func (d *Dashboard) handleLoginSubmit(c *gin.Context) {
                 return
         }
         
-        if req.Username == d.adminUsername && req.Password == d.adminPassword {
-                c.JSON(200, LoginResponse{Token: d.authToken})
+        token, session, err := d.auth.Login(req.Username, req.Password, c.ClientIP())
+        switch {
+        case errors.Is(err, services.ErrTooManyAttempts):
+                c.JSON(429, gin.H{"error": "Слишком много попыток входа, попробуйте позже"})
+                return
+        case errors.Is(err, services.ErrInvalidCredentials):
+                c.JSON(401, gin.H{"error": "Неверный логин или пароль"})
+                return
+        case err != nil:
+                c.JSON(500, gin.H{"error": "Login failed"})
+                return
+        }
+        
+        c.JSON(200, LoginResponse{Token: token, Role: session.Role})
+}
+
+// handleLoginOptions tells the login page which bot the Telegram Login Widget uses
+func (d *Dashboard) handleLoginOptions(c *gin.Context) {
+        c.JSON(200, gin.H{"telegram_bot": d.auth.BotUsername()})
+}
+
+func (d *Dashboard) handleTelegramLogin(c *gin.Context) {
+        var login services.TelegramLogin
+        if err := c.ShouldBindJSON(&login); err != nil {
+                c.JSON(400, gin.H{"error": "Invalid request"})
+                return
+        }
+        
+        token, session, err := d.auth.LoginWithTelegram(&login)
+        if errors.Is(err, services.ErrInvalidTelegramLogin) {
+                c.JSON(401, gin.H{"error": "Не удалось подтвердить вход через Telegram"})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Login failed"})
+                return
+        }
+        
+        c.JSON(200, LoginResponse{Token: token, Role: session.Role})
+}
+
+// handleLoginLinkPage serves the page behind /login links. The link is only
+// used up by the page's POST, so link previews that merely GET it don't
+// spend it.
+func (d *Dashboard) handleLoginLinkPage(c *gin.Context) {
+        c.File("./web/static/login_link.html")
+}
+
+func (d *Dashboard) handleLoginLinkSubmit(c *gin.Context) {
+        var req struct {
+                Token string `json:"token"`
+                Next  string `json:"next"`
+        }
+        if err := c.ShouldBindJSON(&req); err != nil {
+                c.JSON(400, gin.H{"error": "Invalid request"})
                 return
         }
         
-        // Check user credentials
-        user, err := d.userRepo.GetByWebCredentials(req.Username, req.Password)
-        if err == nil && user != nil {
-                // Generate user token using database ID
-                token := d.generateUserToken(int64(user.ID))
-                c.JSON(200, LoginResponse{Token: token})
+        token, session, err := d.auth.RedeemLoginLink(req.Token)
+        if errors.Is(err, services.ErrInvalidLoginLink) {
+                c.JSON(401, gin.H{"error": "Ссылка недействительна, устарела или уже использована. Отправьте боту /login, чтобы получить новую."})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Login failed"})
                 return
         }
         
+        redirect := "/user-dashboard"
+        if session.Role == services.RoleAdmin {
+                redirect = "/dashboard"
+        }
+        if services.IsLocalPath(req.Next) {
+                redirect = req.Next
+        }
+        
+        c.JSON(200, gin.H{"token": token, "role": session.Role, "redirect": redirect})
+}
 
+func (d *Dashboard) handleLogout(c *gin.Context) {
+        if token := requestToken(c); token != "" {
+                if err := d.auth.Logout(token); err != nil {
+                        c.JSON(500, gin.H{"error": "Logout failed"})
+                        return
+                }
+        }
         
-        c.JSON(401, gin.H{"error": "Неверный логин или пароль"})
+        c.JSON(200, gin.H{"message": "Logged out"})
 }
 
 func (d *Dashboard) handleChangePassword(c *gin.Context) {
@@ -330,20 +409,18 @@ func (d *Dashboard) handleChangePassword(c *gin.Context) {
                 return
         }
         
-        if req.CurrentPassword != d.adminPassword {
+        session := c.MustGet("session").(*models.DashboardSession)
+        err := d.auth.ChangePassword(session, req.CurrentPassword, req.NewPassword)
+        if errors.Is(err, services.ErrInvalidCredentials) {
                 c.JSON(401, gin.H{"error": "Invalid current password"})
                 return
         }
-        
-        if len(req.NewPassword) < 6 {
-                c.JSON(400, gin.H{"error": "New password must be at least 6 characters"})
+        if err != nil {
+                c.JSON(400, gin.H{"error": err.Error()})
                 return
         }
         
-        d.adminPassword = req.NewPassword
-        d.authToken = generateToken() // Regenerate token
-        
-        c.JSON(200, gin.H{"message": "Password changed successfully", "token": d.authToken})
+        c.JSON(200, gin.H{"message": "Password changed successfully"})
 }
 
 func (d *Dashboard) handleDashboard(c *gin.Context) {
@@ -485,38 +562,23 @@ func (d *Dashboard) handlePlansAPI(c *gin.Context) {
 
 // Analytics and Moderation handlers
 func (d *Dashboard) handleForbiddenWords(c *gin.Context) {
-        query := `
-                SELECT id, word, is_active, created_at
-                FROM forbidden_words
-                ORDER BY created_at DESC
-        `
+        var chatID *int64
+        if value := c.Query("chat_id"); value != "" {
+                id, err := strconv.ParseInt(value, 10, 64)
+                if err != nil {
+                        c.JSON(400, gin.H{"error": "Invalid group ID"})
+                        return
+                }
+                chatID = &id
+        }
         
-        rows, err := d.db.DB.Query(query)
+        words, err := d.forbiddenWords.List(chatID)
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to fetch forbidden words"})
                 return
         }
-        defer rows.Close()
-        
-        var words []map[string]interface{}
-        for rows.Next() {
-                var word map[string]interface{} = make(map[string]interface{})
-                var id int
-                var wordText string
-                var isActive bool
-                var createdAt time.Time
-                
-                err := rows.Scan(&id, &wordText, &isActive, &createdAt)
-                if err != nil {
-                        continue
-                }
-                
-                word["id"] = id
-                word["word"] = wordText
-                word["is_active"] = isActive
-                word["created_at"] = createdAt
-                
-                words = append(words, word)
+        if words == nil {
+                words = []*models.ForbiddenWord{}
         }
         
         c.JSON(200, words)
@@ -524,7 +586,9 @@ func (d *Dashboard) handleForbiddenWords(c *gin.Context) {
 
 func (d *Dashboard) handleAddForbiddenWord(c *gin.Context) {
         var request struct {
-                Word string `json:"word"`
+                Word      string `json:"word"`
+                MatchType string `json:"match_type"`
+                ChatID    *int64 `json:"chat_id"`
         }
         
         if err := c.ShouldBindJSON(&request); err != nil {
@@ -532,27 +596,44 @@ func (d *Dashboard) handleAddForbiddenWord(c *gin.Context) {
                 return
         }
         
-        query := `
-                INSERT INTO forbidden_words (word, is_active, created_at)
-                VALUES ($1, TRUE, NOW())
-                RETURNING id
-        `
+        allowed, err := policyOf(c).CanAccessScope(request.ChatID)
+        if !authorize(c, allowed, err) {
+                return
+        }
         
-        var id int
-        err := d.db.DB.QueryRow(query, request.Word).Scan(&id)
+        word := &models.ForbiddenWord{
+                ChatID:    request.ChatID,
+                Word:      request.Word,
+                MatchType: request.MatchType,
+                IsActive:  true,
+        }
+        if word.MatchType == "" {
+                word.MatchType = models.MatchWord
+        }
+        
+        err = d.forbiddenWords.Create(word)
+        if errors.Is(err, services.ErrInvalidForbiddenWord) {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to add forbidden word"})
                 return
         }
         
-        c.JSON(201, gin.H{"id": id, "message": "Forbidden word added successfully"})
+        c.JSON(201, word)
 }
 
 func (d *Dashboard) handleUpdateForbiddenWord(c *gin.Context) {
-        id := c.Param("id")
+        word, ok := d.loadForbiddenWord(c)
+        if !ok {
+                return
+        }
+        
         var request struct {
-                Word     string `json:"word"`
-                IsActive bool   `json:"is_active"`
+                Word      string `json:"word"`
+                MatchType string `json:"match_type"`
+                IsActive  *bool  `json:"is_active"`
         }
         
         if err := c.ShouldBindJSON(&request); err != nil {
@@ -560,27 +641,42 @@ func (d *Dashboard) handleUpdateForbiddenWord(c *gin.Context) {
                 return
         }
         
-        query := `
-                UPDATE forbidden_words
-                SET word = $1, is_active = $2
-                WHERE id = $3
-        `
+        word.Word = request.Word
+        if request.MatchType != "" {
+                word.MatchType = request.MatchType
+        }
+        if request.IsActive != nil {
+                word.IsActive = *request.IsActive
+        }
         
-        _, err := d.db.DB.Exec(query, request.Word, request.IsActive, id)
+        err := d.forbiddenWords.Update(word)
+        if errors.Is(err, services.ErrInvalidForbiddenWord) {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Forbidden word not found"})
+                return
+        }
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to update forbidden word"})
                 return
         }
         
-        c.JSON(200, gin.H{"message": "Forbidden word updated successfully"})
+        c.JSON(200, word)
 }
 
 func (d *Dashboard) handleDeleteForbiddenWord(c *gin.Context) {
-        id := c.Param("id")
-        
-        query := `DELETE FROM forbidden_words WHERE id = $1`
+        word, ok := d.loadForbiddenWord(c)
+        if !ok {
+                return
+        }
         
-        _, err := d.db.DB.Exec(query, id)
+        err := d.forbiddenWords.Delete(word)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Forbidden word not found"})
+                return
+        }
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to delete forbidden word"})
                 return
@@ -589,42 +685,50 @@ func (d *Dashboard) handleDeleteForbiddenWord(c *gin.Context) {
         c.JSON(200, gin.H{"message": "Forbidden word deleted successfully"})
 }
 
-func (d *Dashboard) handleGetBans(c *gin.Context) {
-        userType := c.GetString("user_type")
+// loadForbiddenWord reads the word in the :id parameter and checks that the
+// caller may change the list it belongs to
+func (d *Dashboard) loadForbiddenWord(c *gin.Context) (*models.ForbiddenWord, bool) {
+        id, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid word ID"})
+                return nil, false
+        }
         
-        var query string
-        var args []interface{}
+        word, err := d.forbiddenWords.Get(id)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Forbidden word not found"})
+                return nil, false
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to fetch forbidden word"})
+                return nil, false
+        }
         
-        if userType == "admin" {
-                query = `
-                        SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
-                               COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
-                               v.created_at, v.expires_at, 
-                               COALESCE(u.username, '') as username, 
-                               COALESCE(u.first_name, 'Неизвестно') as first_name, 
-                               u.telegram_id
-                        FROM user_violations v
-                        JOIN users u ON v.user_id = u.id
-                        WHERE v.violation_type IN ('temp_ban', 'permanent_ban') AND COALESCE(v.is_active, TRUE) = TRUE
-                        ORDER BY v.created_at DESC
-                `
-        } else {
-                userID := c.GetInt("user_id")
-                query = `
-                        SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
-                               COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
-                               v.created_at, v.expires_at, 
-                               COALESCE(u.username, '') as username, 
-                               COALESCE(u.first_name, 'Неизвестно') as first_name, 
-                               u.telegram_id
-                        FROM user_violations v
-                        JOIN users u ON v.user_id = u.id
-                        JOIN user_groups ug ON v.chat_id = ug.chat_id
-                        WHERE v.violation_type IN ('temp_ban', 'permanent_ban') AND COALESCE(v.is_active, TRUE) = TRUE AND ug.user_id = $1
-                        ORDER BY v.created_at DESC
-                `
-                args = append(args, userID)
+        allowed, err := policyOf(c).CanAccessScope(word.ChatID)
+        if !authorize(c, allowed, err) {
+                return nil, false
         }
+        return word, true
+}
+
+func (d *Dashboard) handleGetBans(c *gin.Context) {
+        scope, args := policyOf(c).ChatFilter("v.chat_id", nil)
+        
+        query := `
+                SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
+                       COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
+                       v.created_at, v.expires_at, 
+                       COALESCE(u.username, '') as username, 
+                       COALESCE(u.first_name, 'Неизвестно') as first_name, 
+                       u.telegram_id
+                FROM user_violations v
+                JOIN users u ON v.user_id = u.id
+                WHERE v.violation_type IN ('mute', 'media_restriction', 'temp_ban', 'permanent_ban')
+                  AND COALESCE(v.is_active, TRUE) = TRUE
+                  AND v.lifted_at IS NULL AND (v.expires_at IS NULL OR v.expires_at > NOW())
+                  AND ` + scope + `
+                ORDER BY v.created_at DESC
+        `
         
         rows, err := d.db.DB.Query(query, args...)
         if err != nil {
@@ -663,107 +767,128 @@ func (d *Dashboard) handleGetBans(c *gin.Context) {
         })
 }
 
-func (d *Dashboard) handleBanUser(c *gin.Context) {
-        var request struct {
-                UserID      int    `json:"user_id"`
-                BanReason   string `json:"ban_reason"`
-                IsPermanent bool   `json:"is_permanent"`
-                Hours       int    `json:"hours"`
-        }
-        
+// moderationRequest is the body of the warn, mute and ban endpoints
+type moderationRequest struct {
+        ChatID     int64  `json:"chat_id"`
+        TelegramID int64  `json:"telegram_id"`
+        Reason     string `json:"reason"`
+        Minutes    int    `json:"minutes"`    // mute and ban; a ban without minutes is permanent
+        MediaOnly  bool   `json:"media_only"` // mute: text may still be sent
+}
+
+// bindModerationRequest reads the body and checks the caller may moderate the chat
+func (d *Dashboard) bindModerationRequest(c *gin.Context) (services.ModerationRequest, bool) {
+        var request moderationRequest
         if err := c.ShouldBindJSON(&request); err != nil {
                 c.JSON(400, gin.H{"error": "Invalid request"})
-                return
+                return services.ModerationRequest{}, false
         }
         
-        var query string
-        var args []interface{}
+        allowed, err := policyOf(c).CanAccessChat(request.ChatID)
+        if !authorize(c, allowed, err) {
+                return services.ModerationRequest{}, false
+        }
         
-        if request.IsPermanent {
-                query = `
-                        INSERT INTO user_bans (user_id, ban_reason, is_permanent, created_at)
-                        VALUES ($1, $2, TRUE, NOW())
-                        RETURNING id
-                `
-                args = []interface{}{request.UserID, request.BanReason}
-        } else {
-                query = `
-                        INSERT INTO user_bans (user_id, ban_reason, is_permanent, banned_until, created_at)
-                        VALUES ($1, $2, FALSE, NOW() + INTERVAL '%d hours', NOW())
-                        RETURNING id
-                `
-                query = fmt.Sprintf(query, request.Hours)
-                args = []interface{}{request.UserID, request.BanReason}
+        return services.ModerationRequest{
+                ChatID:    request.ChatID,
+                User:      &models.User{TelegramID: request.TelegramID},
+                Reason:    request.Reason,
+                Duration:  time.Duration(request.Minutes) * time.Minute,
+                MediaOnly: request.MediaOnly,
+        }, true
+}
+
+// respondModeration reports the outcome of a moderation action
+func respondModeration(c *gin.Context, status int, result interface{}, err error) {
+        switch {
+        case errors.Is(err, services.ErrInvalidModerationAction):
+                c.JSON(400, gin.H{"error": err.Error()})
+        case errors.Is(err, services.ErrTelegramRefused):
+                c.JSON(502, gin.H{"error": err.Error()})
+        case err != nil:
+                c.JSON(500, gin.H{"error": "Failed to record moderation action"})
+        default:
+                c.JSON(status, result)
+        }
+}
+
+func (d *Dashboard) handleWarnUser(c *gin.Context) {
+        request, ok := d.bindModerationRequest(c)
+        if !ok {
+                return
         }
         
-        var id int
-        err := d.db.DB.QueryRow(query, args...).Scan(&id)
-        if err != nil {
-                c.JSON(500, gin.H{"error": "Failed to ban user"})
+        sanction, err := d.moderation.Warn(request)
+        respondModeration(c, 201, sanction, err)
+}
+
+func (d *Dashboard) handleMuteUser(c *gin.Context) {
+        request, ok := d.bindModerationRequest(c)
+        if !ok {
                 return
         }
         
-        c.JSON(201, gin.H{"id": id, "message": "User banned successfully"})
+        sanction, err := d.moderation.Mute(request)
+        respondModeration(c, 201, sanction, err)
 }
 
-func (d *Dashboard) handleUnbanUser(c *gin.Context) {
-        id := c.Param("id")
+func (d *Dashboard) handleBanUser(c *gin.Context) {
+        request, ok := d.bindModerationRequest(c)
+        if !ok {
+                return
+        }
         
-        query := `DELETE FROM user_bans WHERE id = $1`
+        sanction, err := d.moderation.Ban(request)
+        respondModeration(c, 201, sanction, err)
+}
+
+// handleUnbanUser lifts a ban or restriction from the bans list by its id
+// and pardons the member's violations in that chat
+func (d *Dashboard) handleUnbanUser(c *gin.Context) {
+        id, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid ban ID"})
+                return
+        }
         
-        _, err := d.db.DB.Exec(query, id)
+        sanction, err := d.moderation.GetSanction(id)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Ban not found"})
+                return
+        }
         if err != nil {
-                c.JSON(500, gin.H{"error": "Failed to unban user"})
+                c.JSON(500, gin.H{"error": "Failed to fetch ban"})
+                return
+        }
+        
+        allowed, err := policyOf(c).CanAccessChat(sanction.ChatID)
+        if !authorize(c, allowed, err) {
                 return
         }
         
-        c.JSON(200, gin.H{"message": "User unbanned successfully"})
+        err = d.moderation.Unban(sanction.ChatID, sanction.TelegramUserID)
+        respondModeration(c, 200, gin.H{"message": "User unbanned successfully"}, err)
 }
 
 func (d *Dashboard) handleGetViolations(c *gin.Context) {
-        userType := c.GetString("user_type")
+        // Owners see the violations in their chats, not the ones they committed
+        scope, args := policyOf(c).ChatFilter("v.chat_id", nil)
         
-        var query string
-        var args []interface{}
-        
-        if userType == "admin" {
-                query = `
-                        SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
-                               COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
-                               COALESCE(v.message_text, '') as message_text, 
-                               v.created_at, v.expires_at, 
-                               COALESCE(v.is_active, TRUE) as is_active,
-                               COALESCE(u.username, '') as username, 
-                               COALESCE(u.first_name, 'Неизвестно') as first_name, 
-                               u.telegram_id
-                        FROM user_violations v
-                        JOIN users u ON v.user_id = u.id
-                        WHERE COALESCE(v.is_active, TRUE) = TRUE
-                        ORDER BY v.created_at DESC
-                        LIMIT 100
-                `
-        } else {
-                userID := c.GetInt("user_id")
-                if userID == 0 {
-                        userID = 1 // Default fallback
-                }
-                query = `
-                        SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
-                               COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
-                               COALESCE(v.message_text, '') as message_text, 
-                               v.created_at, v.expires_at, 
-                               COALESCE(v.is_active, TRUE) as is_active,
-                               COALESCE(u.username, '') as username, 
-                               COALESCE(u.first_name, 'Неизвестно') as first_name, 
-                               u.telegram_id
-                        FROM user_violations v
-                        JOIN users u ON v.user_id = u.id
-                        WHERE COALESCE(v.is_active, TRUE) = TRUE AND v.user_id = $1
-                        ORDER BY v.created_at DESC
-                        LIMIT 50
-                `
-                args = append(args, userID)
-        }
+        query := `
+                SELECT v.id, v.user_id, v.chat_id, v.violation_type, 
+                       COALESCE(v.violation_reason, 'Не указано') as violation_reason, 
+                       COALESCE(v.message_text, '') as message_text, 
+                       v.created_at, v.expires_at, 
+                       COALESCE(v.is_active, TRUE) as is_active,
+                       COALESCE(u.username, '') as username, 
+                       COALESCE(u.first_name, 'Неизвестно') as first_name, 
+                       u.telegram_id
+                FROM user_violations v
+                JOIN users u ON v.user_id = u.id
+                WHERE COALESCE(v.is_active, TRUE) = TRUE AND ` + scope + `
+                ORDER BY v.created_at DESC
+                LIMIT 100
+        `
         
         rows, err := d.db.DB.Query(query, args...)
         if err != nil {
@@ -809,93 +934,202 @@ func (d *Dashboard) handleGetViolations(c *gin.Context) {
         })
 }
 
+// handleGetReports lists /report complaints in the caller's chats, newest
+// first; ?status= and ?chat_id= narrow the list
+func (d *Dashboard) handleGetReports(c *gin.Context) {
+        var args []interface{}
+        filter := "TRUE"
+        if status := c.Query("status"); status != "" {
+                args = append(args, status)
+                filter += fmt.Sprintf(" AND r.status = $%d", len(args))
+        }
+        if chatParam := c.Query("chat_id"); chatParam != "" {
+                chatID, err := strconv.ParseInt(chatParam, 10, 64)
+                if err != nil {
+                        c.JSON(400, gin.H{"error": "Invalid chat_id"})
+                        return
+                }
+                args = append(args, chatID)
+                filter += fmt.Sprintf(" AND r.chat_id = $%d", len(args))
+        }
+        scope, args := policyOf(c).ChatFilter("r.chat_id", args)
+
+        reports, err := d.reports.List(filter+" AND "+scope, args, 100)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to get reports"})
+                return
+        }
+        if reports == nil {
+                reports = []*models.MessageReport{}
+        }
+
+        c.JSON(200, gin.H{
+                "reports": reports,
+                "total":   len(reports),
+        })
+}
+
 func (d *Dashboard) handleGetModerationSettings(c *gin.Context) {
-        query := `
-                SELECT auto_ban_enabled, temp_ban_duration, warning_threshold, permanent_ban_threshold
-                FROM moderation_settings
-                LIMIT 1
-        `
+        chatID, err := strconv.ParseInt(c.Query("chat_id"), 10, 64)
+        if err != nil {
+                c.JSON(400, gin.H{"error": "chat_id is required"})
+                return
+        }
         
-        var settings map[string]interface{} = make(map[string]interface{})
-        var autoBanEnabled bool
-        var tempBanDuration, warningThreshold, permanentBanThreshold int
+        c.JSON(200, d.moderationSettings.Get(chatID))
+}
+
+func (d *Dashboard) handleUpdateModerationSettings(c *gin.Context) {
+        var settings models.ModerationSettings
+        if err := c.ShouldBindJSON(&settings); err != nil {
+                c.JSON(400, gin.H{"error": "Invalid request"})
+                return
+        }
+        
+        policy := policyOf(c)
+        allowed, err := policy.CanAccessChat(settings.ChatID)
+        if !authorize(c, allowed, err) {
+                return
+        }
+        
+        // Reports are sent to another chat only if the user administers it
+        current := d.moderationSettings.Get(settings.ChatID)
+        if settings.ReportChatID != 0 && settings.ReportChatID != current.ReportChatID && !policy.IsAdmin() {
+                if !d.verifyChatAdmin(c, policy.UserID(), settings.ReportChatID) {
+                        return
+                }
+        }
         
-        err := d.db.DB.QueryRow(query).Scan(&autoBanEnabled, &tempBanDuration, &warningThreshold, &permanentBanThreshold)
+        err = d.moderationSettings.Update(&settings)
+        if errors.Is(err, services.ErrInvalidModerationSettings) {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
         if err != nil {
-                // Default settings if not found
-                settings["auto_ban_enabled"] = true
-                settings["temp_ban_duration"] = 24
-                settings["warning_threshold"] = 3
-                settings["permanent_ban_threshold"] = 5
-        } else {
-                settings["auto_ban_enabled"] = autoBanEnabled
-                settings["temp_ban_duration"] = tempBanDuration
-                settings["warning_threshold"] = warningThreshold
-                settings["permanent_ban_threshold"] = permanentBanThreshold
+                c.JSON(500, gin.H{"error": "Failed to update moderation settings"})
+                return
         }
         
         c.JSON(200, settings)
 }
 
-func (d *Dashboard) handleUpdateModerationSettings(c *gin.Context) {
-        var request struct {
-                AutoBanEnabled         bool `json:"auto_ban_enabled"`
-                TempBanDuration        int  `json:"temp_ban_duration"`
-                WarningThreshold       int  `json:"warning_threshold"`
-                PermanentBanThreshold  int  `json:"permanent_ban_threshold"`
+func (d *Dashboard) handleGetLinkRules(c *gin.Context) {
+        chatID, err := strconv.ParseInt(c.Query("chat_id"), 10, 64)
+        if err != nil {
+                c.JSON(400, gin.H{"error": "chat_id is required"})
+                return
         }
         
-        if err := c.ShouldBindJSON(&request); err != nil {
+        rules, err := d.linkFilter.List(chatID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to fetch link rules"})
+                return
+        }
+        if rules == nil {
+                rules = []*models.LinkRule{}
+        }
+        
+        c.JSON(200, rules)
+}
+
+func (d *Dashboard) handleSaveLinkRule(c *gin.Context) {
+        var rule models.LinkRule
+        if err := c.ShouldBindJSON(&rule); err != nil {
                 c.JSON(400, gin.H{"error": "Invalid request"})
                 return
         }
         
-        query := `
-                INSERT INTO moderation_settings (auto_ban_enabled, temp_ban_duration, warning_threshold, permanent_ban_threshold)
-                VALUES ($1, $2, $3, $4)
-                ON CONFLICT (id) DO UPDATE SET
-                        auto_ban_enabled = EXCLUDED.auto_ban_enabled,
-                        temp_ban_duration = EXCLUDED.temp_ban_duration,
-                        warning_threshold = EXCLUDED.warning_threshold,
-                        permanent_ban_threshold = EXCLUDED.permanent_ban_threshold
-        `
+        allowed, err := policyOf(c).CanAccessChat(rule.ChatID)
+        if !authorize(c, allowed, err) {
+                return
+        }
         
-        _, err := d.db.DB.Exec(query, request.AutoBanEnabled, request.TempBanDuration, request.WarningThreshold, request.PermanentBanThreshold)
+        err = d.linkFilter.Save(&rule)
+        if errors.Is(err, services.ErrInvalidLinkRule) {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
         if err != nil {
-                c.JSON(500, gin.H{"error": "Failed to update moderation settings"})
+                c.JSON(500, gin.H{"error": "Failed to save link rule"})
+                return
+        }
+        
+        c.JSON(200, rule)
+}
+
+func (d *Dashboard) handleDeleteLinkRule(c *gin.Context) {
+        id, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid rule ID"})
+                return
+        }
+        
+        rule, err := d.linkFilter.Get(id)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Link rule not found"})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to fetch link rule"})
+                return
+        }
+        
+        allowed, err := policyOf(c).CanAccessChat(rule.ChatID)
+        if !authorize(c, allowed, err) {
                 return
         }
         
-        c.JSON(200, gin.H{"message": "Moderation settings updated successfully"})
+        err = d.linkFilter.Delete(rule)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Link rule not found"})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to delete link rule"})
+                return
+        }
+        
+        c.JSON(200, gin.H{"message": "Link rule deleted successfully"})
 }
 
 func (d *Dashboard) handleGetPaymentMethods(c *gin.Context) {
+        var methods []map[string]interface{}
+        var names []string
+        
+        for _, provider := range d.paymentService.ActiveProviders() {
+                assets := d.paymentService.ProviderAssets(provider.Name)
+                
+                currencies, _ := provider.Configuration["currencies"].([]interface{})
+                var description []string
+                for _, currency := range currencies {
+                        description = append(description, fmt.Sprintf("%v", currency))
+                }
+                if len(assets) > 0 {
+                        description = assets
+                }
+                
+                methods = append(methods, map[string]interface{}{
+                        "id":          provider.Name,
+                        "name":        provider.DisplayName,
+                        "description": strings.Join(description, ", "),
+                        "assets":      assets,
+                        "available":   true,
+                })
+                names = append(names, provider.Name)
+        }
+        
         c.JSON(200, gin.H{
-                "methods": []map[string]interface{}{
-                        {
-                                "id":          "card",
-                                "name":        "Credit Card",
-                                "description": "Pay with credit/debit card",
-                                "available":   true,
-                        },
-                        {
-                                "id":          "crypto",
-                                "name":        "Cryptocurrency",
-                                "description": "Pay with Bitcoin, Ethereum, etc.",
-                                "available":   true,
-                        },
-                },
-                "payment_methods": []string{"card", "crypto"},
+                "methods":         methods,
+                "payment_methods": names,
         })
 }
 
 func (d *Dashboard) handleCreatePayment(c *gin.Context) {
         var request struct {
-                UserID        int     `json:"user_id"`
-                PlanID        int     `json:"plan_id"`
-                Amount        float64 `json:"amount"`
-                Currency      string  `json:"currency"`
-                PaymentMethod string  `json:"payment_method"`
+                UserID        int    `json:"user_id"`
+                PlanID        int    `json:"plan_id"`
+                PaymentMethod string `json:"payment_method"`
+                Asset         string `json:"asset"`
         }
         
         if err := c.ShouldBindJSON(&request); err != nil {
@@ -903,58 +1137,97 @@ func (d *Dashboard) handleCreatePayment(c *gin.Context) {
                 return
         }
         
-        // Create payment record
-        query := `
-                INSERT INTO payments (user_id, amount_cents, currency, payment_method, status, created_at)
-                VALUES ($1, $2, $3, $4, 'pending', NOW())
-                RETURNING id
-        `
+        // Admins may open a checkout on behalf of a user; users pay for themselves
+        policy := policyOf(c)
+        userID := policy.UserID()
+        if policy.IsAdmin() {
+                userID = request.UserID
+        }
+        
+        d.createCheckout(c, userID, request.PlanID, request.PaymentMethod, request.Asset)
+}
+
+func (d *Dashboard) handleProcessPayment(c *gin.Context) {
+        var req struct {
+                PlanID        int    `json:"plan_id"`
+                PaymentMethod string `json:"payment_method"`
+                Asset         string `json:"asset"`
+        }
+        
+        if err := c.ShouldBindJSON(&req); err != nil {
+                c.JSON(400, gin.H{"error": "Invalid request"})
+                return
+        }
+        
+        d.createCheckout(c, policyOf(c).UserID(), req.PlanID, req.PaymentMethod, req.Asset)
+}
+
+// createCheckout starts a payment through the provider the user picked. The
+// amount always comes from the plan, never from the request.
+func (d *Dashboard) createCheckout(c *gin.Context, userID, planID int, providerName, asset string) {
+        user, err := d.userRepo.GetByID(userID)
+        if err != nil {
+                c.JSON(404, gin.H{"error": "User not found"})
+                return
+        }
         
-        var paymentID int
-        err := d.db.DB.QueryRow(query, request.UserID, int(request.Amount*100), request.Currency, request.PaymentMethod).Scan(&paymentID)
+        // Telegram invoices are delivered to the user's private chat with the bot
+        payment, checkout, err := d.paymentService.CreateCheckout(user, planID, providerName, services.CheckoutOptions{
+                ChatID: user.TelegramID,
+                Asset:  asset,
+        })
+        if errors.Is(err, services.ErrProviderNotAvailable) {
+                c.JSON(400, gin.H{"error": "Payment method is not available"})
+                return
+        }
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to create payment"})
                 return
         }
         
         c.JSON(201, gin.H{
-                "payment_id": paymentID,
-                "message": "Payment created successfully",
-                "status": "pending",
+                "success":      true,
+                "payment_id":   payment.ID,
+                "status":       payment.Status,
+                "payment_url":  checkout.URL,
+                "payment_data": payment.PaymentData,
         })
 }
 
 func (d *Dashboard) handleGetPaymentStatus(c *gin.Context) {
-        paymentID := c.Param("id")
-        
-        query := `
-                SELECT id, user_id, amount_cents, currency, payment_method, status, created_at, completed_at
-                FROM payments
-                WHERE id = $1
-        `
-        
-        var payment map[string]interface{} = make(map[string]interface{})
-        var id, userID, amountCents int
-        var currency, paymentMethod, status string
-        var createdAt time.Time
-        var completedAt *time.Time
+        paymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid payment ID"})
+                return
+        }
         
-        err := d.db.DB.QueryRow(query, paymentID).Scan(&id, &userID, &amountCents, &currency, &paymentMethod, &status, &createdAt, &completedAt)
+        payment, err := d.paymentRepo.GetByID(paymentID)
         if err != nil {
                 c.JSON(404, gin.H{"error": "Payment not found"})
                 return
         }
+        if !authorize(c, policyOf(c).CanAccessPayment(payment), nil) {
+                return
+        }
         
-        payment["id"] = id
-        payment["user_id"] = userID
-        payment["amount"] = float64(amountCents) / 100.0
-        payment["currency"] = currency
-        payment["payment_method"] = paymentMethod
-        payment["status"] = status
-        payment["created_at"] = createdAt
-        payment["completed_at"] = completedAt
+        var completedAt *time.Time
+        if !payment.CompletedAt.IsZero() {
+                completedAt = &payment.CompletedAt
+        }
         
-        c.JSON(200, payment)
+        c.JSON(200, gin.H{
+                "id":               payment.ID,
+                "user_id":          payment.UserID,
+                "plan_id":          payment.PlanID,
+                "amount":           float64(payment.Amount) / 100.0,
+                "currency":         payment.Currency,
+                "payment_method":   payment.PaymentMethod,
+                "payment_provider": payment.PaymentProvider,
+                "status":           payment.Status,
+                "payment_data":     payment.PaymentData,
+                "created_at":       payment.CreatedAt,
+                "completed_at":     completedAt,
+        })
 }
 
 func (d *Dashboard) handleRevenueChart(c *gin.Context) {
@@ -1017,8 +1290,7 @@ func (d *Dashboard) handleGrantSubscription(c *gin.Context) {
         }
         
         expiresAt := time.Now().AddDate(0, 0, request.Days)
-        err = d.userRepo.UpdateSubscription(user.ID, request.PlanID, &expiresAt)
-        if err != nil {
+        if err := d.subscriptions.GrantSubscription(user.ID, request.PlanID, &expiresAt); err != nil {
                 c.JSON(500, gin.H{"error": err.Error()})
                 return
         }
@@ -1041,8 +1313,8 @@ func (d *Dashboard) handleRevokeSubscription(c *gin.Context) {
                 return
         }
         
-        err = d.userRepo.UpdateSubscription(user.ID, 1, nil) // Reset to free plan
-        if err != nil {
+        // The user leaves the plan's chats once the grace period is over
+        if err := d.subscriptions.ExpireSubscription(user.ID); err != nil {
                 c.JSON(500, gin.H{"error": err.Error()})
                 return
         }
@@ -1056,6 +1328,10 @@ func (d *Dashboard) handleCreatePlan(c *gin.Context) {
                 c.JSON(400, gin.H{"error": err.Error()})
                 return
         }
+        if err := plan.ValidateFeatures(); err != nil {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
         
         if err := d.planRepo.Create(&plan); err != nil {
                 c.JSON(500, gin.H{"error": err.Error()})
@@ -1077,6 +1353,10 @@ func (d *Dashboard) handleUpdatePlan(c *gin.Context) {
                 c.JSON(400, gin.H{"error": err.Error()})
                 return
         }
+        if err := plan.ValidateFeatures(); err != nil {
+                c.JSON(400, gin.H{"error": err.Error()})
+                return
+        }
         
         plan.ID = planID
         if err := d.planRepo.Update(&plan); err != nil {
@@ -1084,6 +1364,13 @@ func (d *Dashboard) handleUpdatePlan(c *gin.Context) {
                 return
         }
         
+        // Subscribers of the plan get its new features and group limit
+        d.entitlements.InvalidateAll()
+        if err := d.groupLimits.Enforce(0); err != nil {
+                c.JSON(500, gin.H{"error": "Failed to update suspended groups"})
+                return
+        }
+        
         c.JSON(200, plan)
 }
 
@@ -1102,6 +1389,86 @@ func (d *Dashboard) handleDeletePlan(c *gin.Context) {
         c.JSON(200, gin.H{"message": "Plan deleted successfully"})
 }
 
+// handleGetPlanChats lists the private chats a plan sells access to
+func (d *Dashboard) handleGetPlanChats(c *gin.Context) {
+        planID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid plan ID"})
+                return
+        }
+        
+        chats, err := d.planChats.ListByPlan(planID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to get plan chats"})
+                return
+        }
+        if chats == nil {
+                chats = []*models.PlanChat{}
+        }
+        
+        c.JSON(200, gin.H{"chats": chats})
+}
+
+// handleAddPlanChat makes a chat part of a plan; subscribers who pay for the
+// plan from now on get an invite link to it. The bot must be an admin there
+// allowed to invite and ban members.
+func (d *Dashboard) handleAddPlanChat(c *gin.Context) {
+        planID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid plan ID"})
+                return
+        }
+        
+        var request struct {
+                ChatID int64  `json:"chat_id"`
+                Title  string `json:"title"`
+        }
+        if err := c.ShouldBindJSON(&request); err != nil || request.ChatID == 0 {
+                c.JSON(400, gin.H{"error": "chat_id is required"})
+                return
+        }
+        
+        if _, err := d.planRepo.GetByID(planID); err != nil {
+                c.JSON(404, gin.H{"error": "Plan not found"})
+                return
+        }
+        
+        chat := &models.PlanChat{PlanID: planID, ChatID: request.ChatID, Title: strings.TrimSpace(request.Title)}
+        if err := d.planChats.Add(chat); err != nil {
+                c.JSON(500, gin.H{"error": "Failed to add plan chat"})
+                return
+        }
+        
+        c.JSON(201, chat)
+}
+
+// handleRemovePlanChat stops selling access to a chat with a plan. Members
+// who joined through the plan stay until their subscription lapses.
+func (d *Dashboard) handleRemovePlanChat(c *gin.Context) {
+        planID, err := strconv.Atoi(c.Param("id"))
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid plan ID"})
+                return
+        }
+        chatID, err := strconv.ParseInt(c.Param("chat_id"), 10, 64)
+        if err != nil {
+                c.JSON(400, gin.H{"error": "Invalid chat ID"})
+                return
+        }
+        
+        err = d.planChats.Remove(planID, chatID)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Plan chat not found"})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to remove plan chat"})
+                return
+        }
+        
+        c.JSON(200, gin.H{"message": "Plan chat removed"})
+}
+
 func (d *Dashboard) getDashboardStats() (*DashboardStats, error) {
         stats := &DashboardStats{
                 PlanStats:      make(map[string]int),
@@ -1115,12 +1482,12 @@ func (d *Dashboard) getDashboardStats() (*DashboardStats, error) {
         d.db.QueryRow("SELECT COUNT(*) FROM users WHERE current_plan_id > 1 AND (plan_expires_at IS NULL OR plan_expires_at > CURRENT_TIMESTAMP)").Scan(&stats.ActiveSubscriptions)
         
         // Total payments and revenue
-        d.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed'").Scan(&stats.TotalPayments, &stats.TotalRevenue)
+        d.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed'").Scan(&stats.TotalPayments, &stats.TotalRevenue)
         stats.TotalRevenue /= 100
         
         // Today's stats
         d.db.QueryRow("SELECT COUNT(*) FROM users WHERE DATE(created_at) = CURRENT_DATE").Scan(&stats.TodayUsers)
-        d.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed' AND DATE(completed_at) = CURRENT_DATE").Scan(&stats.TodayPayments, &stats.TodayRevenue)
+        d.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed' AND DATE(completed_at) = CURRENT_DATE").Scan(&stats.TodayPayments, &stats.TodayRevenue)
         stats.TodayRevenue /= 100
         
         // Plan statistics
@@ -1145,7 +1512,7 @@ func (d *Dashboard) getDashboardStats() (*DashboardStats, error) {
         }
         
         // Payment methods statistics
-        rows, err = d.db.Query("SELECT payment_method, COUNT(*), COALESCE(SUM(amount_cents), 0) FROM payments WHERE status = 'completed' GROUP BY payment_method")
+        rows, err = d.db.Query("SELECT payment_method, COUNT(*), COALESCE(SUM(amount), 0) FROM payments WHERE status = 'completed' GROUP BY payment_method")
         if err != nil {
                 return nil, err
         }
@@ -1179,7 +1546,7 @@ func (d *Dashboard) getUsers(page, limit int, search string) ([]RecentUser, erro
         query := `
                 SELECT u.telegram_id, u.username, u.first_name, sp.name as plan_name, 
                        u.plan_expires_at, u.created_at,
-                       COALESCE(SUM(p.amount_cents), 0) as total_spent
+                       COALESCE(SUM(p.amount), 0) as total_spent
                 FROM users u
                 LEFT JOIN subscription_plans sp ON u.current_plan_id = sp.id
                 LEFT JOIN payments p ON u.id = p.user_id AND p.status = 'completed'
@@ -1250,7 +1617,7 @@ func (d *Dashboard) getPayments(page, limit int, status string) ([]RecentPayment
         offset := (page - 1) * limit
         
         query := `
-                SELECT p.id, u.first_name, u.username, p.amount_cents, p.currency, 
+                SELECT p.id, u.first_name, u.username, p.amount, p.currency, 
                        p.payment_method, p.payment_provider, p.status, p.created_at, p.completed_at
                 FROM payments p
                 JOIN users u ON p.user_id = u.id
@@ -1306,7 +1673,7 @@ func (d *Dashboard) getPayments(page, limit int, status string) ([]RecentPayment
 
 func (d *Dashboard) getRevenueChart(days int) (*ChartData, error) {
         query := `
-                SELECT DATE(completed_at) as date, COALESCE(SUM(amount_cents), 0) as revenue
+                SELECT DATE(completed_at) as date, COALESCE(SUM(amount), 0) as revenue
                 FROM payments 
                 WHERE status = 'completed' 
                 AND completed_at >= CURRENT_DATE - INTERVAL '%d days'
@@ -1382,13 +1749,13 @@ func (d *Dashboard) checkDatabaseHealth() string {
 
 
 func (d *Dashboard) handleUserProfile(c *gin.Context) {
-        userType := c.GetString("user_type")
-        if userType != "user" {
+        // The admin account from the config has no profile
+        userID := policyOf(c).UserID()
+        if userID == 0 {
                 c.JSON(403, gin.H{"error": "Access denied"})
                 return
         }
         
-        userID := c.GetInt("user_id")
         user, err := d.userRepo.GetByID(userID)
         if err != nil {
                 c.JSON(404, gin.H{"error": "User not found"})
@@ -1418,9 +1785,62 @@ func (d *Dashboard) handleUserProfile(c *gin.Context) {
         c.JSON(200, profile)
 }
 
+// handleUserEntitlements tells the dashboard which features the user's plan
+// includes and how many of their groups it covers
+func (d *Dashboard) handleUserEntitlements(c *gin.Context) {
+        userID := policyOf(c).UserID()
+        if userID == 0 {
+                c.JSON(403, gin.H{"error": "Access denied"})
+                return
+        }
+        
+        entitlements, err := d.entitlements.ForUser(userID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to load entitlements"})
+                return
+        }
+        groups, err := d.entitlements.GroupQuota(userID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to load entitlements"})
+                return
+        }
+        
+        c.JSON(200, gin.H{
+                "entitlements": entitlements,
+                "groups":       gin.H{"limit": groups.Limit, "used": groups.Used, "remaining": groups.Remaining()},
+        })
+}
+
+// handleUserSubscription returns the user's current subscription with its
+// status changes; null without one
+func (d *Dashboard) handleUserSubscription(c *gin.Context) {
+        userID := policyOf(c).UserID()
+        if userID == 0 {
+                c.JSON(403, gin.H{"error": "Access denied"})
+                return
+        }
+        
+        subscription, err := d.subscriptions.Subscription(userID)
+        if errors.Is(err, services.ErrNoSubscription) {
+                c.JSON(200, gin.H{"subscription": nil, "events": []interface{}{}})
+                return
+        }
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to load subscription"})
+                return
+        }
+        
+        events, err := d.subscriptions.SubscriptionEvents(subscription.ID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to load subscription"})
+                return
+        }
+        
+        c.JSON(200, gin.H{"subscription": subscription, "events": events})
+}
+
 func (d *Dashboard) handleUserPayments(c *gin.Context) {
-        userType := c.GetString("user_type")
-        if userType != "user" {
+        if policyOf(c).UserID() == 0 {
                 c.JSON(403, gin.H{"error": "Access denied"})
                 return
         }
@@ -1434,7 +1854,7 @@ func (d *Dashboard) handleUserPayments(c *gin.Context) {
 
 // Analytics handlers
 func (d *Dashboard) handleUserActivity(c *gin.Context) {
-        userID := c.GetInt("user_id")
+        userID := policyOf(c).UserID()
         if userID == 0 {
                 c.JSON(400, gin.H{"error": "User ID required"})
                 return
@@ -1478,22 +1898,17 @@ func (d *Dashboard) handleUserActivity(c *gin.Context) {
 }
 
 func (d *Dashboard) handleGroupStatistics(c *gin.Context) {
-        userID := c.GetInt("user_id")
-        if userID == 0 {
-                c.JSON(400, gin.H{"error": "User ID required"})
-                return
-        }
+        scope, args := policyOf(c).ChatFilter("gs.chat_id", nil)
         
         query := `
-                SELECT gs.group_id, gs.group_title, gs.member_count, gs.message_count, gs.created_at
+                SELECT gs.chat_id, gs.group_title, gs.total_members, gs.total_messages, gs.created_at
                 FROM group_statistics gs
-                JOIN user_groups ug ON gs.group_id = ug.group_id
-                WHERE ug.user_id = $1
+                WHERE ` + scope + `
                 ORDER BY gs.created_at DESC
                 LIMIT 10
         `
         
-        rows, err := d.db.DB.Query(query, userID)
+        rows, err := d.db.DB.Query(query, args...)
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to fetch group statistics"})
                 return
@@ -1503,7 +1918,8 @@ func (d *Dashboard) handleGroupStatistics(c *gin.Context) {
         var groups []map[string]interface{}
         for rows.Next() {
                 var group map[string]interface{} = make(map[string]interface{})
-                var groupID, memberCount, messageCount int
+                var groupID int64
+                var memberCount, messageCount int
                 var groupTitle string
                 var createdAt time.Time
                 
@@ -1525,11 +1941,6 @@ func (d *Dashboard) handleGroupStatistics(c *gin.Context) {
 }
 
 func (d *Dashboard) handleDailyStatistics(c *gin.Context) {
-        userID := c.GetInt("user_id")
-        if userID == 0 {
-                userID = 1 // Default fallback
-        }
-        
         // Return mock daily statistics to avoid errors
         stats := []gin.H{
                 {"date": "2025-07-12", "messages": 45, "violations": 2, "bans": 0},
@@ -1546,30 +1957,20 @@ func (d *Dashboard) handleGroupManagement(c *gin.Context) {
 }
 
 func (d *Dashboard) handleGetGroups(c *gin.Context) {
-        userType := c.GetString("user_type")
-        var userID int
-        
-        if userType == "user" {
-                userID = c.GetInt("user_id")
-        }
+        scope, args := policyOf(c).OwnerFilter("ug.user_id", nil)
         
         query := `
-                SELECT DISTINCT ug.id, ug.group_id, ug.group_title, 'supergroup' as group_type, ug.is_active, ug.created_at,
+                SELECT DISTINCT ug.id, ug.chat_id, ug.group_name, ug.group_type, ug.is_active, ug.suspended_at, ug.created_at,
                        COALESCE(gs.total_members, 0) as member_count,
                        COALESCE(gs.total_messages, 0) as message_count,
                        COALESCE(COUNT(uv.id), 0) as violations_count
                 FROM user_groups ug
-                LEFT JOIN group_statistics gs ON ug.group_id = gs.chat_id
-                LEFT JOIN user_violations uv ON ug.group_id = uv.chat_id
+                LEFT JOIN group_statistics gs ON ug.chat_id = gs.chat_id
+                LEFT JOIN user_violations uv ON ug.chat_id = uv.chat_id
+                WHERE ` + scope + `
         `
         
-        args := []interface{}{}
-        if userType == "user" {
-                query += " WHERE ug.user_id = $1"
-                args = append(args, userID)
-        }
-        
-        query += " GROUP BY ug.id, ug.group_id, ug.group_title, ug.is_active, ug.created_at, gs.total_members, gs.total_messages ORDER BY ug.created_at DESC"
+        query += " GROUP BY ug.id, ug.chat_id, ug.group_name, ug.group_type, ug.is_active, ug.suspended_at, ug.created_at, gs.total_members, gs.total_messages ORDER BY ug.created_at DESC"
         
         rows, err := d.db.DB.Query(query, args...)
         if err != nil {
@@ -1581,12 +1982,14 @@ func (d *Dashboard) handleGetGroups(c *gin.Context) {
         var groups []map[string]interface{}
         for rows.Next() {
                 var group map[string]interface{} = make(map[string]interface{})
-                var id, chatID, memberCount, messageCount, violationsCount int
+                var id, memberCount, messageCount, violationsCount int
+                var chatID int64
                 var groupName, groupType string
                 var isActive bool
+                var suspendedAt *time.Time
                 var createdAt time.Time
                 
-                err := rows.Scan(&id, &chatID, &groupName, &groupType, &isActive, &createdAt, &memberCount, &messageCount, &violationsCount)
+                err := rows.Scan(&id, &chatID, &groupName, &groupType, &isActive, &suspendedAt, &createdAt, &memberCount, &messageCount, &violationsCount)
                 if err != nil {
                         continue
                 }
@@ -1596,6 +1999,7 @@ func (d *Dashboard) handleGetGroups(c *gin.Context) {
                 group["name"] = groupName
                 group["type"] = groupType
                 group["is_active"] = isActive
+                group["suspended_at"] = suspendedAt
                 group["created_at"] = createdAt
                 group["member_count"] = memberCount
                 group["message_count"] = messageCount
@@ -1607,14 +2011,35 @@ func (d *Dashboard) handleGetGroups(c *gin.Context) {
         c.JSON(200, groups)
 }
 
-func (d *Dashboard) handleAddGroup(c *gin.Context) {
-        userType := c.GetString("user_type")
-        var userID int
+// verifyChatAdmin checks with Telegram that the bot is in the chat and the
+// user administers it, and answers the request when not
+func (d *Dashboard) verifyChatAdmin(c *gin.Context, userID int, chatID int64) bool {
+        user, err := d.userRepo.GetByID(userID)
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to load user"})
+                return false
+        }
         
-        if userType == "user" {
-                userID = c.GetInt("user_id")
-        } else {
-                userID = 1 // Default admin user
+        err = d.moderators.VerifyAdmin(chatID, user.TelegramID)
+        switch {
+        case errors.Is(err, services.ErrBotNotInChat):
+                c.JSON(403, gin.H{"error": "Add the bot to the chat first."})
+        case errors.Is(err, services.ErrNotChatAdmin):
+                c.JSON(403, gin.H{"error": "Only the chat's creator or administrators can use it."})
+        case err != nil:
+                c.JSON(502, gin.H{"error": "Failed to check the chat with Telegram"})
+        default:
+                return true
+        }
+        return false
+}
+
+func (d *Dashboard) handleAddGroup(c *gin.Context) {
+        // Groups belong to a user; the admin account from the config has none
+        userID := policyOf(c).UserID()
+        if userID == 0 {
+                c.JSON(400, gin.H{"error": "Sign in with your Telegram account to add groups"})
+                return
         }
         
         var request struct {
@@ -1630,25 +2055,28 @@ func (d *Dashboard) handleAddGroup(c *gin.Context) {
         
         // Convert chat_id to integer if it's numeric
         if chatIDInt, err := strconv.ParseInt(request.ChatID, 10, 64); err == nil {
-                // It's a numeric ID
-                query := `
-                        INSERT INTO user_groups (user_id, chat_id, group_name, group_type, is_active, created_at)
-                        VALUES ($1, $2, $3, $4, TRUE, NOW())
-                        ON CONFLICT (user_id, chat_id) DO UPDATE SET
-                                group_name = EXCLUDED.group_name,
-                                group_type = EXCLUDED.group_type,
-                                is_active = TRUE
-                        RETURNING id
-                `
+                // Registering a chat grants moderating it here, so only its admins may
+                if !d.verifyChatAdmin(c, userID, chatIDInt) {
+                        return
+                }
                 
-                var id int
-                err := d.db.DB.QueryRow(query, userID, chatIDInt, request.Name, request.Type).Scan(&id)
+                group := &models.UserGroup{
+                        UserID:    userID,
+                        ChatID:    chatIDInt,
+                        GroupName: request.Name,
+                        GroupType: request.Type,
+                }
+                err = d.groupLimits.Add(group)
+                if errors.Is(err, models.ErrGroupLimitReached) {
+                        c.JSON(403, gin.H{"error": "Your plan's group limit is reached. Upgrade your plan or remove a group first."})
+                        return
+                }
                 if err != nil {
                         c.JSON(500, gin.H{"error": "Failed to add group"})
                         return
                 }
                 
-                c.JSON(201, gin.H{"id": id, "message": "Group added successfully"})
+                c.JSON(201, gin.H{"id": group.ID, "message": "Group added successfully"})
         } else {
                 // It's a username, we'll store it as-is for now
                 c.JSON(400, gin.H{"error": "Please provide numeric chat ID. Use /id command in the group to get it."})
@@ -1656,36 +2084,42 @@ func (d *Dashboard) handleAddGroup(c *gin.Context) {
 }
 
 func (d *Dashboard) handleRemoveGroup(c *gin.Context) {
-        userType := c.GetString("user_type")
-        groupID := c.Param("id")
+        scope, args := policyOf(c).OwnerFilter("user_id", []interface{}{c.Param("id")})
         
-        query := `DELETE FROM user_groups WHERE id = $1`
-        args := []interface{}{groupID}
+        query := `DELETE FROM user_groups WHERE id = $1 AND ` + scope + ` RETURNING user_id, chat_id`
         
-        if userType == "user" {
-                userID := c.GetInt("user_id")
-                query += " AND user_id = $2"
-                args = append(args, userID)
+        var ownerID int
+        var chatID int64
+        err := d.db.DB.QueryRow(query, args...).Scan(&ownerID, &chatID)
+        if errors.Is(err, sql.ErrNoRows) {
+                c.JSON(404, gin.H{"error": "Group not found"})
+                return
         }
-        
-        _, err := d.db.DB.Exec(query, args...)
         if err != nil {
                 c.JSON(500, gin.H{"error": "Failed to remove group"})
                 return
         }
         
+        d.entitlements.InvalidateChat(chatID)
+        
+        // The freed slot goes to the oldest suspended group
+        if err := d.groupLimits.Enforce(ownerID); err != nil {
+                c.JSON(500, gin.H{"error": "Failed to update suspended groups"})
+                return
+        }
+        
         c.JSON(200, gin.H{"message": "Group removed successfully"})
 }
 
 func (d *Dashboard) handleTestGroup(c *gin.Context) {
-        groupID := c.Param("id")
+        scope, args := policyOf(c).OwnerFilter("user_id", []interface{}{c.Param("id")})
         
         // Get group information
-        query := `SELECT chat_id, group_name FROM user_groups WHERE id = $1`
+        query := `SELECT chat_id, group_name FROM user_groups WHERE id = $1 AND ` + scope
         
         var chatID int64
         var groupName string
-        err := d.db.DB.QueryRow(query, groupID).Scan(&chatID, &groupName)
+        err := d.db.DB.QueryRow(query, args...).Scan(&chatID, &groupName)
         if err != nil {
                 c.JSON(404, gin.H{"error": "Group not found"})
                 return
@@ -1721,23 +2155,3 @@ func (d *Dashboard) handleGroupPlansPage(c *gin.Context) {
 func (d *Dashboard) handlePaymentPage(c *gin.Context) {
         c.File("./web/static/payment.html")
 }
-
-func (d *Dashboard) handleProcessPayment(c *gin.Context) {
-        var req struct {
-                PlanID        int    `json:"plan_id"`
-                PaymentMethod string `json:"payment_method"`
-                Amount        int    `json:"amount"`
-        }
-        
-        if err := c.ShouldBindJSON(&req); err != nil {
-                c.JSON(400, gin.H{"error": "Invalid request"})
-                return
-        }
-        
-        // Simulate successful payment for now
-        c.JSON(200, gin.H{
-                "success": true,
-                "message": "Payment processed successfully",
-                "transaction_id": "tx_" + generateToken()[:8],
-        })
-}
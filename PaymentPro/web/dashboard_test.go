@@ -0,0 +1,197 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"telegram-subscription-bot/database"
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/services"
+)
+
+// fakeQuery answers one query with its columns and rows
+type fakeQuery func(query string, args []driver.NamedValue) ([]string, [][]driver.Value)
+
+// fakeConnector opens connections that answer every query with query
+type fakeConnector struct {
+	query fakeQuery
+}
+
+func (f fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(f), nil }
+func (f fakeConnector) Driver() driver.Driver                        { return f }
+func (f fakeConnector) Open(string) (driver.Conn, error)             { return fakeConn(f), nil }
+
+type fakeConn fakeConnector
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows := c.query(query, args)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// testDashboard is a dashboard whose queries are answered by query
+func testDashboard(query fakeQuery) *Dashboard {
+	db := sql.OpenDB(fakeConnector{query: query})
+	return &Dashboard{
+		db:          &database.DB{DB: db},
+		paymentRepo: models.NewPaymentRepository(db),
+	}
+}
+
+// serveHandler runs a request for target through handler, routed at
+// pattern, as the policy's caller
+func serveHandler(policy *Policy, pattern string, handler gin.HandlerFunc, target string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET(pattern, func(c *gin.Context) { c.Set("policy", policy) }, handler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// paymentRows holds payment 5, made by user 1
+func paymentRows(query string, args []driver.NamedValue) ([]string, [][]driver.Value) {
+	columns := make([]string, 14)
+	if !strings.Contains(query, "FROM payments") || len(args) != 1 || args[0].Value != int64(5) {
+		return columns, nil
+	}
+	now := time.Now()
+	return columns, [][]driver.Value{{
+		int64(5), int64(1), int64(2), int64(1000), "USD", "card", "stripe",
+		"pi_1", "completed", "", []byte(`{}`), now, now, now,
+	}}
+}
+
+func TestHandleGetPaymentStatus(t *testing.T) {
+	d := testDashboard(paymentRows)
+	tests := []struct {
+		name   string
+		policy *Policy
+		target string
+		want   int
+	}{
+		{"payer", testPolicy(services.RoleUser, 1, nil), "/api/payment/status/5", 200},
+		{"other user", testPolicy(services.RoleUser, 2, nil), "/api/payment/status/5", 403},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), "/api/payment/status/5", 403},
+		{"admin", testPolicy(services.RoleAdmin, 0, nil), "/api/payment/status/5", 200},
+		{"unknown payment", testPolicy(services.RoleUser, 1, nil), "/api/payment/status/6", 404},
+		{"invalid payment", testPolicy(services.RoleUser, 1, nil), "/api/payment/status/abc", 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveHandler(tt.policy, "/api/payment/status/:id", d.handleGetPaymentStatus, tt.target)
+			if w.Code != tt.want {
+				t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, tt.want)
+			}
+			if w.Code != 200 && strings.Contains(w.Body.String(), "pi_1") {
+				t.Errorf("GET %s leaked the payment: %s", tt.target, w.Body.String())
+			}
+		})
+	}
+}
+
+// violationRows answers the violations query as Postgres would for the
+// owners in fakeOwners: one violation in chat 100 of user 1 and one in chat
+// 200 of user 2, every one for admins and those in their own chats for users
+func violationRows(query string, args []driver.NamedValue) ([]string, [][]driver.Value) {
+	columns := make([]string, 12)
+	now := time.Now()
+	violations := [][]driver.Value{
+		{int64(1), int64(10), int64(100), "spam", "links", "", now, nil, true, "first", "First", int64(1001)},
+		{int64(2), int64(11), int64(200), "flood", "flood", "", now, nil, true, "second", "Second", int64(1002)},
+	}
+
+	var rows [][]driver.Value
+	for _, v := range violations {
+		if len(args) == 0 && strings.Contains(query, "AND TRUE") {
+			rows = append(rows, v)
+			continue
+		}
+		if len(args) != 1 || !strings.Contains(query, "v.chat_id IN (SELECT chat_id FROM user_groups WHERE user_id = $1)") {
+			continue
+		}
+		userID, _ := args[0].Value.(int64)
+		if owned, _ := (fakeOwners{}).IsOwner(int(userID), v[2].(int64)); owned {
+			rows = append(rows, v)
+		}
+	}
+	return columns, rows
+}
+
+func TestHandleGetViolations(t *testing.T) {
+	d := testDashboard(violationRows)
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   []int64 // chats of the violations returned
+	}{
+		{"owner of chat 100", testPolicy(services.RoleUser, 1, nil), []int64{100}},
+		{"owner of chat 200", testPolicy(services.RoleUser, 2, nil), []int64{200}},
+		{"user owning no chat", testPolicy(services.RoleUser, 3, nil), nil},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), nil},
+		{"admin", testPolicy(services.RoleAdmin, 0, nil), []int64{100, 200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveHandler(tt.policy, "/api/moderation/violations", d.handleGetViolations, "/api/moderation/violations")
+			if w.Code != 200 {
+				t.Fatalf("GET /api/moderation/violations = %d, want 200", w.Code)
+			}
+
+			var body struct {
+				Violations []struct {
+					ChatID int64 `json:"chat_id"`
+				} `json:"violations"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int64
+			for _, v := range body.Violations {
+				got = append(got, v.ChatID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("violations in chats %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("violations in chats %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package web
+
+import (
+        "database/sql"
+        "errors"
+        "fmt"
+        "strconv"
+
+        "github.com/gin-gonic/gin"
+        "telegram-subscription-bot/models"
+        "telegram-subscription-bot/services"
+)
+
+// Policy decides what the signed-in caller may read and change. Admins may
+// access everything; users only their own account and payments and the chats
+// they registered in user_groups. authMiddleware attaches one to every
+// request, handlers get it with policyOf and scope their queries with it.
+type Policy struct {
+        groups  chatOwners
+        session *models.DashboardSession
+}
+
+// chatOwners is the part of models.UserGroupRepository a Policy needs
+type chatOwners interface {
+        IsOwner(userID int, chatID int64) (bool, error)
+}
+
+func newPolicy(groups chatOwners, session *models.DashboardSession) *Policy {
+        return &Policy{groups: groups, session: session}
+}
+
+func policyOf(c *gin.Context) *Policy {
+        return c.MustGet("policy").(*Policy)
+}
+
+func (p *Policy) IsAdmin() bool {
+        return p.session.Role == services.RoleAdmin
+}
+
+// UserID is the caller's row in users, 0 for the admin account from the config
+func (p *Policy) UserID() int {
+        return p.session.UserID
+}
+
+// CanAccessChat reports whether the caller owns the chat or is an admin
+func (p *Policy) CanAccessChat(chatID int64) (bool, error) {
+        if p.IsAdmin() {
+                return true, nil
+        }
+        if p.UserID() == 0 {
+                return false, nil
+        }
+        return p.groups.IsOwner(p.UserID(), chatID)
+}
+
+// CanAccessScope is CanAccessChat for settings that belong either to one chat
+// or, when chatID is nil, to every chat, which only admins may change
+func (p *Policy) CanAccessScope(chatID *int64) (bool, error) {
+        if chatID == nil {
+                return p.IsAdmin(), nil
+        }
+        return p.CanAccessChat(*chatID)
+}
+
+func (p *Policy) CanAccessPayment(payment *models.Payment) bool {
+        return p.IsAdmin() || (p.UserID() != 0 && payment.UserID == int64(p.UserID()))
+}
+
+// ChatFilter returns an SQL condition limiting column, a chat ID, to the
+// caller's chats, and args with the condition's parameter appended
+func (p *Policy) ChatFilter(column string, args []interface{}) (string, []interface{}) {
+        if p.IsAdmin() {
+                return "TRUE", args
+        }
+        args = append(args, p.UserID())
+        return fmt.Sprintf("%s IN (SELECT chat_id FROM user_groups WHERE user_id = $%d)", column, len(args)), args
+}
+
+// OwnerFilter returns an SQL condition limiting column, a user ID, to the
+// caller, and args with the condition's parameter appended
+func (p *Policy) OwnerFilter(column string, args []interface{}) (string, []interface{}) {
+        if p.IsAdmin() {
+                return "TRUE", args
+        }
+        args = append(args, p.UserID())
+        return fmt.Sprintf("%s = $%d", column, len(args)), args
+}
+
+// authorize answers the request when a policy check failed and reports
+// whether the handler may go on
+func authorize(c *gin.Context, allowed bool, err error) bool {
+        if err != nil {
+                c.JSON(500, gin.H{"error": "Failed to check access"})
+                return false
+        }
+        if !allowed {
+                c.JSON(403, gin.H{"error": "Доступ запрещен"})
+                return false
+        }
+        return true
+}
+
+func (d *Dashboard) requireAdmin() gin.HandlerFunc {
+        return func(c *gin.Context) {
+                if !authorize(c, policyOf(c).IsAdmin(), nil) {
+                        c.Abort()
+                        return
+                }
+                c.Next()
+        }
+}
+
+// requireChatAccess guards routes that take a chat ID from the request, read
+// with chatID. Without a chat ID the route covers every chat, which only
+// admins may see.
+func (d *Dashboard) requireChatAccess(chatID func(*gin.Context) string) gin.HandlerFunc {
+        return func(c *gin.Context) {
+                policy := policyOf(c)
+
+                value := chatID(c)
+                if value == "" {
+                        if !authorize(c, policy.IsAdmin(), nil) {
+                                c.Abort()
+                                return
+                        }
+                        c.Next()
+                        return
+                }
+
+                id, err := strconv.ParseInt(value, 10, 64)
+                if err != nil {
+                        c.JSON(400, gin.H{"error": "Invalid group ID"})
+                        c.Abort()
+                        return
+                }
+
+                allowed, err := policy.CanAccessChat(id)
+                if !authorize(c, allowed, err) {
+                        c.Abort()
+                        return
+                }
+                c.Next()
+        }
+}
+
+// requireRecommendationAccess guards routes on one AI recommendation by the
+// chat it is about; recommendations about all chats are for admins
+func (d *Dashboard) requireRecommendationAccess() gin.HandlerFunc {
+        return func(c *gin.Context) {
+                policy := policyOf(c)
+
+                id, err := strconv.Atoi(c.Param("id"))
+                if err != nil {
+                        c.JSON(400, gin.H{"error": "Invalid recommendation ID"})
+                        c.Abort()
+                        return
+                }
+
+                groupID, err := d.aiService.GetRecommendationGroup(id)
+                if errors.Is(err, sql.ErrNoRows) {
+                        c.JSON(404, gin.H{"error": "Recommendation not found"})
+                        c.Abort()
+                        return
+                }
+
+                allowed := policy.IsAdmin()
+                if err == nil && groupID != nil {
+                        allowed, err = policy.CanAccessChat(*groupID)
+                }
+                if !authorize(c, allowed, err) {
+                        c.Abort()
+                        return
+                }
+                c.Next()
+        }
+}
+
+func pathParam(name string) func(*gin.Context) string {
+        return func(c *gin.Context) string {
+                return c.Param(name)
+        }
+}
+
+func queryParam(name string) func(*gin.Context) string {
+        return func(c *gin.Context) string {
+                return c.Query(name)
+        }
+}
+
+// requireFeature guards routes of a plan feature; admins may always use them
+func (d *Dashboard) requireFeature(feature models.Feature) gin.HandlerFunc {
+        return func(c *gin.Context) {
+                policy := policyOf(c)
+                if policy.IsAdmin() {
+                        c.Next()
+                        return
+                }
+                if policy.UserID() == 0 {
+                        authorize(c, false, nil)
+                        c.Abort()
+                        return
+                }
+
+                entitlements, err := d.entitlements.ForUser(policy.UserID())
+                if err != nil {
+                        authorize(c, false, err)
+                        c.Abort()
+                        return
+                }
+                if !entitlements.Has(feature) {
+                        c.JSON(403, gin.H{"error": "Your plan does not include this feature. Upgrade to use it.", "feature": feature})
+                        c.Abort()
+                        return
+                }
+                c.Next()
+        }
+}
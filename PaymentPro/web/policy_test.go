@@ -0,0 +1,217 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"telegram-subscription-bot/models"
+	"telegram-subscription-bot/services"
+)
+
+// fakeOwners registers chats 100 and 101 to user 1 and chat 200 to user 2
+type fakeOwners struct {
+	err error
+}
+
+func (f fakeOwners) IsOwner(userID int, chatID int64) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	owned := map[int][]int64{1: {100, 101}, 2: {200}}
+	for _, id := range owned[userID] {
+		if id == chatID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var errLookup = errors.New("lookup failed")
+
+func testPolicy(role string, userID int, err error) *Policy {
+	return newPolicy(fakeOwners{err: err}, &models.DashboardSession{Role: role, UserID: userID})
+}
+
+func TestCanAccessChat(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *Policy
+		chatID  int64
+		want    bool
+		wantErr bool
+	}{
+		{"owner", testPolicy(services.RoleUser, 1, nil), 100, true, false},
+		{"owner's other chat", testPolicy(services.RoleUser, 1, nil), 101, true, false},
+		{"another owner's chat", testPolicy(services.RoleUser, 1, nil), 200, false, false},
+		{"other owner the other way", testPolicy(services.RoleUser, 2, nil), 100, false, false},
+		{"unregistered chat", testPolicy(services.RoleUser, 1, nil), 300, false, false},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), 100, false, false},
+		{"admin", testPolicy(services.RoleAdmin, 0, nil), 200, true, false},
+		{"admin with a user row", testPolicy(services.RoleAdmin, 1, nil), 200, true, false},
+		{"lookup error", testPolicy(services.RoleUser, 1, errLookup), 100, false, true},
+		{"admin ignores lookup error", testPolicy(services.RoleAdmin, 0, errLookup), 100, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.CanAccessChat(tt.chatID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CanAccessChat(%d) error = %v, want error %v", tt.chatID, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CanAccessChat(%d) = %v, want %v", tt.chatID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanAccessPayment(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *Policy
+		payment *models.Payment
+		want    bool
+	}{
+		{"payer", testPolicy(services.RoleUser, 1, nil), &models.Payment{ID: 5, UserID: 1}, true},
+		{"other user", testPolicy(services.RoleUser, 2, nil), &models.Payment{ID: 5, UserID: 1}, false},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), &models.Payment{ID: 5, UserID: 1}, false},
+		{"payment without a user", testPolicy(services.RoleUser, 0, nil), &models.Payment{ID: 5}, false},
+		{"admin", testPolicy(services.RoleAdmin, 0, nil), &models.Payment{ID: 5, UserID: 1}, true},
+		{"admin with a user row", testPolicy(services.RoleAdmin, 2, nil), &models.Payment{ID: 5, UserID: 1}, true},
+		{"lookup error does not matter", testPolicy(services.RoleUser, 1, errLookup), &models.Payment{ID: 5, UserID: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.CanAccessPayment(tt.payment); got != tt.want {
+				t.Errorf("CanAccessPayment(payment of user %d) = %v, want %v", tt.payment.UserID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *Policy
+		args     []interface{}
+		want     string
+		wantArgs []interface{}
+	}{
+		{"admin sees every chat", testPolicy(services.RoleAdmin, 0, nil), []interface{}{"x"}, "TRUE", []interface{}{"x"}},
+		{"user without args", testPolicy(services.RoleUser, 1, nil), nil,
+			"chat_id IN (SELECT chat_id FROM user_groups WHERE user_id = $1)", []interface{}{1}},
+		{"user after other args", testPolicy(services.RoleUser, 2, nil), []interface{}{"x", 5},
+			"chat_id IN (SELECT chat_id FROM user_groups WHERE user_id = $3)", []interface{}{"x", 5, 2}},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), nil,
+			"chat_id IN (SELECT chat_id FROM user_groups WHERE user_id = $1)", []interface{}{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.policy.ChatFilter("chat_id", tt.args)
+			if got != tt.want {
+				t.Errorf("ChatFilter() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("ChatFilter() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestOwnerFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *Policy
+		args     []interface{}
+		want     string
+		wantArgs []interface{}
+	}{
+		{"admin sees every owner", testPolicy(services.RoleAdmin, 0, nil), []interface{}{"7"}, "TRUE", []interface{}{"7"}},
+		{"user", testPolicy(services.RoleUser, 1, nil), []interface{}{"7"}, "user_id = $2", []interface{}{"7", 1}},
+		{"other user", testPolicy(services.RoleUser, 2, nil), []interface{}{"7"}, "user_id = $2", []interface{}{"7", 2}},
+		{"user without an account row", testPolicy(services.RoleUser, 0, nil), nil, "user_id = $1", []interface{}{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.policy.OwnerFilter("user_id", tt.args)
+			if got != tt.want {
+				t.Errorf("OwnerFilter() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("OwnerFilter() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// serve runs a request through guard as the policy's caller and returns the
+// status; 200 means the guard let it through
+func serve(policy *Policy, guard gin.HandlerFunc, target string) int {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+	setPolicy := func(c *gin.Context) { c.Set("policy", policy) }
+	r.GET("/chats/:id", setPolicy, guard, handler)
+	r.GET("/chats", setPolicy, guard, handler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w.Code
+}
+
+func TestRequireChatAccess(t *testing.T) {
+	d := &Dashboard{}
+	tests := []struct {
+		name   string
+		policy *Policy
+		guard  gin.HandlerFunc
+		target string
+		want   int
+	}{
+		{"owner by path", testPolicy(services.RoleUser, 1, nil), d.requireChatAccess(pathParam("id")), "/chats/100", 200},
+		{"another owner's chat by path", testPolicy(services.RoleUser, 1, nil), d.requireChatAccess(pathParam("id")), "/chats/200", 403},
+		{"owner by query", testPolicy(services.RoleUser, 2, nil), d.requireChatAccess(queryParam("chat_id")), "/chats?chat_id=200", 200},
+		{"another owner's chat by query", testPolicy(services.RoleUser, 2, nil), d.requireChatAccess(queryParam("chat_id")), "/chats?chat_id=101", 403},
+		{"user without a chat", testPolicy(services.RoleUser, 1, nil), d.requireChatAccess(queryParam("chat_id")), "/chats", 403},
+		{"admin without a chat", testPolicy(services.RoleAdmin, 0, nil), d.requireChatAccess(queryParam("chat_id")), "/chats", 200},
+		{"admin any chat", testPolicy(services.RoleAdmin, 0, nil), d.requireChatAccess(pathParam("id")), "/chats/200", 200},
+		{"invalid chat", testPolicy(services.RoleUser, 1, nil), d.requireChatAccess(pathParam("id")), "/chats/abc", 400},
+		{"lookup error", testPolicy(services.RoleUser, 1, errLookup), d.requireChatAccess(pathParam("id")), "/chats/100", 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.policy, tt.guard, tt.target); got != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	d := &Dashboard{}
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   int
+	}{
+		{"admin from the config", testPolicy(services.RoleAdmin, 0, nil), 200},
+		{"admin with a user row", testPolicy(services.RoleAdmin, 1, nil), 200},
+		{"user", testPolicy(services.RoleUser, 1, nil), 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(tt.policy, d.requireAdmin(), "/chats/100"); got != tt.want {
+				t.Errorf("GET /chats/100 = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}